@@ -56,6 +56,14 @@ type InputDescriptorMapping struct {
 type MatchOptions struct {
 	CredentialOptions       []verifiable.CredentialOpt
 	DisableSchemaValidation bool
+	ContextCache            *ContextCache
+	// PresentationClaims evaluates the constraints fields against the submitted presentation as well,
+	// see WithPresentationClaimsMatch.
+	PresentationClaims bool
+	// PresentationPaths are the paths evaluated against the submitted presentation, see WithPresentationClaimsMatch.
+	PresentationPaths []string
+	// ExternalProofs classifies the credentials without inline proofs by their encoding, see WithExternalProofsMatch.
+	ExternalProofs bool
 }
 
 // MatchOption is an option that sets an option for when matching.
@@ -75,6 +83,32 @@ func WithDisableSchemaValidation() MatchOption {
 	}
 }
 
+// WithMatchContextCache used to reuse the parsed JSON-LD contexts across matches.
+func WithMatchContextCache(cache *ContextCache) MatchOption {
+	return func(m *MatchOptions) {
+		m.ContextCache = cache
+	}
+}
+
+// WithPresentationClaimsMatch evaluates the constraints fields whose paths select no value in a credential against
+// the submitted presentation, the same way WithPresentationClaims does when matching credentials: only the given
+// paths, or $.holder if none are given, are evaluated against the presentation.
+func WithPresentationClaimsMatch(paths ...string) MatchOption {
+	return func(m *MatchOptions) {
+		m.PresentationClaims = true
+		m.PresentationPaths = paths
+	}
+}
+
+// WithExternalProofsMatch checks the format of the submitted credentials without inline proofs (whose proof is
+// a detached JWS or the proof of the enveloping presentation) the same way WithExternalProofs does when matching
+// credentials.
+func WithExternalProofsMatch() MatchOption {
+	return func(m *MatchOptions) {
+		m.ExternalProofs = true
+	}
+}
+
 // Match returns the credentials matched against the InputDescriptors ids.
 func (pd *PresentationDefinition) Match(vp *verifiable.Presentation, // nolint:gocyclo,funlen
 	contextLoader ld.DocumentLoader, options ...MatchOption) (map[string]*verifiable.Credential, error) {
@@ -127,14 +161,28 @@ func (pd *PresentationDefinition) Match(vp *verifiable.Presentation, // nolint:g
 
 		inputDescriptor := pd.inputDescriptor(mapping.ID)
 
-		passed := filterSchema(inputDescriptor.Schema, []*verifiable.Credential{vc}, contextLoader)
+		passed, schemaErr := filterSchema(inputDescriptor.Schema, []*verifiable.Credential{vc},
+			loaderSchemaResolver(contextLoader), opts.ContextCache, false, logger, nil)
+		if schemaErr != nil && !opts.DisableSchemaValidation {
+			return nil, fmt.Errorf("input descriptor id [%s]: %w", inputDescriptor.ID, schemaErr)
+		}
+
 		if len(passed) == 0 && !opts.DisableSchemaValidation {
 			return nil, fmt.Errorf(
 				"input descriptor id [%s] requires schemas %+v which do not match vc with @context [%+v] and types [%+v] selected by path [%s]", // nolint:lll
 				inputDescriptor.ID, inputDescriptor.Schema, vc.Context, vc.Types, mapping.Path)
 		}
 
-		// TODO add support for constraints: https://github.com/hyperledger/aries-framework-go/issues/2108
+		err = checkFormat(pd.descriptorFormat(inputDescriptor), leafMapping(mapping), vc, opts.ExternalProofs)
+		if err != nil {
+			return nil, fmt.Errorf("input descriptor id [%s]: %w", inputDescriptor.ID, err)
+		}
+
+		err = checkConstraints(inputDescriptor, vc, typelessVP, vp.Holder, opts)
+		if err != nil {
+			return nil, fmt.Errorf("input descriptor id [%s] constraints are not satisfied by vc selected by path [%s]: %w",
+				inputDescriptor.ID, mapping.Path, err)
+		}
 
 		result[mapping.ID] = vc
 	}
@@ -184,6 +232,94 @@ func selectVC(typelessVerifiable interface{},
 	return vc, nil
 }
 
+func leafMapping(mapping *InputDescriptorMapping) *InputDescriptorMapping {
+	for mapping.PathNested != nil {
+		mapping = mapping.PathNested
+	}
+
+	return mapping
+}
+
+// checkFormat checks that the format claimed by the mapping matches the selected vc, and that the vc
+// conforms to the claim format designations of the definition (if any).
+func checkFormat(format *Format, mapping *InputDescriptorMapping, vc *verifiable.Credential,
+	externalProofs bool) error {
+	jwtFormat := mapping.Format == FormatJWT || mapping.Format == FormatJWTVC
+	ldpFormat := mapping.Format == FormatLDP || mapping.Format == FormatLDPVC
+	sdJWTFormat := isSDJWTFormat(mapping.Format)
+
+	if (jwtFormat && vc.JWT == "") || (ldpFormat && vc.JWT != "") || (sdJWTFormat && vc.SDJWTHashAlg == "") {
+		return fmt.Errorf("format %s does not match vc selected by path [%s]", mapping.Format, mapping.Path)
+	}
+
+	if !format.notNil() {
+		return nil
+	}
+
+	_, filtered, err := filterFormat(format, []*verifiable.Credential{vc}, nil,
+		&matchRequirementsOpts{externalProofs: externalProofs})
+	if err != nil {
+		return err
+	}
+
+	if len(filtered) == 0 {
+		return fmt.Errorf("vc selected by path [%s] does not match any of the requested formats", mapping.Path)
+	}
+
+	return nil
+}
+
+// checkConstraints checks the submitted vc against the input descriptor constraints.
+// The vc is expected to be limited by the holder already, so limit_disclosure is not applied again,
+// and the fields with required predicate are checked for presence only, since the holder replaces
+// their values by the predicate result. The is_holder directives are checked against the holder of the vp.
+func checkConstraints(descriptor *InputDescriptor, vc *verifiable.Credential, vp interface{}, holderDID string,
+	opts *MatchOptions) error {
+	if descriptor.Constraints == nil {
+		return nil
+	}
+
+	constraints := *descriptor.Constraints
+	constraints.LimitDisclosure = nil
+	constraints.Fields = make([]*Field, len(descriptor.Constraints.Fields))
+
+	for i, field := range descriptor.Constraints.Fields {
+		f := *field
+
+		if f.Predicate.isRequired() {
+			f.Predicate = nil
+			f.Filter = nil
+			f.Filters = nil
+		}
+
+		constraints.Fields[i] = &f
+	}
+
+	matchOpts := newMatchRequirementsOpts([]MatchRequirementsOpt{WithSDCredentialOptions(opts.CredentialOptions...)})
+	matchOpts.rejections = &rejectionCollector{}
+	matchOpts.holderDID = holderDID
+
+	if presentation, ok := vp.(map[string]interface{}); ok && opts.PresentationClaims {
+		matchOpts.presentationClaims = presentation
+		matchOpts.presentationPaths = opts.PresentationPaths
+	}
+
+	passed, err := filterConstraints(descriptor.ID, &constraints, []*verifiable.Credential{vc}, matchOpts)
+	if err != nil {
+		return err
+	}
+
+	if len(passed) != 0 {
+		return nil
+	}
+
+	if len(matchOpts.rejections.rejections) != 0 {
+		return matchOpts.rejections.rejections[0].Reason
+	}
+
+	return ErrNoCredentials
+}
+
 // Ensures the matched credentials meet the submission requirements.
 func (pd *PresentationDefinition) evalSubmissionRequirements(matched map[string]*verifiable.Credential) error {
 	// TODO support submission requirement rules: https://github.com/hyperledger/aries-framework-go/issues/2109
@@ -199,6 +335,14 @@ func (pd *PresentationDefinition) evalSubmissionRequirements(matched map[string]
 	return nil
 }
 
+func (pd *PresentationDefinition) descriptorFormat(descriptor *InputDescriptor) *Format {
+	if descriptor.Format.notNil() {
+		return descriptor.Format
+	}
+
+	return pd.Format
+}
+
 func (pd *PresentationDefinition) inputDescriptor(id string) *InputDescriptor {
 	for i := range pd.InputDescriptors {
 		if pd.InputDescriptors[i].ID == id {
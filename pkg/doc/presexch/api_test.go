@@ -534,6 +534,201 @@ func TestPresentationDefinition_Match(t *testing.T) {
 		), docLoader, WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
 		require.Error(t, err)
 	})
+
+	t.Run("checks constraints", func(t *testing.T) {
+		uri := randomURI()
+
+		docLoader := createTestDocumentLoader(t, uri)
+
+		required := Required
+
+		defs := &PresentationDefinition{
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Schema: []*Schema{{
+					URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+				}},
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:   []string{"$.age"},
+						Filter: &Filter{Type: &intFilterType, Minimum: 18},
+					}, {
+						Path:      []string{"$.adult"},
+						Predicate: &required,
+						Filter:    &Filter{Type: &strFilterType},
+					}},
+				},
+			}},
+		}
+
+		submission := &PresentationSubmission{DescriptorMap: []*InputDescriptorMapping{{
+			ID:     defs.InputDescriptors[0].ID,
+			Path:   "$.verifiableCredential[0]",
+			Format: FormatLDPVC,
+		}}}
+
+		adult := newVCWithCustomFld([]string{uri}, "age", 21)
+		adult.CustomFields["adult"] = true
+
+		matched, err := defs.Match(newVP(t, submission, adult), docLoader,
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.NoError(t, err)
+		require.Len(t, matched, 1)
+
+		teenager := newVCWithCustomFld([]string{uri}, "age", 17)
+		teenager.CustomFields["adult"] = false
+
+		_, err = defs.Match(newVP(t, submission, teenager), docLoader,
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "constraints are not satisfied by vc selected by path [$.verifiableCredential[0]]")
+		require.Contains(t, err.Error(), "Must be greater than or equal to 18")
+
+		_, err = defs.Match(newVP(t, submission, newVCWithCustomFld([]string{uri}, "age", 21)), docLoader,
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "path not applicable")
+	})
+
+	t.Run("checks presentation claims", func(t *testing.T) {
+		uri := randomURI()
+
+		docLoader := createTestDocumentLoader(t, uri)
+
+		defs := &PresentationDefinition{
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Schema: []*Schema{{
+					URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+				}},
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:   []string{"$.holder"},
+						Filter: &Filter{Type: &strFilterType, Const: "did:example:holder"},
+					}},
+				},
+			}},
+		}
+
+		submission := &PresentationSubmission{DescriptorMap: []*InputDescriptorMapping{{
+			ID:     defs.InputDescriptors[0].ID,
+			Path:   "$.verifiableCredential[0]",
+			Format: FormatLDPVC,
+		}}}
+
+		vp := newVP(t, submission, newVCWithCustomFld([]string{uri}, "age", 21))
+		vp.Holder = "did:example:holder"
+
+		matched, err := defs.Match(vp, docLoader, WithPresentationClaimsMatch(),
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.NoError(t, err)
+		require.Len(t, matched, 1)
+
+		_, err = defs.Match(vp, docLoader, WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.ErrorContains(t, err, "path not applicable")
+
+		vp.Holder = "did:example:other"
+
+		_, err = defs.Match(vp, docLoader, WithPresentationClaimsMatch(),
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.ErrorContains(t, err, "path not applicable")
+
+		// the presentation cannot satisfy the claims of the credential.
+		vp.Holder = "did:example:holder"
+		defs.InputDescriptors[0].Constraints.Fields = append(defs.InputDescriptors[0].Constraints.Fields, &Field{
+			Path: []string{"$.verifiableCredential"},
+		})
+
+		_, err = defs.Match(vp, docLoader, WithPresentationClaimsMatch(),
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.ErrorContains(t, err, "path not applicable")
+
+		_, err = defs.Match(vp, docLoader, WithPresentationClaimsMatch("$.holder", "$.verifiableCredential"),
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.NoError(t, err)
+	})
+
+	t.Run("checks is_holder", func(t *testing.T) {
+		uri := randomURI()
+
+		docLoader := createTestDocumentLoader(t, uri)
+
+		required := Required
+
+		defs := &PresentationDefinition{
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Schema: []*Schema{{
+					URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+				}},
+				Constraints: &Constraints{
+					IsHolder: []*Holder{{FieldID: []string{"age"}, Directive: &required}},
+					Fields:   []*Field{{ID: "age", Path: []string{"$.credentialSubject.age"}}},
+				},
+			}},
+		}
+
+		submission := &PresentationSubmission{DescriptorMap: []*InputDescriptorMapping{{
+			ID:     defs.InputDescriptors[0].ID,
+			Path:   "$.verifiableCredential[0]",
+			Format: FormatLDPVC,
+		}}}
+
+		vc := newVC([]string{uri})
+		vc.Subject = map[string]interface{}{"id": "did:example:holder", "age": 21}
+
+		vp := newVP(t, submission, vc)
+		vp.Holder = "did:example:holder#key-1"
+
+		matched, err := defs.Match(vp, docLoader, WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.NoError(t, err)
+		require.Len(t, matched, 1)
+
+		vp.Holder = "did:example:other"
+
+		_, err = defs.Match(vp, docLoader, WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.ErrorContains(t, err, "is_holder: field age: holder is not the subject")
+	})
+
+	t.Run("checks format", func(t *testing.T) {
+		uri := randomURI()
+
+		docLoader := createTestDocumentLoader(t, uri)
+
+		defs := &PresentationDefinition{
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Schema: []*Schema{{
+					URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+				}},
+			}},
+		}
+
+		_, err := defs.Match(newVP(t,
+			&PresentationSubmission{DescriptorMap: []*InputDescriptorMapping{{
+				ID:     defs.InputDescriptors[0].ID,
+				Path:   "$.verifiableCredential[0]",
+				Format: FormatJWTVC,
+			}}},
+			newVC([]string{uri}),
+		), docLoader, WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.EqualError(t, err, fmt.Sprintf("input descriptor id [%s]: format jwt_vc does not match vc "+
+			"selected by path [$.verifiableCredential[0]]", defs.InputDescriptors[0].ID))
+
+		defs.Format = &Format{LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}}}
+
+		_, err = defs.Match(newVP(t,
+			&PresentationSubmission{DescriptorMap: []*InputDescriptorMapping{{
+				ID:     defs.InputDescriptors[0].ID,
+				Path:   "$.verifiableCredential[0]",
+				Format: FormatLDPVC,
+			}}},
+			newVC([]string{uri}),
+		), docLoader, WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(docLoader)))
+		require.EqualError(t, err, fmt.Sprintf("input descriptor id [%s]: vc selected by path "+
+			"[$.verifiableCredential[0]] does not match any of the requested formats", defs.InputDescriptors[0].ID))
+	})
 }
 
 func TestE2E(t *testing.T) {
@@ -0,0 +1,142 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/piprate/json-gold/ld"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// CredentialAuditRecord describes the fields of the input descriptor a credential of a presentation is submitted
+// for, which the credential satisfies.
+type CredentialAuditRecord struct {
+	CredentialID    string
+	DescriptorID    string
+	SatisfiedFields []*FieldAudit
+}
+
+// FieldAudit describes a field satisfied by a credential.
+type FieldAudit struct {
+	// FieldIndex is the index of the field in Constraints.Fields.
+	FieldIndex int
+	FieldID    string
+	FieldName  string
+	// Path is the first path of the field satisfied by the credential, and Value is the value it selects
+	// (or true if the field has a required predicate, the value being replaced).
+	Path  string
+	Value interface{}
+}
+
+// CreateVPWithAudit works like CreateVP, but additionally returns the audit records of the credentials of
+// the presentation, e.g. for a compliance trail: a record per input descriptor a credential is submitted for,
+// with the fields of the descriptor the credential satisfies, ordered by descriptor ID. The optional fields which
+// are not satisfied are omitted.
+func (pd *PresentationDefinition) CreateVPWithAudit(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt,
+) (*verifiable.Presentation, []*CredentialAuditRecord, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.audit = &auditCollector{}
+
+	format, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vp, err := pd.presentation(format, result, matchOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vp, matchOpts.audit.records(result), nil
+}
+
+// auditCollector gathers the fields the credentials matched by the input descriptors satisfy. A nil collector
+// discards them.
+type auditCollector struct {
+	mu     sync.Mutex
+	fields map[auditKey][]*FieldAudit
+}
+
+type auditKey struct {
+	descriptorID string
+	credential   *verifiable.Credential
+}
+
+func (c *auditCollector) add(descriptorID string, credential *verifiable.Credential, fields []*FieldAudit) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fields == nil {
+		c.fields = map[auditKey][]*FieldAudit{}
+	}
+
+	c.fields[auditKey{descriptorID: descriptorID, credential: credential}] = fields
+}
+
+// replace moves the fields of the credential of the input descriptor to the credential replacing it.
+func (c *auditCollector) replace(descriptorID string, old, credential *verifiable.Credential) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if fields, ok := c.fields[auditKey{descriptorID: descriptorID, credential: old}]; ok {
+		delete(c.fields, auditKey{descriptorID: descriptorID, credential: old})
+		c.fields[auditKey{descriptorID: descriptorID, credential: credential}] = fields
+	}
+}
+
+// records returns the records of the credentials of the match result. The records are created once
+// the presentation is, for the IDs of the credentials to be the ones of the presentation.
+func (c *auditCollector) records(result map[string][]*verifiable.Credential) []*CredentialAuditRecord {
+	descriptorIDs := make([]string, 0, len(result))
+	for descriptorID := range result {
+		descriptorIDs = append(descriptorIDs, descriptorID)
+	}
+
+	sort.Strings(descriptorIDs)
+
+	var records []*CredentialAuditRecord
+
+	for _, descriptorID := range descriptorIDs {
+		for _, credential := range result[descriptorID] {
+			records = append(records, &CredentialAuditRecord{
+				CredentialID:    credential.ID,
+				DescriptorID:    descriptorID,
+				SatisfiedFields: c.fields[auditKey{descriptorID: descriptorID, credential: credential}],
+			})
+		}
+	}
+
+	return records
+}
+
+func fieldAudit(i int, field *Field, pathIndex int, credential map[string]interface{}) *FieldAudit {
+	audit := &FieldAudit{
+		FieldIndex: i,
+		FieldID:    field.ID,
+		FieldName:  field.Name,
+		Path:       field.Path[pathIndex],
+		Value:      true,
+	}
+
+	if !field.Predicate.isRequired() {
+		audit.Value, _ = fieldValue(audit.Path, credential)
+	}
+
+	return audit
+}
@@ -0,0 +1,56 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"errors"
+
+	"github.com/piprate/json-gold/ld"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// ErrBindingNotSupported is returned when WithPresentationBinding is given to CreateVPWithOptions (or any other
+// function creating a presentation but CreateBoundVP), which would drop the binding as it returns no more than the
+// presentation.
+var ErrBindingNotSupported = errors.New("presentation binding is only supported by CreateBoundVP")
+
+// PresentationBinding holds the verifier's parameters the proof of the presentation must be bound to.
+type PresentationBinding struct {
+	// Challenge is the verifier's nonce: the challenge of a Linked Data proof or the nonce claim of a JWT VP.
+	Challenge string
+	// Domain is the verifier's audience (client_id): the domain of a Linked Data proof or the aud claim of a JWT VP.
+	Domain string
+}
+
+// BoundPresentation is the presentation along with the binding its proof must apply.
+type BoundPresentation struct {
+	Presentation *verifiable.Presentation
+	// Binding is nil if WithPresentationBinding is not used.
+	Binding *PresentationBinding
+}
+
+// CreateBoundVP works like CreateVP, but returns the presentation along with the binding set by
+// WithPresentationBinding. The presentation is not signed: it is up to the caller to create the proof
+// bound to the returned challenge and domain (e.g. with verifiable.LinkedDataProofContext).
+func (pd *PresentationDefinition) CreateBoundVP(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) (*BoundPresentation, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.bindingReturned = true
+
+	format, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	vp, err := pd.presentation(format, result, matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoundPresentation{Presentation: vp, Binding: matchOpts.binding}, nil
+}
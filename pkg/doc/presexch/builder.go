@@ -0,0 +1,193 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefinitionError describes an element of a presentation definition which failed validation.
+type DefinitionError struct {
+	// Element identifies the offending element, e.g. "input_descriptors[1]" or "submission_requirements[0]".
+	Element string
+	Err     error
+}
+
+// Error returns the error message.
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Element, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DefinitionError) Unwrap() error {
+	return e.Err
+}
+
+// DefinitionBuilder defines a builder of PresentationDefinition which validates the definition
+// as input descriptors and submission requirements are added. The first validation error stops
+// the building and is returned by Build.
+//
+// Input descriptors must be added before the submission requirements referencing their groups.
+type DefinitionBuilder struct {
+	definition *PresentationDefinition
+	err        error
+}
+
+// NewDefinitionBuilder creates a new instance of DefinitionBuilder.
+func NewDefinitionBuilder() *DefinitionBuilder {
+	return &DefinitionBuilder{
+		definition: &PresentationDefinition{},
+	}
+}
+
+// SetID sets the presentation definition ID.
+func (b *DefinitionBuilder) SetID(id string) *DefinitionBuilder {
+	b.definition.ID = id
+	return b
+}
+
+// SetName sets the presentation definition name.
+func (b *DefinitionBuilder) SetName(name string) *DefinitionBuilder {
+	b.definition.Name = name
+	return b
+}
+
+// SetPurpose sets the presentation definition purpose.
+func (b *DefinitionBuilder) SetPurpose(purpose string) *DefinitionBuilder {
+	b.definition.Purpose = purpose
+	return b
+}
+
+// SetFormat sets the presentation definition claim format designations.
+func (b *DefinitionBuilder) SetFormat(format *Format) *DefinitionBuilder {
+	b.definition.Format = format
+	return b
+}
+
+// SetFrame sets the JSON-LD frame of the presentation definition.
+func (b *DefinitionBuilder) SetFrame(frame map[string]interface{}) *DefinitionBuilder {
+	b.definition.Frame = frame
+	return b
+}
+
+// AddInputDescriptor adds input descriptor to the presentation definition.
+// It checks that the descriptor ID is not empty and unique and that every constraint field has a path.
+func (b *DefinitionBuilder) AddInputDescriptor(descriptor *InputDescriptor) *DefinitionBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	element := fmt.Sprintf("input_descriptors[%d]", len(b.definition.InputDescriptors))
+
+	if err := b.checkInputDescriptor(descriptor); err != nil {
+		b.err = &DefinitionError{Element: element, Err: err}
+
+		return b
+	}
+
+	b.definition.InputDescriptors = append(b.definition.InputDescriptors, descriptor)
+
+	return b
+}
+
+// AddSubmissionRequirement adds submission requirement to the presentation definition.
+// It checks that the requirement (and all the nested ones) reference groups declared by input descriptors.
+func (b *DefinitionBuilder) AddSubmissionRequirement(requirement *SubmissionRequirement) *DefinitionBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	element := fmt.Sprintf("submission_requirements[%d]", len(b.definition.SubmissionRequirements))
+
+	if err := b.checkSubmissionRequirement(element, requirement); err != nil {
+		b.err = err
+
+		return b
+	}
+
+	b.definition.SubmissionRequirements = append(b.definition.SubmissionRequirements, requirement)
+
+	return b
+}
+
+// Build validates the schema of constructed PresentationDefinition and returns it.
+func (b *DefinitionBuilder) Build() (*PresentationDefinition, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if err := b.definition.ValidateSchema(); err != nil {
+		return nil, err
+	}
+
+	return b.definition, nil
+}
+
+func (b *DefinitionBuilder) checkInputDescriptor(descriptor *InputDescriptor) error {
+	if descriptor == nil {
+		return errors.New("input descriptor is nil")
+	}
+
+	if descriptor.ID == "" {
+		return errors.New("id is required")
+	}
+
+	if b.definition.inputDescriptor(descriptor.ID) != nil {
+		return fmt.Errorf("duplicate id: %s", descriptor.ID)
+	}
+
+	if descriptor.Constraints == nil {
+		return nil
+	}
+
+	for i, field := range descriptor.Constraints.Fields {
+		if field == nil || len(field.Path) == 0 {
+			return fmt.Errorf("constraints.fields[%d]: path is required", i)
+		}
+
+		for j, path := range field.Path {
+			if path == "" {
+				return fmt.Errorf("constraints.fields[%d].path[%d]: path is empty", i, j)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *DefinitionBuilder) checkSubmissionRequirement(element string, requirement *SubmissionRequirement) error {
+	if requirement == nil {
+		return &DefinitionError{Element: element, Err: errors.New("submission requirement is nil")}
+	}
+
+	if err := requirement.checkFrom(); err != nil {
+		return &DefinitionError{Element: element, Err: err}
+	}
+
+	if requirement.hasFrom() {
+		opts := newMatchRequirementsOpts(nil)
+		if len(opts.fromDescriptors(requirement, b.definition.InputDescriptors)) != 0 {
+			return nil
+		}
+
+		return &DefinitionError{Element: element, Err: fmt.Errorf("no descriptors for from: %s", requirement.fromName())}
+	}
+
+	if len(requirement.FromNested) == 0 {
+		return &DefinitionError{Element: element, Err: errors.New("from or from_nested is required")}
+	}
+
+	for i, nested := range requirement.FromNested {
+		err := b.checkSubmissionRequirement(fmt.Sprintf("%s.from_nested[%d]", element, i), nested)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+)
+
+func TestDefinitionBuilder(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		pd, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			SetName("Adult check").
+			SetPurpose("To sell you a drink we need to know that you are an adult.").
+			AddInputDescriptor(&InputDescriptor{
+				ID:    "age",
+				Group: []string{"A"},
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path: []string{"$.age"},
+						Filter: &Filter{
+							Type:    &intFilterType,
+							Minimum: 18,
+						},
+					}},
+				},
+			}).
+			AddInputDescriptor(&InputDescriptor{
+				ID:    "name",
+				Group: []string{"B"},
+			}).
+			AddSubmissionRequirement(&SubmissionRequirement{Rule: All, From: "A"}).
+			AddSubmissionRequirement(&SubmissionRequirement{
+				Rule:       Pick,
+				Count:      1,
+				FromNested: []*SubmissionRequirement{{Rule: All, From: "B"}},
+			}).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, "Adult check", pd.Name)
+		require.Len(t, pd.InputDescriptors, 2)
+		require.Len(t, pd.SubmissionRequirements, 2)
+	})
+
+	t.Run("Missing descriptor ID", func(t *testing.T) {
+		pd, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{}).
+			Build()
+
+		require.Nil(t, pd)
+		require.EqualError(t, err, "input_descriptors[0]: id is required")
+
+		var defErr *DefinitionError
+		require.True(t, errors.As(err, &defErr))
+		require.Equal(t, "input_descriptors[0]", defErr.Element)
+	})
+
+	t.Run("Duplicate descriptor ID", func(t *testing.T) {
+		_, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{ID: "age"}).
+			AddInputDescriptor(&InputDescriptor{ID: "age"}).
+			Build()
+
+		require.EqualError(t, err, "input_descriptors[1]: duplicate id: age")
+	})
+
+	t.Run("Empty field path", func(t *testing.T) {
+		_, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{
+				ID:          "age",
+				Constraints: &Constraints{Fields: []*Field{{Path: []string{"$.age"}}, {}}},
+			}).
+			Build()
+
+		require.EqualError(t, err, "input_descriptors[0]: constraints.fields[1]: path is required")
+
+		_, err = NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{
+				ID:          "age",
+				Constraints: &Constraints{Fields: []*Field{{Path: []string{"$.age", ""}}}},
+			}).
+			Build()
+
+		require.EqualError(t, err, "input_descriptors[0]: constraints.fields[0].path[1]: path is empty")
+	})
+
+	t.Run("Unknown group", func(t *testing.T) {
+		_, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{ID: "age", Group: []string{"A"}}).
+			AddSubmissionRequirement(&SubmissionRequirement{
+				Rule: Pick,
+				FromNested: []*SubmissionRequirement{
+					{Rule: All, From: "A"},
+					{Rule: All, From: "teenager"},
+				},
+			}).
+			Build()
+
+		require.EqualError(t, err, "submission_requirements[0].from_nested[1]: no descriptors for from: teenager")
+	})
+
+	t.Run("Missing from", func(t *testing.T) {
+		_, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{ID: "age", Group: []string{"A"}}).
+			AddSubmissionRequirement(&SubmissionRequirement{Rule: All}).
+			Build()
+
+		require.EqualError(t, err, "submission_requirements[0]: from or from_nested is required")
+	})
+
+	t.Run("Both from and from_nested", func(t *testing.T) {
+		_, err := NewDefinitionBuilder().
+			SetID(uuid.New().String()).
+			AddInputDescriptor(&InputDescriptor{ID: "age", Group: []string{"A"}}).
+			AddSubmissionRequirement(&SubmissionRequirement{
+				Rule:       All,
+				From:       "A",
+				FromNested: []*SubmissionRequirement{{Rule: All, From: "A"}},
+			}).
+			Build()
+
+		require.ErrorIs(t, err, ErrFromAndFromNested)
+		require.EqualError(t, err, "submission_requirements[0]: from and from_nested are mutually exclusive")
+	})
+
+	t.Run("Checks schema", func(t *testing.T) {
+		pd, err := NewDefinitionBuilder().
+			AddInputDescriptor(&InputDescriptor{ID: "age"}).
+			Build()
+
+		require.Nil(t, pd)
+		require.EqualError(t, err, "v1: presentation_definition: id is required,"+
+			"presentation_definition.input_descriptors.0: schema is required; "+
+			"v2: presentation_definition: id is required")
+	})
+}
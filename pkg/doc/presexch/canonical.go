@@ -0,0 +1,106 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// CanonicalHash returns the SHA-256 hash of the canonical JSON of the presentation definition, e.g. to key
+// a cache of the matching results or to detect that a verifier changed its request. The canonical JSON has
+// no insignificant whitespace, has the properties of every object sorted by name and has the numbers
+// normalized (e.g. 1 and 1.0 are the same), so the definitions unmarshaled from JSON of a different formatting
+// or property order have the same hash. The numbers are normalized from their literal text, so the integers
+// beyond the precision of float64 (e.g. 2^53 and 2^53+1) are told apart.
+func (pd *PresentationDefinition) CanonicalHash() ([32]byte, error) {
+	data, err := json.Marshal(pd)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("marshal presentation definition: %w", err)
+	}
+
+	var doc interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err = decoder.Decode(&doc); err != nil {
+		return [32]byte{}, fmt.Errorf("unmarshal presentation definition: %w", err)
+	}
+
+	doc, err = canonicalNumbers(doc)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	// the maps are marshaled with the keys sorted.
+	canonical, err := json.Marshal(doc)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("marshal canonical presentation definition: %w", err)
+	}
+
+	return sha256.Sum256(canonical), nil
+}
+
+// canonicalNumbers replaces the numbers of the decoded JSON with their canonical form: the integers (whatever their
+// literal, e.g. 1.0 or 1e0) as plain integers, and the other numbers as exact decimals without trailing zeros.
+func canonicalNumbers(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, e := range value {
+			canonical, err := canonicalNumbers(e)
+			if err != nil {
+				return nil, err
+			}
+
+			value[k] = canonical
+		}
+	case []interface{}:
+		for i, e := range value {
+			canonical, err := canonicalNumbers(e)
+			if err != nil {
+				return nil, err
+			}
+
+			value[i] = canonical
+		}
+	case json.Number:
+		r, ok := new(big.Rat).SetString(value.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s", value)
+		}
+
+		if r.IsInt() {
+			return json.Number(r.Num().String()), nil
+		}
+
+		return json.Number(r.FloatString(decimalDigits(r.Denom()))), nil
+	}
+
+	return v, nil
+}
+
+// decimalDigits returns the number of the decimal digits of the fraction with the given denominator, which is
+// a product of the powers of 2 and 5, as the denominator of a number parsed from JSON is.
+func decimalDigits(denom *big.Int) int {
+	twos := int(denom.TrailingZeroBits())
+
+	fives := 0
+
+	for d, five := new(big.Int).Rsh(denom, uint(twos)), big.NewInt(5); d.BitLen() > 1; d.Quo(d, five) {
+		fives++
+	}
+
+	if twos > fives {
+		return twos
+	}
+
+	return fives
+}
@@ -0,0 +1,280 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+// Clone returns a deep copy of the presentation definition: the slices, maps and pointers it holds (the format,
+// frame, submission requirements and input descriptors along with their constraints, fields, filters and metadata)
+// are copied as well, so that the copy can be changed (e.g. to set a nonce in a filter) without changing
+// the presentation definition, which can be matched concurrently.
+func (pd *PresentationDefinition) Clone() *PresentationDefinition {
+	if pd == nil {
+		return nil
+	}
+
+	c := *pd
+	c.LocalizedNames = copyStringMap(pd.LocalizedNames)
+	c.LocalizedPurposes = copyStringMap(pd.LocalizedPurposes)
+	c.Format = pd.Format.clone()
+	c.Frame = copyMap(pd.Frame)
+
+	if pd.SubmissionRequirements != nil {
+		c.SubmissionRequirements = make([]*SubmissionRequirement, len(pd.SubmissionRequirements))
+		for i, requirement := range pd.SubmissionRequirements {
+			c.SubmissionRequirements[i] = requirement.clone()
+		}
+	}
+
+	if pd.InputDescriptors != nil {
+		c.InputDescriptors = make([]*InputDescriptor, len(pd.InputDescriptors))
+		for i, descriptor := range pd.InputDescriptors {
+			c.InputDescriptors[i] = descriptor.clone()
+		}
+	}
+
+	if pd.SameSubject != nil {
+		c.SameSubject = make([]*SameSubjectGroup, len(pd.SameSubject))
+		for i, group := range pd.SameSubject {
+			if group != nil {
+				c.SameSubject[i] = &SameSubjectGroup{
+					FieldID:   copyStrings(group.FieldID),
+					Directive: copyPreference(group.Directive),
+				}
+			}
+		}
+	}
+
+	return &c
+}
+
+func (sr *SubmissionRequirement) clone() *SubmissionRequirement {
+	if sr == nil {
+		return nil
+	}
+
+	c := *sr
+	c.Format = sr.Format.clone()
+	c.FromGroups = copyStrings(sr.FromGroups)
+
+	if sr.FromNested != nil {
+		c.FromNested = make([]*SubmissionRequirement, len(sr.FromNested))
+		for i, nested := range sr.FromNested {
+			c.FromNested[i] = nested.clone()
+		}
+	}
+
+	return &c
+}
+
+func (id *InputDescriptor) clone() *InputDescriptor {
+	if id == nil {
+		return nil
+	}
+
+	c := *id
+	c.Group = copyStrings(id.Group)
+	c.Metadata = copyMap(id.Metadata)
+	c.Constraints = id.Constraints.clone()
+	c.Format = id.Format.clone()
+	c.Frame = copyMap(id.Frame)
+	c.LocalizedNames = copyStringMap(id.LocalizedNames)
+	c.LocalizedPurposes = copyStringMap(id.LocalizedPurposes)
+
+	if id.Schema != nil {
+		c.Schema = make([]*Schema, len(id.Schema))
+		for i, schema := range id.Schema {
+			if schema != nil {
+				s := *schema
+				c.Schema[i] = &s
+			}
+		}
+	}
+
+	return &c
+}
+
+func (c *Constraints) clone() *Constraints {
+	if c == nil {
+		return nil
+	}
+
+	result := *c
+	result.LimitDisclosure = copyPreference(c.LimitDisclosure)
+	result.Statuses = c.Statuses.clone()
+	result.SubjectIsIssuer = copyPreference(c.SubjectIsIssuer)
+
+	if c.IsHolder != nil {
+		result.IsHolder = make([]*Holder, len(c.IsHolder))
+		for i, holder := range c.IsHolder {
+			if holder != nil {
+				result.IsHolder[i] = &Holder{
+					FieldID:   copyStrings(holder.FieldID),
+					Directive: copyPreference(holder.Directive),
+				}
+			}
+		}
+	}
+
+	if c.Fields != nil {
+		result.Fields = make([]*Field, len(c.Fields))
+		for i, field := range c.Fields {
+			result.Fields[i] = field.clone()
+		}
+	}
+
+	return &result
+}
+
+func (s *Statuses) clone() *Statuses {
+	if s == nil {
+		return nil
+	}
+
+	return &Statuses{
+		Active:    s.Active.clone(),
+		Suspended: s.Suspended.clone(),
+		Revoked:   s.Revoked.clone(),
+	}
+}
+
+func (d *StatusDirective) clone() *StatusDirective {
+	if d == nil {
+		return nil
+	}
+
+	return &StatusDirective{Directive: d.Directive, Type: copyStrings(d.Type)}
+}
+
+func (f *Field) clone() *Field {
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+	c.Path = copyStrings(f.Path)
+	c.Filter = f.Filter.clone()
+	c.Predicate = copyPreference(f.Predicate)
+
+	if f.Filters != nil {
+		c.Filters = make([]*Filter, len(f.Filters))
+		for i, filter := range f.Filters {
+			c.Filters[i] = filter.clone()
+		}
+	}
+
+	return &c
+}
+
+func (f *Filter) clone() *Filter {
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+
+	if f.Type != nil {
+		filterType := *f.Type
+		c.Type = &filterType
+	}
+
+	c.Types = copyStrings(f.Types)
+	c.Minimum = copyValue(f.Minimum)
+	c.Maximum = copyValue(f.Maximum)
+	c.MinLength = copyInt(f.MinLength)
+	c.MaxLength = copyInt(f.MaxLength)
+	c.ExclusiveMinimum = copyValue(f.ExclusiveMinimum)
+	c.ExclusiveMaximum = copyValue(f.ExclusiveMaximum)
+	c.Const = copyValue(f.Const)
+	c.Not = copyMap(f.Not)
+	c.Contains = copyMap(f.Contains)
+	c.MinContains = copyInt(f.MinContains)
+	c.MaxContains = copyInt(f.MaxContains)
+	c.CustomFields = copyMap(f.CustomFields)
+
+	if f.Enum != nil {
+		c.Enum = make([]StrOrInt, len(f.Enum))
+		for i, v := range f.Enum {
+			c.Enum[i] = copyValue(v)
+		}
+	}
+
+	return &c
+}
+
+func (f *Format) clone() *Format {
+	if f == nil {
+		return nil
+	}
+
+	return &Format{
+		Jwt:     f.Jwt.clone(),
+		JwtVC:   f.JwtVC.clone(),
+		JwtVP:   f.JwtVP.clone(),
+		Ldp:     f.Ldp.clone(),
+		LdpVC:   f.LdpVC.clone(),
+		LdpVP:   f.LdpVP.clone(),
+		SdJwt:   f.SdJwt.clone(),
+		DcSdJwt: f.DcSdJwt.clone(),
+		MsoMdoc: f.MsoMdoc.clone(),
+	}
+}
+
+func (t *JwtType) clone() *JwtType {
+	if t == nil {
+		return nil
+	}
+
+	return &JwtType{Alg: copyStrings(t.Alg)}
+}
+
+func (t *LdpType) clone() *LdpType {
+	if t == nil {
+		return nil
+	}
+
+	return &LdpType{ProofType: copyStrings(t.ProofType), Cryptosuite: copyStrings(t.Cryptosuite)}
+}
+
+func (t *SdJwtType) clone() *SdJwtType {
+	if t == nil {
+		return nil
+	}
+
+	return &SdJwtType{SdJwtAlgValues: copyStrings(t.SdJwtAlgValues), KbJwtAlgValues: copyStrings(t.KbJwtAlgValues)}
+}
+
+func copyPreference(p *Preference) *Preference {
+	if p == nil {
+		return nil
+	}
+
+	c := *p
+
+	return &c
+}
+
+func copyInt(i *int) *int {
+	if i == nil {
+		return nil
+	}
+
+	c := *i
+
+	return &c
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+
+	return result
+}
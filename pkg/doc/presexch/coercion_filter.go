@@ -0,0 +1,95 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+const (
+	filterTypeString  = "string"
+	filterTypeNumber  = "number"
+	filterTypeInteger = "integer"
+)
+
+// coercionType returns the type the values compared by the filter are coerced to with WithTypeCoercion:
+// string, number or integer. The date filters are not coerced, as their bounds are compared as dates, and neither
+// are the filters allowing several types, as the type to coerce to is ambiguous.
+func (f *Filter) coercionType() string {
+	if f == nil || f.Type == nil || f.Types != nil || f.isDateFilter() {
+		return ""
+	}
+
+	switch *f.Type {
+	case filterTypeString, filterTypeNumber, filterTypeInteger:
+		return *f.Type
+	default:
+		return ""
+	}
+}
+
+// coerced returns a copy of the filter with const, enum and the numeric bounds coerced to the type of the filter,
+// e.g. {"type": "number", "const": "21"} is compared as {"type": "number", "const": 21}.
+func (f *Filter) coerced() *Filter {
+	typ := f.coercionType()
+	if typ == "" {
+		return f
+	}
+
+	filter := *f
+	filter.Const = coerceValue(typ, f.Const)
+
+	if f.Enum != nil {
+		filter.Enum = make([]StrOrInt, len(f.Enum))
+		for i, value := range f.Enum {
+			filter.Enum[i] = coerceValue(typ, value)
+		}
+	}
+
+	if typ != filterTypeString {
+		for _, bound := range []*StrOrInt{
+			&filter.Minimum, &filter.Maximum, &filter.ExclusiveMinimum, &filter.ExclusiveMaximum,
+		} {
+			*bound = coerceValue(typ, *bound)
+		}
+	}
+
+	return &filter
+}
+
+// coerceValue converts the value to the type if it is a scalar encoding a value of the type: a numeric string
+// to a number (a whole one for integer) or a number to its string. The other values are returned as is, for
+// the JSON schema of the filter to reject them.
+func coerceValue(typ string, value interface{}) interface{} {
+	switch typ {
+	case filterTypeNumber, filterTypeInteger:
+		str, ok := value.(string)
+		if !ok {
+			return value
+		}
+
+		number, err := strconv.ParseFloat(str, 64)
+		if err != nil || math.IsInf(number, 0) || (typ == filterTypeInteger && number != math.Trunc(number)) {
+			return value
+		}
+
+		return number
+	case filterTypeString:
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case int:
+			return strconv.Itoa(v)
+		case json.Number:
+			return v.String()
+		}
+	}
+
+	return value
+}
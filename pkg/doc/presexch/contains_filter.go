@@ -0,0 +1,100 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// hasContainsBounds checks whether the filter bounds the number of array elements matching the contains subschema
+// with minContains or maxContains. The bounds are ignored if contains is not set.
+func (f *Filter) hasContainsBounds() bool {
+	return f != nil && f.Contains != nil && (f.MinContains != nil || f.MaxContains != nil)
+}
+
+// withoutContains returns a copy of the filter without contains, minContains and maxContains.
+// gojsonschema supports JSON Schema up to draft-07 which has no minContains and maxContains, so contains
+// (which requires at least one matching element) is checked along with the bounds by validateContains instead.
+func (f *Filter) withoutContains() *Filter {
+	filter := *f
+	filter.Contains = nil
+	filter.MinContains = nil
+	filter.MaxContains = nil
+
+	return &filter
+}
+
+// validateContains checks that the number of the array elements matching the contains subschema is within
+// minContains (defaults to 1) and maxContains. The value which is not an array is not applicable.
+func validateContains(f *Filter, value interface{}, opts *matchRequirementsOpts) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: value is not an array", errPathNotApplicable)
+	}
+
+	schema, err := opts.containsSchema(f)
+	if err != nil {
+		return fmt.Errorf("filter contains: %w", err)
+	}
+
+	var count int
+
+	for _, item := range items {
+		result, err := schema.Validate(gojsonschema.NewGoLoader(item))
+		if err != nil {
+			return fmt.Errorf("%w: %s", errPathNotApplicable, err)
+		}
+
+		if result.Valid() {
+			count++
+		}
+	}
+
+	minContains := 1
+	if f.MinContains != nil {
+		minContains = *f.MinContains
+	}
+
+	if count < minContains {
+		return fmt.Errorf("%w: %d array items match contains, minContains is %d",
+			errPathNotApplicable, count, minContains)
+	}
+
+	if f.MaxContains != nil && count > *f.MaxContains {
+		return fmt.Errorf("%w: %d array items match contains, maxContains is %d",
+			errPathNotApplicable, count, *f.MaxContains)
+	}
+
+	return nil
+}
+
+// containsSchema returns the compiled contains subschema of the filter (or the error compiling it), compiling it
+// on the first use, as filterSchema does for the filter itself.
+func (opts *matchRequirementsOpts) containsSchema(f *Filter) (*gojsonschema.Schema, error) {
+	opts.schemasMu.Lock()
+
+	compiled, ok := opts.containsSchemas[f]
+	if !ok {
+		compiled = &compiledFilter{}
+
+		if opts.containsSchemas == nil {
+			opts.containsSchemas = make(map[*Filter]*compiledFilter)
+		}
+
+		opts.containsSchemas[f] = compiled
+	}
+
+	opts.schemasMu.Unlock()
+
+	compiled.once.Do(func() {
+		compiled.schema, compiled.err = newFilterSchema(f.containsSchema(), opts.loadFilterRef)
+	})
+
+	return compiled.schema, compiled.err
+}
@@ -0,0 +1,97 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"sync"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// ContextCache caches the parsed JSON-LD contexts (and the IRIs of the types they define) used to check
+// the input descriptor schemas, so that they are not loaded and parsed on every match.
+// It is safe for concurrent use and can be shared across presentation definitions. The zero value is ready to use.
+type ContextCache struct {
+	mu       sync.RWMutex
+	contexts map[string]*ld.Context
+	types    map[typeKey][]string
+}
+
+type typeKey struct {
+	contextURI string
+	typ        string
+}
+
+// NewContextCache creates a new instance of ContextCache.
+func NewContextCache() *ContextCache {
+	return &ContextCache{
+		contexts: make(map[string]*ld.Context),
+		types:    make(map[typeKey][]string),
+	}
+}
+
+// context returns the parsed context, resolving it if it is not cached. The cache is not used if it is nil.
+func (c *ContextCache) context(contextURI string, resolve SchemaResolver) (*ld.Context, error) {
+	if c == nil {
+		return resolve(contextURI)
+	}
+
+	c.mu.RLock()
+	ctxObj, ok := c.contexts[contextURI]
+	c.mu.RUnlock()
+
+	if ok {
+		return ctxObj, nil
+	}
+
+	ctxObj, err := resolve(contextURI)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.contexts == nil {
+		c.contexts = make(map[string]*ld.Context)
+	}
+
+	c.contexts[contextURI] = ctxObj
+	c.mu.Unlock()
+
+	return ctxObj, nil
+}
+
+// typeIDs returns the IRIs of the type defined by the context. The cache is not used if it is nil.
+func (c *ContextCache) typeIDs(contextURI, typ string, ctxObj *ld.Context) ([]string, error) {
+	if c == nil {
+		return typeFoundInContext(typ, ctxObj)
+	}
+
+	key := typeKey{contextURI: contextURI, typ: typ}
+
+	c.mu.RLock()
+	ids, ok := c.types[key]
+	c.mu.RUnlock()
+
+	if ok {
+		return ids, nil
+	}
+
+	ids, err := typeFoundInContext(typ, ctxObj)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.types == nil {
+		c.types = make(map[typeKey][]string)
+	}
+
+	c.types[key] = ids
+	c.mu.Unlock()
+
+	return ids, nil
+}
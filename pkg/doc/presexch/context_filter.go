@@ -0,0 +1,34 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+// contextPaths are the paths selecting the JSON-LD context of the credential (joined by "."), including the one
+// of the vc claim of the JWT credentials.
+var contextPaths = map[string]struct{}{ // nolint: gochecknoglobals
+	"@context":    {},
+	"vc.@context": {},
+}
+
+// isContextPath checks whether the path is $['@context'] or $.vc['@context'] (in the dot or the bracket notation).
+func isContextPath(path string) bool {
+	names, ok := simplePath(path)
+	if !ok {
+		return false
+	}
+
+	_, ok = contextPaths[names]
+
+	return ok
+}
+
+// contextValues returns the values a filter on the JSON-LD context is validated against when the credential
+// serializes a single context as a string: the string itself and the string wrapped into an array, so that both
+// a filter on the string (e.g. {"const": "..."}) and a filter on the contexts (e.g. {"contains": {"const": "..."}})
+// apply whether the credential has one context or several, the embedded contexts included.
+func contextValues(context string) []interface{} {
+	return []interface{}{context, []interface{}{context}}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"github.com/hyperledger/aries-framework-go/pkg/doc/sdjwt/common"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+func copyCredentials(credentials []*verifiable.Credential) []*verifiable.Credential {
+	if credentials == nil {
+		return nil
+	}
+
+	result := make([]*verifiable.Credential, len(credentials))
+	for i, credential := range credentials {
+		result[i] = copyCredential(credential)
+	}
+
+	return result
+}
+
+// copyCredential returns a deep copy of the credential. The JSON-like values (maps, slices, subjects,
+// custom fields) are copied recursively, while the values of other types (e.g. custom subject structs)
+// are copied as is.
+func copyCredential(vc *verifiable.Credential) *verifiable.Credential {
+	if vc == nil {
+		return nil
+	}
+
+	c := *vc
+
+	c.Context = copyStrings(vc.Context)
+	c.Types = copyStrings(vc.Types)
+	c.Subject = copyValue(vc.Subject)
+	c.Issuer = verifiable.Issuer{ID: vc.Issuer.ID, CustomFields: copyCustomFields(vc.Issuer.CustomFields)}
+	c.Issued = copyTime(vc.Issued)
+	c.Expired = copyTime(vc.Expired)
+	c.Evidence = copyValue(vc.Evidence)
+	c.Schemas = copyTypedIDs(vc.Schemas)
+	c.TermsOfUse = copyTypedIDs(vc.TermsOfUse)
+	c.RefreshService = copyTypedIDs(vc.RefreshService)
+	c.CustomFields = copyCustomFields(vc.CustomFields)
+
+	if vc.CustomContext != nil {
+		c.CustomContext = copySlice(vc.CustomContext)
+	}
+
+	if vc.Proofs != nil {
+		c.Proofs = make([]verifiable.Proof, len(vc.Proofs))
+		for i, proof := range vc.Proofs {
+			c.Proofs[i] = copyMap(proof)
+		}
+	}
+
+	if vc.Status != nil {
+		status := copyTypedID(*vc.Status)
+		c.Status = &status
+	}
+
+	if vc.SDJWTDisclosures != nil {
+		c.SDJWTDisclosures = make([]*common.DisclosureClaim, len(vc.SDJWTDisclosures))
+
+		for i, disclosure := range vc.SDJWTDisclosures {
+			if disclosure == nil {
+				continue
+			}
+
+			d := *disclosure
+			d.Value = copyValue(disclosure.Value)
+			c.SDJWTDisclosures[i] = &d
+		}
+	}
+
+	return &c
+}
+
+// nolint: gocyclo
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return copyMap(val)
+	case []interface{}:
+		return copySlice(val)
+	case []string:
+		return copyStrings(val)
+	case verifiable.CustomFields:
+		return copyCustomFields(val)
+	case verifiable.Subject:
+		return copySubject(val)
+	case *verifiable.Subject:
+		if val == nil {
+			return val
+		}
+
+		subject := copySubject(*val)
+
+		return &subject
+	case []verifiable.Subject:
+		subjects := make([]verifiable.Subject, len(val))
+		for i, subject := range val {
+			subjects[i] = copySubject(subject)
+		}
+
+		return subjects
+	default:
+		return v
+	}
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = copyValue(v)
+	}
+
+	return result
+}
+
+func copySlice(s []interface{}) []interface{} {
+	if s == nil {
+		return nil
+	}
+
+	result := make([]interface{}, len(s))
+	for i, v := range s {
+		result[i] = copyValue(v)
+	}
+
+	return result
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string{}, s...)
+}
+
+func copyCustomFields(cf verifiable.CustomFields) verifiable.CustomFields {
+	if cf == nil {
+		return nil
+	}
+
+	return copyMap(cf)
+}
+
+func copySubject(subject verifiable.Subject) verifiable.Subject {
+	return verifiable.Subject{ID: subject.ID, CustomFields: copyCustomFields(subject.CustomFields)}
+}
+
+func copyTypedID(typedID verifiable.TypedID) verifiable.TypedID {
+	return verifiable.TypedID{ID: typedID.ID, Type: typedID.Type, CustomFields: copyCustomFields(typedID.CustomFields)}
+}
+
+func copyTypedIDs(typedIDs []verifiable.TypedID) []verifiable.TypedID {
+	if typedIDs == nil {
+		return nil
+	}
+
+	result := make([]verifiable.TypedID, len(typedIDs))
+	for i, typedID := range typedIDs {
+		result[i] = copyTypedID(typedID)
+	}
+
+	return result
+}
+
+func copyTime(t *util.TimeWrapper) *util.TimeWrapper {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+
+	return &c
+}
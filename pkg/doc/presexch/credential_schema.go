@@ -0,0 +1,151 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// jsonSchemaTypes are the types of the credentialSchema whose document is the JSON schema the credential
+// conforms to (VC Data Model 2.0 and VC JSON Schema).
+var jsonSchemaTypes = map[string]struct{}{ // nolint: gochecknoglobals
+	"JsonSchema":     {},
+	"JsonSchema2023": {},
+}
+
+// CredentialSchemaLoader loads the JSON schema document identified by the id of the credentialSchema
+// of a credential, e.g. with an HTTP client applying its own policy (allowed hosts, timeouts, caching).
+type CredentialSchemaLoader func(id string) ([]byte, error)
+
+// WithCredentialSchemaMatch matches the input descriptor schemas against the ids of the credentialSchema of
+// the credentials instead of the types defined by their JSON-LD contexts: a schema URI is satisfied by
+// the credential with a credentialSchema of the same id. Unless the loader is nil, the credential must also
+// conform to the JSON schema of its matched credentialSchema of the JsonSchema (or JsonSchema2023) type.
+// The external $refs of the JSON schemas are loaded with the loader as well. The schemas the loader fails to load
+// are not validated, and each schema is loaded once per match.
+func WithCredentialSchemaMatch(loader CredentialSchemaLoader) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.credentialSchemaMatch = true
+		opts.loadCredentialSchema = loader
+	}
+}
+
+// filterCredentialSchema works like filterSchema, but matches the schema URIs against the credentialSchema ids.
+func filterCredentialSchema(schemas []*Schema, credentials []*verifiable.Credential,
+	opts *matchRequirementsOpts) []*verifiable.Credential {
+	var result []*verifiable.Credential
+
+	for _, credential := range credentials {
+		schemaSatisfied := map[string]struct{}{}
+
+		for _, credentialSchema := range credential.Schemas {
+			if !schemaURIsContain(schemas, credentialSchema.ID) {
+				continue
+			}
+
+			if err := validateCredentialSchema(credential, credentialSchema, opts); err != nil {
+				opts.log().Debugf("credential %s does not conform to schema %s: %s", credential.ID, credentialSchema.ID, err)
+
+				continue
+			}
+
+			schemaSatisfied[credentialSchema.ID] = struct{}{}
+		}
+
+		if schemasSatisfied(schemas, schemaSatisfied) {
+			result = append(result, credential)
+		}
+	}
+
+	return result
+}
+
+func schemaURIsContain(schemas []*Schema, uri string) bool {
+	for _, schema := range schemas {
+		if schema.URI == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateCredentialSchema validates the credential against the JSON schema of its credentialSchema, if it has
+// a JSON schema type and the loader loads it.
+func validateCredentialSchema(credential *verifiable.Credential, credentialSchema verifiable.TypedID,
+	opts *matchRequirementsOpts) error {
+	if _, ok := jsonSchemaTypes[credentialSchema.Type]; !ok || opts.loadCredentialSchema == nil {
+		return nil
+	}
+
+	schema, err := opts.credentialSchema(credentialSchema.ID)
+	if err != nil {
+		opts.log().Debugf("load credential schema %s: %s", credentialSchema.ID, err)
+
+		return nil
+	}
+
+	if credential.SDJWTHashAlg != "" {
+		credential, err = credential.CreateDisplayCredential(verifiable.DisplayAllDisclosures())
+		if err != nil {
+			return fmt.Errorf("create display credential: %w", err)
+		}
+	}
+
+	src, err := marshalWithoutJWT(credential)
+	if err != nil {
+		return err
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(src))
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid() {
+		errs := make([]string, len(result.Errors()))
+		for i, resultError := range result.Errors() {
+			errs[i] = resultError.String()
+		}
+
+		return fmt.Errorf("%s", strings.Join(errs, ","))
+	}
+
+	return nil
+}
+
+// compileCredentialSchema loads the JSON schema of the credentialSchema id, along with the documents of its
+// external $refs, with the loader and compiles it.
+func compileCredentialSchema(id string, loader CredentialSchemaLoader) (*gojsonschema.Schema, error) {
+	documentURL, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("credential schema id %s: %w", id, err)
+	}
+
+	documentURL.Fragment = ""
+	documentURL.RawFragment = ""
+
+	raw, err := loader(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+
+	if err = json.Unmarshal(raw, &document); err != nil {
+		return nil, fmt.Errorf("unmarshal credential schema %s: %w", id, err)
+	}
+
+	return compileSchema(document, documentURL, FilterRefLoader(loader))
+}
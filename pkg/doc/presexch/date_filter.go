@@ -0,0 +1,114 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// FilterFormatDate is the JSON Schema format of full-date values (e.g. "2022-01-31").
+	FilterFormatDate = "date"
+	// FilterFormatDateTime is the JSON Schema format of date-time values (e.g. "2022-01-31T10:00:00+02:00").
+	FilterFormatDateTime = "date-time"
+
+	dateLayout = "2006-01-02"
+)
+
+// isDateFilter checks whether the filter compares dates, i.e. it has the "date" or "date-time" format
+// and at least one of minimum, maximum, exclusiveMinimum or exclusiveMaximum is a string.
+func (f *Filter) isDateFilter() bool {
+	if f == nil || (f.Format != FilterFormatDate && f.Format != FilterFormatDateTime) {
+		return false
+	}
+
+	for _, bound := range []StrOrInt{f.Minimum, f.Maximum, f.ExclusiveMinimum, f.ExclusiveMaximum} {
+		if _, ok := bound.(string); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withoutDateBounds returns a copy of the date filter without the format and the string bounds, so that
+// JSON Schema validation neither compares the dates lexically nor rejects full-dates of "date-time" format.
+// The format and the bounds are checked by validateDateRange instead.
+func (f *Filter) withoutDateBounds() *Filter {
+	filter := *f
+	filter.Format = ""
+
+	for _, bound := range []*StrOrInt{
+		&filter.Minimum, &filter.Maximum, &filter.ExclusiveMinimum, &filter.ExclusiveMaximum,
+	} {
+		if _, ok := (*bound).(string); ok {
+			*bound = nil
+		}
+	}
+
+	return &filter
+}
+
+// validateDateRange checks that the date value is within the bounds of the date filter.
+//
+// Both the value and the bounds may be RFC3339 date-times or full-dates; a full-date is treated as
+// the midnight of that day in UTC. The values are normalized to UTC before comparison, so date-times
+// with different offsets are compared by the instant they represent rather than lexically.
+func validateDateRange(f *Filter, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%w: date value is not a string", errPathNotApplicable)
+	}
+
+	date, err := parseDate(str)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPathNotApplicable, err)
+	}
+
+	checks := []struct {
+		name  string
+		bound StrOrInt
+		valid func(date, bound time.Time) bool
+	}{
+		{name: "minimum", bound: f.Minimum, valid: func(d, b time.Time) bool { return !d.Before(b) }},
+		{name: "maximum", bound: f.Maximum, valid: func(d, b time.Time) bool { return !d.After(b) }},
+		{name: "exclusiveMinimum", bound: f.ExclusiveMinimum, valid: func(d, b time.Time) bool { return d.After(b) }},
+		{name: "exclusiveMaximum", bound: f.ExclusiveMaximum, valid: func(d, b time.Time) bool { return d.Before(b) }},
+	}
+
+	for _, check := range checks {
+		boundStr, ok := check.bound.(string)
+		if !ok {
+			continue
+		}
+
+		bound, err := parseDate(boundStr)
+		if err != nil {
+			return fmt.Errorf("filter %s: %w", check.name, err)
+		}
+
+		if !check.valid(date, bound) {
+			return fmt.Errorf("%w: date %s does not satisfy %s %s", errPathNotApplicable, str, check.name, boundStr)
+		}
+	}
+
+	return nil
+}
+
+// parseDate parses RFC3339 date-time or full-date and normalizes it to UTC.
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
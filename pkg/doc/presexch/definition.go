@@ -8,15 +8,16 @@ package presexch
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
-	"github.com/PaesslerAG/jsonpath"
-	"github.com/google/uuid"
-	jsonpathkeys "github.com/kawamuray/jsonpath"
 	"github.com/piprate/json-gold/ld"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
@@ -26,7 +27,9 @@ import (
 	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/sdjwt/common"
+	jsonutil "github.com/hyperledger/aries-framework-go/pkg/doc/util/json"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	spi "github.com/hyperledger/aries-framework-go/spi/log"
 )
 
 const (
@@ -41,10 +44,21 @@ const (
 	// Preferred predicate`s value.
 	Preferred Preference = "preferred"
 
+	// DirectiveRequired status directive`s value.
+	DirectiveRequired Directive = "required"
+	// DirectiveAllowed status directive`s value.
+	DirectiveAllowed Directive = "allowed"
+	// DirectiveDisallowed status directive`s value.
+	DirectiveDisallowed Directive = "disallowed"
+
 	tmpEnding = "tmp_unique_id_"
 
 	credentialSchema = "credentialSchema"
 
+	credentialsV2Context = "https://www.w3.org/ns/credentials/v2"
+
+	dataIntegrityProofType = "DataIntegrityProof"
+
 	// FormatJWT presentation exchange format.
 	FormatJWT = "jwt"
 	// FormatJWTVC presentation exchange format.
@@ -57,10 +71,101 @@ const (
 	FormatLDPVC = "ldp_vc"
 	// FormatLDPVP presentation exchange format.
 	FormatLDPVP = "ldp_vp"
+	// FormatSDJWT presentation exchange format.
+	FormatSDJWT = "vc+sd-jwt"
+	// FormatDCSDJWT presentation exchange format.
+	FormatDCSDJWT = "dc+sd-jwt"
 )
 
 var errPathNotApplicable = errors.New("path not applicable")
 
+// ErrPredicateNotSupported is the rejection reason of SD-JWT credentials matched by an input descriptor with
+// a required predicate. Unlike BBS+, the disclosed values of SD-JWT are signed by the issuer, so the value can not
+// be replaced with true, and the credential is rejected rather than disclosing the value.
+var ErrPredicateNotSupported = errors.New("predicate is not supported by SD-JWT credentials")
+
+// ErrSelectiveDisclosureNotSupported is the rejection reason of the signed credentials which would be limited
+// (by limit_disclosure or a predicate) without a proof, with WithVerifiableDisclosure. Only the BBS+
+// (BbsBlsSignature2020) proofs are derived for the limited JSON-LD credentials, and not for the predicates.
+var ErrSelectiveDisclosureNotSupported = errors.New("selective disclosure is not supported by the credential proof")
+
+// ErrDisclosureNotVerified is returned with WithPostDisclosureVerification when a limited credential no longer
+// satisfies the fields it discloses.
+var ErrDisclosureNotVerified = errors.New("limited credential does not satisfy the disclosed fields")
+
+// ErrUnparsableJWT is returned with WithStrictJWTParsing when the JWT of a credential cannot be parsed.
+var ErrUnparsableJWT = errors.New("credential JWT cannot be parsed")
+
+// ErrFromAndFromNested is wrapped by the *DefinitionError of a submission requirement with both from and
+// from_nested, which are mutually exclusive.
+var ErrFromAndFromNested = errors.New("from and from_nested are mutually exclusive")
+
+// MatchTimeoutError is returned when the context set by WithContext is done before the credentials are matched.
+// It wraps the error of the context, e.g. context.DeadlineExceeded.
+type MatchTimeoutError struct {
+	Err error
+}
+
+// Error returns the error message.
+func (e *MatchTimeoutError) Error() string {
+	return fmt.Sprintf("match credentials: %s", e.Err)
+}
+
+// Unwrap returns the error of the context.
+func (e *MatchTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// FilterValueError is returned when a filter is unmarshaled with a value which is not a scalar (a string, a number
+// or a boolean) where the filter keyword requires one, e.g. an object as the minimum.
+type FilterValueError struct {
+	// Keyword is the filter keyword of the value, e.g. "maximum" or "formatMinimum".
+	Keyword string
+	Value   interface{}
+}
+
+// Error returns the error message.
+func (e *FilterValueError) Error() string {
+	return fmt.Sprintf("filter %s: expected a string, a number or a boolean, got %T", e.Keyword, e.Value)
+}
+
+// DefaultMaxNestingDepth is the default maximum depth of the nested submission requirements, see WithMaxNestingDepth.
+const DefaultMaxNestingDepth = 10
+
+// NestingDepthError is returned when the submission requirements of a presentation definition are nested deeper
+// than the maximum depth.
+type NestingDepthError struct {
+	MaxDepth int
+}
+
+// Error returns the error message.
+func (e *NestingDepthError) Error() string {
+	return fmt.Sprintf("submission requirements are nested deeper than %d levels", e.MaxDepth)
+}
+
+// ContextLoadError is returned when a JSON-LD context of a credential cannot be loaded (or resolved by
+// the SchemaResolver) to check the input descriptor schemas, e.g. if the document loader is nil or misconfigured.
+// See WithLenientSchema to match the types by name instead.
+type ContextLoadError struct {
+	ContextURI string
+	Err        error
+}
+
+// Error returns the error message.
+func (e *ContextLoadError) Error() string {
+	return fmt.Sprintf("load context %s: %s", e.ContextURI, e.Err)
+}
+
+// Unwrap returns the error loading the context.
+func (e *ContextLoadError) Unwrap() error {
+	return e.Err
+}
+
+// ErrContextRefused is returned (wrapped) by a SchemaResolver refusing to resolve a context by policy, e.g. a context
+// of a host which is not allowed. The credentials with a refused context are rejected (with a ContextLoadError
+// wrapping it) without failing the match, and are not matched by type names with WithLenientSchema.
+var ErrContextRefused = errors.New("context refused")
+
 var logger = log.New("doc/presexch")
 
 type (
@@ -68,6 +173,8 @@ type (
 	Selection string
 	// Preference can be "required" or "preferred".
 	Preference string
+	// Directive can be "required", "allowed" or "disallowed".
+	Directive string
 	// StrOrInt type that defines string or integer.
 	StrOrInt interface{}
 )
@@ -80,6 +187,14 @@ func (v *Preference) isRequired() bool {
 	return *v == Required
 }
 
+func (v *Preference) isPreferred() bool {
+	if v == nil {
+		return false
+	}
+
+	return *v == Preferred
+}
+
 // Format describes PresentationDefinition`s Format field.
 type Format struct {
 	Jwt   *JwtType `json:"jwt,omitempty"`
@@ -88,11 +203,19 @@ type Format struct {
 	Ldp   *LdpType `json:"ldp,omitempty"`
 	LdpVC *LdpType `json:"ldp_vc,omitempty"`
 	LdpVP *LdpType `json:"ldp_vp,omitempty"`
+	// SdJwt is the designation of SD-JWT VCs (credentials with a non-empty SDJWTHashAlg).
+	SdJwt *SdJwtType `json:"vc+sd-jwt,omitempty"`
+	// DcSdJwt is the designation of SD-JWT VCs under the "dc+sd-jwt" identifier.
+	DcSdJwt *SdJwtType `json:"dc+sd-jwt,omitempty"`
+	// MsoMdoc is the designation of the mdoc credentials (see NewMdocCredential) by the algorithm
+	// of their issuer signature.
+	MsoMdoc *JwtType `json:"mso_mdoc,omitempty"`
 }
 
 func (f *Format) notNil() bool {
 	return f != nil &&
-		(f.Jwt != nil || f.JwtVC != nil || f.JwtVP != nil || f.Ldp != nil || f.LdpVC != nil || f.LdpVP != nil)
+		(f.Jwt != nil || f.JwtVC != nil || f.JwtVP != nil || f.Ldp != nil || f.LdpVC != nil || f.LdpVP != nil ||
+			f.SdJwt != nil || f.DcSdJwt != nil || f.MsoMdoc != nil)
 }
 
 // JwtType contains alg.
@@ -100,9 +223,23 @@ type JwtType struct {
 	Alg []string `json:"alg,omitempty"`
 }
 
+// SdJwtType contains the algorithms of SD-JWT format.
+// If SdJwtAlgValues is empty, SD-JWT signed by any algorithm is accepted. KbJwtAlgValues is informational
+// only, since the key binding JWT is created when the credential is presented.
+type SdJwtType struct {
+	SdJwtAlgValues []string `json:"sd-jwt_alg_values,omitempty"`
+	KbJwtAlgValues []string `json:"kb-jwt_alg_values,omitempty"`
+}
+
 // LdpType contains proof_type.
+//
+// The Data Integrity proofs (of the DataIntegrityProof type) are told apart by their cryptosuite: they are matched
+// by the DataIntegrityProof type or by the name of their cryptosuite (e.g. eddsa-rdfc-2022) in ProofType.
+// Cryptosuite restricts the Data Integrity proofs to the ones of the listed cryptosuites, and matches them on its
+// own if ProofType is empty. The other proofs are matched by their type only.
 type LdpType struct {
-	ProofType []string `json:"proof_type,omitempty"`
+	ProofType   []string `json:"proof_type,omitempty"`
+	Cryptosuite []string `json:"cryptosuite,omitempty"`
 }
 
 // PresentationDefinition presentation definitions (https://identity.foundation/presentation-exchange/).
@@ -114,6 +251,10 @@ type PresentationDefinition struct {
 	// Purpose describes the purpose for which the Presentation Definition’s inputs are being requested.
 	Purpose string `json:"purpose,omitempty"`
 	Locale  string `json:"locale,omitempty"`
+	// LocalizedNames and LocalizedPurposes map the locales (e.g. "en" or "fr-CA") to the translations of Name
+	// and Purpose, see the LocalizedName and LocalizedPurpose methods.
+	LocalizedNames    map[string]string `json:"localized_name,omitempty"`
+	LocalizedPurposes map[string]string `json:"localized_purpose,omitempty"`
 	// Format is an object with one or more properties matching the registered Claim Format Designations
 	// (jwt, jwt_vc, jwt_vp, etc.) to inform the Holder of the claim format configurations the Verifier can process.
 	Format *Format `json:"format,omitempty"`
@@ -123,6 +264,9 @@ type PresentationDefinition struct {
 	// If not present, all inputs listed in the InputDescriptors array are required for submission.
 	SubmissionRequirements []*SubmissionRequirement `json:"submission_requirements,omitempty"`
 	InputDescriptors       []*InputDescriptor       `json:"input_descriptors,omitempty"`
+	// SameSubject requires the credentials submitted for the input descriptors of the grouped fields
+	// to be about the same subject.
+	SameSubject []*SameSubjectGroup `json:"same_subject,omitempty"`
 }
 
 // SubmissionRequirement describes input that must be submitted via a Presentation Submission
@@ -136,6 +280,12 @@ type SubmissionRequirement struct {
 	Max        int                      `json:"max,omitempty"`
 	From       string                   `json:"from,omitempty"`
 	FromNested []*SubmissionRequirement `json:"from_nested,omitempty"`
+	// FromGroups holds the array form of from, an extension of Presentation Exchange selecting the input
+	// descriptors of any of the groups (see WithFromGroupLists).
+	FromGroups []string `json:"-"`
+	// Format overrides the definition format for the input descriptors reached through the requirement
+	// (including the nested ones), unless the input descriptor has its own format.
+	Format *Format `json:"format,omitempty"`
 }
 
 // InputDescriptor input descriptors.
@@ -148,6 +298,12 @@ type InputDescriptor struct {
 	Schema      []*Schema              `json:"schema,omitempty"`
 	Constraints *Constraints           `json:"constraints,omitempty"`
 	Format      *Format                `json:"format,omitempty"`
+	// Frame is used for JSON-LD document framing of the credentials matched against the input descriptor.
+	// It takes precedence over the Frame of the presentation definition.
+	Frame map[string]interface{} `json:"frame,omitempty"`
+	// LocalizedNames and LocalizedPurposes map the locales to the translations of Name and Purpose.
+	LocalizedNames    map[string]string `json:"localized_name,omitempty"`
+	LocalizedPurposes map[string]string `json:"localized_purpose,omitempty"`
 }
 
 // Schema input descriptor schema.
@@ -162,49 +318,232 @@ type Holder struct {
 	Directive *Preference `json:"directive,omitempty"`
 }
 
+// StatusDirective describes Statuses`s status object.
+type StatusDirective struct {
+	Directive Directive `json:"directive,omitempty"`
+	Type      []string  `json:"type,omitempty"`
+}
+
+// Statuses describes Constraints`s statuses object.
+type Statuses struct {
+	Active    *StatusDirective `json:"active,omitempty"`
+	Suspended *StatusDirective `json:"suspended,omitempty"`
+	Revoked   *StatusDirective `json:"revoked,omitempty"`
+}
+
 // Constraints describes InputDescriptor`s Constraints field.
 type Constraints struct {
 	LimitDisclosure *Preference `json:"limit_disclosure,omitempty"`
+	Statuses        *Statuses   `json:"statuses,omitempty"`
 	SubjectIsIssuer *Preference `json:"subject_is_issuer,omitempty"`
 	IsHolder        []*Holder   `json:"is_holder,omitempty"`
 	Fields          []*Field    `json:"fields,omitempty"`
 }
 
 // Field describes Constraints`s Fields field.
+//
+// Filters, unlike Filter, are aligned with the Path: Path[i] is validated against Filters[i], e.g. to require
+// one path to be a URI and another one to be an integer, and the field is satisfied if any path is. The paths
+// beyond the Filters are validated against Filter, and the Filters beyond the paths are ignored.
+//
+// The paths selecting the issuer ID ($.issuer and $.issuer.id, or $.vc.issuer and $.vc.issuer.id of the JWT
+// claims) fall back to it when they do not select a string, whether the issuer is serialized as a string or as
+// an object, e.g. to allow-list the trusted issuers with
+// {"path": ["$.issuer"], "filter": {"type": "string", "enum": ["did:example:issuer1", "did:example:issuer2"]}}.
+// A filter on the value the path selects (e.g. {"type": "object"} on $.issuer) is applied to it first.
+//
+// The paths of the JWT credentials (jwt_vc, and SD-JWT) select the values either in the credential
+// (e.g. $.credentialSubject.name) or in its JWT claims: in the vc claim (e.g. $.vc.credentialSubject.name or
+// $['vc']['credentialSubject']['name']), and the registered iss, sub, exp, nbf and jti claims (e.g. $.exp,
+// with the NumericDate value). The claims are set from the credential however it was parsed, and the JWT claims
+// selected by a disclosed field are disclosed as the credential properties they are set from (e.g. $.exp as
+// $.expirationDate).
+//
+// An optional field (Presentation Exchange v2) does not reject the credentials it is not satisfied by, i.e. whose
+// paths select no value or no value valid against the filter. The field is disclosed only if it is satisfied:
+// with limit_disclosure, the credential is limited without the unsatisfied optional fields.
+//
+// A derived field (an extension of Presentation Exchange) validates the filter against the value derived from
+// the one selected by the path, e.g. the age of a birthdate (see DeriveAge), and discloses the result only:
+// the value is replaced with true as with a required predicate, while the SD-JWT and mdoc credentials, whose
+// values are signed by the issuer, disclose the claim issued with the result instead (e.g. age_over_18), and are
+// rejected if they have none.
+//
+// Name is the human-readable label of the field (e.g. "Date of birth"), shown by wallets instead of the path,
+// and carried to the disclosure previews and audit records of the fields.
 type Field struct {
 	Path           []string    `json:"path,omitempty"`
 	ID             string      `json:"id,omitempty"`
+	Name           string      `json:"name,omitempty"`
 	Purpose        string      `json:"purpose,omitempty"`
 	Filter         *Filter     `json:"filter,omitempty"`
+	Filters        []*Filter   `json:"filters,omitempty"`
 	Predicate      *Preference `json:"predicate,omitempty"`
 	IntentToRetain bool        `json:"intent_to_retain,omitempty"`
+	Optional       bool        `json:"optional,omitempty"`
+	Derive         string      `json:"derive,omitempty"`
+}
+
+// pathFilter returns the filter the i-th path of the field is validated against.
+func (f *Field) pathFilter(i int) *Filter {
+	if i < len(f.Filters) {
+		return f.Filters[i]
+	}
+
+	return f.Filter
 }
 
 // Filter describes filter.
+// MinLength, MaxLength, MinContains and MaxContains are pointers, so that a zero value is not omitted
+// when marshaling.
+//
+// Not disallows the values matching its schema, e.g. {"not": {"const": "US"}} rejects the credentials with
+// the field equal to "US", and it may be the only keyword of the filter. Not applies to the value selected by
+// the path only: if the path selects nothing, the field is not satisfied and the credential is rejected as well,
+// i.e. a credential without the field does not satisfy the filter disallowing some of its values.
+// An empty Not disallows any value.
+//
+// Type holds the single JSON type of the type keyword, while Types holds its array form, e.g.
+// {"type": ["string", "number"]} allows both strings and numbers. If Types is set, Type is ignored.
 type Filter struct {
 	Type             *string                `json:"type,omitempty"`
 	Format           string                 `json:"format,omitempty"`
 	Pattern          string                 `json:"pattern,omitempty"`
 	Minimum          StrOrInt               `json:"minimum,omitempty"`
 	Maximum          StrOrInt               `json:"maximum,omitempty"`
-	MinLength        int                    `json:"minLength,omitempty"`
-	MaxLength        int                    `json:"maxLength,omitempty"`
+	MinLength        *int                   `json:"minLength,omitempty"`
+	MaxLength        *int                   `json:"maxLength,omitempty"`
 	ExclusiveMinimum StrOrInt               `json:"exclusiveMinimum,omitempty"`
 	ExclusiveMaximum StrOrInt               `json:"exclusiveMaximum,omitempty"`
 	Const            StrOrInt               `json:"const,omitempty"`
 	Enum             []StrOrInt             `json:"enum,omitempty"`
 	Not              map[string]interface{} `json:"not,omitempty"`
 	Contains         map[string]interface{} `json:"contains,omitempty"`
+	MinContains      *int                   `json:"minContains,omitempty"`
+	MaxContains      *int                   `json:"maxContains,omitempty"`
+
+	// Types holds the array form of the type keyword.
+	Types []string `json:"-"`
+
+	// CustomFields holds JSON Schema keywords which are not modeled by Filter (e.g. oneOf, anyOf, allOf).
+	CustomFields map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON marshals Filter to JSON, including JSON Schema keywords from CustomFields.
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	type Alias Filter
+
+	alias := (*Alias)(f)
+
+	customFields := f.CustomFields
+
+	// an empty not is omitted by omitempty, while it disallows any value;
+	// the array form of type is not modeled by the alias
+	if (f.Not != nil && len(f.Not) == 0) || f.Types != nil {
+		customFields = make(map[string]interface{}, len(f.CustomFields)+2)
+
+		for k, v := range f.CustomFields {
+			customFields[k] = v
+		}
+
+		if f.Not != nil && len(f.Not) == 0 {
+			customFields["not"] = f.Not
+		}
+
+		if f.Types != nil {
+			single := *alias
+			single.Type = nil
+			alias = &single
+
+			customFields["type"] = f.Types
+		}
+	}
+
+	data, err := marshalWithCustomFields(alias, customFields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal Filter: %w", err)
+	}
+
+	return data, nil
+}
+
+// marshalWithCustomFields works like jsonutil.MarshalWithCustomFields, but keeps the numbers of the value as they
+// are marshaled (e.g. the integers beyond the precision of float64, see CanonicalHash).
+func marshalWithCustomFields(v interface{}, customFields map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err = decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	for k, value := range customFields {
+		if _, ok := fields[k]; !ok {
+			fields[k] = value
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON unmarshals Filter from JSON. Keywords which are not modeled by Filter are put to CustomFields.
+// The minimum, maximum, exclusiveMinimum, exclusiveMaximum, formatMinimum and formatMaximum values must be scalars,
+// otherwise FilterValueError is returned.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	type Alias Filter
+
+	alias := (*Alias)(f)
+
+	f.CustomFields = make(map[string]interface{})
+
+	data, err := f.unmarshalTypes(data)
+	if err != nil {
+		return err
+	}
+
+	err = jsonutil.UnmarshalWithCustomFields(data, alias, f.CustomFields)
+	if err != nil {
+		return fmt.Errorf("unmarshal Filter: %w", err)
+	}
+
+	if err = f.validateScalars(); err != nil {
+		return err
+	}
+
+	// an empty not is not modeled by the alias because of omitempty
+	if f.Not != nil {
+		delete(f.CustomFields, "not")
+	}
+
+	if len(f.CustomFields) == 0 {
+		f.CustomFields = nil
+	}
+
+	return nil
 }
 
 // MatchedSubmissionRequirement contains information about VCs that matched a presentation definition.
 type MatchedSubmissionRequirement struct {
-	Name        string
-	Purpose     string
-	Rule        Selection
-	Count       int
-	Min         int
-	Max         int
+	Name    string
+	Purpose string
+	Rule    Selection
+	Count   int
+	Min     int
+	Max     int
+	// SelectFrom is the number of the options the holder can choose from: the descriptors with matched VCs
+	// or, for the nested requirements, the nested requirements which can be satisfied.
+	SelectFrom int
+	// SelectCount is the number of the options the holder must choose to satisfy the requirement, i.e. Count
+	// if it is set or Min otherwise. Max, if set, limits the number of the options which may be chosen.
+	// The requirement can be satisfied if SelectFrom is not less than SelectCount.
+	SelectCount int
 	Descriptors []*MatchedInputDescriptor
 	Nested      []*MatchedSubmissionRequirement
 }
@@ -217,24 +556,333 @@ type MatchedInputDescriptor struct {
 	MatchedVCs []*verifiable.Credential
 }
 
+// ResolvedRequirement describes how a submission requirement expands into input descriptors.
+type ResolvedRequirement struct {
+	Name               string
+	Purpose            string
+	Rule               Selection
+	Count              int
+	Min                int
+	Max                int
+	InputDescriptorIDs []string
+	Nested             []*ResolvedRequirement
+}
+
+// RejectionStage identifies the matching phase in which an input descriptor rejected a credential.
+type RejectionStage string
+
+const (
+	// ExpirationStage means the credential is expired, see WithRejectExpired.
+	ExpirationStage RejectionStage = "expiration"
+	// ProofStage means the proof of the credential does not verify, see WithRequireValidProofs.
+	ProofStage RejectionStage = "proof"
+	// FrameStage means the credential cannot be framed with the frame of the input descriptor.
+	FrameStage RejectionStage = "frame"
+	// FormatStage means the credential does not conform to the claim format designations.
+	FormatStage RejectionStage = "format"
+	// SchemaStage means the credential types do not satisfy the input descriptor schemas.
+	SchemaStage RejectionStage = "schema"
+	// ConstraintsStage means the credential does not satisfy the input descriptor constraints.
+	ConstraintsStage RejectionStage = "constraints"
+)
+
+// CredentialRejection contains information about why a VC did not match an input descriptor.
+type CredentialRejection struct {
+	CredentialID string
+	DescriptorID string
+	Stage        RejectionStage
+	// FieldIndex is the index of the rejecting field in Constraints.Fields or -1 if the rejection
+	// is not caused by a particular field.
+	FieldIndex int
+	// FieldPath is the path of the rejecting field (if any).
+	FieldPath []string
+	Reason    error
+}
+
+// rejectionCollector gathers credential rejections. A nil collector discards them.
+type rejectionCollector struct {
+	mu         sync.Mutex
+	rejections []*CredentialRejection
+}
+
+func (c *rejectionCollector) add(rejection *CredentialRejection) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.rejections = append(c.rejections, rejection)
+	c.mu.Unlock()
+}
+
+// rejectMissing records a rejection for every credential from before that is not present in after.
+func (c *rejectionCollector) rejectMissing(descriptorID string, stage RejectionStage,
+	before, after []*verifiable.Credential, reason error) {
+	if c == nil {
+		return
+	}
+
+	passed := make(map[*verifiable.Credential]struct{}, len(after))
+	for _, credential := range after {
+		passed[credential] = struct{}{}
+	}
+
+	for _, credential := range before {
+		if _, ok := passed[credential]; ok {
+			continue
+		}
+
+		c.add(&CredentialRejection{
+			CredentialID: credential.ID,
+			DescriptorID: descriptorID,
+			Stage:        stage,
+			FieldIndex:   -1,
+			Reason:       reason,
+		})
+	}
+}
+
 // ValidateSchema validates presentation definition.
-func (pd *PresentationDefinition) ValidateSchema() error {
-	result, err := gojsonschema.Validate(
-		gojsonschema.NewStringLoader(DefinitionJSONSchemaV1),
-		gojsonschema.NewGoLoader(struct {
-			PD *PresentationDefinition `json:"presentation_definition"`
-		}{PD: pd}),
-	)
+func (pd *PresentationDefinition) ValidateSchema(opts ...ValidateSchemaOpt) error {
+	_, err := pd.ValidateSchemaVersion(opts...)
 
-	if err != nil || !result.Valid() {
-		result, err = gojsonschema.Validate(
-			gojsonschema.NewStringLoader(DefinitionJSONSchemaV2),
-			gojsonschema.NewGoLoader(struct {
-				PD *PresentationDefinition `json:"presentation_definition"`
-			}{PD: pd}),
-		)
+	return err
+}
+
+// ValidateSchemaVersion validates presentation definition and returns the Presentation Exchange version
+// of the schema (DefinitionJSONSchemaV1 or DefinitionJSONSchemaV2) it is valid against.
+// If the definition is valid against neither of them, the errors of both schemas are returned
+// (once if they are the same).
+func (pd *PresentationDefinition) ValidateSchemaVersion(opts ...ValidateSchemaOpt) (SpecVersion, error) {
+	return validateSchemaVersion(gojsonschema.NewGoLoader(struct {
+		PD *PresentationDefinition `json:"presentation_definition"`
+	}{PD: pd}), opts...)
+}
+
+// ValidateSchemaBytes works like ValidateSchema, but validates the JSON of a presentation definition as it is
+// received rather than the PresentationDefinition it unmarshals to, so that the properties the struct cannot
+// represent (e.g. unknown properties, or values of wrong types which are dropped or converted) are validated too.
+func ValidateSchemaBytes(raw []byte, opts ...ValidateSchemaOpt) error {
+	_, err := ValidateSchemaBytesVersion(raw, opts...)
+
+	return err
+}
+
+// ValidateSchemaBytesVersion works like ValidateSchemaVersion, but validates the JSON of a presentation definition
+// as it is received, see ValidateSchemaBytes.
+func ValidateSchemaBytesVersion(raw []byte, opts ...ValidateSchemaOpt) (SpecVersion, error) {
+	document, err := json.Marshal(struct {
+		PD json.RawMessage `json:"presentation_definition"`
+	}{PD: raw})
+	if err != nil {
+		return "", fmt.Errorf("invalid presentation definition JSON: %w", err)
+	}
+
+	return validateSchemaVersion(gojsonschema.NewBytesLoader(document), opts...)
+}
+
+func validateSchemaVersion(document gojsonschema.JSONLoader, opts ...ValidateSchemaOpt) (SpecVersion, error) {
+	validateOpts := &validateSchemaOpts{}
+
+	for _, opt := range opts {
+		opt(validateOpts)
+	}
+
+	switch validateOpts.version {
+	case V1:
+		return V1, validateSchema(document, DefinitionJSONSchemaV1, V1)
+	case V2:
+		return V2, validateSchema(document, DefinitionJSONSchemaV2, V2)
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported presentation exchange version: %s", validateOpts.version)
+	}
+
+	errV1 := validateSchema(document, DefinitionJSONSchemaV1, V1)
+	if errV1 == nil {
+		return V1, nil
+	}
+
+	errV2 := validateSchema(document, DefinitionJSONSchemaV2, V2)
+	if errV2 == nil {
+		return V2, nil
+	}
+
+	if errV1.Error() == errV2.Error() {
+		return "", errV1
+	}
+
+	var schemaErrV1, schemaErrV2 *SchemaValidationError
+
+	if errors.As(errV1, &schemaErrV1) && errors.As(errV2, &schemaErrV2) {
+		return "", &SchemaValidationError{Failures: append(schemaErrV1.Failures, schemaErrV2.Failures...)}
+	}
+
+	return "", fmt.Errorf("%s: %s; %s: %s", V1, errV1, V2, errV2)
+}
+
+// ValidateSemantics validates what the JSON schema can not express: the input descriptor IDs are unique,
+// a submission requirement (or a nested one) does not have both from and from_nested, every group referenced
+// by the from of a submission requirement is declared by an input descriptor, and the count, min and max of
+// the pick rule are consistent (see checkSelection) and do not select more options than there are.
+// The returned *DefinitionError names the offending element and identifier. The options (e.g. WithFromGroupLists)
+// are the ones the definition is matched with.
+func (pd *PresentationDefinition) ValidateSemantics(opts ...MatchRequirementsOpt) error {
+	matchOpts := newMatchRequirementsOpts(opts)
+	ids := make(map[string]struct{}, len(pd.InputDescriptors))
+
+	for i, descriptor := range pd.InputDescriptors {
+		if descriptor == nil {
+			continue
+		}
+
+		if _, ok := ids[descriptor.ID]; ok {
+			return &DefinitionError{
+				Element: fmt.Sprintf("input_descriptors[%d]", i),
+				Err:     fmt.Errorf("duplicate id: %s", descriptor.ID),
+			}
+		}
+
+		ids[descriptor.ID] = struct{}{}
+	}
+
+	for i, requirement := range pd.SubmissionRequirements {
+		err := checkRequirement(fmt.Sprintf("submission_requirements[%d]", i), requirement, pd.InputDescriptors,
+			matchOpts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkRequirement checks the group and the selection of the submission requirement and of the nested ones.
+func checkRequirement(element string, requirement *SubmissionRequirement, descriptors []*InputDescriptor,
+	opts *matchRequirementsOpts) error {
+	if requirement == nil {
+		return nil
+	}
+
+	if err := requirement.checkFrom(); err != nil {
+		return &DefinitionError{Element: element, Err: err}
+	}
+
+	options := len(requirement.FromNested)
+
+	if requirement.hasFrom() {
+		if options = len(opts.fromDescriptors(requirement, descriptors)); options == 0 {
+			return &DefinitionError{Element: element, Err: fmt.Errorf("no descriptors for from: %s", requirement.fromName())}
+		}
+	}
+
+	if err := requirement.checkSelection(); err != nil {
+		return &DefinitionError{Element: element, Err: err}
+	}
+
+	// a pick selecting more options than there are can not be satisfied.
+	if requirement.Rule == Pick && requirement.selectCount() > options {
+		return &DefinitionError{
+			Element: element,
+			Err:     fmt.Errorf("pick selects %d of %d options", requirement.selectCount(), options),
+		}
+	}
+
+	for i, nested := range requirement.FromNested {
+		err := checkRequirement(fmt.Sprintf("%s.from_nested[%d]", element, i), nested, descriptors, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkFrom checks that the submission requirement does not have both from and from_nested.
+func (sr *SubmissionRequirement) checkFrom() error {
+	if sr.hasFrom() && len(sr.FromNested) != 0 {
+		return ErrFromAndFromNested
+	}
+
+	return nil
+}
+
+// checkSelection checks that the count, min and max of the pick rule are consistent: the min is not greater than
+// the max, and a pick with the count set selects a number of options within the min and the max (if set).
+// The selection of the all rule is not checked, as it selects all the options.
+func (sr *SubmissionRequirement) checkSelection() error {
+	if sr.Rule != Pick {
+		return nil
+	}
+
+	switch {
+	case sr.Max > 0 && sr.Min > sr.Max:
+		return fmt.Errorf("min %d is greater than max %d", sr.Min, sr.Max)
+	case sr.Count > 0 && sr.Count < sr.Min:
+		return fmt.Errorf("count %d is less than min %d", sr.Count, sr.Min)
+	case sr.Count > 0 && sr.Max > 0 && sr.Count > sr.Max:
+		return fmt.Errorf("count %d is greater than max %d", sr.Count, sr.Max)
+	}
+
+	return nil
+}
+
+// selectCount returns the least number of the options the submission requirement of the pick rule selects.
+func (sr *SubmissionRequirement) selectCount() int {
+	if sr.Count > 0 {
+		return sr.Count
+	}
+
+	return sr.Min
+}
+
+// SchemaFailure describes why the presentation definition does not conform to the JSON schema.
+type SchemaFailure struct {
+	// Version is the Presentation Exchange version of the JSON schema.
+	Version SpecVersion
+	// Field is the path of the failed field, e.g. presentation_definition.input_descriptors.0.
+	Field string
+	// Description describes the failure, e.g. "id is required".
+	Description string
+	// Type is the type of the failure, e.g. required or additional_property_not_allowed.
+	Type string
+}
+
+// SchemaValidationError is returned by ValidateSchema and ValidateSchemaVersion if the presentation definition
+// does not conform to the JSON schema.
+type SchemaValidationError struct {
+	Failures []SchemaFailure
+}
+
+// Error returns the comma-joined failures. The failures of the JSON schemas of both Presentation Exchange
+// versions are grouped by the version, e.g. "v1: <failures>; v2: <failures>".
+func (e *SchemaValidationError) Error() string {
+	var versions []SpecVersion
+
+	failures := map[SpecVersion][]string{}
+
+	for _, failure := range e.Failures {
+		if _, ok := failures[failure.Version]; !ok {
+			versions = append(versions, failure.Version)
+		}
+
+		failures[failure.Version] = append(failures[failure.Version], failure.Field+": "+failure.Description)
 	}
 
+	if len(versions) == 1 {
+		return strings.Join(failures[versions[0]], ",")
+	}
+
+	msgs := make([]string, len(versions))
+	for i, version := range versions {
+		msgs[i] = fmt.Sprintf("%s: %s", version, strings.Join(failures[version], ","))
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+func validateSchema(document gojsonschema.JSONLoader, schema string, version SpecVersion) error {
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(schema), document)
 	if err != nil {
 		return err
 	}
@@ -245,12 +893,17 @@ func (pd *PresentationDefinition) ValidateSchema() error {
 
 	resultErrors := result.Errors()
 
-	errs := make([]string, len(resultErrors))
-	for i := range resultErrors {
-		errs[i] = resultErrors[i].String()
+	failures := make([]SchemaFailure, len(resultErrors))
+	for i, resultError := range resultErrors {
+		failures[i] = SchemaFailure{
+			Version:     version,
+			Field:       resultError.Field(),
+			Description: resultError.Description(),
+			Type:        resultError.Type(),
+		}
 	}
 
-	return errors.New(strings.Join(errs, ","))
+	return &SchemaValidationError{Failures: failures}
 }
 
 type requirement struct {
@@ -262,8 +915,13 @@ type requirement struct {
 	Max              int
 	InputDescriptors []*InputDescriptor
 	Nested           []*requirement
+	Format           *Format
 }
 
+// isLenApplicable checks whether the number of the matched options satisfies the requirement: it must equal
+// the count if it is set, and lie within the min and the max otherwise (each bound applying if set), e.g.
+// a pick with min 1 and max 2 (and no count) accepts one or two options. The count of the all rule is
+// the number of all its options (see toRequirement).
 func (r *requirement) isLenApplicable(val int) bool {
 	if r.Count > 0 && val != r.Count {
 		return false
@@ -280,6 +938,15 @@ func (r *requirement) isLenApplicable(val int) bool {
 	return true
 }
 
+// selectCount returns the least number of the options which satisfies the requirement (see isLenApplicable).
+func (r *requirement) selectCount() int {
+	if r.Count > 0 {
+		return r.Count
+	}
+
+	return r.Min
+}
+
 func contains(data []string, e string) bool {
 	for _, el := range data {
 		if el == e {
@@ -290,28 +957,42 @@ func contains(data []string, e string) bool {
 	return false
 }
 
-func toRequirement(sr *SubmissionRequirement, descriptors []*InputDescriptor) (*requirement, error) {
+// toRequirement resolves the submission requirement of the given depth and its nested requirements,
+// failing with NestingDepthError once maxDepth is exceeded (which stops self-referencing requirements as well).
+// The element identifies the submission requirement in the *DefinitionError of a malformed one.
+func toRequirement(element string, sr *SubmissionRequirement, descriptors []*InputDescriptor,
+	parentFormat *Format, depth int, opts *matchRequirementsOpts) (*requirement, error) {
+	if depth > opts.maxNestingDepth {
+		return nil, &NestingDepthError{MaxDepth: opts.maxNestingDepth}
+	}
+
+	if err := sr.checkFrom(); err != nil {
+		return nil, &DefinitionError{Element: element, Err: err}
+	}
+
 	var (
 		inputDescriptors []*InputDescriptor
 		nested           []*requirement
+		format           = parentFormat
 	)
 
+	if sr.Format.notNil() {
+		format = sr.Format
+	}
+
 	var totalCount int
 
-	if sr.From != "" {
-		for _, descriptor := range descriptors {
-			if contains(descriptor.Group, sr.From) {
-				inputDescriptors = append(inputDescriptors, descriptor)
-			}
-		}
+	if sr.hasFrom() {
+		inputDescriptors = opts.fromDescriptors(sr, descriptors)
 
 		totalCount = len(inputDescriptors)
 		if totalCount == 0 {
-			return nil, fmt.Errorf("no descriptors for from: %s", sr.From)
+			return nil, fmt.Errorf("no descriptors for from: %s", sr.fromName())
 		}
 	} else {
-		for _, sReq := range sr.FromNested {
-			req, err := toRequirement(sReq, descriptors)
+		for i, sReq := range sr.FromNested {
+			req, err := toRequirement(fmt.Sprintf("%s.from_nested[%d]", element, i), sReq, descriptors, format,
+				depth+1, opts)
 			if err != nil {
 				return nil, err
 			}
@@ -321,6 +1002,11 @@ func toRequirement(sr *SubmissionRequirement, descriptors []*InputDescriptor) (*
 		totalCount = len(nested)
 	}
 
+	if err := sr.checkSelection(); err != nil {
+		return nil, &DefinitionError{Element: element, Err: err}
+	}
+
+	// the count of the pick rule is kept unset if it is not given, so that its min and max apply instead.
 	count := sr.Count
 
 	if sr.Rule == All {
@@ -336,10 +1022,12 @@ func toRequirement(sr *SubmissionRequirement, descriptors []*InputDescriptor) (*
 		Max:              sr.Max,
 		InputDescriptors: inputDescriptors,
 		Nested:           nested,
+		Format:           format,
 	}, nil
 }
 
-func makeRequirement(requirements []*SubmissionRequirement, descriptors []*InputDescriptor) (*requirement, error) {
+func makeRequirement(requirements []*SubmissionRequirement, descriptors []*InputDescriptor,
+	opts *matchRequirementsOpts) (*requirement, error) {
 	if len(requirements) == 0 {
 		return &requirement{
 			Count:            len(descriptors),
@@ -351,8 +1039,9 @@ func makeRequirement(requirements []*SubmissionRequirement, descriptors []*Input
 		Count: len(requirements),
 	}
 
-	for _, submissionRequirement := range requirements {
-		r, err := toRequirement(submissionRequirement, descriptors)
+	for i, submissionRequirement := range requirements {
+		r, err := toRequirement(fmt.Sprintf("submission_requirements[%d]", i), submissionRequirement, descriptors,
+			nil, 1, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -363,46 +1052,194 @@ func makeRequirement(requirements []*SubmissionRequirement, descriptors []*Input
 	return req, nil
 }
 
-// CreateVP creates verifiable presentation.
+// CreateVP creates verifiable presentation. The credential options are used when applying selective disclosure,
+// see CreateVPWithOptions for the other options.
 func (pd *PresentationDefinition) CreateVP(credentials []*verifiable.Credential,
 	documentLoader ld.DocumentLoader, opts ...verifiable.CredentialOpt) (*verifiable.Presentation, error) {
-	if err := pd.ValidateSchema(); err != nil {
-		return nil, err
-	}
+	return pd.CreateVPWithOptions(credentials, documentLoader, WithSDCredentialOptions(opts...))
+}
 
-	req, err := makeRequirement(pd.SubmissionRequirements, pd.InputDescriptors)
+// CreateVPWithOptions works like CreateVP, but takes the match options (WithSDCredentialOptions for the
+// credential options of CreateVP).
+//
+// The order of the presentation is deterministic: the credentials are ordered by the ID of the input descriptor
+// they are first matched by, then in the order they are given in (or ranked in by WithCredentialRanker), and
+// the descriptor map is ordered by input descriptor ID, then in the same order of the credentials. The same
+// credentials matched by the same definition (with the IDs set by WithIDGenerator and WithSubmissionID) make
+// the same presentation.
+func (pd *PresentationDefinition) CreateVPWithOptions(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) (*verifiable.Presentation, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+
+	format, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	format, result, err := pd.applyRequirement(req, credentials, documentLoader, opts...)
-	if err != nil {
-		return nil, err
-	}
+	return pd.presentation(format, result, matchOpts)
+}
 
-	applicableCredentials, descriptors := merge(format, result)
+// CreateVPs works like CreateVP, but groups the matched credentials by their native format (jwt_vc or ldp_vc)
+// and creates a verifiable presentation per format (jwt_vp or ldp_vp respectively), each with its own
+// presentation submission. The presentations are ordered by format.
+func (pd *PresentationDefinition) CreateVPs(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) ([]*verifiable.Presentation, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
 
-	vp, err := verifiable.NewPresentation(verifiable.WithCredentials(applicableCredentials...))
+	_, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	vp.Context = append(vp.Context, PresentationSubmissionJSONLDContextIRI)
-	vp.Type = append(vp.Type, PresentationSubmissionJSONLDType)
+	resultByFormat := map[string]map[string][]*verifiable.Credential{}
 
-	vp.CustomFields = verifiable.CustomFields{
-		submissionProperty: &PresentationSubmission{
-			ID:            uuid.New().String(),
-			DefinitionID:  pd.ID,
-			DescriptorMap: descriptors,
-		},
-	}
+	for descriptorID, creds := range result {
+		for _, credential := range creds {
+			format := FormatLDPVP
+			if credential.JWT != "" {
+				format = FormatJWTVP
+			}
 
-	return vp, nil
+			if _, ok := resultByFormat[format]; !ok {
+				resultByFormat[format] = map[string][]*verifiable.Credential{}
+			}
+
+			resultByFormat[format][descriptorID] = append(resultByFormat[format][descriptorID], credential)
+		}
+	}
+
+	formats := make([]string, 0, len(resultByFormat))
+	for format := range resultByFormat {
+		formats = append(formats, format)
+	}
+
+	sort.Strings(formats)
+
+	vps := make([]*verifiable.Presentation, 0, len(formats))
+
+	for _, format := range formats {
+		vp, err := pd.presentation(format, resultByFormat[format], matchOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		vps = append(vps, vp)
+	}
+
+	return vps, nil
+}
+
+func (pd *PresentationDefinition) matchCredentials(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts *matchRequirementsOpts) (string, map[string][]*verifiable.Credential, error) {
+	if err := pd.ValidateSchema(); err != nil {
+		return "", nil, err
+	}
+
+	if opts.strictPatterns {
+		if err := pd.checkPatterns(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	req, err := makeRequirement(pd.SubmissionRequirements, pd.InputDescriptors, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	credentials, err = opts.prepareCredentials(credentials)
+	if err != nil {
+		return "", nil, err
+	}
+
+	partial := opts.partialMatches && len(pd.SubmissionRequirements) == 0
+	if partial {
+		req.Count = 0
+		req.Min = 1
+	}
+
+	format, result, err := pd.applyRequirement(req, credentials, documentLoader, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// same_subject relates the credentials of different input descriptors,
+	// so it is checked once all of them have been matched.
+	if err := pd.applySameSubject(result, opts); err != nil {
+		return "", nil, err
+	}
+
+	if opts.unionDisclosure {
+		if err := pd.unionDisclosures(result, opts); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if partial {
+		if err := pd.collectUnmetDescriptors(result, opts); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return format, result, nil
+}
+
+// collectUnmetDescriptors removes the input descriptors without credentials from the partial result
+// and stores their IDs to the unmet descriptor IDs of the options.
+func (pd *PresentationDefinition) collectUnmetDescriptors(result map[string][]*verifiable.Credential,
+	opts *matchRequirementsOpts) error {
+	var unmet []string
+
+	for _, descriptor := range pd.InputDescriptors {
+		if len(result[descriptor.ID]) == 0 {
+			delete(result, descriptor.ID)
+
+			unmet = append(unmet, descriptor.ID)
+		}
+	}
+
+	if len(result) == 0 {
+		return ErrNoCredentials
+	}
+
+	if opts.unmetDescriptorIDs != nil {
+		*opts.unmetDescriptorIDs = unmet
+	}
+
+	return nil
+}
+
+func (pd *PresentationDefinition) presentation(format string, result map[string][]*verifiable.Credential,
+	opts *matchRequirementsOpts) (*verifiable.Presentation, error) {
+	if opts.binding != nil && !opts.bindingReturned {
+		return nil, ErrBindingNotSupported
+	}
+
+	credentialOpts, descriptors := merge(format, result, opts)
+
+	vp, err := verifiable.NewPresentation(credentialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	vp.Context = append(vp.Context, PresentationSubmissionJSONLDContextIRI)
+	vp.Type = append(vp.Type, PresentationSubmissionJSONLDType)
+
+	vp.CustomFields = verifiable.CustomFields{
+		submissionProperty: &PresentationSubmission{
+			ID:            opts.presentationSubmissionID(),
+			DefinitionID:  pd.ID,
+			DescriptorMap: descriptors,
+		},
+	}
+
+	opts.originals.collect(result)
+	pd.collectMappingDetails(descriptors, opts)
+
+	return vp, nil
 }
 
 func makeRequirementsForMatch(requirements []*SubmissionRequirement,
-	descriptors []*InputDescriptor) ([]*requirement, error) {
+	descriptors []*InputDescriptor, opts *matchRequirementsOpts) ([]*requirement, error) {
 	if len(requirements) == 0 {
 		return []*requirement{{
 			Name:             "",
@@ -416,8 +1253,9 @@ func makeRequirementsForMatch(requirements []*SubmissionRequirement,
 
 	var reqs []*requirement
 
-	for _, submissionRequirement := range requirements {
-		r, err := toRequirement(submissionRequirement, descriptors)
+	for i, submissionRequirement := range requirements {
+		r, err := toRequirement(fmt.Sprintf("submission_requirements[%d]", i), submissionRequirement, descriptors,
+			nil, 1, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -431,11 +1269,40 @@ func makeRequirementsForMatch(requirements []*SubmissionRequirement,
 // MatchSubmissionRequirement return information about matching VCs.
 func (pd *PresentationDefinition) MatchSubmissionRequirement(credentials []*verifiable.Credential,
 	documentLoader ld.DocumentLoader, opts ...verifiable.CredentialOpt) ([]*MatchedSubmissionRequirement, error) {
-	if err := pd.ValidateSchema(); err != nil {
+	return pd.MatchSubmissionRequirementWithOptions(credentials, documentLoader, WithSDCredentialOptions(opts...))
+}
+
+// MatchSubmissionRequirementWithOptions works like MatchSubmissionRequirement, but takes the match options
+// (WithSDCredentialOptions for the credential options of MatchSubmissionRequirement).
+func (pd *PresentationDefinition) MatchSubmissionRequirementWithOptions(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) ([]*MatchedSubmissionRequirement, error) {
+	return pd.matchSubmissionRequirement(credentials, documentLoader, newMatchRequirementsOpts(opts))
+}
+
+// MatchSubmissionRequirementDetailed works like MatchSubmissionRequirement, but additionally returns
+// the reasons why input descriptors rejected the given VCs.
+func (pd *PresentationDefinition) MatchSubmissionRequirementDetailed(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt,
+) ([]*MatchedSubmissionRequirement, []*CredentialRejection, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.rejections = &rejectionCollector{}
+
+	matched, err := pd.matchSubmissionRequirement(credentials, documentLoader, matchOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return matched, matchOpts.rejections.rejections, nil
+}
+
+func (pd *PresentationDefinition) matchSubmissionRequirement(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts *matchRequirementsOpts) ([]*MatchedSubmissionRequirement, error) {
+	requirements, err := pd.requirementsForMatch(opts)
+	if err != nil {
 		return nil, err
 	}
 
-	requirements, err := makeRequirementsForMatch(pd.SubmissionRequirements, pd.InputDescriptors)
+	credentials, err = opts.prepareCredentials(credentials)
 	if err != nil {
 		return nil, err
 	}
@@ -443,7 +1310,7 @@ func (pd *PresentationDefinition) MatchSubmissionRequirement(credentials []*veri
 	var matchedReqs []*MatchedSubmissionRequirement
 
 	for _, req := range requirements {
-		matched, err := pd.matchRequirement(req, credentials, documentLoader, opts...)
+		matched, err := pd.matchRequirement(req, credentials, documentLoader, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -454,12 +1321,156 @@ func (pd *PresentationDefinition) MatchSubmissionRequirement(credentials []*veri
 	return matchedReqs, nil
 }
 
+// requirementsForMatch validates the presentation definition and returns the requirements
+// MatchSubmissionRequirement matches the credentials against.
+func (pd *PresentationDefinition) requirementsForMatch(opts *matchRequirementsOpts) ([]*requirement, error) {
+	if opts.applicabilityOnly {
+		// the framing and the selective disclosure are left to CreateVP.
+		opts.noFrame = true
+		opts.noLimitDisclosure = true
+	}
+
+	if err := pd.ValidateSchema(); err != nil {
+		return nil, err
+	}
+
+	if opts.strictPatterns {
+		if err := pd.checkPatterns(); err != nil {
+			return nil, err
+		}
+	}
+
+	return makeRequirementsForMatch(pd.SubmissionRequirements, pd.InputDescriptors, opts)
+}
+
+// ResolveRequirements returns the tree of submission requirements with the input descriptors they resolve to.
+// If the definition has no submission requirements, a single requirement of all input descriptors is returned.
+// The options (e.g. WithFromGroupLists) are the ones the definition is matched with.
+func (pd *PresentationDefinition) ResolveRequirements(opts ...MatchRequirementsOpt) ([]*ResolvedRequirement, error) {
+	if err := pd.ValidateSchema(); err != nil {
+		return nil, err
+	}
+
+	requirements, err := makeRequirementsForMatch(pd.SubmissionRequirements, pd.InputDescriptors,
+		newMatchRequirementsOpts(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make([]*ResolvedRequirement, len(requirements))
+	for i, req := range requirements {
+		resolved[i] = req.resolve()
+	}
+
+	return resolved, nil
+}
+
+// ReferencedPaths returns the sorted list of the distinct JSONPath expressions referenced by the constraints
+// fields of all input descriptors, including every alternative path of a field. It can be used to preselect
+// the credentials which could possibly match the definition.
+func (pd *PresentationDefinition) ReferencedPaths() []string {
+	set := map[string]struct{}{}
+
+	for _, descriptor := range pd.InputDescriptors {
+		if descriptor == nil || descriptor.Constraints == nil {
+			continue
+		}
+
+		for _, field := range descriptor.Constraints.Fields {
+			if field == nil {
+				continue
+			}
+
+			for _, path := range field.Path {
+				set[path] = struct{}{}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+func (r *requirement) resolve() *ResolvedRequirement {
+	resolved := &ResolvedRequirement{
+		Name:    r.Name,
+		Purpose: r.Purpose,
+		Rule:    r.Rule,
+		Count:   r.Count,
+		Min:     r.Min,
+		Max:     r.Max,
+	}
+
+	for _, descriptor := range r.InputDescriptors {
+		resolved.InputDescriptorIDs = append(resolved.InputDescriptorIDs, descriptor.ID)
+	}
+
+	for _, nested := range r.Nested {
+		resolved.Nested = append(resolved.Nested, nested.resolve())
+	}
+
+	return resolved
+}
+
 // ErrNoCredentials when any credentials do not satisfy requirements.
 var ErrNoCredentials = errors.New("credentials do not satisfy requirements")
 
 func (pd *PresentationDefinition) matchRequirement(req *requirement, creds []*verifiable.Credential,
-	documentLoader ld.DocumentLoader,
-	opts ...verifiable.CredentialOpt) (*MatchedSubmissionRequirement, error) {
+	documentLoader ld.DocumentLoader, opts *matchRequirementsOpts) (*MatchedSubmissionRequirement, error) {
+	var (
+		descriptors []*MatchedInputDescriptor
+		nested      []*MatchedSubmissionRequirement
+	)
+
+	if len(req.InputDescriptors) != 0 {
+		matches, err := pd.filterCredentialsThatMatchDescriptors(
+			creds, req.InputDescriptors, req.Format, documentLoader, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, descriptor := range req.InputDescriptors {
+			descriptors = append(descriptors, matchedDescriptor(descriptor, matches[i].filtered))
+		}
+	}
+
+	for _, nestedReq := range req.Nested {
+		nestedMatch, err := pd.matchRequirement(nestedReq, creds, documentLoader, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		nested = append(nested, nestedMatch)
+	}
+
+	return newMatchedRequirement(req, descriptors, nested), nil
+}
+
+// matchedDescriptor returns the match of the input descriptor. The IDs of the matched credentials limited for
+// the descriptor are restored, as the limited credentials are not merged into a presentation.
+func matchedDescriptor(descriptor *InputDescriptor, matched []*verifiable.Credential) *MatchedInputDescriptor {
+	for _, credential := range matched {
+		credential.ID = trimTmpID(credential.ID)
+	}
+
+	return &MatchedInputDescriptor{
+		ID:         descriptor.ID,
+		Name:       descriptor.Name,
+		Purpose:    descriptor.Purpose,
+		MatchedVCs: matched,
+	}
+}
+
+// newMatchedRequirement returns the match of the requirement with the given matches of its input descriptors
+// and nested requirements.
+func newMatchedRequirement(req *requirement, descriptors []*MatchedInputDescriptor,
+	nested []*MatchedSubmissionRequirement) *MatchedSubmissionRequirement {
 	matchedReq := &MatchedSubmissionRequirement{
 		Name:        req.Name,
 		Purpose:     req.Purpose,
@@ -467,68 +1478,63 @@ func (pd *PresentationDefinition) matchRequirement(req *requirement, creds []*ve
 		Count:       req.Count,
 		Min:         req.Min,
 		Max:         req.Max,
-		Descriptors: nil,
-		Nested:      nil,
+		Descriptors: descriptors,
+		Nested:      nested,
 	}
 
-	if len(req.InputDescriptors) != 0 {
-		for _, descriptor := range req.InputDescriptors {
-			_, filtered, err := pd.filterCredentialsThatMatchDescriptor(
-				creds, descriptor, documentLoader, opts...)
-
-			if err != nil {
-				return nil, err
-			}
+	matchedReq.SelectCount = req.selectCount()
 
-			matchedReq.Descriptors = append(matchedReq.Descriptors, &MatchedInputDescriptor{
-				ID:         descriptor.ID,
-				Name:       descriptor.Name,
-				Purpose:    descriptor.Purpose,
-				MatchedVCs: filtered,
-			})
+	for _, descriptor := range matchedReq.Descriptors {
+		if len(descriptor.MatchedVCs) != 0 {
+			matchedReq.SelectFrom++
 		}
 	}
 
-	for _, nestedReq := range req.Nested {
-		nestedMatch, err := pd.matchRequirement(nestedReq, creds, documentLoader, opts...)
-		if err != nil {
-			return nil, err
+	for _, nested := range matchedReq.Nested {
+		if nested.satisfied() {
+			matchedReq.SelectFrom++
 		}
-
-		matchedReq.Nested = append(matchedReq.Nested, nestedMatch)
 	}
 
-	return matchedReq, nil
+	return matchedReq
+}
+
+// satisfied tells whether enough input descriptors or nested requirements of the requirement are matched.
+func (r *MatchedSubmissionRequirement) satisfied() bool {
+	return r.SelectFrom != 0 && r.SelectFrom >= r.SelectCount
 }
 
 // nolint: gocyclo,funlen,gocognit
 func (pd *PresentationDefinition) applyRequirement(req *requirement, creds []*verifiable.Credential,
 	documentLoader ld.DocumentLoader,
-	opts ...verifiable.CredentialOpt) (string, map[string][]*verifiable.Credential, error) {
+	opts *matchRequirementsOpts) (string, map[string][]*verifiable.Credential, error) {
 	result := make(map[string][]*verifiable.Credential)
 	// assume LDPVP format if pd.Format is not set.
 	// Usually pd.Format will be set when creds include a non-empty Proofs field since they represent the designated
 	// format.
 	vpFormat := FormatLDPVP
 
-	for _, descriptor := range req.InputDescriptors {
-		descFormat, filtered, err := pd.filterCredentialsThatMatchDescriptor(
-			creds, descriptor, documentLoader, opts...)
-
-		if err != nil {
-			return "", nil, err
-		}
+	matches, err := pd.filterCredentialsThatMatchDescriptors(
+		creds, req.InputDescriptors, req.Format, documentLoader, opts)
+	if err != nil {
+		return "", nil, err
+	}
 
-		if descFormat != "" {
-			vpFormat = descFormat
+	for i, descriptor := range req.InputDescriptors {
+		if matches[i].format != "" {
+			vpFormat = matches[i].format
 		}
 
-		if len(filtered) != 0 {
-			result[descriptor.ID] = filtered
+		if len(matches[i].filtered) != 0 {
+			result[descriptor.ID] = matches[i].filtered
 		}
 	}
 
 	if len(req.InputDescriptors) != 0 {
+		if opts.credentialRanker != nil {
+			result = opts.rankDescriptors(req, result)
+		}
+
 		if req.isLenApplicable(len(result)) {
 			return vpFormat, result, nil
 		}
@@ -538,11 +1544,8 @@ func (pd *PresentationDefinition) applyRequirement(req *requirement, creds []*ve
 
 	var nestedResult []map[string][]*verifiable.Credential
 
-	// maps credential to descriptors that satisfy requirements
-	set := map[string]map[string]string{}
-
 	for _, r := range req.Nested {
-		vpFmt, res, err := pd.applyRequirement(r, creds, documentLoader, opts...)
+		vpFmt, res, err := pd.applyRequirement(r, creds, documentLoader, opts)
 		if errors.Is(err, ErrNoCredentials) {
 			continue
 		}
@@ -551,20 +1554,31 @@ func (pd *PresentationDefinition) applyRequirement(req *requirement, creds []*ve
 			return "", nil, err
 		}
 
+		if len(res) != 0 {
+			nestedResult = append(nestedResult, res)
+			vpFormat = vpFmt
+		}
+	}
+
+	if opts.credentialRanker != nil {
+		nestedResult = opts.pickResults(req, nestedResult)
+	}
+
+	// maps credential to descriptors that satisfy requirements
+	set := map[string]map[string]string{}
+
+	for _, res := range nestedResult {
 		for desc, credentials := range res {
 			for _, cred := range credentials {
-				if _, ok := set[trimTmpID(cred.ID)]; !ok {
-					set[trimTmpID(cred.ID)] = map[string]string{}
+				originalID := opts.originalID(cred)
+
+				if _, ok := set[originalID]; !ok {
+					set[originalID] = map[string]string{}
 				}
 
-				set[trimTmpID(cred.ID)][desc] = cred.ID
+				set[originalID][desc] = opts.credentialKey(cred)
 			}
 		}
-
-		if len(res) != 0 {
-			nestedResult = append(nestedResult, res)
-			vpFormat = vpFmt
-		}
 	}
 
 	exclude := map[string]struct{}{}
@@ -577,35 +1591,98 @@ func (pd *PresentationDefinition) applyRequirement(req *requirement, creds []*ve
 		}
 	}
 
-	return vpFormat, mergeNestedResult(nestedResult, exclude), nil
+	return vpFormat, mergeNestedResult(nestedResult, exclude, opts), nil
 }
 
 func (pd *PresentationDefinition) filterCredentialsThatMatchDescriptor(creds []*verifiable.Credential,
 	descriptor *InputDescriptor,
+	requirementFormat *Format,
 	documentLoader ld.DocumentLoader,
-	opts ...verifiable.CredentialOpt) (string, []*verifiable.Credential, error) {
+	opts *matchRequirementsOpts) (string, []*verifiable.Credential, error) {
 	format := pd.Format
+	if requirementFormat.notNil() {
+		format = requirementFormat
+	}
+
 	if descriptor.Format.notNil() {
 		format = descriptor.Format
 	}
 
 	vpFormat := ""
 
-	filtered, err := frameCreds(pd.Frame, creds, opts...)
+	filtered, err := filterExpired(descriptor.ID, creds, opts)
 	if err != nil {
 		return "", nil, err
 	}
 
+	filtered = filterInvalidProofs(descriptor.ID, filtered, opts)
+
+	// the credentials are framed before their constraints are evaluated, for the audit records and the originals
+	// to be collected for the framed credentials the presentation holds.
+	switch {
+	case opts.noFrame:
+		// the credentials are matched as given.
+	case descriptor.Frame != nil:
+		filtered = frameDescriptorCreds(descriptor, filtered, opts)
+	default:
+		filtered, err = frameCreds(pd.Frame, filtered, opts)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
 	if format.notNil() {
-		vpFormat, filtered = filterFormat(format, filtered)
+		beforeFormat := filtered
+
+		vpFormat, filtered, err = filterFormat(format, filtered, opts.preferredFormats, opts)
+		if err != nil {
+			return "", nil, err
+		}
+
+		reason := errors.New("credential does not match any of the requested formats")
+		if vpFormat != "" {
+			reason = fmt.Errorf("credential does not match the selected format %s", vpFormat)
+		}
+
+		opts.rejections.rejectMissing(descriptor.ID, FormatStage, beforeFormat, filtered, reason)
 	}
 
 	// Validate schema only for v1
 	if descriptor.Schema != nil {
-		filtered = filterSchema(descriptor.Schema, filtered, documentLoader)
+		var refused []*verifiable.Credential
+
+		beforeSchema := filtered
+
+		switch {
+		case opts.credentialSchemaMatch:
+			filtered = filterCredentialSchema(descriptor.Schema, filtered, opts)
+		case opts.typeMatchOnly:
+			filtered = filterSchemaByType(descriptor.Schema, filtered)
+		default:
+			filtered, err = filterSchema(descriptor.Schema, filtered, opts.schemaResolver(documentLoader),
+				opts.contextCache, opts.lenientSchema, opts.log(), func(credential *verifiable.Credential, reason error) {
+					refused = append(refused, credential)
+
+					opts.rejections.add(&CredentialRejection{
+						CredentialID: credential.ID,
+						DescriptorID: descriptor.ID,
+						Stage:        SchemaStage,
+						FieldIndex:   -1,
+						Reason:       reason,
+					})
+				})
+			if err != nil {
+				return "", nil, err
+			}
+		}
+
+		// the credentials whose contexts are refused are rejected already.
+		opts.rejections.rejectMissing(descriptor.ID, SchemaStage, beforeSchema,
+			append(append([]*verifiable.Credential{}, filtered...), refused...),
+			fmt.Errorf("credential does not satisfy schemas %s", schemaURIs(descriptor.Schema)))
 	}
 
-	filtered, err = filterConstraints(descriptor.Constraints, filtered, opts...)
+	filtered, err = filterConstraints(descriptor.ID, descriptor.Constraints, filtered, opts)
 	if err != nil {
 		return "", nil, err
 	}
@@ -613,8 +1690,90 @@ func (pd *PresentationDefinition) filterCredentialsThatMatchDescriptor(creds []*
 	return vpFormat, filtered, nil
 }
 
+type descriptorMatch struct {
+	format   string
+	filtered []*verifiable.Credential
+}
+
+// filterCredentialsThatMatchDescriptors filters the credentials for every descriptor. Up to opts.concurrency
+// descriptors are processed concurrently; after an error, the descriptors not started yet are skipped.
+func (pd *PresentationDefinition) filterCredentialsThatMatchDescriptors(creds []*verifiable.Credential,
+	descriptors []*InputDescriptor,
+	requirementFormat *Format,
+	documentLoader ld.DocumentLoader,
+	opts *matchRequirementsOpts) ([]*descriptorMatch, error) {
+	matches := make([]*descriptorMatch, len(descriptors))
+
+	if opts.concurrency <= 1 || len(descriptors) <= 1 {
+		for i, descriptor := range descriptors {
+			format, filtered, err := pd.filterCredentialsThatMatchDescriptor(
+				creds, descriptor, requirementFormat, documentLoader, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			matches[i] = &descriptorMatch{format: format, filtered: filtered}
+		}
+
+		return matches, nil
+	}
+
+	var (
+		errs   = make([]error, len(descriptors))
+		jobs   = make(chan int)
+		failed int32
+		wg     sync.WaitGroup
+	)
+
+	workers := opts.concurrency
+	if workers > len(descriptors) {
+		workers = len(descriptors)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				format, filtered, err := pd.filterCredentialsThatMatchDescriptor(
+					creds, descriptors[i], requirementFormat, documentLoader, opts)
+				if err != nil {
+					errs[i] = err
+
+					atomic.StoreInt32(&failed, 1)
+
+					continue
+				}
+
+				matches[i] = &descriptorMatch{format: format, filtered: filtered}
+			}
+		}()
+	}
+
+	for i := range descriptors {
+		if atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return matches, nil
+}
+
 func mergeNestedResult(nr []map[string][]*verifiable.Credential,
-	exclude map[string]struct{}) map[string][]*verifiable.Credential {
+	exclude map[string]struct{}, opts *matchRequirementsOpts) map[string][]*verifiable.Credential {
 	result := make(map[string][]*verifiable.Credential)
 
 	for _, res := range nr {
@@ -624,17 +1783,19 @@ func mergeNestedResult(nr []map[string][]*verifiable.Credential,
 			var mergedCredentials []*verifiable.Credential
 
 			for _, credential := range result[key] {
-				if _, ok := set[credential.ID]; !ok {
+				if _, ok := set[opts.credentialKey(credential)]; !ok {
 					mergedCredentials = append(mergedCredentials, credential)
-					set[credential.ID] = struct{}{}
+					set[opts.credentialKey(credential)] = struct{}{}
 				}
 			}
 
 			for _, credential := range credentials {
-				if _, ok := set[credential.ID]; !ok {
-					if _, exist := exclude[key+credential.ID]; !exist {
+				credentialKey := opts.credentialKey(credential)
+
+				if _, ok := set[credentialKey]; !ok {
+					if _, exist := exclude[key+credentialKey]; !exist {
 						mergedCredentials = append(mergedCredentials, credential)
-						set[credential.ID] = struct{}{}
+						set[credentialKey] = struct{}{}
 					}
 				}
 			}
@@ -680,6 +1841,13 @@ func getSubjectIDs(subject interface{}) []string { // nolint: gocyclo
 		}
 
 		return []string{sID}
+	case []interface{}:
+		var res []string
+		for i := range s {
+			res = append(res, getSubjectIDs(s[i])...)
+		}
+
+		return res
 	case verifiable.Subject:
 		return []string{s.ID}
 
@@ -695,9 +1863,13 @@ func getSubjectIDs(subject interface{}) []string { // nolint: gocyclo
 	return nil
 }
 
+// subjectIsIssuer tells whether a subject of the credential is its issuer. The DIDs are compared regardless of
+// their DID URL path, query and fragment, e.g. did:example:123#key-1 is the issuer did:example:123, see normalizeDID.
 func subjectIsIssuer(credential *verifiable.Credential) bool {
+	issuer := normalizeDID(credential.Issuer.ID)
+
 	for _, ID := range getSubjectIDs(credential.Subject) {
-		if ID != "" && ID == credential.Issuer.ID {
+		if ID != "" && normalizeDID(ID) == issuer {
 			return true
 		}
 	}
@@ -705,48 +1877,127 @@ func subjectIsIssuer(credential *verifiable.Credential) bool {
 	return false
 }
 
+// caseInsensitiveDIDMethods are the DID methods whose method-specific IDs are case-insensitive, e.g. the hex
+// addresses of did:ethr. The method-specific IDs of the other methods (e.g. the multibase keys of did:key)
+// are case-sensitive.
+var caseInsensitiveDIDMethods = map[string]bool{"ethr": true}
+
+// normalizeDID returns the DID of the DID URL, without its path, query and fragment, with the scheme and
+// the method in lower case. The method-specific ID is in lower case as well if the method is case-insensitive,
+// and so is the domain of did:web (but not its path). The IDs which are not DIDs are returned as is.
+func normalizeDID(id string) string {
+	parts := strings.SplitN(id, ":", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[0], "did") {
+		return id
+	}
+
+	method, methodSpecificID := strings.ToLower(parts[1]), parts[2]
+
+	if i := strings.IndexAny(methodSpecificID, "/?#"); i >= 0 {
+		methodSpecificID = methodSpecificID[:i]
+	}
+
+	switch {
+	case caseInsensitiveDIDMethods[method]:
+		methodSpecificID = strings.ToLower(methodSpecificID)
+	case method == "web":
+		segments := strings.SplitN(methodSpecificID, ":", 2)
+		segments[0] = strings.ToLower(segments[0])
+		methodSpecificID = strings.Join(segments, ":")
+	}
+
+	return "did:" + method + ":" + methodSpecificID
+}
+
+func schemaURIs(schemas []*Schema) []string {
+	uris := make([]string, len(schemas))
+
+	for i := range schemas {
+		uris[i] = schemas[i].URI
+	}
+
+	return uris
+}
+
 // nolint: gocyclo,funlen,gocognit
-func filterConstraints(constraints *Constraints, creds []*verifiable.Credential,
-	opts ...verifiable.CredentialOpt) ([]*verifiable.Credential, error) {
+func filterConstraints(descriptorID string, constraints *Constraints, creds []*verifiable.Credential,
+	opts *matchRequirementsOpts) ([]*verifiable.Credential, error) {
 	if constraints == nil {
 		return creds, nil
 	}
 
+	holderBindings, err := resolveHolderBindings(constraints)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*verifiable.Credential
 
+	reject := func(credential *verifiable.Credential, fieldIndex int, field *Field, reason error) {
+		rejection := &CredentialRejection{
+			CredentialID: credential.ID,
+			DescriptorID: descriptorID,
+			Stage:        ConstraintsStage,
+			FieldIndex:   fieldIndex,
+			Reason:       reason,
+		}
+
+		if field != nil {
+			rejection.FieldPath = field.Path
+		}
+
+		opts.rejections.add(rejection)
+	}
+
 	for _, credential := range creds {
 		if constraints.SubjectIsIssuer.isRequired() && !subjectIsIssuer(credential) {
+			reject(credential, -1, nil, errors.New("credential subject is not the issuer"))
+
+			continue
+		}
+
+		if err := checkStatuses(constraints.Statuses, credential, opts.statusResolver); err != nil {
+			reject(credential, -1, nil, err)
+
 			continue
 		}
 
 		var applicable bool
 
-		// if credential.JWT is set, credential will marshal to a JSON string.
-		// temporarily clear credential.JWT to avoid this.
-
 		var err error
 
-		credJWT := credential.JWT
-
 		credentialWithFieldValues := credential
 
 		if credential.SDJWTHashAlg != "" {
 			credentialWithFieldValues, err = credential.CreateDisplayCredential(verifiable.DisplayAllDisclosures())
 			if err != nil {
+				err = fmt.Errorf("create display credential: %w", err)
+
+				opts.skip(credential, err)
+				reject(credential, -1, nil, err)
+
 				continue
 			}
 		}
 
-		// if credential.JWT is set, credential will marshal to a JSON string.
-		// temporarily clear credential.JWT to avoid this.
-		credentialWithFieldValues.JWT = ""
-
-		credentialSrc, err := json.Marshal(credentialWithFieldValues)
+		credentialSrc, err := marshalWithoutJWT(credentialWithFieldValues)
 		if err != nil {
+			err = fmt.Errorf("marshal credential: %w", err)
+
+			opts.skip(credential, err)
+			reject(credential, -1, nil, err)
+
 			continue
 		}
 
-		credentialWithFieldValues.JWT = credJWT
+		// unlimited is the credential before it is limited, whose JSON is collected by WithDebugOriginals.
+		unlimited := credential
+
+		if i, err := checkIsHolder(holderBindings, constraints.Fields, credentialSrc, opts); err != nil {
+			reject(credential, i, constraints.Fields[i], fmt.Errorf("is_holder: %w", err))
+
+			continue
+		}
 
 		var credentialMap map[string]interface{}
 
@@ -755,13 +2006,75 @@ func filterConstraints(constraints *Constraints, creds []*verifiable.Credential,
 			return nil, err
 		}
 
+		if credential.JWT != "" {
+			credentialMap = withJWTClaims(credentialWithFieldValues, credentialMap)
+		}
+
 		var predicate bool
 
+		predicateField := -1
+
+		// disclosed are the constraints without the optional fields the credential does not satisfy, and with
+		// the fields narrowed to the paths satisfying them if WithMinimalDisclosure is set.
+		disclosed := *constraints
+		disclosed.Fields = nil
+
+		var satisfied []*FieldAudit
+
+		// failed is set once a field is not satisfied. With WithNearMisses, the remaining fields are evaluated
+		// as well, to report the fields a near-miss credential fails.
+		var failed bool
+
+		nearMiss := opts.nearMisses.track(descriptorID, credential)
+
 		for i, field := range constraints.Fields {
-			err = filterField(field, credentialMap)
+			if err = opts.checkDeadline(); err != nil {
+				return nil, err
+			}
+
+			var pathIndex int
+
+			pathIndex, err = filterField(field, credentialMap, opts)
+			if errors.Is(err, errPathNotApplicable) && opts.presentationClaims != nil {
+				// the field satisfied by the presentation is neither disclosed nor audited by the credential.
+				if f := opts.presentationField(field); f != nil {
+					if _, e := filterField(f, opts.presentationClaims, opts); e == nil {
+						applicable = true
+
+						continue
+					}
+				}
+			}
+
+			if errors.Is(err, errPathNotApplicable) && field.Optional {
+				applicable = true
+
+				continue
+			}
+
 			if errors.Is(err, errPathNotApplicable) {
 				applicable = false
 
+				if !failed {
+					reject(credential, i, field, err)
+				}
+
+				failed = true
+
+				if nearMiss.fail(i, field, credentialMap, err) {
+					continue
+				}
+
+				break
+			}
+
+			if err != nil && failed {
+				// the credential is rejected already: the error of a field evaluated for WithNearMisses only
+				// is recorded rather than failing the match.
+				if nearMiss.fail(i, field, credentialMap, err) {
+					continue
+				}
+
 				break
 			}
 
@@ -769,67 +2082,211 @@ func filterConstraints(constraints *Constraints, creds []*verifiable.Credential,
 				return nil, fmt.Errorf("filter field.%d: %w", i, err)
 			}
 
-			if field.Predicate.isRequired() {
+			if field.Derive != "" {
+				signedValues := credential.SDJWTHashAlg != "" || isMdoc(credential)
+
+				field, err = derivedDisclosure(field, pathIndex, credentialMap, signedValues, opts)
+				if err != nil && constraints.Fields[i].Optional {
+					applicable = true
+
+					continue
+				}
+
+				if err != nil {
+					applicable = false
+
+					if !failed {
+						reject(credential, i, constraints.Fields[i], err)
+					}
+
+					failed = true
+
+					if nearMiss.fail(i, constraints.Fields[i], credentialMap, err) {
+						continue
+					}
+
+					break
+				}
+
+				if signedValues {
+					pathIndex = 0
+				}
+			}
+
+			if field.Predicate.isRequired() && !predicate {
 				predicate = true
+				predicateField = i
+			}
+
+			if opts.audit != nil {
+				satisfied = append(satisfied, fieldAudit(i, field, pathIndex, credentialMap))
+			}
+
+			if opts.minimalDisclosure {
+				field = field.narrowed(pathIndex)
+			}
+
+			if credential.JWT != "" {
+				field = field.inCredential()
 			}
 
+			disclosed.Fields = append(disclosed.Fields, field)
 			applicable = true
 		}
 
+		if failed {
+			applicable = false
+
+			nearMiss.done()
+		}
+
 		if !applicable {
+			if len(constraints.Fields) == 0 {
+				reject(credential, -1, nil, errors.New("constraints have no fields"))
+			}
+
 			continue
 		}
 
-		if (constraints.LimitDisclosure.isRequired() || predicate) && credential.SDJWTHashAlg == "" {
-			template := credentialSrc
+		if predicate && credential.SDJWTHashAlg != "" {
+			reject(credential, predicateField, constraints.Fields[predicateField], ErrPredicateNotSupported)
+
+			continue
+		}
+
+		if predicate && isMdoc(credential) {
+			reject(credential, predicateField, constraints.Fields[predicateField], errMdocPredicate)
+
+			continue
+		}
+
+		if opts.noLimitDisclosure {
+			// PreviewDisclosure, AddSubmissionToPresentation and WithApplicabilityOnly do not limit
+			// the credential.
+			result = append(result, credential)
+			opts.audit.add(descriptorID, credential, satisfied)
+
+			continue
+		}
+
+		limitDisclosure := shouldLimitDisclosure(constraints.LimitDisclosure, credential)
+
+		if doc, ok := MdocOf(credential); ok {
+			if limitDisclosure {
+				credential, err = limitMdoc(credential, doc, disclosed.Fields)
+				if err != nil {
+					return nil, err
+				}
+
+				if opts.postDisclosureVerification {
+					if err = verifyDisclosure(credential, disclosed.Fields, opts); err != nil {
+						return nil, fmt.Errorf("verify disclosure of credential %s: %w", unlimited.ID, err)
+					}
+				}
 
-			var contexts []interface{}
+				opts.originals.add(descriptorID, credential, credentialSrc)
+			}
+
+			result = append(result, credential)
+			opts.audit.add(descriptorID, credential, satisfied)
+
+			continue
+		}
 
-			for _, ctx := range credential.Context {
-				contexts = append(contexts, ctx)
+		if opts.verifiableDisclosure && (limitDisclosure || predicate) && credential.SDJWTHashAlg == "" &&
+			!verifiablyLimited(credential, limitDisclosure, predicate) {
+			if predicate {
+				reject(credential, predicateField, constraints.Fields[predicateField], ErrSelectiveDisclosureNotSupported)
+			} else {
+				reject(credential, -1, nil, ErrSelectiveDisclosureNotSupported)
 			}
 
-			contexts = append(contexts, credential.CustomContext...)
+			continue
+		}
+
+		if (limitDisclosure || predicate) && credential.SDJWTHashAlg == "" {
+			template := credentialSrc
 
-			if constraints.LimitDisclosure.isRequired() {
-				template, err = json.Marshal(map[string]interface{}{
-					"id":                credential.ID,
-					"type":              credential.Types,
-					"@context":          contexts,
-					"issuer":            credential.Issuer,
-					"credentialSubject": toSubject(credential.Subject),
-					"issuanceDate":      credential.Issued,
-				})
+			if limitDisclosure {
+				var fields map[string]interface{}
+
+				fields, err = limitDisclosureTemplate(credential, credentialSrc, disclosed.Fields)
+				if err != nil {
+					return nil, err
+				}
+
+				template, err = json.Marshal(fields)
 				if err != nil {
 					return nil, err
 				}
 			}
 
-			var err error
+			limited, err := createNewCredential(&disclosed, credentialSrc, template, credential, limitDisclosure, opts)
 
-			credential, err = createNewCredential(constraints, credentialSrc, template, credential, opts...)
-			if err != nil {
+			switch {
+			case err == nil:
+				credential = limited
+				opts.markLimited(credential)
+			case !constraints.LimitDisclosure.isRequired() && !predicate:
+				// limit_disclosure is preferred only, so the credential is disclosed in full.
+				opts.log().Debugf("limit disclosure of credential %s: %s", credential.ID, err)
+			default:
 				return nil, fmt.Errorf("create new credential: %w", err)
 			}
-
-			credential.ID = tmpID(credential.ID)
 		}
 
-		if constraints.LimitDisclosure.isRequired() && credential.SDJWTHashAlg != "" {
-			limitedDisclosures, err := getLimitedDisclosures(constraints, credentialSrc, credential)
-			if err != nil {
+		if limitDisclosure && credential.SDJWTHashAlg != "" {
+			limitedDisclosures, err := getLimitedDisclosures(&disclosed, credentialSrc, credential)
+
+			switch {
+			case err == nil:
+				credential = limitedSDJWT(credential, limitedDisclosures)
+			case !constraints.LimitDisclosure.isRequired():
+				// limit_disclosure is preferred only, so all the disclosures are kept.
+				opts.log().Debugf("limit disclosures of credential %s: %s", credential.ID, err)
+			default:
 				return nil, err
 			}
+		}
+
+		if credential != unlimited && opts.postDisclosureVerification {
+			if err = verifyDisclosure(credential, disclosed.Fields, opts); err != nil {
+				return nil, fmt.Errorf("verify disclosure of credential %s: %w", unlimited.ID, err)
+			}
+		}
 
-			credential.SDJWTDisclosures = limitedDisclosures
+		if credential != unlimited {
+			opts.originals.add(descriptorID, credential, credentialSrc)
+			opts.recordLimited(credential, unlimited)
 		}
 
 		result = append(result, credential)
+		opts.audit.add(descriptorID, credential, satisfied)
 	}
 
 	return result, nil
 }
 
+// limitedSDJWT returns a deep copy of the SD-JWT credential with the given disclosures of the credential only.
+// The credential is copied rather than shared, since it may be limited differently for other descriptors and
+// must be left to the caller as given, whatever the holder does with the limited one.
+func limitedSDJWT(credential *verifiable.Credential, disclosures []*common.DisclosureClaim) *verifiable.Credential {
+	limited := copyCredential(credential)
+
+	copies := make(map[*common.DisclosureClaim]*common.DisclosureClaim, len(credential.SDJWTDisclosures))
+	for i, disclosure := range credential.SDJWTDisclosures {
+		copies[disclosure] = limited.SDJWTDisclosures[i]
+	}
+
+	limited.SDJWTDisclosures = nil
+
+	for _, disclosure := range disclosures {
+		limited.SDJWTDisclosures = append(limited.SDJWTDisclosures, copies[disclosure])
+	}
+
+	return limited
+}
+
 // nolint: gocyclo,funlen,gocognit
 func getLimitedDisclosures(constraints *Constraints, displaySrc []byte, credential *verifiable.Credential) ([]*common.DisclosureClaim, error) { // nolint:lll
 	hash, err := common.GetCryptoHash(credential.SDJWTHashAlg)
@@ -837,71 +2294,116 @@ func getLimitedDisclosures(constraints *Constraints, displaySrc []byte, credenti
 		return nil, err
 	}
 
-	vcJWT := credential.JWT
-	credential.JWT = ""
+	credentialSrc, err := marshalWithoutJWT(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	var limitedDisclosures []*common.DisclosureClaim
 
-	credentialSrc, err := json.Marshal(credential)
+	fieldPaths, err := getFieldsJSONPaths(constraints.Fields, displaySrc)
 	if err != nil {
 		return nil, err
 	}
 
-	// revert JWT to original value
-	credential.JWT = vcJWT
+	var credentialDoc interface{}
 
-	var limitedDisclosures []*common.DisclosureClaim
+	if err = json.Unmarshal(credentialSrc, &credentialDoc); err != nil {
+		return nil, err
+	}
+
+	included := map[string]struct{}{}
+
+	for _, fieldPath := range fieldPaths {
+		path := fieldPath.path
+
+		if strings.Contains(path[0], credentialSchema) {
+			continue
+		}
 
-	for _, f := range constraints.Fields {
-		jPaths, err := getJSONPaths(f.Path, displaySrc)
+		chain, err := disclosureChain(credentialDoc, strings.Split(path[1], "."), credential.SDJWTDisclosures, hash)
 		if err != nil {
 			return nil, err
 		}
 
-		for _, path := range jPaths {
-			if strings.Contains(path[0], credentialSchema) {
-				continue
+		for _, dc := range chain {
+			if _, ok := included[dc.Disclosure]; !ok {
+				included[dc.Disclosure] = struct{}{}
+				limitedDisclosures = append(limitedDisclosures, dc)
 			}
+		}
+	}
 
-			parentPath := ""
+	return limitedDisclosures, nil
+}
 
-			key := path[1]
+// disclosureChain returns the disclosures revealing the value at the path of the credential JSON: the disclosure
+// of the value if it is selectively disclosable, along with the disclosures of the selectively disclosable objects
+// it is nested in, at any depth (e.g. the disclosure of address for credentialSubject.address.street when address
+// is disclosed as an object having the digest of street). There are none if the value cannot be revealed.
+func disclosureChain(doc interface{}, path []string, disclosures []*common.DisclosureClaim,
+	hash crypto.Hash) ([]*common.DisclosureClaim, error) {
+	var chain []*common.DisclosureClaim
 
-			pathParts := strings.Split(path[1], ".")
-			if len(pathParts) > 1 {
-				parentPath = strings.Join(pathParts[:len(pathParts)-1], ".")
-				key = pathParts[len(pathParts)-1]
-			}
+	for _, key := range path {
+		switch obj := doc.(type) {
+		case map[string]interface{}:
+			if value, ok := obj[key]; ok {
+				doc = value
 
-			parentObj, ok := gjson.GetBytes(credentialSrc, parentPath).Value().(map[string]interface{})
-			if !ok {
-				// no selective disclosures at this level, so nothing to add to limited disclosures
 				continue
 			}
 
-			digests, err := common.GetDisclosureDigests(parentObj)
-			if err != nil {
+			dc, err := findDisclosure(obj, key, disclosures, hash)
+			if err != nil || dc == nil {
 				return nil, err
 			}
 
-			for _, dc := range credential.SDJWTDisclosures {
-				if dc.Name == key {
-					digest, err := common.GetHash(hash, dc.Disclosure)
-					if err != nil {
-						return nil, err
-					}
-
-					if _, ok := digests[digest]; ok {
-						limitedDisclosures = append(limitedDisclosures, dc)
-					}
-				}
+			chain = append(chain, dc)
+			doc = dc.Value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(obj) {
+				return nil, nil
 			}
+
+			doc = obj[i]
+		default:
+			return nil, nil
 		}
 	}
 
-	return limitedDisclosures, nil
+	return chain, nil
+}
+
+// findDisclosure returns the disclosure of the named claim of the object, i.e. the one whose digest the object has.
+func findDisclosure(obj map[string]interface{}, name string, disclosures []*common.DisclosureClaim,
+	hash crypto.Hash) (*common.DisclosureClaim, error) {
+	digests, err := common.GetDisclosureDigests(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dc := range disclosures {
+		if dc.Name != name {
+			continue
+		}
+
+		digest, err := common.GetHash(hash, dc.Disclosure)
+		if err != nil {
+			return nil, err
+		}
+
+		if digests[digest] {
+			return dc, nil
+		}
+	}
+
+	return nil, nil
 }
 
 func frameCreds(frame map[string]interface{}, creds []*verifiable.Credential,
-	opts ...verifiable.CredentialOpt) ([]*verifiable.Credential, error) {
+	opts *matchRequirementsOpts) ([]*verifiable.Credential, error) {
 	if frame == nil {
 		return creds, nil
 	}
@@ -909,7 +2411,7 @@ func frameCreds(frame map[string]interface{}, creds []*verifiable.Credential,
 	var result []*verifiable.Credential
 
 	for _, credential := range creds {
-		bbsVC, err := credential.GenerateBBSSelectiveDisclosure(frame, nil, opts...)
+		bbsVC, err := opts.selectiveDisclosure(credential, frame, nil, opts.credOpts...)
 		if err != nil {
 			return nil, err
 		}
@@ -920,6 +2422,44 @@ func frameCreds(frame map[string]interface{}, creds []*verifiable.Credential,
 	return result, nil
 }
 
+// frameDescriptorCreds frames the credentials with the frame of the input descriptor. Unlike the frame of
+// the presentation definition, the credentials which cannot be framed are rejected rather than failing the match.
+func frameDescriptorCreds(descriptor *InputDescriptor, creds []*verifiable.Credential,
+	opts *matchRequirementsOpts) []*verifiable.Credential {
+	var result []*verifiable.Credential
+
+	for _, credential := range creds {
+		framed, err := frameCreds(descriptor.Frame, []*verifiable.Credential{credential}, opts)
+		if err != nil {
+			opts.rejections.add(&CredentialRejection{
+				CredentialID: credential.ID,
+				DescriptorID: descriptor.ID,
+				Stage:        FrameStage,
+				FieldIndex:   -1,
+				Reason:       fmt.Errorf("frame credential: %w", err),
+			})
+
+			continue
+		}
+
+		result = append(result, framed...)
+	}
+
+	return result
+}
+
+// marshalWithoutJWT marshals the credential without its JWT, or the data elements of an mdoc credential.
+func marshalWithoutJWT(credential *verifiable.Credential) ([]byte, error) {
+	if doc, ok := MdocOf(credential); ok {
+		return json.Marshal(doc.NameSpaces)
+	}
+
+	vc := *credential
+	vc.JWT = ""
+
+	return json.Marshal(&vc)
+}
+
 func toSubject(subject interface{}) interface{} {
 	sub, ok := subject.([]verifiable.Subject)
 	if ok && len(sub) == 1 {
@@ -929,8 +2469,115 @@ func toSubject(subject interface{}) interface{} {
 	return subject
 }
 
-func tmpID(id string) string {
-	return id + tmpEnding + uuid.New().String()
+// limitedSubject returns the subject of the limited credential, which the disclosed claims are added to. An array
+// of subjects is limited to the IDs of the subjects the fields disclose claims of (or of all the subjects if they
+// disclose none), without their other claims. They are kept in the order of the array since its indices are
+// compacted in the limited credential (see getPath), so that the claims are added to the subject they belong to,
+// and the IDs of the other subjects are not disclosed.
+func limitedSubject(credential *verifiable.Credential, credentialSrc []byte, fields []*Field) (interface{}, error) {
+	subject := gjson.GetBytes(credentialSrc, "credentialSubject")
+
+	if !subject.IsArray() {
+		return toSubject(credential.Subject), nil
+	}
+
+	fieldPaths, err := getFieldsJSONPaths(fields, credentialSrc)
+	if err != nil {
+		return nil, err
+	}
+
+	disclosed := map[int]bool{}
+
+	for _, fieldPath := range fieldPaths {
+		keys := strings.SplitN(fieldPath.path[1], ".", 3)
+		if len(keys) < 2 || keys[0] != "credentialSubject" {
+			continue
+		}
+
+		if i, err := strconv.Atoi(keys[1]); err == nil {
+			disclosed[i] = true
+		}
+	}
+
+	subjects := []interface{}{}
+
+	for i, s := range subject.Array() {
+		if len(disclosed) == 0 || disclosed[i] {
+			subjects = append(subjects, subjectWithoutClaims(s.Value()))
+		}
+	}
+
+	return subjects, nil
+}
+
+// subjectWithoutClaims returns the subject object with its id only. The subject IDs (strings) are returned as is.
+func subjectWithoutClaims(subject interface{}) interface{} {
+	s, ok := subject.(map[string]interface{})
+	if !ok {
+		return subject
+	}
+
+	if id, ok := s["id"]; ok {
+		return map[string]interface{}{"id": id}
+	}
+
+	return map[string]interface{}{}
+}
+
+// limitDisclosureTemplate returns the mandatory properties of the credential, which the disclosed fields are
+// added to. The id and type are copied as they are in the credential (e.g. a single type of VC Data Model 2.0
+// is kept a string). The validity dates depend on the data model: issuanceDate of VC Data Model 1.1, or validFrom
+// and validUntil (if any) of VC Data Model 2.0, which renames them. An array of subjects is limited to the IDs
+// of the subjects the fields disclose claims of (see limitedSubject).
+func limitDisclosureTemplate(credential *verifiable.Credential, credentialSrc []byte,
+	fields []*Field) (map[string]interface{}, error) {
+	subject, err := limitedSubject(credential, credentialSrc, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var contexts []interface{}
+
+	for _, ctx := range credential.Context {
+		contexts = append(contexts, ctx)
+	}
+
+	contexts = append(contexts, credential.CustomContext...)
+
+	template := map[string]interface{}{
+		"id":                credential.ID,
+		"type":              credential.Types,
+		"@context":          contexts,
+		"issuer":            credential.Issuer,
+		"credentialSubject": subject,
+	}
+
+	if typ := gjson.GetBytes(credentialSrc, "type"); typ.Exists() {
+		template["type"] = typ.Value()
+	}
+
+	if len(credential.Context) == 0 || credential.Context[0] != credentialsV2Context {
+		template["issuanceDate"] = credential.Issued
+
+		return template, nil
+	}
+
+	// VC Data Model 2.0 makes id optional.
+	if credential.ID == "" {
+		delete(template, "id")
+	}
+
+	for _, property := range []string{"validFrom", "validUntil"} {
+		if value := gjson.GetBytes(credentialSrc, property); value.Exists() {
+			template[property] = value.Value()
+		}
+	}
+
+	return template, nil
+}
+
+func tmpID(id, unique string) string {
+	return id + tmpEnding + unique
 }
 
 func trimTmpID(id string) string {
@@ -943,54 +2590,61 @@ func trimTmpID(id string) string {
 }
 
 // nolint: funlen,gocognit,gocyclo
-func createNewCredential(constraints *Constraints, src, limitedCred []byte,
-	credential *verifiable.Credential, opts ...verifiable.CredentialOpt) (*verifiable.Credential, error) {
+func createNewCredential(constraints *Constraints, src, limitedCred []byte, credential *verifiable.Credential,
+	limitDisclosure bool, matchOpts *matchRequirementsOpts) (*verifiable.Credential, error) {
 	var (
+		opts                = append([]verifiable.CredentialOpt{}, matchOpts.credOpts...)
 		BBSSupport          = hasBBS(credential)
 		modifiedByPredicate bool
 		explicitPaths       = make(map[string]bool)
 	)
 
-	for _, f := range constraints.Fields {
-		jPaths, err := getJSONPaths(f.Path, src)
-		if err != nil {
-			return nil, err
+	fieldPaths, err := getFieldsJSONPaths(constraints.Fields, src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fieldPath := range fieldPaths {
+		f, path := fieldPath.field, fieldPath.path
+
+		if strings.Contains(path[0], credentialSchema) {
+			continue
 		}
 
-		for _, path := range jPaths {
-			if strings.Contains(path[0], credentialSchema) {
-				continue
-			}
+		var val interface{} = true
 
-			var val interface{} = true
+		if !modifiedByPredicate {
+			modifiedByPredicate = f.Predicate.isRequired()
+		}
 
-			if !modifiedByPredicate {
-				modifiedByPredicate = f.Predicate.isRequired()
-			}
+		if f.Predicate == nil || *f.Predicate != Required {
+			val = gjson.GetBytes(src, path[1]).Value()
+		}
 
-			if f.Predicate == nil || *f.Predicate != Required {
-				val = gjson.GetBytes(src, path[1]).Value()
-			}
+		if limitDisclosure && BBSSupport {
+			chunks := strings.Split(path[0], ".")
+			explicitPath := strings.Join(chunks[:len(chunks)-1], ".")
+			explicitPaths[explicitPath] = true
+		}
 
-			if constraints.LimitDisclosure.isRequired() && BBSSupport {
-				chunks := strings.Split(path[0], ".")
-				explicitPath := strings.Join(chunks[:len(chunks)-1], ".")
-				explicitPaths[explicitPath] = true
-			}
+		// the array indices are compacted in the limited credential only.
+		target := path[0]
+		if !limitDisclosure {
+			target = path[1]
+		}
 
-			limitedCred, err = sjson.SetBytes(limitedCred, path[0], val)
-			if err != nil {
-				return nil, err
-			}
+		limitedCred, err = sjson.SetBytes(limitedCred, target, val)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if !constraints.LimitDisclosure.isRequired() || !BBSSupport || modifiedByPredicate {
+	if !limitDisclosure || !BBSSupport || modifiedByPredicate {
 		opts = append(opts, verifiable.WithDisabledProofCheck())
 		return verifiable.ParseCredential(limitedCred, opts...)
 	}
 
-	limitedCred, err := enhanceRevealDoc(explicitPaths, limitedCred, src)
+	limitedCred, err = enhanceRevealDoc(explicitPaths, limitedCred, src)
 	if err != nil {
 		return nil, err
 	}
@@ -1000,34 +2654,71 @@ func createNewCredential(constraints *Constraints, src, limitedCred []byte,
 		return nil, err
 	}
 
-	return credential.GenerateBBSSelectiveDisclosure(doc, []byte(uuid.New().String()), opts...)
+	return matchOpts.selectiveDisclosure(credential, doc, []byte(matchOpts.newID()), opts...)
 }
 
 func getJSONPaths(keys []string, src []byte) ([][2]string, error) {
-	paths, err := jsonpathkeys.ParsePaths(keys...)
+	fieldPaths, err := getFieldsJSONPaths([]*Field{{Path: keys}}, src)
 	if err != nil {
 		return nil, err
 	}
 
-	eval, err := jsonpathkeys.EvalPathsInReader(bytes.NewReader(src), paths)
-	if err != nil {
+	var jPaths [][2]string
+
+	for _, fieldPath := range fieldPaths {
+		jPaths = append(jPaths, fieldPath.path)
+	}
+
+	return jPaths, nil
+}
+
+// fieldJSONPath is a location matched by the field: the path with the array indices compacted (i.e. the path
+// in the limited credential) and the path in the original credential.
+type fieldJSONPath struct {
+	field *Field
+	path  [2]string
+}
+
+// getFieldsJSONPaths returns the locations matched by the fields ordered by their location in the document.
+// The array indices are compacted over all the fields, so that the array elements matched by different fields
+// keep their order and are not written over each other in the limited credential.
+func getFieldsJSONPaths(fields []*Field, src []byte) ([]*fieldJSONPath, error) {
+	var doc interface{}
+	if err := json.Unmarshal(src, &doc); err != nil {
 		return nil, err
 	}
 
-	var jPaths [][2]string
+	type fieldMatch struct {
+		field *Field
+		keys  []interface{}
+	}
 
-	set := map[string]int{}
+	var matches []*fieldMatch
 
-	for {
-		result, ok := eval.Next()
-		if !ok {
-			break
+	for _, f := range fields {
+		fieldMatches, err := jsonPathMatches(issuerDisclosurePaths(f.Path, doc), doc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range fieldMatches {
+			matches = append(matches, &fieldMatch{field: f, keys: m.keys})
 		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareKeys(matches[i].keys, matches[j].keys) < 0
+	})
+
+	fieldPaths := make([]*fieldJSONPath, len(matches))
 
-		jPaths = append(jPaths, getPath(result.Keys, set))
+	indices, counts := map[string]int{}, map[string]int{}
+
+	for i, m := range matches {
+		fieldPaths[i] = &fieldJSONPath{field: m.field, path: getPath(m.keys, indices, counts)}
 	}
 
-	return jPaths, nil
+	return fieldPaths, nil
 }
 
 func enhanceRevealDoc(explicitPaths map[string]bool, limitedCred, vcBytes []byte) ([]byte, error) {
@@ -1101,6 +2792,68 @@ func enhanceRevealField(path string, limitedCred, vcBytes []byte) ([]byte, error
 	return limitedCred, nil
 }
 
+// shouldLimitDisclosure checks whether the disclosure of the credential is to be limited: always if limit_disclosure
+// is required, and only for credentials supporting selective disclosure (BBS+ or SD-JWT) if it is preferred.
+func shouldLimitDisclosure(limitDisclosure *Preference, vc *verifiable.Credential) bool {
+	if limitDisclosure.isRequired() {
+		return true
+	}
+
+	return limitDisclosure.isPreferred() && (hasBBS(vc) || vc.SDJWTHashAlg != "" || isMdoc(vc))
+}
+
+// verifiablyLimited checks whether the credential limited by createNewCredential can be verified: the unsigned
+// credentials have no proof to verify, and a BBS+ proof is derived for the credential limited without predicates.
+// The other proofs (and the JWT) are dropped, as they do not sign the limited credential.
+func verifiablyLimited(vc *verifiable.Credential, limitDisclosure, predicate bool) bool {
+	if len(vc.Proofs) == 0 && vc.JWT == "" {
+		return true
+	}
+
+	return limitDisclosure && !predicate && hasBBS(vc)
+}
+
+// verifyDisclosure checks that the limited credential still satisfies the disclosed fields, see
+// WithPostDisclosureVerification. The values of the fields with a required predicate are replaced with true,
+// so only their presence is checked.
+func verifyDisclosure(credential *verifiable.Credential, fields []*Field, opts *matchRequirementsOpts) error {
+	var err error
+
+	if credential.SDJWTHashAlg != "" {
+		credential, err = credential.CreateDisplayCredential(verifiable.DisplayAllDisclosures())
+		if err != nil {
+			return fmt.Errorf("create display credential: %w", err)
+		}
+	}
+
+	// the ID of the limited credential is unique until it is presented (see tmpID).
+	limited := *credential
+	limited.ID = trimTmpID(credential.ID)
+
+	credentialSrc, err := marshalWithoutJWT(&limited)
+	if err != nil {
+		return fmt.Errorf("marshal credential: %w", err)
+	}
+
+	var credentialMap map[string]interface{}
+
+	if err = json.Unmarshal(credentialSrc, &credentialMap); err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if field.Predicate.isRequired() {
+			field = &Field{Path: field.Path}
+		}
+
+		if _, err = filterField(field, credentialMap, opts); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDisclosureNotVerified, strings.Join(field.Path, ","), err)
+		}
+	}
+
+	return nil
+}
+
 func hasBBS(vc *verifiable.Credential) bool {
 	for _, proof := range vc.Proofs {
 		if proof["type"] == "BbsBlsSignature2020" {
@@ -1111,9 +2864,25 @@ func hasBBS(vc *verifiable.Credential) bool {
 	return false
 }
 
-func hasProofWithType(vc *verifiable.Credential, proofType string) bool {
+// hasProofWithType checks whether the credential has a proof of the type. A Data Integrity proof is of the type
+// of its cryptosuite as well, and it must be of one of the cryptosuites if any are given.
+func hasProofWithType(vc *verifiable.Credential, proofType string, cryptosuites []string) bool {
 	for _, proof := range vc.Proofs {
-		if proof["type"] == proofType {
+		if proof["type"] != dataIntegrityProofType {
+			if proof["type"] == proofType {
+				return true
+			}
+
+			continue
+		}
+
+		cryptosuite, _ := proof["cryptosuite"].(string)
+
+		if len(cryptosuites) != 0 && !stringsContain(cryptosuites, cryptosuite) {
+			continue
+		}
+
+		if proofType == dataIntegrityProofType || cryptosuite != "" && cryptosuite == proofType {
 			return true
 		}
 	}
@@ -1121,51 +2890,162 @@ func hasProofWithType(vc *verifiable.Credential, proofType string) bool {
 	return false
 }
 
-func filterField(f *Field, credential map[string]interface{}) error {
-	var schema gojsonschema.JSONLoader
+// filterField returns the index of the first path of the field satisfied by the credential.
+func filterField(f *Field, credential map[string]interface{}, opts *matchRequirementsOpts) (int, error) {
+	var lastErr error
 
-	if f.Filter != nil {
-		schema = gojsonschema.NewGoLoader(*f.Filter)
-	}
+	for i, path := range f.Path {
+		values, err := fieldValues(path, credential)
+		if err != nil {
+			lastErr = fmt.Errorf("%w: %s", errPathNotApplicable, err)
 
-	var lastErr error
+			continue
+		}
+
+		for _, patch := range values {
+			if f.Derive != "" {
+				patch, err = derivedValue(f, patch, opts)
+				if err != nil {
+					lastErr = fmt.Errorf("%w: %s", errPathNotApplicable, err)
+
+					continue
+				}
+			}
 
-	for _, path := range f.Path {
-		patch, err := jsonpath.Get(path, credential)
-		if err == nil {
-			err = validatePatch(schema, patch)
+			err = validateFilter(f.pathFilter(i), patch, opts)
 			if err == nil {
-				return nil
+				return i, nil
 			}
 
 			lastErr = err
-		} else {
-			lastErr = errPathNotApplicable
 		}
 	}
 
-	return lastErr
+	return -1, lastErr
+}
+
+// narrowed returns a copy of the field with the i-th path only (and the filter of the path). A field with
+// a required predicate is kept as is, for the values of all its paths to be replaced.
+func (f *Field) narrowed(i int) *Field {
+	if len(f.Path) <= 1 || f.Predicate.isRequired() {
+		return f
+	}
+
+	narrowed := *f
+	narrowed.Path = []string{f.Path[i]}
+	narrowed.Filter = f.pathFilter(i)
+	narrowed.Filters = nil
+
+	return &narrowed
+}
+
+// MinimalDisclosurePaths returns the paths the credential (unmarshaled to a map) must disclose to satisfy
+// the fields: the first path satisfying each field, rather than all the paths of the field. The optional fields
+// the credential does not satisfy are omitted, and an error is returned if it does not satisfy a required field.
+// These are the paths a credential is limited to with limit_disclosure and WithMinimalDisclosure.
+func (c *Constraints) MinimalDisclosurePaths(credential map[string]interface{}) ([]string, error) {
+	opts := newMatchRequirementsOpts(nil)
+
+	var paths []string
+
+	for i, field := range c.Fields {
+		pathIndex, err := filterField(field, credential, opts)
+		if errors.Is(err, errPathNotApplicable) && field.Optional {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("field %d is not satisfied: %w", i, err)
+		}
+
+		if !stringsContain(paths, field.Path[pathIndex]) {
+			paths = append(paths, field.Path[pathIndex])
+		}
+	}
+
+	return paths, nil
 }
 
-func validatePatch(schema gojsonschema.JSONLoader, patch interface{}) error {
-	if schema == nil {
+func validateFilter(f *Filter, patch interface{}, opts *matchRequirementsOpts) error {
+	if f == nil {
 		return nil
 	}
 
+	schema, err := opts.filterSchema(f)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPathNotApplicable, err)
+	}
+
+	if opts.typeCoercion {
+		patch = coerceValue(f.coercionType(), patch)
+	}
+
+	err = validatePatch(schema, patch)
+	if err == nil && f.isDateFilter() {
+		err = validateDateRange(f, patch)
+	}
+
+	if err == nil && !f.isDateFilter() && f.hasStringFormat() {
+		err = validateStringFormat(f, patch)
+	}
+
+	if err == nil && f.hasContainsBounds() {
+		err = validateContains(f, patch, opts)
+	}
+
+	return err
+}
+
+// compileFilter compiles the JSON schema of the filter without the keywords which are validated separately:
+// the date bounds, the string formats and the contains bounds. The external $refs are loaded with the loader.
+func compileFilter(f *Filter, loader FilterRefLoader) (*gojsonschema.Schema, error) {
+	dateFilter := f.isDateFilter()
+	filter := f
+
+	if dateFilter {
+		filter = filter.withoutDateBounds()
+	}
+
+	if !dateFilter && f.hasStringFormat() {
+		filter = filter.withoutFormat()
+	}
+
+	if f.hasContainsBounds() {
+		filter = filter.withoutContains()
+	}
+
+	return newFilterSchema(filter, loader)
+}
+
+func validatePatch(schema *gojsonschema.Schema, patch interface{}) error {
 	raw, err := json.Marshal(patch)
 	if err != nil {
 		return err
 	}
 
-	result, err := gojsonschema.Validate(schema, gojsonschema.NewBytesLoader(raw))
-	if err != nil || !result.Valid() {
-		return errPathNotApplicable
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPathNotApplicable, err)
+	}
+
+	if !result.Valid() {
+		resultErrors := result.Errors()
+
+		errs := make([]string, len(resultErrors))
+		for i := range resultErrors {
+			errs[i] = resultErrors[i].String()
+		}
+
+		return fmt.Errorf("%w: %s", errPathNotApplicable, strings.Join(errs, ","))
 	}
 
 	return nil
 }
 
-func getPath(keys []interface{}, set map[string]int) [2]string {
+// getPath returns the path of the keys with the array indices compacted and the original path. The indices
+// maps the original paths of the array elements to their compacted indices, and the counts maps the original
+// paths of the arrays to the number of their elements compacted so far.
+func getPath(keys []interface{}, indices, counts map[string]int) [2]string {
 	var (
 		newPath      []string
 		originalPath []string
@@ -1178,12 +3058,12 @@ func getPath(keys []interface{}, set map[string]int) [2]string {
 			originalPath = append(originalPath, fmt.Sprintf("%d", v))
 			mapperKey := strings.Join(originalPath, ".")
 
-			if _, ok := set[mapperKey]; !ok {
-				set[mapperKey] = set[counterKey]
-				set[counterKey]++
+			if _, ok := indices[mapperKey]; !ok {
+				indices[mapperKey] = counts[counterKey]
+				counts[counterKey]++
 			}
 
-			newPath = append(newPath, fmt.Sprintf("%d", set[mapperKey]))
+			newPath = append(newPath, fmt.Sprintf("%d", indices[mapperKey]))
 		default:
 			originalPath = append(originalPath, fmt.Sprintf("%s", v))
 			newPath = append(newPath, fmt.Sprintf("%s", v))
@@ -1193,13 +3073,30 @@ func getPath(keys []interface{}, set map[string]int) [2]string {
 	return [...]string{strings.Join(newPath, "."), strings.Join(originalPath, ".")}
 }
 
-func merge(presentationFormat string, setOfCredentials map[string][]*verifiable.Credential) ([]*verifiable.Credential, []*InputDescriptorMapping) { //nolint:lll
+// mappingKey identifies the mapping of an input descriptor to a credential, see matchRequirementsOpts.credentialKey.
+type mappingKey struct {
+	descriptorID  string
+	credentialKey string
+}
+
+// merge adds the matched credentials to the presentation options, along with their input descriptor mappings,
+// in the order documented by CreateVP. The mappings are sorted stably, for the mappings of the same input
+// descriptor to keep the order of the credentials. A credential matched by several input descriptors is added once,
+// and every input descriptor gets its own mapping to it; the mappings are deduplicated per input descriptor only.
+//
+// The credentials are told apart by their credential key, so that a limited credential is not confused with
+// the original one (or the one limited for another input descriptor) of the same ID. The unique values are trimmed
+// from the IDs of the limited credentials once all the credentials are added, for the keys not to change meanwhile.
+func merge(presentationFormat string, setOfCredentials map[string][]*verifiable.Credential,
+	opts *matchRequirementsOpts) ([]verifiable.CreatePresentationOpt, []*InputDescriptorMapping) {
 	setOfCreds := make(map[string]int)
-	setOfDescriptors := make(map[string]struct{})
+	setOfPresentations := make(map[*verifiable.Presentation]int)
+	setOfMappings := make(map[mappingKey]struct{})
 
 	var (
-		result      []*verifiable.Credential
+		result      []verifiable.CreatePresentationOpt
 		descriptors []*InputDescriptorMapping
+		added       []*verifiable.Credential
 	)
 
 	keys := make([]string, 0, len(setOfCredentials))
@@ -1213,114 +3110,249 @@ func merge(presentationFormat string, setOfCredentials map[string][]*verifiable.
 		credentials := setOfCredentials[descriptorID]
 
 		for _, credential := range credentials {
-			if _, ok := setOfCreds[credential.ID]; !ok {
-				credential.ID = trimTmpID(credential.ID)
-				result = append(result, credential)
-				setOfCreds[credential.ID] = len(descriptors)
+			credentialKey := opts.credentialKey(credential)
+
+			key := mappingKey{descriptorID: descriptorID, credentialKey: credentialKey}
+			if _, ok := setOfMappings[key]; ok {
+				continue
 			}
 
-			vcFormat := FormatLDPVC
-			if credential.JWT != "" {
-				vcFormat = FormatJWTVC
+			setOfMappings[key] = struct{}{}
+
+			if enclosing, ok := opts.enclosing[credential]; ok {
+				idx, added := setOfPresentations[enclosing.presentation]
+				if !added {
+					idx = len(result)
+					result = append(result, verifiable.WithPresentations(enclosing.presentation))
+					setOfPresentations[enclosing.presentation] = idx
+				}
+
+				descriptors = append(descriptors,
+					nestedDescriptorMapping(descriptorID, presentationFormat, credential, enclosing, idx))
+
+				continue
 			}
 
-			if _, ok := setOfDescriptors[fmt.Sprintf("%s-%s", credential.ID, credential.ID)]; !ok {
-				descriptors = append(descriptors, &InputDescriptorMapping{
-					ID:     descriptorID,
-					Format: presentationFormat,
-					Path:   "$",
-					PathNested: &InputDescriptorMapping{
-						ID:     descriptorID,
-						Format: vcFormat,
-						Path:   fmt.Sprintf("$.verifiableCredential[%d]", setOfCreds[credential.ID]),
-					},
-				})
+			if _, ok := setOfCreds[credentialKey]; !ok {
+				setOfCreds[credentialKey] = len(result)
+				result = append(result, verifiable.WithCredentials(credential))
+				added = append(added, credential)
 			}
+
+			descriptors = append(descriptors,
+				descriptorMapping(descriptorID, presentationFormat, credential, setOfCreds[credentialKey]))
 		}
 	}
 
-	sort.Sort(byID(descriptors))
+	if !opts.stableCredentialIDs {
+		for _, credential := range added {
+			credential.ID = trimTmpID(credential.ID)
+		}
+	}
+
+	sort.Stable(byID(descriptors))
 
 	return result, descriptors
 }
 
+// descriptorMapping maps the input descriptor to the credential at the given index of the presentation.
+func descriptorMapping(descriptorID, presentationFormat string, credential *verifiable.Credential,
+	idx int) *InputDescriptorMapping {
+	vcFormat := FormatLDPVC
+
+	switch {
+	case credential.SDJWTHashAlg != "" && isSDJWTFormat(presentationFormat):
+		vcFormat = presentationFormat
+	case isMdoc(credential):
+		vcFormat = FormatMsoMdoc
+	case credential.JWT != "":
+		vcFormat = FormatJWTVC
+	}
+
+	return &InputDescriptorMapping{
+		ID:     descriptorID,
+		Format: presentationFormat,
+		Path:   "$",
+		PathNested: &InputDescriptorMapping{
+			ID:     descriptorID,
+			Format: vcFormat,
+			Path:   fmt.Sprintf("$.verifiableCredential[%d]", idx),
+		},
+	}
+}
+
+func isSDJWTFormat(format string) bool {
+	return format == FormatSDJWT || format == FormatDCSDJWT
+}
+
 type byID []*InputDescriptorMapping
 
 func (a byID) Len() int           { return len(a) }
 func (a byID) Less(i, j int) bool { return a[i].ID < a[j].ID }
 func (a byID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
-//nolint:funlen,gocyclo
-func filterFormat(format *Format, credentials []*verifiable.Credential) (string, []*verifiable.Credential) {
-	var ldpCreds, ldpvcCreds, ldpvpCreds, jwtCreds, jwtvcCreds, jwtvpCreds []*verifiable.Credential
-
-	for _, credential := range credentials {
-		if credByProof(credential, format.Ldp) {
-			ldpCreds = append(ldpCreds, credential)
-		}
+// formatFamilies are the claim formats the credentials are filtered by, in the order of precedence, grouped by
+// the kind of the presentation the credentials are presented with.
+var formatFamilies = [][]string{
+	{FormatLDP, FormatLDPVC, FormatLDPVP},
+	{FormatSDJWT, FormatDCSDJWT},
+	{FormatJWT, FormatJWTVC, FormatJWTVP},
+	{FormatMsoMdoc},
+}
 
-		if credByProof(credential, format.LdpVC) {
-			ldpvcCreds = append(ldpvcCreds, credential)
-		}
+// filterFormat selects the first family of the formats (see formatFamilies) some credentials fit, and returns all
+// the credentials fitting any format of the family, so that a credential with several proofs (e.g. a BBS+ and
+// an Ed25519 one) is kept whichever of its proofs the formats require. The format of the family fitted by most
+// of the credentials is returned along with them (the first one in the order of precedence on a tie).
+//
+// The preferred formats (see WithPreferredFormats) take precedence over formatFamilies: the families of
+// the preferred formats come first, and the first preferred format some credentials fit is selected in its family.
+func filterFormat(format *Format, credentials []*verifiable.Credential,
+	preferred []string, opts *matchRequirementsOpts) (string, []*verifiable.Credential, error) {
+	fits := make([]map[string]bool, len(credentials))
+
+	for i, credential := range credentials {
+		var err error
 
-		if credByProof(credential, format.LdpVP) {
-			ldpvpCreds = append(ldpvpCreds, credential)
+		fits[i], err = credentialFormats(format, credential, opts)
+		if err != nil {
+			return "", nil, err
 		}
+	}
 
+	for _, family := range preferredFamilies(preferred) {
 		var (
-			alg    string
-			hasAlg bool
+			result []*verifiable.Credential
+			counts = map[string]int{}
 		)
 
-		if credential.JWT != "" {
-			pJWT, err := jwt.Parse(credential.JWT, jwt.WithSignatureVerifier(&noVerifier{}))
-			if err != nil {
-				logger.Warnf("unmarshal credential error: %w", err)
+		for i, credential := range credentials {
+			fitted := false
 
-				continue
+			for _, f := range family {
+				if fits[i][f] {
+					counts[f]++
+					fitted = true
+				}
+			}
+
+			if fitted {
+				result = append(result, credential)
 			}
+		}
+
+		if len(result) == 0 {
+			continue
+		}
+
+		selected := ""
 
-			alg, hasAlg = pJWT.Headers.Algorithm()
+		for _, f := range preferred {
+			if counts[f] > 0 && stringsContain(family, f) {
+				return f, result, nil
+			}
 		}
 
-		if hasAlg && algMatch(alg, format.Jwt) {
-			jwtCreds = append(jwtCreds, credential)
+		for _, f := range family {
+			if counts[f] > counts[selected] {
+				selected = f
+			}
 		}
 
-		if hasAlg && algMatch(alg, format.JwtVC) {
-			jwtvcCreds = append(jwtvcCreds, credential)
+		return selected, result, nil
+	}
+
+	return "", nil, nil
+}
+
+// preferredFamilies returns formatFamilies ordered by the first of the preferred formats in each family,
+// the families without preferred formats keeping their order after the others.
+func preferredFamilies(preferred []string) [][]string {
+	if len(preferred) == 0 {
+		return formatFamilies
+	}
+
+	families := make([][]string, 0, len(formatFamilies))
+
+	for _, f := range preferred {
+		for _, family := range formatFamilies {
+			if stringsContain(family, f) && !containsFamily(families, family) {
+				families = append(families, family)
+			}
 		}
+	}
 
-		if hasAlg && algMatch(alg, format.JwtVP) {
-			jwtvpCreds = append(jwtvpCreds, credential)
+	for _, family := range formatFamilies {
+		if !containsFamily(families, family) {
+			families = append(families, family)
 		}
 	}
 
-	if len(ldpCreds) > 0 {
-		return FormatLDP, ldpCreds
+	return families
+}
+
+func containsFamily(families [][]string, family []string) bool {
+	for _, f := range families {
+		if f[0] == family[0] {
+			return true
+		}
 	}
 
-	if len(ldpvcCreds) > 0 {
-		return FormatLDPVC, ldpvcCreds
+	return false
+}
+
+// credentialFormats returns the formats the credential fits: the LDP formats requiring the type of any of
+// the credential proofs, the JWT formats with the algorithm of the credential JWT, and the mso_mdoc format
+// with the algorithm of the mdoc.
+// A JSON-LD credential without proofs fits no format, unless WithExternalProofs is set. A JWT which cannot be
+// parsed fails with ErrUnparsableJWT if WithStrictJWTParsing is set, and fits no JWT format otherwise.
+func credentialFormats(format *Format, credential *verifiable.Credential,
+	opts *matchRequirementsOpts) (map[string]bool, error) {
+	if doc, ok := MdocOf(credential); ok {
+		return map[string]bool{FormatMsoMdoc: algMatch(doc.Alg, format.MsoMdoc)}, nil
+	}
+
+	if opts.externalProofs && credential.JWT == "" && len(credential.Proofs) == 0 {
+		return map[string]bool{
+			FormatLDP:   format.Ldp != nil,
+			FormatLDPVC: format.LdpVC != nil,
+			FormatLDPVP: format.LdpVP != nil,
+		}, nil
 	}
 
-	if len(ldpvpCreds) > 0 {
-		return FormatLDPVP, ldpvpCreds
+	fits := map[string]bool{
+		FormatLDP:   credByProof(credential, format.Ldp),
+		FormatLDPVC: credByProof(credential, format.LdpVC),
+		FormatLDPVP: credByProof(credential, format.LdpVP),
 	}
 
-	if len(jwtCreds) > 0 {
-		return FormatJWT, jwtCreds
+	if credential.JWT == "" {
+		return fits, nil
 	}
 
-	if len(jwtvcCreds) > 0 {
-		return FormatJWTVC, jwtvcCreds
+	pJWT, err := jwt.Parse(credential.JWT, jwt.WithSignatureVerifier(&noVerifier{}))
+	if err != nil {
+		if opts.strictJWT {
+			return nil, fmt.Errorf("%w: credential %s: %s", ErrUnparsableJWT, credential.ID, err)
+		}
+
+		opts.log().Warnf("unmarshal credential %s JWT: %s", credential.ID, err)
+
+		return fits, nil
 	}
 
-	if len(jwtvpCreds) > 0 {
-		return FormatJWTVP, jwtvpCreds
+	alg, hasAlg := pJWT.Headers.Algorithm()
+	if !hasAlg {
+		return fits, nil
 	}
 
-	return "", nil
+	fits[FormatSDJWT] = sdJWTAlgMatch(credential, alg, format.SdJwt)
+	fits[FormatDCSDJWT] = sdJWTAlgMatch(credential, alg, format.DcSdJwt)
+	fits[FormatJWT] = algMatch(alg, format.Jwt)
+	fits[FormatJWTVC] = algMatch(alg, format.JwtVC)
+	fits[FormatJWTVP] = algMatch(alg, format.JwtVP)
+
+	return fits, nil
 }
 
 // noVerifier is used when no JWT signature verification is needed.
@@ -1345,13 +3377,35 @@ func algMatch(credAlg string, jwtType *JwtType) bool {
 	return false
 }
 
+func sdJWTAlgMatch(credential *verifiable.Credential, credAlg string, sdJWTType *SdJwtType) bool {
+	if sdJWTType == nil || credential.SDJWTHashAlg == "" {
+		return false
+	}
+
+	if len(sdJWTType.SdJwtAlgValues) == 0 {
+		return true
+	}
+
+	for _, alg := range sdJWTType.SdJwtAlgValues {
+		if strings.EqualFold(credAlg, alg) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func credByProof(c *verifiable.Credential, ldp *LdpType) bool {
 	if ldp == nil {
 		return false
 	}
 
+	if len(ldp.ProofType) == 0 && len(ldp.Cryptosuite) != 0 {
+		return hasProofWithType(c, dataIntegrityProofType, ldp.Cryptosuite)
+	}
+
 	for _, proofType := range ldp.ProofType {
-		if hasProofWithType(c, proofType) {
+		if hasProofWithType(c, proofType, ldp.Cryptosuite) {
 			return true
 		}
 	}
@@ -1359,9 +3413,15 @@ func credByProof(c *verifiable.Credential, ldp *LdpType) bool {
 	return false
 }
 
+// filterSchema returns the credentials whose types, resolved with their contexts, satisfy the schemas. A context
+// which cannot be loaded fails the match with a ContextLoadError, unless lenient is set: the credential is matched
+// by the names of its types then, the same way filterSchemaByType does, and the failure is logged as a warning.
+// A context refused by the resolver (see ErrContextRefused) excludes the credential only, which is passed
+// to refused (if not nil) along with the ContextLoadError, whether lenient is set or not.
 // nolint: gocyclo
-func filterSchema(schemas []*Schema, credentials []*verifiable.Credential,
-	documentLoader ld.DocumentLoader) []*verifiable.Credential {
+func filterSchema(schemas []*Schema, credentials []*verifiable.Credential, resolve SchemaResolver,
+	cache *ContextCache, lenient bool, logger spi.Logger,
+	refused func(credential *verifiable.Credential, err error)) ([]*verifiable.Credential, error) {
 	var result []*verifiable.Credential
 
 	contexts := map[string]*ld.Context{}
@@ -1369,13 +3429,16 @@ func filterSchema(schemas []*Schema, credentials []*verifiable.Credential,
 	for _, credential := range credentials {
 		schemaSatisfied := map[string]struct{}{}
 
+		var loadErr error
+
 		for _, ctx := range credential.Context {
 			ctxObj, ok := contexts[ctx]
 			if !ok {
-				context, err := getContext(ctx, documentLoader)
+				context, err := cache.context(ctx, resolve)
 				if err != nil {
-					logger.Errorf("failed to load context '%s': %s", ctx, err.Error())
-					return nil
+					loadErr = &ContextLoadError{ContextURI: ctx, Err: err}
+
+					break
 				}
 
 				contexts[ctx] = context
@@ -1383,7 +3446,7 @@ func filterSchema(schemas []*Schema, credentials []*verifiable.Credential,
 			}
 
 			for _, typ := range credential.Types {
-				ids, err := typeFoundInContext(typ, ctxObj)
+				ids, err := cache.typeIDs(ctx, typ, ctxObj)
 				if err != nil {
 					continue
 				}
@@ -1394,19 +3457,53 @@ func filterSchema(schemas []*Schema, credentials []*verifiable.Credential,
 			}
 		}
 
-		var applicable bool
+		if errors.Is(loadErr, ErrContextRefused) {
+			if refused != nil {
+				refused(credential, loadErr)
+			}
+
+			continue
+		}
+
+		if loadErr != nil && !lenient {
+			return nil, loadErr
+		}
+
+		if loadErr != nil {
+			logger.Warnf("match credential %s by type names: %s", credential.ID, loadErr)
+
+			result = append(result, filterSchemaByType(schemas, []*verifiable.Credential{credential})...)
+
+			continue
+		}
+
+		if schemasSatisfied(schemas, schemaSatisfied) {
+			result = append(result, credential)
+		}
+	}
+
+	return result, nil
+}
+
+// filterSchemaByType works like filterSchema, but matches the declared credential types against the schema URIs
+// directly instead of resolving the types with the credential contexts. A type matches the schema URI which is
+// either the type itself or ends with it, e.g. UniversityDegreeCredential matches
+// https://www.w3.org/2018/credentials/examples/v1#UniversityDegreeCredential.
+func filterSchemaByType(schemas []*Schema, credentials []*verifiable.Credential) []*verifiable.Credential {
+	var result []*verifiable.Credential
+
+	for _, credential := range credentials {
+		schemaSatisfied := map[string]struct{}{}
 
 		for _, schema := range schemas {
-			_, ok := schemaSatisfied[schema.URI]
-			if ok {
-				applicable = true
-			} else if schema.Required {
-				applicable = false
-				break
+			for _, typ := range credential.Types {
+				if typeMatchesSchema(typ, schema.URI) {
+					schemaSatisfied[schema.URI] = struct{}{}
+				}
 			}
 		}
 
-		if applicable {
+		if schemasSatisfied(schemas, schemaSatisfied) {
 			result = append(result, credential)
 		}
 	}
@@ -1414,6 +3511,31 @@ func filterSchema(schemas []*Schema, credentials []*verifiable.Credential,
 	return result
 }
 
+func typeMatchesSchema(typ, schemaURI string) bool {
+	if typ == schemaURI {
+		return true
+	}
+
+	return schemaURI[strings.LastIndexAny(schemaURI, "#/:")+1:] == typ
+}
+
+// schemasSatisfied checks that at least one schema and all the required ones are satisfied.
+func schemasSatisfied(schemas []*Schema, satisfied map[string]struct{}) bool {
+	var applicable bool
+
+	for _, schema := range schemas {
+		_, ok := satisfied[schema.URI]
+		if ok {
+			applicable = true
+		} else if schema.Required {
+			applicable = false
+			break
+		}
+	}
+
+	return applicable
+}
+
 func typeFoundInContext(typ string, ctxObj *ld.Context) ([]string, error) {
 	var out []string
 
@@ -1447,6 +3569,17 @@ func typeFoundInContext(typ string, ctxObj *ld.Context) ([]string, error) {
 	return out, nil
 }
 
+// loaderSchemaResolver resolves the contexts with the document loader.
+func loaderSchemaResolver(documentLoader ld.DocumentLoader) SchemaResolver {
+	return func(contextURI string) (*ld.Context, error) {
+		if documentLoader == nil {
+			return nil, errors.New("no document loader")
+		}
+
+		return getContext(contextURI, documentLoader)
+	}
+}
+
 func getContext(contextURI string, documentLoader ld.DocumentLoader) (*ld.Context, error) {
 	contextURI = strings.SplitN(contextURI, "#", 2)[0]
 
@@ -11,6 +11,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 
+	"github.com/hyperledger/aries-framework-go/pkg/common/log/mocklogger"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto/primitive/bbs12381g2pub"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/ld"
@@ -72,8 +74,449 @@ func TestPresentationDefinition_IsValid(t *testing.T) {
 		pd := &PresentationDefinition{
 			SubmissionRequirements: []*SubmissionRequirement{{Rule: All, From: "A"}},
 		}
-		require.EqualError(t, pd.ValidateSchema(), errMsg)
+		err := pd.ValidateSchema()
+		require.EqualError(t, err, errMsg)
+
+		var schemaErr *SchemaValidationError
+		require.ErrorAs(t, err, &schemaErr)
+		require.Equal(t, []SchemaFailure{
+			{Version: V1, Field: "presentation_definition", Description: "id is required", Type: "required"},
+			{Version: V1, Field: "presentation_definition", Description: "input_descriptors is required", Type: "required"},
+		}, schemaErr.Failures)
 	})
+
+	t.Run("spec version", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: "A", Schema: []*Schema{{URI: verifiable.ContextURI}}}},
+		}
+
+		version, err := pd.ValidateSchemaVersion()
+		require.NoError(t, err)
+		require.Equal(t, V1, version)
+
+		version, err = pd.ValidateSchemaVersion(WithPESpecVersion(V2))
+		require.Equal(t, V2, version)
+		require.EqualError(t, err, "presentation_definition.input_descriptors.0: Additional property schema is not allowed")
+
+		pd.InputDescriptors[0].Schema = nil
+
+		version, err = pd.ValidateSchemaVersion()
+		require.NoError(t, err)
+		require.Equal(t, V2, version)
+
+		require.EqualError(t, pd.ValidateSchema(WithPESpecVersion(V1)),
+			"presentation_definition.input_descriptors.0: schema is required")
+
+		pd.InputDescriptors[0].ID = ""
+
+		version, err = pd.ValidateSchemaVersion()
+		require.Empty(t, version)
+		require.EqualError(t, err, "v1: presentation_definition.input_descriptors.0: id is required,"+
+			"presentation_definition.input_descriptors.0: schema is required; "+
+			"v2: presentation_definition.input_descriptors.0: id is required")
+
+		var schemaErr *SchemaValidationError
+		require.ErrorAs(t, err, &schemaErr)
+		require.Len(t, schemaErr.Failures, 3)
+		require.Equal(t, SchemaFailure{
+			Version:     V2,
+			Field:       "presentation_definition.input_descriptors.0",
+			Description: "id is required",
+			Type:        "required",
+		}, schemaErr.Failures[2])
+
+		_, err = pd.ValidateSchemaVersion(WithPESpecVersion("v3"))
+		require.EqualError(t, err, "unsupported presentation exchange version: v3")
+	})
+}
+
+func TestValidateSchemaBytes(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		raw := []byte(`{"id":"pd","input_descriptors":[{"id":"name","schema":[{"uri":"https://example.com/Name"}]}]}`)
+
+		require.NoError(t, ValidateSchemaBytes(raw))
+
+		version, err := ValidateSchemaBytesVersion(raw)
+		require.NoError(t, err)
+		require.Equal(t, V1, version)
+
+		_, err = ValidateSchemaBytesVersion(raw, WithPESpecVersion("v3"))
+		require.EqualError(t, err, "unsupported presentation exchange version: v3")
+	})
+
+	t.Run("Properties the struct does not represent", func(t *testing.T) {
+		for name, raw := range map[string]string{
+			"Unknown property": `{"id":"pd","input_descriptors":[{"id":"name","unknown":true}]}`,
+			"Wrong type":       `{"id":"pd","input_descriptors":[{"id":"name","constraints":{"limit_disclosure":1}}]}`,
+		} {
+			t.Run(name, func(t *testing.T) {
+				err := ValidateSchemaBytes([]byte(raw))
+
+				var schemaErr *SchemaValidationError
+				require.ErrorAs(t, err, &schemaErr)
+			})
+		}
+
+		var pd PresentationDefinition
+		require.NoError(t, json.Unmarshal([]byte(`{"id":"pd","input_descriptors":[{"id":"name","unknown":true}]}`),
+			&pd))
+		require.NoError(t, pd.ValidateSchema())
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		require.ErrorContains(t, ValidateSchemaBytes([]byte(`{"id":`)), "invalid presentation definition JSON")
+	})
+}
+
+func TestPresentationDefinition_ValidateSemantics(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var pd *PresentationDefinition
+		parseJSONFile(t, "testdata/sample_2.json", &pd)
+
+		require.NoError(t, pd.ValidateSemantics())
+	})
+
+	t.Run("Duplicate descriptor ID", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: "A"}, {ID: "B"}, {ID: "A"}},
+		}
+
+		require.NoError(t, pd.ValidateSchema())
+
+		err := pd.ValidateSemantics()
+		require.EqualError(t, err, "input_descriptors[2]: duplicate id: A")
+
+		var defErr *DefinitionError
+		require.ErrorAs(t, err, &defErr)
+		require.Equal(t, "input_descriptors[2]", defErr.Element)
+	})
+
+	t.Run("Missing group", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: "A", Group: []string{"adult"}}},
+			SubmissionRequirements: []*SubmissionRequirement{
+				{Rule: All, From: "adult"},
+				{
+					Rule:  Pick,
+					Count: 1,
+					FromNested: []*SubmissionRequirement{
+						{Rule: All, From: "adult"},
+						{Rule: All, From: "teenager"},
+					},
+				},
+			},
+		}
+
+		err := pd.ValidateSemantics()
+		require.EqualError(t, err, "submission_requirements[1].from_nested[1]: no descriptors for from: teenager")
+
+		var defErr *DefinitionError
+		require.ErrorAs(t, err, &defErr)
+		require.Equal(t, "submission_requirements[1].from_nested[1]", defErr.Element)
+	})
+
+	t.Run("Both from and from_nested", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: "A", Group: []string{"adult"}}},
+			SubmissionRequirements: []*SubmissionRequirement{{
+				Rule:  Pick,
+				Count: 1,
+				FromNested: []*SubmissionRequirement{{
+					Rule:       All,
+					From:       "adult",
+					FromNested: []*SubmissionRequirement{{Rule: All, From: "teenager"}},
+				}},
+			}},
+		}
+
+		err := pd.ValidateSemantics()
+		require.ErrorIs(t, err, ErrFromAndFromNested)
+
+		var defErr *DefinitionError
+		require.ErrorAs(t, err, &defErr)
+		require.Equal(t, "submission_requirements[0].from_nested[0]", defErr.Element)
+
+		// the JSON schema rejects the requirement as well, before it is matched.
+		var schemaErr *SchemaValidationError
+
+		_, err = pd.MatchSubmissionRequirement([]*verifiable.Credential{getTestVC()}, createTestJSONLDDocumentLoader(t))
+		require.ErrorAs(t, err, &schemaErr)
+	})
+
+	t.Run("Pick selection", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  *SubmissionRequirement
+			err  string
+		}{
+			{name: "count", req: &SubmissionRequirement{Count: 2}},
+			{name: "min", req: &SubmissionRequirement{Min: 2}},
+			{name: "max", req: &SubmissionRequirement{Max: 3}},
+			{name: "min and max", req: &SubmissionRequirement{Min: 1, Max: 2}},
+			{name: "count within min and max", req: &SubmissionRequirement{Count: 2, Min: 1, Max: 2}},
+			{
+				name: "min greater than max",
+				req:  &SubmissionRequirement{Min: 2, Max: 1},
+				err:  "min 2 is greater than max 1",
+			},
+			{
+				name: "count less than min",
+				req:  &SubmissionRequirement{Count: 1, Min: 2},
+				err:  "count 1 is less than min 2",
+			},
+			{
+				name: "count greater than max",
+				req:  &SubmissionRequirement{Count: 3, Max: 2},
+				err:  "count 3 is greater than max 2",
+			},
+			{
+				name: "count greater than options",
+				req:  &SubmissionRequirement{Count: 4},
+				err:  "pick selects 4 of 3 options",
+			},
+			{
+				name: "min greater than options",
+				req:  &SubmissionRequirement{Min: 4, Max: 5},
+				err:  "pick selects 4 of 3 options",
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				tc.req.Rule = Pick
+				tc.req.From = "A"
+
+				pd := &PresentationDefinition{
+					ID: uuid.New().String(),
+					InputDescriptors: []*InputDescriptor{
+						{ID: "1", Group: []string{"A"}},
+						{ID: "2", Group: []string{"A"}},
+						{ID: "3", Group: []string{"A"}},
+					},
+					SubmissionRequirements: []*SubmissionRequirement{tc.req},
+				}
+
+				require.NoError(t, pd.ValidateSchema())
+
+				err := pd.ValidateSemantics()
+				if tc.err == "" {
+					require.NoError(t, err)
+
+					return
+				}
+
+				require.EqualError(t, err, "submission_requirements[0]: "+tc.err)
+			})
+		}
+	})
+}
+
+func TestPresentationDefinition_CreateVP_PickSelection(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(name string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      "urn:credential:" + name,
+			Subject: []verifiable.Subject{{ID: "did:example:a"}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+			CustomFields: map[string]interface{}{
+				name: true,
+			},
+		}
+	}
+
+	newDescriptor := func(name string) *InputDescriptor {
+		return &InputDescriptor{
+			ID:    name,
+			Group: []string{"A"},
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$." + name}}},
+			},
+		}
+	}
+
+	credentials := []*verifiable.Credential{newVC("email"), newVC("passport"), newVC("license")}
+
+	tests := []struct {
+		name string
+		req  *SubmissionRequirement
+		// matched are the numbers of the matched descriptors (out of 3) the requirement is satisfied with.
+		matched []int
+		err     string
+	}{
+		{name: "all", req: &SubmissionRequirement{Rule: All}, matched: []int{3}},
+		{name: "pick count", req: &SubmissionRequirement{Rule: Pick, Count: 2}, matched: []int{2}},
+		{name: "pick min", req: &SubmissionRequirement{Rule: Pick, Min: 2}, matched: []int{2, 3}},
+		{name: "pick max", req: &SubmissionRequirement{Rule: Pick, Max: 2}, matched: []int{1, 2}},
+		{name: "pick min and max", req: &SubmissionRequirement{Rule: Pick, Min: 1, Max: 2}, matched: []int{1, 2}},
+		{
+			name:    "pick count within min and max",
+			req:     &SubmissionRequirement{Rule: Pick, Count: 2, Min: 1, Max: 3},
+			matched: []int{2},
+		},
+		{
+			name: "pick min greater than max",
+			req:  &SubmissionRequirement{Rule: Pick, Min: 3, Max: 2},
+			err:  "submission_requirements[0]: min 3 is greater than max 2",
+		},
+		{
+			name: "pick count out of min and max",
+			req:  &SubmissionRequirement{Rule: Pick, Count: 3, Min: 1, Max: 2},
+			err:  "submission_requirements[0]: count 3 is greater than max 2",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.From = "A"
+
+			pd := &PresentationDefinition{
+				ID:                     uuid.New().String(),
+				SubmissionRequirements: []*SubmissionRequirement{tc.req},
+				InputDescriptors: []*InputDescriptor{
+					newDescriptor("email"), newDescriptor("passport"), newDescriptor("license"),
+				},
+			}
+
+			for n := 1; n <= len(credentials); n++ {
+				vp, err := pd.CreateVP(credentials[:n], lddl)
+				if tc.err != "" {
+					require.EqualError(t, err, tc.err)
+
+					continue
+				}
+
+				// the unsatisfied requirement presents no credentials.
+				var presented int
+
+				if !errors.Is(err, ErrNoCredentials) {
+					require.NoError(t, err, n)
+
+					presented = len(vp.Credentials())
+				}
+
+				if containsInt(tc.matched, n) {
+					require.Equal(t, n, presented, n)
+				} else {
+					require.Zero(t, presented, n)
+				}
+			}
+		})
+	}
+}
+
+func TestPresentationDefinition_CreateVP_FromGroupLists(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(name string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context:      []string{verifiable.ContextURI},
+			Types:        []string{verifiable.VCType},
+			ID:           "urn:credential:" + name,
+			CustomFields: map[string]interface{}{name: true},
+		}
+	}
+
+	newDescriptor := func(name string, groups ...string) *InputDescriptor {
+		return &InputDescriptor{
+			ID:    name,
+			Group: groups,
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$." + name}}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID:                     uuid.New().String(),
+		SubmissionRequirements: []*SubmissionRequirement{{Rule: All, From: "A, B"}},
+		InputDescriptors: []*InputDescriptor{
+			newDescriptor("email", "A"), newDescriptor("passport", "A", "B"), newDescriptor("license", "C"),
+		},
+	}
+
+	email, passport, license := newVC("email"), newVC("passport"), newVC("license")
+
+	t.Run("All descriptors of the listed groups", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{email, passport, license}, lddl, WithFromGroupLists())
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{email, passport}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+
+		// the unsatisfied requirement presents no credentials.
+		vp, err = pd.CreateVPWithOptions([]*verifiable.Credential{email, license}, lddl, WithFromGroupLists())
+		require.NoError(t, err)
+		require.Empty(t, vp.Credentials())
+	})
+
+	t.Run("Single group by default", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{email, passport, license}, lddl)
+		require.EqualError(t, err, "no descriptors for from: A, B")
+
+		single := &PresentationDefinition{
+			ID:                     uuid.New().String(),
+			SubmissionRequirements: []*SubmissionRequirement{{Rule: All, From: "A,B"}},
+			InputDescriptors:       []*InputDescriptor{newDescriptor("email", "A,B"), newDescriptor("passport", "B")},
+		}
+
+		vp, err := single.CreateVP([]*verifiable.Credential{email, passport}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{email}, vp.Credentials())
+	})
+
+	t.Run("Array of groups", func(t *testing.T) {
+		array := &PresentationDefinition{}
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"id": "`+uuid.New().String()+`",
+			"submission_requirements": [{"rule": "all", "from": ["A", "B"]}],
+			"input_descriptors": [
+				{"id": "email", "group": ["A"], "constraints": {"fields": [{"path": ["$.email"]}]}},
+				{"id": "passport", "group": ["B"], "constraints": {"fields": [{"path": ["$.passport"]}]}},
+				{"id": "license", "group": ["C"], "constraints": {"fields": [{"path": ["$.license"]}]}}
+			]
+		}`), array))
+		require.Equal(t, []string{"A", "B"}, array.SubmissionRequirements[0].FromGroups)
+		require.NoError(t, array.ValidateSemantics())
+
+		data, err := json.Marshal(array.SubmissionRequirements[0])
+		require.NoError(t, err)
+		require.JSONEq(t, `{"rule": "all", "from": ["A", "B"]}`, string(data))
+
+		vp, err := array.CreateVP([]*verifiable.Credential{email, passport, license}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{email, passport}, vp.Credentials())
+	})
+
+	t.Run("Semantics and resolved requirements", func(t *testing.T) {
+		require.EqualError(t, pd.ValidateSemantics(), "submission_requirements[0]: no descriptors for from: A, B")
+		require.NoError(t, pd.ValidateSemantics(WithFromGroupLists()))
+
+		_, err := pd.ResolveRequirements()
+		require.EqualError(t, err, "no descriptors for from: A, B")
+
+		resolved, err := pd.ResolveRequirements(WithFromGroupLists())
+		require.NoError(t, err)
+		require.Len(t, resolved, 1)
+		require.Equal(t, []string{"email", "passport"}, resolved[0].InputDescriptorIDs)
+	})
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
 }
 
 func TestPresentationDefinition_CreateVP(t *testing.T) {
@@ -724,7 +1167,7 @@ func TestPresentationDefinition_CreateVP(t *testing.T) {
 		checkVP(t, vp)
 	})
 
-	t.Run("SD-JWT: No Limit Disclosure + Predicate Satisfied", func(t *testing.T) {
+	t.Run("SD-JWT: No Limit Disclosure + Predicate Not Supported", func(t *testing.T) {
 		required := Required
 
 		pd := &PresentationDefinition{
@@ -755,33 +1198,15 @@ func TestPresentationDefinition_CreateVP(t *testing.T) {
 
 		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC},
 			lddl, verifiable.WithJSONLDDocumentLoader(createTestJSONLDDocumentLoader(t)))
+		require.EqualError(t, err, ErrNoCredentials.Error())
+		require.Nil(t, vp)
 
+		// the value can not be replaced with true, so the credential is rejected rather than disclosing it.
+		_, rejections, err := pd.MatchSubmissionRequirementDetailed([]*verifiable.Credential{sdJwtVC}, lddl)
 		require.NoError(t, err)
-		require.NotNil(t, vp)
-		require.Equal(t, 1, len(vp.Credentials()))
-
-		vc, ok := vp.Credentials()[0].(*verifiable.Credential)
-		require.True(t, ok)
-
-		require.Len(t, vc.SDJWTDisclosures, 10)
-
-		require.Len(t, vc.Subject.([]verifiable.Subject)[0].CustomFields["_sd"].([]interface{}), 6)
-		require.NotNil(t, vc.Subject.([]verifiable.Subject)[0].CustomFields["address"])
-
-		_, ok = vc.Subject.([]verifiable.Subject)[0].CustomFields["email"]
-		require.False(t, ok)
-
-		displayVC, err := vc.CreateDisplayCredential(verifiable.DisplayAllDisclosures())
-		require.NoError(t, err)
-
-		printObject(t, "Display VC - No Limit Disclosure (all fields displayed)", displayVC)
-
-		require.Equal(t, "John", displayVC.Subject.([]verifiable.Subject)[0].CustomFields["given_name"])
-		require.Equal(t, "Doe", displayVC.Subject.([]verifiable.Subject)[0].CustomFields["family_name"])
-		require.Equal(t, "johndoe@example.com", displayVC.Subject.([]verifiable.Subject)[0].CustomFields["email"])
-
-		checkSubmission(t, vp, pd)
-		checkVP(t, vp)
+		require.Len(t, rejections, 1)
+		require.Equal(t, 0, rejections[0].FieldIndex)
+		require.ErrorIs(t, rejections[0].Reason, ErrPredicateNotSupported)
 	})
 
 	t.Run("SD-JWT: hash algorithm not supported", func(t *testing.T) {
@@ -855,7 +1280,7 @@ func TestPresentationDefinition_CreateVP(t *testing.T) {
 
 		require.Error(t, err)
 		require.Nil(t, vp)
-		require.Contains(t, err.Error(), "Expected $ or @ at start of path instead of  U+0031")
+		require.EqualError(t, err, ErrNoCredentials.Error())
 	})
 
 	t.Run("SD-JWT: Limit Disclosure (credentials don't meet requirement)", func(t *testing.T) {
@@ -2017,6 +2442,6184 @@ func TestPresentationDefinition_CreateVP(t *testing.T) {
 	})
 }
 
+func TestFilter_JSONSchemaCombinators(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(country string, age interface{}) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			CustomFields: map[string]interface{}{
+				"country": country,
+				"age":     age,
+			},
+		}
+	}
+
+	matchedIDs := func(t *testing.T, pdJSON string, creds ...*verifiable.Credential) []string {
+		t.Helper()
+
+		var pd PresentationDefinition
+		require.NoError(t, json.Unmarshal([]byte(pdJSON), &pd))
+
+		matched, err := pd.MatchSubmissionRequirement(creds, lddl)
+		require.NoError(t, err)
+		require.Len(t, matched, 1)
+		require.Len(t, matched[0].Descriptors, 1)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	us, ca, de := newCred("US", 21), newCred("CA", 16), newCred("DE", "21")
+
+	t.Run("oneOf", func(t *testing.T) {
+		ids := matchedIDs(t, `{
+			"id": "pd",
+			"input_descriptors": [{
+				"id": "country",
+				"constraints": {"fields": [{
+					"path": ["$.country"],
+					"filter": {"oneOf": [{"const": "US"}, {"const": "CA"}]}
+				}]}
+			}]
+		}`, us, ca, de)
+
+		require.Equal(t, []string{us.ID, ca.ID}, ids)
+	})
+
+	t.Run("anyOf mixed with typed keywords", func(t *testing.T) {
+		ids := matchedIDs(t, `{
+			"id": "pd",
+			"input_descriptors": [{
+				"id": "age",
+				"constraints": {"fields": [{
+					"path": ["$.age"],
+					"filter": {"type": "integer", "anyOf": [{"minimum": 18}, {"maximum": 10}]}
+				}]}
+			}]
+		}`, us, ca, de)
+
+		require.Equal(t, []string{us.ID}, ids)
+	})
+
+	t.Run("allOf", func(t *testing.T) {
+		ids := matchedIDs(t, `{
+			"id": "pd",
+			"input_descriptors": [{
+				"id": "country",
+				"constraints": {"fields": [{
+					"path": ["$.country"],
+					"filter": {"type": "string", "allOf": [{"pattern": "^[A-Z]+$"}, {"not": {"const": "US"}}]}
+				}]}
+			}]
+		}`, us, ca, de)
+
+		require.Equal(t, []string{ca.ID, de.ID}, ids)
+	})
+
+	t.Run("not", func(t *testing.T) {
+		noCountry := &verifiable.Credential{
+			Context:      []string{verifiable.ContextURI},
+			Types:        []string{verifiable.VCType},
+			ID:           uuid.New().String(),
+			CustomFields: map[string]interface{}{"age": 21},
+		}
+
+		ids := matchedIDs(t, `{
+			"id": "pd",
+			"input_descriptors": [{
+				"id": "country",
+				"constraints": {"fields": [{
+					"path": ["$.country"],
+					"filter": {"not": {"const": "US"}}
+				}]}
+			}]
+		}`, us, ca, de, noCountry)
+
+		require.Equal(t, []string{ca.ID, de.ID}, ids)
+
+		ids = matchedIDs(t, `{
+			"id": "pd",
+			"input_descriptors": [{
+				"id": "country",
+				"constraints": {"fields": [{
+					"path": ["$.country"],
+					"filter": {"enum": ["US", "CA"], "not": {"const": "US"}}
+				}]}
+			}]
+		}`, us, ca, de, noCountry)
+
+		require.Equal(t, []string{ca.ID}, ids)
+	})
+
+	t.Run("empty not", func(t *testing.T) {
+		var filter Filter
+		require.NoError(t, json.Unmarshal([]byte(`{"not":{}}`), &filter))
+		require.NotNil(t, filter.Not)
+		require.Empty(t, filter.CustomFields)
+
+		out, err := json.Marshal(&Filter{Not: map[string]interface{}{}})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"not":{}}`, string(out))
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{Fields: []*Field{{
+					Path:   []string{"$.country"},
+					Filter: &Filter{Not: map[string]interface{}{}},
+				}}},
+			}},
+		}
+
+		_, err = pd.CreateVP([]*verifiable.Credential{us, ca, de}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("custom fields set programmatically", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{Fields: []*Field{{
+					Path: []string{"$.country"},
+					Filter: &Filter{
+						Type: &strFilterType,
+						CustomFields: map[string]interface{}{
+							"oneOf": []interface{}{
+								map[string]interface{}{"const": "DE"},
+							},
+						},
+					},
+				}}},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{us, ca, de}, lddl)
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors[0].MatchedVCs, 1)
+		require.Equal(t, de.ID, matched[0].Descriptors[0].MatchedVCs[0].ID)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		src := `{"type":"string","minLength":1,"oneOf":[{"const":"US"},{"const":"CA"}],"x-custom":true}`
+
+		var filter Filter
+		require.NoError(t, json.Unmarshal([]byte(src), &filter))
+		require.Equal(t, strFilterType, *filter.Type)
+		require.Equal(t, 1, *filter.MinLength)
+		require.Contains(t, filter.CustomFields, "oneOf")
+		require.Contains(t, filter.CustomFields, "x-custom")
+
+		out, err := json.Marshal(&filter)
+		require.NoError(t, err)
+		require.JSONEq(t, src, string(out))
+	})
+
+	t.Run("zero values", func(t *testing.T) {
+		zero := 0
+
+		out, err := json.Marshal(&Filter{Type: &intFilterType, Minimum: 0, Maximum: 0})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"type":"integer","minimum":0,"maximum":0}`, string(out))
+
+		out, err = json.Marshal(&Filter{Type: &strFilterType, MinLength: &zero, MaxLength: &zero})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"type":"string","minLength":0,"maxLength":0}`, string(out))
+
+		out, err = json.Marshal(&Filter{Type: &strFilterType})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"type":"string"}`, string(out))
+
+		empty, us := newCred("", 21), newCred("US", 21)
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{Fields: []*Field{{
+					Path:   []string{"$.country"},
+					Filter: &Filter{Type: &strFilterType, MaxLength: &zero},
+				}}},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{empty, us}, lddl)
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors[0].MatchedVCs, 1)
+		require.Equal(t, empty.ID, matched[0].Descriptors[0].MatchedVCs[0].ID)
+	})
+}
+
+func TestConstraints_Statuses(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(status string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Status:  &verifiable.TypedID{ID: status, Type: "StatusList2021Entry"},
+			CustomFields: map[string]interface{}{
+				"first_name": "Jesse",
+			},
+		}
+	}
+
+	active, revoked, suspended := newCred("active"), newCred("revoked"), newCred("suspended")
+	creds := []*verifiable.Credential{active, revoked, suspended}
+
+	resolver := mockStatusResolver(func(vc *verifiable.Credential) (CredentialStatus, error) {
+		return CredentialStatus(vc.Status.ID), nil
+	})
+
+	for _, tc := range []struct {
+		name     string
+		statuses *Statuses
+		opts     []MatchRequirementsOpt
+		expected []interface{}
+	}{
+		{
+			name:     "Revoked disallowed",
+			statuses: &Statuses{Revoked: &StatusDirective{Directive: DirectiveDisallowed}},
+			opts:     []MatchRequirementsOpt{WithStatusResolver(resolver)},
+			expected: []interface{}{active, suspended},
+		},
+		{
+			name:     "Active required",
+			statuses: &Statuses{Active: &StatusDirective{Directive: DirectiveRequired}},
+			opts:     []MatchRequirementsOpt{WithStatusResolver(resolver)},
+			expected: []interface{}{active},
+		},
+		{
+			name: "Directive of other status type",
+			statuses: &Statuses{Active: &StatusDirective{
+				Directive: DirectiveRequired,
+				Type:      []string{"RevocationList2020Status"},
+			}},
+			opts:     []MatchRequirementsOpt{WithStatusResolver(resolver)},
+			expected: []interface{}{active, revoked, suspended},
+		},
+		{
+			name:     "No resolver",
+			statuses: &Statuses{Active: &StatusDirective{Directive: DirectiveRequired}},
+			expected: []interface{}{active, revoked, suspended},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pd := &PresentationDefinition{
+				ID: uuid.New().String(),
+				InputDescriptors: []*InputDescriptor{{
+					ID: uuid.New().String(),
+					Constraints: &Constraints{
+						Statuses: tc.statuses,
+						Fields:   []*Field{{Path: []string{"$.first_name"}}},
+					},
+				}},
+			}
+
+			vp, err := pd.CreateVPWithOptions(creds, lddl, tc.opts...)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, vp.Credentials())
+		})
+	}
+
+	t.Run("Resolver error", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					Statuses: &Statuses{Revoked: &StatusDirective{Directive: DirectiveDisallowed}},
+					Fields:   []*Field{{Path: []string{"$.first_name"}}},
+				},
+			}},
+		}
+
+		_, rejections, err := pd.MatchSubmissionRequirementDetailed([]*verifiable.Credential{active}, lddl,
+			WithStatusResolver(mockStatusResolver(func(*verifiable.Credential) (CredentialStatus, error) {
+				return "", errors.New("status list is not available")
+			})))
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+		require.EqualError(t, rejections[0].Reason, "resolve credential status: status list is not available")
+	})
+}
+
+type mockStatusResolver func(vc *verifiable.Credential) (CredentialStatus, error)
+
+func (r mockStatusResolver) Resolve(vc *verifiable.Credential) (CredentialStatus, error) {
+	return r(vc)
+}
+
+func TestPresentationDefinition_CreateVP_SelectiveDisclosureProvider(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"given_name": "John", "family_name": "Doe"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+		Proofs: []verifiable.Proof{{"type": "BbsBlsSignature2020"}},
+	}
+
+	var revealDocs []map[string]interface{}
+
+	provider := mockDisclosureProvider(func(credential *verifiable.Credential,
+		revealDoc map[string]interface{}, nonce []byte) (*verifiable.Credential, error) {
+		revealDocs = append(revealDocs, revealDoc)
+
+		derived := *credential
+		derived.Proofs = []verifiable.Proof{{"type": "BbsBlsSignatureProof2020", "nonce": nonce}}
+
+		return &derived, nil
+	})
+
+	t.Run("Limit disclosure", func(t *testing.T) {
+		revealDocs = nil
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "name",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields:          []*Field{{Path: []string{"$.credentialSubject.given_name"}}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl,
+			WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)),
+			WithSelectiveDisclosureProvider(provider))
+		require.NoError(t, err)
+		require.Len(t, revealDocs, 1)
+
+		subject, ok := revealDocs[0]["credentialSubject"].(map[string]interface{})
+		require.True(t, ok)
+		require.Equal(t, "John", subject["given_name"])
+		require.NotContains(t, subject, "family_name")
+
+		derived, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Equal(t, "BbsBlsSignatureProof2020", derived.Proofs[0]["type"])
+		require.NotEmpty(t, derived.Proofs[0]["nonce"])
+	})
+
+	t.Run("Frame", func(t *testing.T) {
+		revealDocs = nil
+
+		frame := map[string]interface{}{"@explicit": true, "type": []interface{}{"VerifiableCredential"}}
+
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Frame:            frame,
+			InputDescriptors: []*InputDescriptor{{ID: "credential"}},
+		}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithSelectiveDisclosureProvider(provider))
+		require.NoError(t, err)
+		require.Equal(t, []map[string]interface{}{frame}, revealDocs)
+	})
+
+	t.Run("Provider error", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Frame:            map[string]interface{}{"@explicit": true},
+			InputDescriptors: []*InputDescriptor{{ID: "credential"}},
+		}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithSelectiveDisclosureProvider(
+			mockDisclosureProvider(func(*verifiable.Credential, map[string]interface{},
+				[]byte) (*verifiable.Credential, error) {
+				return nil, errors.New("no BBS+ backend")
+			})))
+		require.EqualError(t, err, "no BBS+ backend")
+	})
+}
+
+type mockDisclosureProvider func(credential *verifiable.Credential, revealDoc map[string]interface{},
+	nonce []byte) (*verifiable.Credential, error)
+
+func (p mockDisclosureProvider) GenerateSelectiveDisclosure(credential *verifiable.Credential,
+	revealDoc map[string]interface{}, nonce []byte, _ ...verifiable.CredentialOpt) (*verifiable.Credential, error) {
+	return p(credential, revealDoc, nonce)
+}
+
+func TestPresentationDefinition_CreateVP_IDs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Schema: []*Schema{{
+				URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+			}},
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path: []string{"$.first_name"},
+				}},
+			},
+		}},
+	}
+
+	newVC := func() *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      "http://example.edu/credentials/1872",
+			Subject: "did:example:76e12ec712ebc6f1c221ebfeb1f",
+			Issued:  util.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+			Issuer:  verifiable.Issuer{ID: "did:example:76e12ec712ebc6f1c221ebfeb1f"},
+			CustomFields: map[string]interface{}{
+				"first_name": "Jesse",
+				"last_name":  "Travis",
+			},
+		}
+	}
+
+	newGenerator := func() func() string {
+		var n int
+
+		return func() string {
+			n++
+
+			return fmt.Sprintf("id-%d", n)
+		}
+	}
+
+	t.Run("Submission ID", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{newVC()}, lddl,
+			WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)),
+			WithSubmissionID("submission-id"))
+		require.NoError(t, err)
+
+		checkSubmission(t, vp, pd)
+		require.Equal(t, "submission-id", vp.CustomFields["presentation_submission"].(*PresentationSubmission).ID)
+	})
+
+	t.Run("ID generator", func(t *testing.T) {
+		createVP := func() []byte {
+			vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{newVC()}, lddl,
+				WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)),
+				WithIDGenerator(newGenerator()))
+			require.NoError(t, err)
+
+			checkSubmission(t, vp, pd)
+
+			src, err := json.Marshal(vp)
+			require.NoError(t, err)
+
+			return src
+		}
+
+		src := createVP()
+		require.Equal(t, src, createVP())
+		require.Contains(t, string(src), `"id":"id-2"`)
+		require.NotContains(t, string(src), "last_name")
+	})
+
+	t.Run("Stable credential IDs", func(t *testing.T) {
+		createVP := func(opts ...MatchRequirementsOpt) []byte {
+			vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{newVC()}, lddl, append(opts,
+				WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)),
+				WithIDGenerator(newGenerator()))...)
+			require.NoError(t, err)
+
+			src, err := json.Marshal(vp)
+			require.NoError(t, err)
+
+			return src
+		}
+
+		require.Equal(t, createVP(), createVP(WithStableCredentialIDs()))
+
+		matched, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{newVC()}, lddl,
+			WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)),
+			WithStableCredentialIDs())
+		require.NoError(t, err)
+
+		limited := matched[0].Descriptors[0].MatchedVCs[0]
+		require.Equal(t, "http://example.edu/credentials/1872", limited.ID)
+		require.NotContains(t, limited.CustomFields, "last_name")
+	})
+}
+
+func TestPresentationDefinition_CreateBoundVP(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Schema: []*Schema{{
+				URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+			}},
+		}},
+	}
+
+	t.Run("With binding", func(t *testing.T) {
+		bound, err := pd.CreateBoundVP([]*verifiable.Credential{getTestVC()}, lddl,
+			WithPresentationBinding("nonce-123", "https://verifier.example.com"))
+		require.NoError(t, err)
+		require.Equal(t, &PresentationBinding{
+			Challenge: "nonce-123",
+			Domain:    "https://verifier.example.com",
+		}, bound.Binding)
+
+		require.Empty(t, bound.Presentation.Proofs)
+		require.Len(t, bound.Presentation.Credentials(), 1)
+		checkSubmission(t, bound.Presentation, pd)
+	})
+
+	t.Run("Without binding", func(t *testing.T) {
+		bound, err := pd.CreateBoundVP([]*verifiable.Credential{getTestVC()}, lddl)
+		require.NoError(t, err)
+		require.Nil(t, bound.Binding)
+		require.NotNil(t, bound.Presentation)
+	})
+
+	t.Run("No credentials", func(t *testing.T) {
+		bound, err := pd.CreateBoundVP(nil, lddl, WithPresentationBinding("nonce-123", "client"))
+		require.EqualError(t, err, ErrNoCredentials.Error())
+		require.Nil(t, bound)
+	})
+
+	t.Run("CreateVP with binding", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{getTestVC()}, lddl,
+			WithPresentationBinding("nonce-123", "client"))
+		require.ErrorIs(t, err, ErrBindingNotSupported)
+		require.Nil(t, vp)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_Order(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(id string, subject verifiable.CustomFields) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      id,
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: subject}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.name"}}},
+			},
+		}, {
+			ID: "age",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.age"}}},
+			},
+		}},
+	}
+
+	createVP := func() *verifiable.Presentation {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{
+			newVC("urn:credential:z", verifiable.CustomFields{"name": "John"}),
+			newVC("urn:credential:y", verifiable.CustomFields{"name": "John", "age": 42}),
+			newVC("urn:credential:x", verifiable.CustomFields{"name": "John"}),
+		}, lddl, WithSubmissionID("submission-id"))
+		require.NoError(t, err)
+
+		return vp
+	}
+
+	vp := createVP()
+
+	var ids []string
+	for _, credential := range vp.Credentials() {
+		ids = append(ids, credential.(*verifiable.Credential).ID)
+	}
+
+	require.Equal(t, []string{"urn:credential:y", "urn:credential:z", "urn:credential:x"}, ids)
+
+	var paths []string
+	for _, mapping := range vp.CustomFields["presentation_submission"].(*PresentationSubmission).DescriptorMap {
+		paths = append(paths, mapping.ID+" "+mapping.PathNested.Path)
+	}
+
+	require.Equal(t, []string{
+		"age $.verifiableCredential[0]",
+		"name $.verifiableCredential[1]",
+		"name $.verifiableCredential[0]",
+		"name $.verifiableCredential[2]",
+	}, paths)
+
+	for i := 0; i < 5; i++ {
+		require.Equal(t, vp, createVP())
+	}
+}
+
+func TestPresentationDefinition_CreateVP_SharedCredential(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "urn:credential:shared",
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"name": "John", "age": 42},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.name"}}},
+			},
+		}, {
+			ID: "age",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.age"}}},
+			},
+		}},
+	}
+
+	vp, err := pd.CreateVP([]*verifiable.Credential{vc, vc}, lddl)
+	require.NoError(t, err)
+	require.Len(t, vp.Credentials(), 1)
+
+	var paths []string
+	for _, mapping := range vp.CustomFields["presentation_submission"].(*PresentationSubmission).DescriptorMap {
+		paths = append(paths, mapping.ID+" "+mapping.PathNested.Path)
+	}
+
+	require.Equal(t, []string{
+		"age $.verifiableCredential[0]",
+		"name $.verifiableCredential[0]",
+	}, paths)
+
+	// the mappings are told apart whatever the IDs of the input descriptors and the credentials.
+	nameVC, ageVC := *vc, *vc
+	nameVC.ID, ageVC.ID = "b-c", "c"
+	pd.InputDescriptors[0].ID, pd.InputDescriptors[1].ID = "a", "a-b"
+
+	vp, err = pd.CreateVP([]*verifiable.Credential{&nameVC, &ageVC}, lddl)
+	require.NoError(t, err)
+	require.Len(t, vp.CustomFields["presentation_submission"].(*PresentationSubmission).DescriptorMap, 4)
+
+	required := Required
+
+	// the limited credential is added first (by the ID of its input descriptor), and is not confused with
+	// the original one of the same ID.
+	limitedPD := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "limited",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields:          []*Field{{Path: []string{"$.credentialSubject.name"}}},
+			},
+		}, {
+			ID: "unlimited",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.age"}}},
+			},
+		}},
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []MatchRequirementsOpt
+	}{
+		{name: "Limited and unlimited"},
+		{name: "Limited and unlimited with stable IDs", opts: []MatchRequirementsOpt{WithStableCredentialIDs()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vp, err := limitedPD.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl,
+				append(tc.opts, WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)))...)
+			require.NoError(t, err)
+			require.Len(t, vp.Credentials(), 2)
+
+			ages := map[string]interface{}{}
+
+			for _, mapping := range vp.CustomFields["presentation_submission"].(*PresentationSubmission).DescriptorMap {
+				var idx int
+
+				_, err = fmt.Sscanf(mapping.PathNested.Path, "$.verifiableCredential[%d]", &idx)
+				require.NoError(t, err)
+
+				credential, ok := vp.Credentials()[idx].(*verifiable.Credential)
+				require.True(t, ok)
+				require.Equal(t, vc.ID, credential.ID)
+
+				ages[mapping.ID] = credential.Subject.([]verifiable.Subject)[0].CustomFields["age"]
+			}
+
+			require.Equal(t, map[string]interface{}{"limited": nil, "unlimited": 42}, ages)
+		})
+	}
+}
+
+func TestPresentationDefinition_CreateVP_UnionDisclosure(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	nameField := &Field{Path: []string{"$.credentialSubject.given_name"}}
+	ageField := &Field{Path: []string{"$.credentialSubject.age"}}
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "urn:credential:1",
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"given_name": "John", "family_name": "Doe", "age": 42},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	descriptorPaths := func(vp *verifiable.Presentation) []string {
+		var paths []string
+		for _, mapping := range vp.CustomFields["presentation_submission"].(*PresentationSubmission).DescriptorMap {
+			paths = append(paths, mapping.ID+" "+mapping.PathNested.Path)
+		}
+
+		return paths
+	}
+
+	subject := func(t *testing.T, credential interface{}) map[string]interface{} {
+		t.Helper()
+
+		src, err := json.Marshal(credential)
+		require.NoError(t, err)
+
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(src, &doc))
+
+		s, ok := doc["credentialSubject"].(map[string]interface{})
+		require.True(t, ok)
+
+		return s
+	}
+
+	t.Run("Credential per input descriptor by default", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "name",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{nameField}},
+			}, {
+				ID:          "age",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{ageField}},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+	})
+
+	t.Run("Union of the disclosures", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "name",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{nameField}},
+			}, {
+				ID:          "age",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{ageField}},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, WithUnionDisclosure())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+		require.Equal(t, []string{"age $.verifiableCredential[0]", "name $.verifiableCredential[0]"},
+			descriptorPaths(vp))
+
+		require.Equal(t, map[string]interface{}{
+			"id": "did:example:a", "given_name": "John", "age": float64(42),
+		}, subject(t, vp.Credentials()[0]))
+
+		src, err := json.Marshal(vp)
+		require.NoError(t, err)
+
+		received, err := verifiable.ParsePresentation(src, verifiable.WithPresDisabledProofCheck(),
+			verifiable.WithPresJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+
+		matched, err := pd.Match(received, lddl, WithDisableSchemaValidation(),
+			WithCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl), verifiable.WithDisabledProofCheck()))
+		require.NoError(t, err)
+		require.Len(t, matched, 2)
+		require.Equal(t, vc.ID, matched["name"].ID)
+		require.Equal(t, vc.ID, matched["age"].ID)
+	})
+
+	t.Run("Disclosed in full for an input descriptor", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "name",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{nameField}},
+			}, {
+				ID:          "age",
+				Constraints: &Constraints{Fields: []*Field{ageField}},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, WithUnionDisclosure())
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{vc}, vp.Credentials())
+	})
+
+	t.Run("Conflicting disclosures", func(t *testing.T) {
+		predicate := Required
+		minAge := 18
+
+		// the age is disclosed for an input descriptor, and replaced by the predicate result for the other.
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "name",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{ageField}},
+			}, {
+				ID: "age",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:      []string{"$.credentialSubject.age"},
+						Filter:    &Filter{Type: &intFilterType, Minimum: minAge},
+						Predicate: &predicate,
+					}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, WithUnionDisclosure(),
+			WithSDCredentialOptions(verifiable.WithDisabledProofCheck()))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+	})
+
+	t.Run("SD-JWT", func(t *testing.T) {
+		signer, err := newCryptoSigner(kms.ED25519Type)
+		require.NoError(t, err)
+
+		sdJWTVC := newSdJwtVC(t, getTestVC(), signer)
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "name",
+				Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{nameField}},
+			}, {
+				ID: "family_name",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields:          []*Field{{Path: []string{"$.credentialSubject.family_name"}}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{sdJWTVC}, lddl, credOpts, WithUnionDisclosure())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		limited, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+
+		var claims []string
+		for _, disclosure := range limited.SDJWTDisclosures {
+			claims = append(claims, disclosure.Name)
+		}
+
+		require.ElementsMatch(t, []string{"given_name", "family_name"}, claims)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_NoTmpIDs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "urn:credential:1",
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"given_name": "John", "family_name": "Doe"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	newDescriptor := func(name string) *InputDescriptor {
+		return &InputDescriptor{
+			ID: name,
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields:          []*Field{{Path: []string{"$.credentialSubject." + name}}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{newDescriptor("given_name"), newDescriptor("family_name")},
+	}
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	t.Run("Presentation", func(t *testing.T) {
+		var originals []*OriginalCredential
+
+		vp, records, err := pd.CreateVPWithAudit([]*verifiable.Credential{vc}, lddl, credOpts,
+			WithDebugOriginals(&originals))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+
+		src, err := json.Marshal(vp)
+		require.NoError(t, err)
+		require.NotContains(t, string(src), "tmp_unique_id_")
+
+		for _, record := range records {
+			require.Equal(t, vc.ID, record.CredentialID)
+		}
+
+		require.Len(t, originals, 2)
+
+		for _, original := range originals {
+			require.Equal(t, vc.ID, original.CredentialID)
+		}
+	})
+
+	t.Run("Matched submission requirements", func(t *testing.T) {
+		matched, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{vc}, lddl, credOpts)
+		require.NoError(t, err)
+
+		for _, descriptor := range matched[0].Descriptors {
+			require.Len(t, descriptor.MatchedVCs, 1)
+			require.Equal(t, vc.ID, descriptor.MatchedVCs[0].ID)
+		}
+	})
+}
+
+func TestSanitizePresentation(t *testing.T) {
+	vc := &verifiable.Credential{ID: "urn:credential:1tmp_unique_id_6f8a1c"}
+	enclosed := &verifiable.Credential{ID: "urn:credential:2tmp_unique_id_b3e0"}
+
+	nested, err := verifiable.NewPresentation(verifiable.WithCredentials(enclosed))
+	require.NoError(t, err)
+
+	vp, err := verifiable.NewPresentation(verifiable.WithCredentials(vc), verifiable.WithPresentations(nested))
+	require.NoError(t, err)
+
+	vp.CustomFields = verifiable.CustomFields{
+		"presentation_submission": &PresentationSubmission{
+			DescriptorMap: []*InputDescriptorMapping{{
+				ID:   "name",
+				Path: "$.verifiableCredential[?(@.id=='urn:credential:1tmp_unique_id_6f8a1c')]",
+				PathNested: &InputDescriptorMapping{
+					ID:   "name",
+					Path: "$.verifiableCredential[?(@.id==\"urn:credential:2tmp_unique_id_b3e0\")]",
+				},
+			}},
+		},
+	}
+
+	SanitizePresentation(vp)
+
+	require.Equal(t, "urn:credential:1", vc.ID)
+	require.Equal(t, "urn:credential:2", enclosed.ID)
+
+	mapping := vp.CustomFields["presentation_submission"].(*PresentationSubmission).DescriptorMap[0]
+	require.Equal(t, "$.verifiableCredential[?(@.id=='urn:credential:1')]", mapping.Path)
+	require.Equal(t, "$.verifiableCredential[?(@.id==\"urn:credential:2\")]", mapping.PathNested.Path)
+
+	require.NotPanics(t, func() { SanitizePresentation(nil) })
+}
+
+func TestPresentationDefinition_CreateVP_PresentationClaims(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:holder",
+			CustomFields: verifiable.CustomFields{"given_name": "John", "family_name": "Doe"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path: []string{"$.credentialSubject.given_name"},
+				}, {
+					Path:   []string{"$.holder"},
+					Filter: &Filter{Type: &strFilterType, Const: "did:example:holder"},
+				}},
+			},
+		}},
+	}
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	t.Run("Holder of the presentation", func(t *testing.T) {
+		vp, records, err := pd.CreateVPWithAudit([]*verifiable.Credential{vc}, lddl, credOpts,
+			WithPresentationClaims(map[string]interface{}{"holder": "did:example:holder"}))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		subject := vp.Credentials()[0].(*verifiable.Credential).Subject.([]verifiable.Subject)
+		require.Equal(t, verifiable.CustomFields{"given_name": "John"}, subject[0].CustomFields)
+
+		// the holder is asserted by the presentation, not by the credential.
+		require.Len(t, records, 1)
+		require.Len(t, records[0].SatisfiedFields, 1)
+		require.Equal(t, "$.credentialSubject.given_name", records[0].SatisfiedFields[0].Path)
+	})
+
+	t.Run("Other holder", func(t *testing.T) {
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts,
+			WithPresentationClaims(map[string]interface{}{"holder": "did:example:other"}))
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Credential claims in the presentation", func(t *testing.T) {
+		claims := map[string]interface{}{
+			"holder":            "did:example:holder",
+			"credentialSubject": map[string]interface{}{"age": 21},
+		}
+
+		fields := append([]*Field{{
+			Path:   []string{"$.credentialSubject.age"},
+			Filter: &Filter{Type: &intFilterType, Minimum: 18},
+		}}, pd.InputDescriptors[0].Constraints.Fields...)
+
+		agePD := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "age",
+				Constraints: &Constraints{Fields: fields},
+			}},
+		}
+
+		_, err := agePD.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, WithPresentationClaims(claims))
+		require.ErrorIs(t, err, ErrNoCredentials)
+
+		vp, err := agePD.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts,
+			WithPresentationClaims(claims, "$.holder", "$.credentialSubject.age"))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("Credentials only by default", func(t *testing.T) {
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts)
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_VerifiableDisclosure(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	newVC := func(proofType string) *verifiable.Credential {
+		vc := &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{
+				ID:           "did:example:a",
+				CustomFields: verifiable.CustomFields{"given_name": "John", "age": 42},
+			}},
+			Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+			Issued: util.NewTime(time.Now()),
+		}
+
+		if proofType != "" {
+			vc.Proofs = []verifiable.Proof{{"type": proofType}}
+		}
+
+		return vc
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path: []string{"$.credentialSubject.given_name"},
+				}, {
+					Path:   []string{"$.credentialSubject.age"},
+					Filter: &Filter{Type: &intFilterType, Minimum: 18},
+				}},
+			},
+		}},
+	}
+
+	predicatePD := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path: []string{"$.credentialSubject.given_name"},
+				}, {
+					Path:      []string{"$.credentialSubject.age"},
+					Filter:    &Filter{Type: &intFilterType, Minimum: 18},
+					Predicate: &required,
+				}},
+			},
+		}},
+	}
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	t.Run("Limited without a proof by default", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{newVC("Ed25519Signature2018")}, lddl, credOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+		require.Empty(t, vp.Credentials()[0].(*verifiable.Credential).Proofs)
+	})
+
+	t.Run("Ed25519 proof is rejected", func(t *testing.T) {
+		vc := newVC("Ed25519Signature2018")
+
+		_, rejections, err := pd.MatchSubmissionRequirementDetailed([]*verifiable.Credential{vc}, lddl,
+			credOpts, WithVerifiableDisclosure())
+		require.NoError(t, err)
+		require.Equal(t, []*CredentialRejection{{
+			CredentialID: vc.ID,
+			DescriptorID: "name",
+			Stage:        ConstraintsStage,
+			FieldIndex:   -1,
+			Reason:       ErrSelectiveDisclosureNotSupported,
+		}}, rejections)
+
+		_, err = pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, WithVerifiableDisclosure())
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("BBS+ proof with a predicate is rejected", func(t *testing.T) {
+		vc := newVC("BbsBlsSignature2020")
+
+		_, rejections, err := predicatePD.MatchSubmissionRequirementDetailed([]*verifiable.Credential{vc}, lddl,
+			credOpts, WithVerifiableDisclosure())
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+		require.Equal(t, 1, rejections[0].FieldIndex)
+		require.ErrorIs(t, rejections[0].Reason, ErrSelectiveDisclosureNotSupported)
+	})
+
+	t.Run("Unsigned credential is limited", func(t *testing.T) {
+		vp, err := predicatePD.CreateVPWithOptions([]*verifiable.Credential{newVC("")}, lddl, credOpts,
+			WithVerifiableDisclosure())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		subject := vp.Credentials()[0].(*verifiable.Credential).Subject.([]verifiable.Subject)
+		require.Equal(t, verifiable.CustomFields{"given_name": "John", "age": true}, subject[0].CustomFields)
+	})
+}
+
+func TestPresentationDefinition_CanonicalHash(t *testing.T) {
+	parse := func(src string) *PresentationDefinition {
+		pd := &PresentationDefinition{}
+		require.NoError(t, json.Unmarshal([]byte(src), pd))
+
+		return pd
+	}
+
+	hash, err := parse(`{
+		"id": "pd",
+		"purpose": "age",
+		"frame": {
+			"@context": ["https://www.w3.org/2018/credentials/v1"],
+			"type": ["VerifiableCredential"],
+			"@explicit": true
+		},
+		"input_descriptors": [{
+			"id": "age",
+			"metadata": {"b": 1, "a": {"y": "y", "x": "x"}},
+			"constraints": {"fields": [{"path": ["$.age"], "filter": {"type": "number", "minimum": 18}}]}
+		}]
+	}`).CanonicalHash()
+	require.NoError(t, err)
+
+	sameHash, err := parse(`{"input_descriptors":[{"constraints":{"fields":[{"filter":{"minimum":18.0,"type":"number"},` +
+		`"path":["$.age"]}]},"metadata":{"a":{"x":"x","y":"y"},"b":1.0},"id":"age"}],"frame":{"@explicit":true,` +
+		`"type":["VerifiableCredential"],"@context":["https://www.w3.org/2018/credentials/v1"]},"purpose":"age",` +
+		`"id":"pd"}`).CanonicalHash()
+	require.NoError(t, err)
+	require.Equal(t, hash, sameHash)
+
+	otherHash, err := parse(`{"id":"pd","purpose":"age","input_descriptors":[{"id":"age","constraints":{"fields":` +
+		`[{"path":["$.age"],"filter":{"type":"number","minimum":21}}]}}]}`).CanonicalHash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, otherHash)
+
+	// the integers beyond the precision of float64 are told apart.
+	bigConst := func(value interface{}) [32]byte {
+		pd := &PresentationDefinition{
+			ID: "pd",
+			InputDescriptors: []*InputDescriptor{{
+				ID: "id",
+				Constraints: &Constraints{
+					Fields: []*Field{{Path: []string{"$.id"}, Filter: &Filter{Const: value}}},
+				},
+			}},
+		}
+
+		h, e := pd.CanonicalHash()
+		require.NoError(t, e)
+
+		return h
+	}
+
+	require.NotEqual(t, bigConst(int64(1<<53)), bigConst(int64(1<<53+1)))
+	require.NotEqual(t, bigConst(uint64(1<<63)), bigConst(uint64(1<<63+1)))
+	require.Equal(t, bigConst(int64(1<<53)), bigConst(float64(1<<53)))
+	require.Equal(t, bigConst(0.5), bigConst(json.Number("5e-1")))
+}
+
+func TestPresentationDefinition_CreateVP_PostDisclosureVerification(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "person",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject.given_name"},
+					Filter: &Filter{Type: &strFilterType, Const: "John"},
+				}, {
+					Path: []string{"$.credentialSubject.address.country"},
+				}},
+			},
+		}},
+	}
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	t.Run("JSON-LD", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{getTestVC()}, lddl, credOpts,
+			WithPostDisclosureVerification())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("SD-JWT", func(t *testing.T) {
+		signer, err := newCryptoSigner(kms.ED25519Type)
+		require.NoError(t, err)
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{newSdJwtVC(t, getTestVC(), signer)}, lddl, credOpts,
+			WithPostDisclosureVerification())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+		require.Len(t, vp.Credentials()[0].(*verifiable.Credential).SDJWTDisclosures, 2)
+	})
+
+	t.Run("Predicate", func(t *testing.T) {
+		predicate := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "person",
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:      []string{"$.credentialSubject.given_name"},
+						Filter:    &Filter{Type: &strFilterType, Const: "John"},
+						Predicate: &required,
+					}},
+				},
+			}},
+		}
+
+		vc := getTestVC()
+		vc.Schemas = nil
+
+		vp, err := predicate.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts,
+			WithPostDisclosureVerification())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_MultipleSubjects(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "http://example.edu/credentials/1872",
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:parent",
+			CustomFields: verifiable.CustomFields{"name": "Jane", "role": "parent"},
+		}, {
+			ID:           "did:example:child",
+			CustomFields: verifiable.CustomFields{"name": "John", "role": "child"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+
+	createVP := func(path string) []verifiable.Subject {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "subject",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields:          []*Field{{Path: []string{path}}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{vc}, lddl,
+			verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		subjects, ok := vp.Credentials()[0].(*verifiable.Credential).Subject.([]verifiable.Subject)
+		require.True(t, ok)
+
+		return subjects
+	}
+
+	t.Run("Claim of one subject", func(t *testing.T) {
+		require.Equal(t, []verifiable.Subject{{
+			ID:           "did:example:child",
+			CustomFields: verifiable.CustomFields{"name": "John"},
+		}}, createVP("$.credentialSubject[1].name"))
+	})
+
+	t.Run("Claims of all the subjects", func(t *testing.T) {
+		require.Equal(t, []verifiable.Subject{{
+			ID:           "did:example:parent",
+			CustomFields: verifiable.CustomFields{"role": "parent"},
+		}, {
+			ID:           "did:example:child",
+			CustomFields: verifiable.CustomFields{"role": "child"},
+		}}, createVP("$.credentialSubject[*].role"))
+	})
+
+	t.Run("No claim of the subjects", func(t *testing.T) {
+		require.Equal(t, []verifiable.Subject{
+			{ID: "did:example:parent", CustomFields: verifiable.CustomFields{}},
+			{ID: "did:example:child", CustomFields: verifiable.CustomFields{}},
+		}, createVP("$.issuer"))
+	})
+}
+
+func TestPresentationDefinition_Clone(t *testing.T) {
+	required := Required
+	minLength := 1
+
+	pd := &PresentationDefinition{
+		ID:             "pd",
+		Purpose:        "purpose",
+		LocalizedNames: map[string]string{"fr": "nom"},
+		Format:         &Format{JwtVC: &JwtType{Alg: []string{"EdDSA"}}, LdpVC: &LdpType{ProofType: []string{"proof"}}},
+		Frame:          map[string]interface{}{"type": []interface{}{"VerifiableCredential"}},
+		SubmissionRequirements: []*SubmissionRequirement{{
+			Rule:       Pick,
+			Count:      1,
+			FromNested: []*SubmissionRequirement{{Rule: All, From: "A"}},
+		}},
+		InputDescriptors: []*InputDescriptor{{
+			ID:       "descriptor",
+			Group:    []string{"A"},
+			Metadata: map[string]interface{}{"nested": map[string]interface{}{"key": "value"}},
+			Schema:   []*Schema{{URI: "https://example.com/schema"}},
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Statuses:        &Statuses{Active: &StatusDirective{Directive: DirectiveRequired}},
+				IsHolder:        []*Holder{{FieldID: []string{"name"}, Directive: &required}},
+				Fields: []*Field{{
+					ID:   "name",
+					Path: []string{"$.credentialSubject.name"},
+					Filter: &Filter{
+						Type:      &strFilterType,
+						MinLength: &minLength,
+						Enum:      []StrOrInt{"John", "Jane"},
+						Not:       map[string]interface{}{"const": "Bob"},
+					},
+					Filters:   []*Filter{{Types: []string{"string", "number"}}},
+					Predicate: &required,
+				}},
+			},
+		}},
+		SameSubject: []*SameSubjectGroup{{FieldID: []string{"name"}, Directive: &required}},
+	}
+
+	original, err := json.Marshal(pd)
+	require.NoError(t, err)
+
+	clone := pd.Clone()
+	require.Equal(t, pd, clone)
+
+	clone.Purpose = "other"
+	clone.LocalizedNames["fr"] = "autre"
+	clone.Format.JwtVC.Alg[0] = "ES256"
+	clone.Frame["type"].([]interface{})[0] = "Other"
+	clone.SubmissionRequirements[0].FromNested[0].From = "B"
+	clone.InputDescriptors[0].Group[0] = "B"
+	clone.InputDescriptors[0].Metadata["nested"].(map[string]interface{})["key"] = "other"
+	clone.InputDescriptors[0].Schema[0].URI = "https://example.com/other"
+	*clone.InputDescriptors[0].Constraints.LimitDisclosure = Preferred
+	clone.InputDescriptors[0].Constraints.Statuses.Active.Directive = DirectiveDisallowed
+	clone.InputDescriptors[0].Constraints.IsHolder[0].FieldID[0] = "other"
+
+	field := clone.InputDescriptors[0].Constraints.Fields[0]
+	field.Path[0] = "$.credentialSubject.nonce"
+	*field.Filter.Type = "number"
+	*field.Filter.MinLength = 2
+	field.Filter.Enum[0] = "nonce"
+	field.Filter.Not["const"] = "nonce"
+	field.Filters[0].Types[0] = "boolean"
+	clone.SameSubject[0].FieldID[0] = "other"
+
+	unchanged, err := json.Marshal(pd)
+	require.NoError(t, err)
+	require.JSONEq(t, string(original), string(unchanged))
+	require.Equal(t, "string", strFilterType)
+	require.Equal(t, Required, required)
+
+	require.Nil(t, (*PresentationDefinition)(nil).Clone())
+}
+
+func TestMatchesDescriptor(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	newDescriptor := func(minimum int) *InputDescriptor {
+		return &InputDescriptor{
+			ID:     "age",
+			Schema: []*Schema{{URI: verifiable.ContextID + "#" + verifiable.VCType}},
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject.age"},
+					Filter: &Filter{Type: &intFilterType, Minimum: minimum},
+				}},
+			},
+		}
+	}
+
+	signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	vc := getTestVC()
+	vc.Schemas = nil
+	vc.Subject.(map[string]interface{})["age"] = 42
+
+	for name, credential := range map[string]*verifiable.Credential{
+		"JSON-LD": vc,
+		"SD-JWT":  newSdJwtVC(t, getTestVC(), signer),
+	} {
+		t.Run(name, func(t *testing.T) {
+			before, err := json.Marshal(credential)
+			require.NoError(t, err)
+
+			disclosures := len(credential.SDJWTDisclosures)
+
+			field := "$.credentialSubject.age"
+			if credential.SDJWTHashAlg != "" {
+				field = "$.credentialSubject.given_name"
+			}
+
+			descriptor := newDescriptor(18)
+			descriptor.Constraints.Fields[0].Path = []string{field}
+			descriptor.Constraints.Fields[0].Filter = nil
+
+			matches, err := MatchesDescriptor(credential, descriptor, lddl)
+			require.NoError(t, err)
+			require.True(t, matches)
+
+			after, err := json.Marshal(credential)
+			require.NoError(t, err)
+			require.Equal(t, before, after)
+			require.Len(t, credential.SDJWTDisclosures, disclosures)
+		})
+	}
+
+	t.Run("Constraints", func(t *testing.T) {
+		matches, err := MatchesDescriptor(vc, newDescriptor(18), lddl)
+		require.NoError(t, err)
+		require.True(t, matches)
+
+		matches, err = MatchesDescriptor(vc, newDescriptor(50), lddl)
+		require.NoError(t, err)
+		require.False(t, matches)
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		descriptor := newDescriptor(18)
+		descriptor.Format = &Format{JwtVC: &JwtType{Alg: []string{"EdDSA"}}}
+
+		matches, err := MatchesDescriptor(vc, descriptor, lddl)
+		require.NoError(t, err)
+		require.False(t, matches)
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		descriptor := newDescriptor(18)
+		descriptor.Schema = []*Schema{{URI: "https://example.com/schema#Passport"}}
+
+		matches, err := MatchesDescriptor(vc, descriptor, lddl)
+		require.NoError(t, err)
+		require.False(t, matches)
+	})
+}
+
+func TestPresentationDefinition_UnsatisfiedDescriptors(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newDescriptor := func(id, claim string) *InputDescriptor {
+		return &InputDescriptor{
+			ID: id,
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject." + claim}}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{
+			newDescriptor("name", "given_name"),
+			newDescriptor("address", "street"),
+			newDescriptor("age", "age"),
+		},
+		SubmissionRequirements: []*SubmissionRequirement{{Rule: Pick, Count: 1, From: "A"}},
+	}
+
+	for _, descriptor := range pd.InputDescriptors {
+		descriptor.Group = []string{"A"}
+	}
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"given_name": "John"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	unsatisfied, err := pd.UnsatisfiedDescriptors([]*verifiable.Credential{vc}, lddl)
+	require.NoError(t, err)
+	require.Equal(t, []*InputDescriptor{pd.InputDescriptors[1], pd.InputDescriptors[2]}, unsatisfied)
+
+	unsatisfied, err = pd.UnsatisfiedDescriptors(nil, lddl)
+	require.NoError(t, err)
+	require.Equal(t, pd.InputDescriptors, unsatisfied)
+
+	vc.Subject.([]verifiable.Subject)[0].CustomFields["street"] = "Main St"
+	vc.Subject.([]verifiable.Subject)[0].CustomFields["age"] = 42
+
+	unsatisfied, err = pd.UnsatisfiedDescriptors([]*verifiable.Credential{vc}, lddl)
+	require.NoError(t, err)
+	require.Empty(t, unsatisfied)
+
+	// the credentials are not framed, as only the matches are returned.
+	pd.Frame = map[string]interface{}{"@type": "VerifiableCredential"}
+
+	_, err = pd.CreateVP([]*verifiable.Credential{vc}, lddl)
+	require.Error(t, err)
+
+	unsatisfied, err = pd.UnsatisfiedDescriptors([]*verifiable.Credential{vc}, lddl)
+	require.NoError(t, err)
+	require.Empty(t, unsatisfied)
+}
+
+func TestPresentationDefinition_CreateVP_LimitDisclosurePreferred(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	preferred := Preferred
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Schema: []*Schema{{
+				URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+			}},
+			Constraints: &Constraints{
+				LimitDisclosure: &preferred,
+				Fields: []*Field{{
+					Path: []string{
+						"$.credentialSubject.family_name",
+						"$.credentialSubject.given_name",
+						"$.credentialSubject.address.country",
+					},
+				}},
+			},
+		}},
+	}
+
+	t.Run("SD-JWT", func(t *testing.T) {
+		ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+		require.NoError(t, err)
+
+		sdJwtVC := newSdJwtVC(t, getTestVC(), ed25519Signer)
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl,
+			verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		vc, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Len(t, vc.SDJWTDisclosures, 3)
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+	})
+
+	t.Run("No selective disclosure support", func(t *testing.T) {
+		vp, err := pd.CreateVP([]*verifiable.Credential{getTestVC()}, lddl,
+			verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		vc, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Equal(t, "johndoe@example.com", vc.Subject.(map[string]interface{})["email"])
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+	})
+}
+
+func TestConstraints_IsHolder(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	const holderDID = "did:example:holder"
+
+	newCred := func(subjects ...verifiable.Subject) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: subjects,
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	required, preferred := Required, Preferred
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Constraints: &Constraints{
+				IsHolder: []*Holder{{FieldID: []string{"name"}, Directive: &required}},
+				Fields:   []*Field{{ID: "name", Path: []string{"$.credentialSubject.name"}}},
+			},
+		}},
+	}
+
+	holderCred := newCred(verifiable.Subject{ID: holderDID, CustomFields: map[string]interface{}{"name": "Jesse"}})
+	otherCred := newCred(verifiable.Subject{ID: "did:example:other", CustomFields: map[string]interface{}{"name": "Ann"}})
+
+	t.Run("Required", func(t *testing.T) {
+		vp, rejections, err := pd.MatchSubmissionRequirementDetailed(
+			[]*verifiable.Credential{holderCred, otherCred}, lddl, WithHolderDID(holderDID))
+		require.NoError(t, err)
+		require.Len(t, vp, 1)
+		require.Len(t, vp[0].Descriptors[0].MatchedVCs, 1)
+		require.Equal(t, holderCred.ID, vp[0].Descriptors[0].MatchedVCs[0].ID)
+
+		require.Len(t, rejections, 1)
+		require.Equal(t, otherCred.ID, rejections[0].CredentialID)
+		require.Equal(t, 0, rejections[0].FieldIndex)
+		require.EqualError(t, rejections[0].Reason, "is_holder: field name: holder is not the subject")
+	})
+
+	t.Run("Required without holder DID", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{holderCred}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("Required with DID URL of the holder", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{holderCred, otherCred}, lddl,
+			WithHolderDID("DID:Example:holder#key-1"))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("Preferred", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					IsHolder: []*Holder{{FieldID: []string{"name"}, Directive: &preferred}},
+					Fields:   []*Field{{ID: "name", Path: []string{"$.credentialSubject.name"}}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{holderCred, otherCred}, lddl, WithHolderDID(holderDID))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+	})
+
+	t.Run("Multiple subjects", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					IsHolder: []*Holder{{FieldID: []string{"name"}, Directive: &required}},
+					Fields:   []*Field{{ID: "name", Path: []string{"$.credentialSubject[*].name"}}},
+				},
+			}},
+		}
+
+		multiCred := newCred(
+			verifiable.Subject{ID: "did:example:other", CustomFields: map[string]interface{}{"name": "Ann"}},
+			verifiable.Subject{ID: holderDID, CustomFields: map[string]interface{}{"name": "Jesse"}},
+		)
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{multiCred}, lddl, WithHolderDID(holderDID))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		_, err = pd.CreateVPWithOptions([]*verifiable.Credential{multiCred}, lddl, WithHolderDID("did:example:unknown"))
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("Unknown field ID", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					IsHolder: []*Holder{{FieldID: []string{"age"}, Directive: &required}},
+					Fields:   []*Field{{ID: "name", Path: []string{"$.credentialSubject.name"}}},
+				},
+			}},
+		}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{holderCred}, lddl, WithHolderDID(holderDID))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "is_holder: field_id age is not defined in constraints fields")
+	})
+}
+
+func TestPresentationDefinition_CreateVP_SameSubject(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(subjectID, field string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: subjectID, CustomFields: map[string]interface{}{field: "123"}}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	required, preferred := Required, Preferred
+
+	descriptors := []*InputDescriptor{{
+		ID: "passport",
+		Constraints: &Constraints{
+			Fields: []*Field{{ID: "passport_number", Path: []string{"$.credentialSubject.passport_number"}}},
+		},
+	}, {
+		ID: "utility_bill",
+		Constraints: &Constraints{
+			Fields: []*Field{{ID: "account", Path: []string{"$.credentialSubject.account"}}},
+		},
+	}}
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		InputDescriptors: descriptors,
+		SameSubject: []*SameSubjectGroup{{
+			FieldID:   []string{"passport_number", "account"},
+			Directive: &required,
+		}},
+	}
+
+	passportA := newCred("did:example:a", "passport_number")
+	passportB := newCred("did:example:b", "passport_number")
+	billB := newCred("did:example:b", "account")
+	billC := newCred("did:example:c", "account")
+
+	t.Run("Required", func(t *testing.T) {
+		vp, err := pd.CreateVP([]*verifiable.Credential{passportA, passportB, billB, billC}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+		require.Equal(t, passportB, vp.Credentials()[0])
+		require.Equal(t, billB, vp.Credentials()[1])
+	})
+
+	t.Run("Required with credentials without IDs", func(t *testing.T) {
+		noIDPassportA, noIDPassportB := *passportA, *passportB
+		noIDPassportA.ID, noIDPassportB.ID = "", ""
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{&noIDPassportA, &noIDPassportB, billB}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+		require.Same(t, &noIDPassportB, vp.Credentials()[0])
+		require.Equal(t, billB, vp.Credentials()[1])
+	})
+
+	t.Run("Required without common subject", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{passportA, billC}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("Preferred without common subject", func(t *testing.T) {
+		preferredPD := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: descriptors,
+			SameSubject: []*SameSubjectGroup{{
+				FieldID:   []string{"passport_number", "account"},
+				Directive: &preferred,
+			}},
+		}
+
+		vp, err := preferredPD.CreateVP([]*verifiable.Credential{passportA, billC}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+	})
+
+	t.Run("Unknown field ID", func(t *testing.T) {
+		unknown := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: descriptors,
+			SameSubject: []*SameSubjectGroup{{
+				FieldID:   []string{"passport_number", "name"},
+				Directive: &required,
+			}},
+		}
+
+		_, err := unknown.CreateVP([]*verifiable.Credential{passportB, billB}, lddl)
+		require.EqualError(t, err, "same_subject[0]: field_id name is not defined in input descriptors")
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		require.NoError(t, pd.ValidateSchema())
+
+		noDirective := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: descriptors,
+			SameSubject:      []*SameSubjectGroup{{FieldID: []string{"passport_number", "account"}}},
+		}
+		require.Error(t, noDirective.ValidateSchema())
+	})
+}
+
+func TestPresentationDefinition_PreviewDisclosure(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Schema: []*Schema{{
+				URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+			}},
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path: []string{"$.credentialSubject.family_name"},
+					Name: "Family name",
+				}, {
+					Path:      []string{"$.credentialSubject.birthdate"},
+					Filter:    &Filter{Type: &strFilterType},
+					Predicate: &required,
+				}},
+			},
+		}},
+	}
+
+	t.Run("Limit disclosure required", func(t *testing.T) {
+		vc := getTestVC()
+
+		previews, err := pd.PreviewDisclosure([]*verifiable.Credential{vc}, lddl)
+		require.NoError(t, err)
+		require.Len(t, previews, 1)
+
+		require.Equal(t, "name", previews[0].DescriptorID)
+		require.Equal(t, vc.ID, previews[0].CredentialID)
+		require.True(t, previews[0].Limited)
+		require.Equal(t, []*DisclosedField{
+			{Path: "credentialSubject.family_name", Name: "Family name", Value: "Doe"},
+			{Path: "credentialSubject.birthdate", Value: true},
+		}, previews[0].Fields)
+
+		// the credential is not modified by the preview
+		require.Equal(t, "1940-01-01", vc.Subject.(map[string]interface{})["birthdate"])
+	})
+
+	t.Run("SD-JWT", func(t *testing.T) {
+		ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+		require.NoError(t, err)
+
+		sdJwtVC := newSdJwtVC(t, getTestVC(), ed25519Signer)
+		disclosures := len(sdJwtVC.SDJWTDisclosures)
+
+		// the predicate is not supported by SD-JWT credentials.
+		_, err = pd.PreviewDisclosure([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+
+		noPredicate := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "name",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path: []string{"$.credentialSubject.family_name"},
+						Name: "Family name",
+					}, {
+						Path:   []string{"$.credentialSubject.birthdate"},
+						Filter: &Filter{Type: &strFilterType},
+					}},
+				},
+			}},
+		}
+
+		previews, err := noPredicate.PreviewDisclosure([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.NoError(t, err)
+		require.Len(t, previews, 1)
+		require.True(t, previews[0].Limited)
+		require.Equal(t, []*DisclosedField{
+			{Path: "credentialSubject.family_name", Name: "Family name", Value: "Doe"},
+			{Path: "credentialSubject.birthdate", Value: "1940-01-01"},
+		}, previews[0].Fields)
+		require.Len(t, sdJwtVC.SDJWTDisclosures, disclosures)
+	})
+
+	t.Run("No limit disclosure", func(t *testing.T) {
+		unlimited := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "name",
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path: []string{"$.credentialSubject.family_name"},
+						Name: "Family name",
+					}, {
+						Path:      []string{"$.credentialSubject.birthdate"},
+						Filter:    &Filter{Type: &strFilterType},
+						Predicate: &required,
+					}},
+				},
+			}},
+		}
+
+		previews, err := unlimited.PreviewDisclosure([]*verifiable.Credential{getTestVC()}, lddl)
+		require.NoError(t, err)
+		require.Len(t, previews, 1)
+		require.False(t, previews[0].Limited)
+		require.Len(t, previews[0].Fields, 2)
+	})
+
+	t.Run("No credentials", func(t *testing.T) {
+		vc := getTestVC()
+		vc.Subject = []verifiable.Subject{{ID: "did:example:123"}}
+
+		_, err := pd.PreviewDisclosure([]*verifiable.Credential{vc}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+}
+
+func TestFilter_UnmarshalScalars(t *testing.T) {
+	t.Run("Scalars", func(t *testing.T) {
+		var filter Filter
+
+		require.NoError(t, json.Unmarshal([]byte(`{"type":"string","minimum":"2000-01-01","maximum":10,
+			"exclusiveMinimum":1.5,"const":true,"enum":["a",1,false]}`), &filter))
+		require.Equal(t, "2000-01-01", filter.Minimum)
+		require.Equal(t, []StrOrInt{"a", float64(1), false}, filter.Enum)
+	})
+
+	t.Run("Any const and enum values", func(t *testing.T) {
+		var filter Filter
+
+		require.NoError(t, json.Unmarshal([]byte(`{"const":["VerifiableCredential","X"],
+			"enum":["a",{"b":[]},[1]]}`), &filter))
+		require.Equal(t, []interface{}{"VerifiableCredential", "X"}, filter.Const)
+		require.Equal(t, []StrOrInt{"a", map[string]interface{}{"b": []interface{}{}}, []interface{}{float64(1)}},
+			filter.Enum)
+
+		constraints := &Constraints{Fields: []*Field{{Path: []string{"$.type"}, Filter: &Filter{Const: filter.Const}}}}
+
+		_, err := constraints.MinimalDisclosurePaths(map[string]interface{}{
+			"type": []interface{}{"VerifiableCredential", "X"},
+		})
+		require.NoError(t, err)
+
+		_, err = constraints.MinimalDisclosurePaths(map[string]interface{}{"type": []interface{}{"VerifiableCredential"}})
+		require.Error(t, err)
+	})
+
+	for name, test := range map[string]struct {
+		data    string
+		keyword string
+	}{
+		"Object minimum":   {`{"minimum":{"minimum":1}}`, "minimum"},
+		"Array maximum":    {`{"maximum":[1,[2,[3]]]}`, "maximum"},
+		"Object exclusive": {`{"exclusiveMaximum":{}}`, "exclusiveMaximum"},
+		"Object format":    {`{"format":"date","formatMinimum":{"$ref":"#"}}`, "formatMinimum"},
+		"Array format":     {`{"format":"date","formatMaximum":["2020-01-01"]}`, "formatMaximum"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var filter Filter
+
+			err := json.Unmarshal([]byte(test.data), &filter)
+
+			var valueErr *FilterValueError
+			require.ErrorAs(t, err, &valueErr)
+			require.Equal(t, test.keyword, valueErr.Keyword)
+		})
+	}
+
+	t.Run("Presentation definition", func(t *testing.T) {
+		var pd PresentationDefinition
+
+		err := json.Unmarshal([]byte(`{"id":"pd","input_descriptors":[{"id":"age","constraints":{"fields":[
+			{"path":["$.age"],"filter":{"type":"number","minimum":{"$ref":"#"}}}]}}]}`), &pd)
+
+		var valueErr *FilterValueError
+		require.ErrorAs(t, err, &valueErr)
+	})
+}
+
+func FuzzFilter_UnmarshalJSON(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"string","pattern":"^a"}`,
+		`{"type":"number","minimum":18,"exclusiveMaximum":65.5}`,
+		`{"type":"string","format":"date","maximum":"2020-01-01"}`,
+		`{"const":"a","enum":["a","b",1,true]}`,
+		`{"type":"array","contains":{"const":"a"},"minContains":1}`,
+		`{"not":{},"oneOf":[{"const":1},{"const":2}]}`,
+		`{"minimum":{"minimum":{"minimum":[[[]]]}}}`,
+		`{"enum":[{"a":[1,{"b":null}]}]}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var filter Filter
+
+		if err := json.Unmarshal(data, &filter); err != nil {
+			return
+		}
+
+		for _, value := range []StrOrInt{filter.Minimum, filter.Maximum, filter.ExclusiveMinimum,
+			filter.ExclusiveMaximum, filter.CustomFields["formatMinimum"], filter.CustomFields["formatMaximum"]} {
+			switch value.(type) {
+			case nil, string, float64, bool:
+			default:
+				t.Fatalf("unmarshaled a non-scalar value %T from %s", value, data)
+			}
+		}
+
+		src, err := json.Marshal(&filter)
+		require.NoError(t, err)
+
+		var roundTrip Filter
+		require.NoError(t, json.Unmarshal(src, &roundTrip))
+	})
+}
+
+func TestFilter_Types(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		for name, test := range map[string]struct {
+			data  string
+			typ   *string
+			types []string
+		}{
+			"Single":    {`{"type":"string","minLength":1}`, &strFilterType, nil},
+			"Array":     {`{"type":["string","number"],"minLength":1}`, nil, []string{"string", "number"}},
+			"Empty":     {`{"type":[],"minLength":1}`, nil, []string{}},
+			"Not typed": {`{"minLength":1}`, nil, nil},
+		} {
+			t.Run(name, func(t *testing.T) {
+				var filter Filter
+
+				require.NoError(t, json.Unmarshal([]byte(test.data), &filter))
+				require.Equal(t, test.typ, filter.Type)
+				require.Equal(t, test.types, filter.Types)
+				require.Nil(t, filter.CustomFields)
+
+				src, err := json.Marshal(&filter)
+				require.NoError(t, err)
+				require.JSONEq(t, test.data, string(src))
+			})
+		}
+	})
+
+	t.Run("Types take precedence", func(t *testing.T) {
+		src, err := json.Marshal(&Filter{Type: &strFilterType, Types: []string{"boolean", "null"}})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"type":["boolean","null"]}`, string(src))
+	})
+
+	t.Run("Invalid type", func(t *testing.T) {
+		var filter Filter
+
+		require.Error(t, json.Unmarshal([]byte(`{"type":["string",1]}`), &filter))
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		lddl := createTestJSONLDDocumentLoader(t)
+
+		newCred := func(age interface{}) *verifiable.Credential {
+			return &verifiable.Credential{
+				Context:      []string{verifiable.ContextURI},
+				Types:        []string{verifiable.VCType},
+				ID:           uuid.New().String(),
+				CustomFields: map[string]interface{}{"age": age},
+			}
+		}
+
+		str, number, boolean := newCred("21"), newCred(21), newCred(true)
+
+		var pd PresentationDefinition
+
+		require.NoError(t, json.Unmarshal([]byte(`{"id":"pd","input_descriptors":[{"id":"age","constraints":{
+			"fields":[{"path":["$.age"],"filter":{"type":["string","number"]}}]}}]}`), &pd))
+		require.NoError(t, pd.ValidateSchema())
+
+		matched, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{str, number, boolean}, lddl)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		require.Equal(t, []string{str.ID, number.ID}, ids)
+	})
+}
+
+func TestFilter_Refs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(ages ...interface{}) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context:      []string{verifiable.ContextURI},
+			Types:        []string{verifiable.VCType},
+			ID:           uuid.New().String(),
+			CustomFields: map[string]interface{}{"age": ages[0], "ages": ages},
+		}
+	}
+
+	minor, adult := newCred(float64(17)), newCred(float64(21), float64(17))
+
+	matchedIDs := func(t *testing.T, path, filter string, opts ...MatchRequirementsOpt) []string {
+		t.Helper()
+
+		var pd PresentationDefinition
+
+		require.NoError(t, json.Unmarshal([]byte(`{"id":"pd","input_descriptors":[{"id":"age","constraints":{
+			"fields":[{"path":["`+path+`"],"filter":`+filter+`}]}}]}`), &pd))
+
+		matched, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{minor, adult}, lddl, opts...)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	t.Run("Local $defs", func(t *testing.T) {
+		filter := `{"$ref":"#/$defs/adult","$defs":{"adult":{"type":"number","minimum":18}}}`
+
+		var f Filter
+		require.NoError(t, json.Unmarshal([]byte(filter), &f))
+
+		src, err := json.Marshal(&f)
+		require.NoError(t, err)
+		require.JSONEq(t, filter, string(src))
+
+		require.Equal(t, []string{adult.ID}, matchedIDs(t, "$.age", filter))
+	})
+
+	t.Run("Local definitions in contains", func(t *testing.T) {
+		ids := matchedIDs(t, "$.ages", `{"type":"array","contains":{"$ref":"#/definitions/adult"},"minContains":1,
+			"definitions":{"adult":{"type":"number","minimum":18}}}`)
+		require.Equal(t, []string{adult.ID}, ids)
+	})
+
+	const external = `{"$ref":"https://example.com/schemas/adult.json"}`
+
+	t.Run("External $ref is not loaded by default", func(t *testing.T) {
+		require.Empty(t, matchedIDs(t, "$.age", external))
+	})
+
+	t.Run("External $ref loaded with the loader", func(t *testing.T) {
+		var loaded []string
+
+		loader := func(ref string) ([]byte, error) {
+			loaded = append(loaded, ref)
+
+			switch ref {
+			case "https://example.com/schemas/adult.json":
+				return []byte(`{"$ref":"age.json#/definitions/adult"}`), nil
+			case "https://example.com/schemas/age.json":
+				return []byte(`{"definitions":{"adult":{"type":"number","minimum":18}}}`), nil
+			default:
+				return nil, fmt.Errorf("unexpected ref %s", ref)
+			}
+		}
+
+		require.Equal(t, []string{adult.ID}, matchedIDs(t, "$.age", external, WithFilterRefLoader(loader)))
+		require.Equal(t, []string{
+			"https://example.com/schemas/adult.json",
+			"https://example.com/schemas/age.json",
+		}, loaded)
+	})
+
+	t.Run("External $ref fails to load", func(t *testing.T) {
+		loader := func(ref string) ([]byte, error) {
+			return nil, errors.New("not allowed")
+		}
+
+		require.Empty(t, matchedIDs(t, "$.age", external, WithFilterRefLoader(loader)))
+	})
+}
+
+func TestFilter_Contains(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(degrees ...string) *verifiable.Credential {
+		var items []interface{}
+		for _, degree := range degrees {
+			items = append(items, map[string]interface{}{"type": degree})
+		}
+
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			CustomFields: map[string]interface{}{
+				"name":    "Jesse",
+				"degrees": items,
+			},
+		}
+	}
+
+	none := newCred("MasterDegree")
+	one := newCred("BachelorDegree", "MasterDegree")
+	two := newCred("BachelorDegree", "MasterDegree", "BachelorDegree")
+
+	matchedIDs := func(t *testing.T, path string, filter *Filter) []string {
+		t.Helper()
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					Fields: []*Field{{Path: []string{path}, Filter: filter}},
+				},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{none, one, two}, lddl)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	bachelor := map[string]interface{}{"const": "BachelorDegree"}
+	zero, once, twice := 0, 1, 2
+
+	t.Run("contains", func(t *testing.T) {
+		ids := matchedIDs(t, "$.degrees[*].type", &Filter{Contains: bachelor})
+		require.Equal(t, []string{one.ID, two.ID}, ids)
+	})
+
+	t.Run("minContains", func(t *testing.T) {
+		ids := matchedIDs(t, "$.degrees[*].type", &Filter{Contains: bachelor, MinContains: &twice})
+		require.Equal(t, []string{two.ID}, ids)
+
+		ids = matchedIDs(t, "$.degrees[*].type", &Filter{Contains: bachelor, MinContains: &zero})
+		require.Equal(t, []string{none.ID, one.ID, two.ID}, ids)
+	})
+
+	t.Run("maxContains", func(t *testing.T) {
+		ids := matchedIDs(t, "$.degrees[*].type", &Filter{Contains: bachelor, MaxContains: &once})
+		require.Equal(t, []string{one.ID}, ids)
+
+		ids = matchedIDs(t, "$.degrees[*].type",
+			&Filter{Type: &arrFilterType, Contains: bachelor, MinContains: &zero, MaxContains: &once})
+		require.Equal(t, []string{none.ID, one.ID}, ids)
+	})
+
+	t.Run("not an array", func(t *testing.T) {
+		ids := matchedIDs(t, "$.name", &Filter{Contains: bachelor, MinContains: &once})
+		require.Empty(t, ids)
+	})
+
+	t.Run("marshal", func(t *testing.T) {
+		out, err := json.Marshal(&Filter{Contains: bachelor, MinContains: &zero, MaxContains: &twice})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"contains":{"const":"BachelorDegree"},"minContains":0,"maxContains":2}`, string(out))
+	})
+}
+
+func TestPresentationDefinition_CreateVP_PartialMatches(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(field string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: map[string]interface{}{field: "123"}}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	newDescriptor := func(field string) *InputDescriptor {
+		return &InputDescriptor{
+			ID: field,
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject." + field}}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{
+			newDescriptor("passport_number"), newDescriptor("account"), newDescriptor("license_number"),
+		},
+	}
+
+	passport, license := newCred("passport_number"), newCred("license_number")
+
+	t.Run("All or nothing by default", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{passport, license}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("Partial matches", func(t *testing.T) {
+		var unmet []string
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{passport, license}, lddl, WithPartialMatches(&unmet))
+		require.NoError(t, err)
+		require.Equal(t, []string{"account"}, unmet)
+
+		require.Len(t, vp.Credentials(), 2)
+		require.Equal(t, license, vp.Credentials()[0])
+		require.Equal(t, passport, vp.Credentials()[1])
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+
+		ps, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Len(t, ps.DescriptorMap, 2)
+		require.Equal(t, "license_number", ps.DescriptorMap[0].ID)
+		require.Equal(t, "passport_number", ps.DescriptorMap[1].ID)
+	})
+
+	t.Run("All matched", func(t *testing.T) {
+		unmet := []string{"stale"}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{passport, newCred("account"), license}, lddl,
+			WithPartialMatches(&unmet))
+		require.NoError(t, err)
+		require.Empty(t, unmet)
+		require.Len(t, vp.Credentials(), 3)
+	})
+
+	t.Run("Nothing matched", func(t *testing.T) {
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{newCred("email")}, lddl, WithPartialMatches(nil))
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("No effect with submission requirements", func(t *testing.T) {
+		withRequirements := &PresentationDefinition{
+			ID: uuid.New().String(),
+			SubmissionRequirements: []*SubmissionRequirement{{
+				Rule: Pick,
+				Min:  1,
+				From: "A",
+			}},
+			InputDescriptors: []*InputDescriptor{
+				newDescriptor("passport_number"), newDescriptor("account"),
+			},
+		}
+
+		for _, descriptor := range withRequirements.InputDescriptors {
+			descriptor.Group = []string{"A"}
+		}
+
+		var unmet []string
+
+		vp, err := withRequirements.CreateVPWithOptions([]*verifiable.Credential{passport}, lddl, WithPartialMatches(&unmet))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+		require.Nil(t, unmet)
+	})
+}
+
+func TestAddSubmissionToPresentation(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(field string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: map[string]interface{}{field: "123"}}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	newDescriptor := func(field string) *InputDescriptor {
+		return &InputDescriptor{
+			ID: field,
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject." + field}}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{newDescriptor("passport_number"), newDescriptor("account")},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		email, passport, bill := newCred("email"), newCred("passport_number"), newCred("account")
+
+		vp, err := verifiable.NewPresentation(verifiable.WithCredentials(email, passport, bill))
+		require.NoError(t, err)
+
+		vp.Holder = "did:example:a"
+
+		require.NoError(t, AddSubmissionToPresentation(vp, pd, lddl, WithSubmissionID("submission")))
+		require.NoError(t, AddSubmissionToPresentation(vp, pd, lddl, WithSubmissionID("submission")))
+
+		require.Equal(t, []string{verifiable.ContextURI, PresentationSubmissionJSONLDContextIRI}, vp.Context)
+		require.Equal(t, []string{verifiable.VPType, PresentationSubmissionJSONLDType}, vp.Type)
+		require.Equal(t, "did:example:a", vp.Holder)
+		require.Equal(t, []interface{}{email, passport, bill}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+
+		ps, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Equal(t, "submission", ps.ID)
+		require.Len(t, ps.DescriptorMap, 2)
+		require.Equal(t, "account", ps.DescriptorMap[0].ID)
+		require.Equal(t, "$.verifiableCredential[2]", ps.DescriptorMap[0].PathNested.Path)
+		require.Equal(t, "passport_number", ps.DescriptorMap[1].ID)
+		require.Equal(t, "$.verifiableCredential[1]", ps.DescriptorMap[1].PathNested.Path)
+	})
+
+	t.Run("Credentials do not satisfy the definition", func(t *testing.T) {
+		vp, err := verifiable.NewPresentation(verifiable.WithCredentials(newCred("passport_number")))
+		require.NoError(t, err)
+
+		err = AddSubmissionToPresentation(vp, pd, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+		require.Equal(t, []string{verifiable.VPType}, vp.Type)
+		require.Nil(t, vp.CustomFields)
+	})
+
+	t.Run("Credential is not parsed", func(t *testing.T) {
+		vp, err := verifiable.NewPresentation(verifiable.WithCredentials(newCred("passport_number")),
+			verifiable.WithJWTCredentials("header.payload.signature"))
+		require.NoError(t, err)
+
+		err = AddSubmissionToPresentation(vp, pd, lddl)
+		require.EqualError(t, err, "credential 1 of the presentation is not parsed")
+	})
+}
+
+func TestPresentationDefinition_CreateVP_TypeMatchOnly(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI, "https://example.com/custom/v1"},
+		Types:   []string{verifiable.VCType, "CustomCredential"},
+		ID:      uuid.New().String(),
+		Subject: "did:example:a",
+		Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+		Issued:  util.NewTime(time.Now()),
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID:     uuid.New().String(),
+			Schema: []*Schema{{URI: "https://example.com/custom/v1#CustomCredential"}},
+		}},
+	}
+
+	t.Run("Context is not resolved by default", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{vc}, lddl)
+
+		var loadErr *ContextLoadError
+		require.ErrorAs(t, err, &loadErr)
+		require.Equal(t, "https://example.com/custom/v1", loadErr.ContextURI)
+	})
+
+	t.Run("Lenient schema", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithLenientSchema())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		_, err = pd.CreateVPWithOptions([]*verifiable.Credential{vc}, nil, WithLenientSchema())
+		require.NoError(t, err)
+	})
+
+	t.Run("Type match only", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithTypeMatchOnly())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		checkSubmission(t, vp, pd)
+	})
+
+	for _, tc := range []struct {
+		name    string
+		schemas []*Schema
+		err     error
+	}{
+		{name: "Short name", schemas: []*Schema{{URI: "CustomCredential"}}},
+		{name: "IRI", schemas: []*Schema{{URI: "https://example.com/custom/v1/CustomCredential"}}},
+		{name: "Context ID", schemas: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}}},
+		{
+			name: "Required schema is not satisfied",
+			schemas: []*Schema{
+				{URI: "https://example.com/custom/v1#CustomCredential"},
+				{URI: "https://example.com/custom/v1#OtherCredential", Required: true},
+			},
+			err: ErrNoCredentials,
+		},
+		{name: "Other type", schemas: []*Schema{{URI: "https://example.com/custom/v1#Custom"}}, err: ErrNoCredentials},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			typePD := &PresentationDefinition{
+				ID:               uuid.New().String(),
+				InputDescriptors: []*InputDescriptor{{ID: uuid.New().String(), Schema: tc.schemas}},
+			}
+
+			_, err := typePD.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithTypeMatchOnly())
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.err.Error())
+			}
+		})
+	}
+}
+
+func TestPresentationDefinition_CreateVP_CredentialSchemaMatch(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	const schemaID = "https://example.com/schemas/person.json"
+
+	newVC := func(schemaID string, subject verifiable.CustomFields) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: subject}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+			Schemas: []verifiable.TypedID{{ID: schemaID, Type: "JsonSchema"}},
+		}
+	}
+
+	person := newVC(schemaID, verifiable.CustomFields{"given_name": "John"})
+	invalid := newVC(schemaID, verifiable.CustomFields{"given_name": 42})
+	other := newVC("https://example.com/schemas/other.json", verifiable.CustomFields{"given_name": "Jane"})
+	creds := []*verifiable.Credential{person, invalid, other}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID:     uuid.New().String(),
+			Schema: []*Schema{{URI: schemaID}},
+		}},
+	}
+
+	matchedIDs := func(t *testing.T, opts ...MatchRequirementsOpt) []string {
+		t.Helper()
+
+		matched, err := pd.MatchSubmissionRequirementWithOptions(creds, lddl, opts...)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	var loads int
+
+	loader := func(id string) ([]byte, error) {
+		loads++
+
+		if id != schemaID {
+			return nil, fmt.Errorf("unknown schema %s", id)
+		}
+
+		return []byte(`{
+			"type": "object",
+			"properties": {
+				"credentialSubject": {
+					"type": "object",
+					"properties": {"given_name": {"type": "string"}},
+					"required": ["given_name"]
+				}
+			},
+			"required": ["credentialSubject"]
+		}`), nil
+	}
+
+	t.Run("Types are matched by default", func(t *testing.T) {
+		require.Empty(t, matchedIDs(t))
+	})
+
+	t.Run("Credential schema IDs", func(t *testing.T) {
+		require.ElementsMatch(t, []string{person.ID, invalid.ID}, matchedIDs(t, WithCredentialSchemaMatch(nil)))
+	})
+
+	t.Run("Credentials are validated against the loaded schema", func(t *testing.T) {
+		require.Equal(t, []string{person.ID}, matchedIDs(t, WithCredentialSchemaMatch(loader)))
+		require.Equal(t, 1, loads)
+	})
+
+	t.Run("Schema which can not be loaded is not validated", func(t *testing.T) {
+		failing := func(string) ([]byte, error) {
+			return nil, errors.New("host is not allowed")
+		}
+
+		require.ElementsMatch(t, []string{person.ID, invalid.ID}, matchedIDs(t, WithCredentialSchemaMatch(failing)))
+	})
+
+	t.Run("External $refs are loaded with the loader", func(t *testing.T) {
+		var loaded []string
+
+		refLoader := func(id string) ([]byte, error) {
+			loaded = append(loaded, id)
+
+			switch id {
+			case schemaID:
+				return []byte(`{
+					"type": "object",
+					"properties": {"credentialSubject": {"$ref": "defs/subject.json"}},
+					"required": ["credentialSubject"]
+				}`), nil
+			case "https://example.com/schemas/defs/subject.json":
+				return []byte(`{
+					"type": "object",
+					"properties": {"given_name": {"$ref": "#/$defs/name"}},
+					"$defs": {"name": {"type": "string"}}
+				}`), nil
+			}
+
+			return nil, fmt.Errorf("unknown schema %s", id)
+		}
+
+		require.Equal(t, []string{person.ID}, matchedIDs(t, WithCredentialSchemaMatch(refLoader)))
+		require.Equal(t, []string{schemaID, "https://example.com/schemas/defs/subject.json"}, loaded)
+	})
+}
+
+func TestPresentationDefinition_ReferencedPaths(t *testing.T) {
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "degree",
+			Constraints: &Constraints{Fields: []*Field{
+				{Path: []string{"$.type", "$.vc.type"}},
+				{Path: []string{"$.credentialSubject.degree.type", "$.vc.credentialSubject.degree.type"}},
+			}},
+		}, {
+			ID: "no_constraints",
+		}, {
+			ID: "employee",
+			Constraints: &Constraints{Fields: []*Field{
+				{Path: []string{"$.vc.type", "$.type"}},
+				{Path: []string{"$.credentialSubject['name']"}},
+			}},
+		}},
+	}
+
+	require.Equal(t, []string{
+		"$.credentialSubject.degree.type",
+		"$.credentialSubject['name']",
+		"$.type",
+		"$.vc.credentialSubject.degree.type",
+		"$.vc.type",
+	}, pd.ReferencedPaths())
+
+	require.Empty(t, (&PresentationDefinition{}).ReferencedPaths())
+}
+
+func TestInputDescriptor_Frame(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	publicKey, privateKey, err := bbs12381g2pub.GenerateKeyPair(sha256.New, nil)
+	require.NoError(t, err)
+
+	srcPublicKey, err := publicKey.Marshal()
+	require.NoError(t, err)
+
+	signer, err := newBBSSigner(privateKey)
+	require.NoError(t, err)
+
+	bbsVC := &verifiable.Credential{
+		ID:      "https://issuer.oidp.uscis.gov/credentials/83627465",
+		Context: []string{verifiable.ContextURI, "https://w3id.org/security/bbs/v1"},
+		Types:   []string{verifiable.VCType},
+		Subject: "did:example:b34ca6cd37bbf23",
+		Issued:  util.NewTime(time.Now()),
+		Issuer:  verifiable.Issuer{ID: "did:example:489398593"},
+	}
+
+	require.NoError(t, bbsVC.AddLinkedDataProof(&verifiable.LinkedDataProofContext{
+		SignatureType:           "BbsBlsSignature2020",
+		SignatureRepresentation: verifiable.SignatureProofValue,
+		Suite:                   bbsblssignature2020.New(suite.WithSigner(signer)),
+		VerificationMethod:      "did:example:123456#key1",
+	}, jsonld.WithDocumentLoader(lddl)))
+
+	plainVC := getTestVC()
+
+	frame := map[string]interface{}{
+		"@context": []interface{}{verifiable.ContextURI, "https://w3id.org/security/bbs/v1"},
+		"type":     []interface{}{verifiable.VCType},
+	}
+
+	sdOpts := WithSDCredentialOptions(
+		verifiable.WithJSONLDDocumentLoader(lddl),
+		verifiable.WithPublicKeyFetcher(verifiable.SingleKey(srcPublicKey, "Bls12381G2Key2020")),
+	)
+
+	t.Run("Descriptor frame", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String(), Frame: frame}},
+		}
+
+		matched, rejections, err := pd.MatchSubmissionRequirementDetailed(
+			[]*verifiable.Credential{bbsVC, plainVC}, lddl, sdOpts)
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors[0].MatchedVCs, 1)
+
+		framed := matched[0].Descriptors[0].MatchedVCs[0]
+		require.Equal(t, bbsVC.ID, framed.ID)
+		require.Len(t, framed.Proofs, 1)
+		require.Equal(t, "BbsBlsSignatureProof2020", framed.Proofs[0]["type"])
+
+		require.Len(t, rejections, 1)
+		require.Equal(t, plainVC.ID, rejections[0].CredentialID)
+		require.Equal(t, FrameStage, rejections[0].Stage)
+	})
+
+	t.Run("Descriptor frame takes precedence", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Frame:            map[string]interface{}{"@type": "VerifiableCredential"},
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+		}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{bbsVC}, lddl, sdOpts)
+		require.Error(t, err)
+
+		pd.InputDescriptors[0].Frame = frame
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{bbsVC}, lddl, sdOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("Definition frame", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Frame:            frame,
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{bbsVC}, lddl, sdOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		_, err = pd.CreateVPWithOptions([]*verifiable.Credential{bbsVC, plainVC}, lddl, sdOpts)
+		require.Error(t, err)
+	})
+
+	t.Run("Audit and originals", func(t *testing.T) {
+		required := Required
+
+		constraints := &Constraints{
+			LimitDisclosure: &required,
+			Fields:          []*Field{{ID: "issuer", Path: []string{"$.issuer"}}},
+		}
+
+		for _, pd := range []*PresentationDefinition{{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String(), Frame: frame, Constraints: constraints}},
+		}, {
+			ID:               uuid.New().String(),
+			Frame:            frame,
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String(), Constraints: constraints}},
+		}} {
+
+			var originals []*OriginalCredential
+
+			vp, records, err := pd.CreateVPWithAudit([]*verifiable.Credential{bbsVC}, lddl, sdOpts,
+				WithDebugOriginals(&originals))
+			require.NoError(t, err)
+			require.Len(t, vp.Credentials(), 1)
+
+			// the fields are evaluated against the framed credentials, which the records are kept for.
+			require.Len(t, records, 1)
+			require.Len(t, records[0].SatisfiedFields, 1)
+			require.Equal(t, "issuer", records[0].SatisfiedFields[0].FieldID)
+			require.Equal(t, "did:example:489398593", records[0].SatisfiedFields[0].Value)
+
+			require.Len(t, originals, 1)
+			require.Equal(t, records[0].CredentialID, originals[0].CredentialID)
+		}
+	})
+
+	t.Run("Applicability only", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:    uuid.New().String(),
+			Frame: frame,
+			InputDescriptors: []*InputDescriptor{
+				{ID: uuid.New().String(), Frame: frame}, {ID: uuid.New().String()},
+			},
+		}
+
+		matched, rejections, err := pd.MatchSubmissionRequirementDetailed(
+			[]*verifiable.Credential{bbsVC, plainVC}, lddl, sdOpts, WithApplicabilityOnly())
+		require.NoError(t, err)
+		require.Empty(t, rejections)
+
+		for _, descriptor := range matched[0].Descriptors {
+			require.Equal(t, []*verifiable.Credential{bbsVC, plainVC}, descriptor.MatchedVCs)
+			require.Same(t, bbsVC, descriptor.MatchedVCs[0])
+		}
+	})
+}
+
+func TestPresentationDefinition_CreateVP_CopyCredentials(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields:          []*Field{{Path: []string{"$.credentialSubject.family_name"}}},
+			},
+		}, {
+			ID: "email",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.email"}}},
+			},
+		}},
+	}
+
+	newCreds := func() []*verifiable.Credential {
+		plainVC := getTestVC()
+		plainVC.ID = "http://example.edu/credentials/1873"
+		plainVC.CustomFields = verifiable.CustomFields{"extra": map[string]interface{}{"key": "value"}}
+		plainVC.Proofs = []verifiable.Proof{{"type": "Ed25519Signature2018", "jws": "..."}}
+		delete(plainVC.Subject.(map[string]interface{}), "family_name")
+
+		return []*verifiable.Credential{newSdJwtVC(t, getTestVC(), ed25519Signer), plainVC}
+	}
+
+	marshal := func(t *testing.T, v interface{}) string {
+		t.Helper()
+
+		src, err := json.Marshal(v)
+		require.NoError(t, err)
+
+		return string(src)
+	}
+
+	creds := newCreds()
+	snapshot := marshal(t, creds)
+
+	vp, err := pd.CreateVPWithOptions(creds, lddl, WithCopyCredentials(), WithSubmissionID("submission"),
+		WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)))
+	require.NoError(t, err)
+	require.Len(t, vp.Credentials(), 2)
+
+	for i, c := range vp.Credentials() {
+		vc, ok := c.(*verifiable.Credential)
+		require.True(t, ok)
+
+		for _, original := range creds {
+			require.NotSame(t, original, vc, i)
+		}
+	}
+
+	require.Equal(t, snapshot, marshal(t, creds))
+
+	mutated, ok := vp.Credentials()[1].(*verifiable.Credential)
+	require.True(t, ok)
+	require.Equal(t, creds[1].ID, mutated.ID)
+
+	mutated.CustomFields["extra"].(map[string]interface{})["key"] = "changed"
+	mutated.Proofs[0]["jws"] = "changed"
+	mutated.Subject.(map[string]interface{})["email"] = "changed"
+
+	require.Equal(t, snapshot, marshal(t, creds))
+
+	creds = newCreds()
+
+	copied, err := pd.CreateVPWithOptions(creds, lddl, WithCopyCredentials(), WithSubmissionID("submission"),
+		WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)))
+	require.NoError(t, err)
+
+	expected, err := pd.CreateVPWithOptions(creds, lddl, WithSubmissionID("submission"),
+		WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)))
+	require.NoError(t, err)
+
+	require.Equal(t, marshal(t, expected), marshal(t, copied))
+
+	sdJWTVC, ok := copied.Credentials()[0].(*verifiable.Credential)
+	require.True(t, ok)
+	require.Equal(t, creds[0].JWT, sdJWTVC.JWT)
+	require.Equal(t, creds[0].SDJWTDisclosures, sdJWTVC.SDJWTDisclosures)
+
+	snapshot = marshal(t, creds)
+
+	matched, err := pd.MatchSubmissionRequirementWithOptions(creds, lddl, WithCopyCredentials(),
+		WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)))
+	require.NoError(t, err)
+	require.Len(t, matched[0].Descriptors[1].MatchedVCs, 2)
+	require.NotSame(t, creds[1], matched[0].Descriptors[1].MatchedVCs[1])
+	require.Equal(t, snapshot, marshal(t, creds))
+}
+
+func TestPresentationDefinition_CreateVP_CredentialRanker(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	now := time.Now()
+
+	newCred := func(field string, issued time.Time) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: map[string]interface{}{field: "123"}}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(issued),
+		}
+	}
+
+	newDescriptor := func(field string) *InputDescriptor {
+		return &InputDescriptor{
+			ID:    field,
+			Group: []string{"A"},
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject." + field}}},
+			},
+		}
+	}
+
+	freshest := func(a, b *verifiable.Credential) int {
+		switch {
+		case a.Issued.After(b.Issued.Time):
+			return -1
+		case b.Issued.After(a.Issued.Time):
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	oldPassport := newCred("passport_number", now.Add(-2*time.Hour))
+	passport := newCred("passport_number", now.Add(-time.Hour))
+	license := newCred("license_number", now)
+
+	credentials := []*verifiable.Credential{oldPassport, passport, license}
+
+	t.Run("A credential per input descriptor", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{newDescriptor("passport_number")},
+		}
+
+		vp, err := pd.CreateVP(credentials, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+
+		vp, err = pd.CreateVPWithOptions(credentials, lddl, WithCredentialRanker(freshest))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{passport}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+	})
+
+	t.Run("Pick the best ranked input descriptors", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			SubmissionRequirements: []*SubmissionRequirement{{
+				Rule:  Pick,
+				Count: 1,
+				From:  "A",
+			}},
+			InputDescriptors: []*InputDescriptor{newDescriptor("passport_number"), newDescriptor("license_number")},
+		}
+
+		// without the ranker, two input descriptors are matched while the requirement picks one
+		vp, err := pd.CreateVP(credentials, lddl)
+		require.NoError(t, err)
+		require.Empty(t, vp.Credentials())
+
+		vp, err = pd.CreateVPWithOptions(credentials, lddl, WithCredentialRanker(freshest))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{license}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+	})
+
+	t.Run("Pick the best ranked nested requirements", func(t *testing.T) {
+		passportDescriptor, licenseDescriptor := newDescriptor("passport_number"), newDescriptor("license_number")
+		passportDescriptor.Group = []string{"B"}
+		licenseDescriptor.Group = []string{"C"}
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			SubmissionRequirements: []*SubmissionRequirement{{
+				Rule: Pick,
+				Min:  1,
+				FromNested: []*SubmissionRequirement{
+					{Rule: All, From: "B"},
+					{Rule: All, From: "C"},
+				},
+			}},
+			InputDescriptors: []*InputDescriptor{passportDescriptor, licenseDescriptor},
+		}
+
+		vp, err := pd.CreateVPWithOptions(credentials, lddl, WithCredentialRanker(func(a, b *verifiable.Credential) int {
+			return -freshest(a, b)
+		}))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{oldPassport}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+	})
+
+	t.Run("Requirements not met", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{newDescriptor("passport_number"), newDescriptor("account")},
+		}
+
+		_, err := pd.CreateVPWithOptions(credentials, lddl, WithCredentialRanker(freshest))
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+}
+
+func TestPresentationDefinition_CreateVP_Presentations(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(field string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: map[string]interface{}{field: "123"}}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	newDescriptor := func(field string) *InputDescriptor {
+		return &InputDescriptor{
+			ID: field,
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject." + field}}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{newDescriptor("passport_number"), newDescriptor("license_number")},
+	}
+
+	passport, license := newCred("passport_number"), newCred("license_number")
+
+	delegated, err := verifiable.NewPresentation(verifiable.WithCredentials(newCred("account"), passport))
+	require.NoError(t, err)
+
+	t.Run("Match credentials of the presentations", func(t *testing.T) {
+		matched, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{license}, lddl,
+			WithPresentations([]*verifiable.Presentation{delegated}))
+		require.NoError(t, err)
+		require.Len(t, matched, 1)
+		require.Len(t, matched[0].Descriptors, 2)
+		require.Equal(t, []*verifiable.Credential{passport}, matched[0].Descriptors[0].MatchedVCs)
+		require.Equal(t, []*verifiable.Credential{license}, matched[0].Descriptors[1].MatchedVCs)
+	})
+
+	t.Run("Present credentials within the presentations", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{license}, lddl,
+			WithPresentations([]*verifiable.Presentation{delegated}))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{license, delegated}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+		checkVP(t, vp)
+
+		ps, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Len(t, ps.DescriptorMap, 2)
+
+		require.Equal(t, &InputDescriptorMapping{
+			ID:     "passport_number",
+			Format: FormatLDPVP,
+			Path:   "$",
+			PathNested: &InputDescriptorMapping{
+				ID:     "passport_number",
+				Format: FormatLDPVP,
+				Path:   "$.verifiableCredential[1]",
+				PathNested: &InputDescriptorMapping{
+					ID:     "passport_number",
+					Format: FormatLDPVC,
+					Path:   "$.verifiableCredential[1]",
+				},
+			},
+		}, ps.DescriptorMap[1])
+
+		src, err := json.Marshal(vp)
+		require.NoError(t, err)
+
+		var selected interface{}
+		require.NoError(t, json.Unmarshal(src, &selected))
+
+		builder := gval.Full(jsonpath.PlaceholderExtension())
+
+		for mapping := ps.DescriptorMap[1]; mapping != nil; mapping = mapping.PathNested {
+			path, err := builder.NewEvaluable(mapping.Path)
+			require.NoError(t, err)
+
+			selected, err = path(context.TODO(), selected)
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, passport.ID, selected.(map[string]interface{})["id"])
+	})
+
+	t.Run("Credentials of the presentations are copied", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{license}, lddl,
+			WithPresentations([]*verifiable.Presentation{delegated}), WithCopyCredentials())
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+		require.Equal(t, delegated, vp.Credentials()[1])
+		require.NotSame(t, license, vp.Credentials()[0])
+	})
+
+	t.Run("Credentials of the presentations are not parsed", func(t *testing.T) {
+		unparsed, err := verifiable.NewPresentation()
+		require.NoError(t, err)
+
+		unparsed.AddCredentials(passport)
+
+		jwtVP, err := verifiable.NewPresentation(verifiable.WithJWTCredentials("eyJhbGciOiJub25lIn0.e30."))
+		require.NoError(t, err)
+
+		_, err = pd.CreateVPWithOptions([]*verifiable.Credential{license}, lddl,
+			WithPresentations([]*verifiable.Presentation{unparsed, jwtVP}))
+		require.EqualError(t, err, "credential 0 of presentation 1 is not parsed")
+	})
+}
+
+func TestFilter_Validate(t *testing.T) {
+	t.Run("Anchored pattern", func(t *testing.T) {
+		diagnostics, err := (&Filter{Type: &strFilterType, Pattern: "^1234$"}).Validate()
+		require.NoError(t, err)
+		require.Empty(t, diagnostics)
+	})
+
+	t.Run("Unanchored pattern", func(t *testing.T) {
+		diagnostics, err := (&Filter{Type: &strFilterType, Pattern: "1234"}).Validate()
+		require.NoError(t, err)
+		require.Len(t, diagnostics, 1)
+		require.Equal(t, UnanchoredPatternDiagnostic, diagnostics[0].Kind)
+		require.Equal(t, "pattern", diagnostics[0].Keyword)
+		require.Equal(t, "1234", diagnostics[0].Value)
+		require.NotEmpty(t, diagnostics[0].Message)
+	})
+
+	t.Run("Nested quantifiers in nested schemas", func(t *testing.T) {
+		diagnostics, err := (&Filter{
+			Type:     &arrFilterType,
+			Contains: map[string]interface{}{"type": "string", "pattern": "^(a+)+$"},
+			CustomFields: map[string]interface{}{
+				"items": map[string]interface{}{"pattern": "^[a-z]*$"},
+			},
+		}).Validate()
+		require.NoError(t, err)
+		require.Len(t, diagnostics, 1)
+		require.Equal(t, NestedQuantifiersDiagnostic, diagnostics[0].Kind)
+		require.Equal(t, "^(a+)+$", diagnostics[0].Value)
+	})
+
+	t.Run("Malformed pattern", func(t *testing.T) {
+		_, err := (&Filter{Type: &strFilterType, Pattern: "^(12$"}).Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `invalid pattern "^(12$"`)
+	})
+
+	t.Run("Strict patterns", func(t *testing.T) {
+		lddl := createTestJSONLDDocumentLoader(t)
+
+		vc := &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      "http://example.edu/credentials/1872",
+			Subject: []verifiable.Subject{{ID: "did:example:a"}},
+			Issued:  util.NewTime(time.Now()),
+		}
+
+		for _, tc := range []struct {
+			pattern string
+			opts    []MatchRequirementsOpt
+			err     string
+		}{
+			// an unanchored pattern is only a diagnostic
+			{pattern: "1872", opts: []MatchRequirementsOpt{WithStrictPatterns()}},
+			{pattern: "^(http:/+[a-z.]*)+/credentials"},
+			{
+				pattern: "^(http:/+[a-z.]*)+/credentials",
+				opts:    []MatchRequirementsOpt{WithStrictPatterns()},
+				err:     "input descriptor descriptor field 0: pattern",
+			},
+			{pattern: "(1872"},
+			{
+				pattern: "(1872",
+				opts:    []MatchRequirementsOpt{WithStrictPatterns()},
+				err:     "input descriptor descriptor field 0: invalid pattern",
+			},
+		} {
+			pd := &PresentationDefinition{
+				ID: uuid.New().String(),
+				InputDescriptors: []*InputDescriptor{{
+					ID:     "descriptor",
+					Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+					Constraints: &Constraints{
+						Fields: []*Field{{
+							Path:   []string{"$.id"},
+							Filter: &Filter{Type: &strFilterType, Pattern: tc.pattern},
+						}},
+					},
+				}},
+			}
+
+			_, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{vc}, lddl, tc.opts...)
+			if tc.err == "" {
+				require.NoError(t, err, tc.pattern)
+
+				continue
+			}
+
+			require.Error(t, err, tc.pattern)
+			require.Contains(t, err.Error(), tc.err)
+		}
+	})
+}
+
+func TestPresentationDefinition_CreateVP_DataModel2(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	vc := &verifiable.Credential{
+		Context: []string{"https://www.w3.org/ns/credentials/v2"},
+		Types:   []string{verifiable.VCType},
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: map[string]interface{}{"given_name": "John", "family_name": "Doe"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		CustomFields: map[string]interface{}{
+			"validFrom":  "2023-01-01T00:00:00Z",
+			"validUntil": "2033-01-01T00:00:00Z",
+		},
+	}
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields:          []*Field{{Path: []string{"$.credentialSubject.given_name"}}},
+			},
+		}},
+	}
+
+	// the default validation of the credentials is of VC Data Model 1.1
+	vp, err := pd.CreateVP([]*verifiable.Credential{vc}, lddl, verifiable.WithSchema(`{"type": "object"}`),
+		verifiable.WithBaseContextExtendedValidation(vc.Context, vc.Types))
+	require.NoError(t, err)
+	require.Len(t, vp.Credentials(), 1)
+
+	limited, ok := vp.Credentials()[0].(*verifiable.Credential)
+	require.True(t, ok)
+
+	src, err := json.Marshal(limited)
+	require.NoError(t, err)
+
+	var limitedAsMap map[string]interface{}
+	require.NoError(t, json.Unmarshal(src, &limitedAsMap))
+
+	require.Equal(t, "2023-01-01T00:00:00Z", limitedAsMap["validFrom"])
+	require.Equal(t, "2033-01-01T00:00:00Z", limitedAsMap["validUntil"])
+	require.NotContains(t, limitedAsMap, "issuanceDate")
+	require.NotContains(t, limitedAsMap, "id")
+	require.Equal(t, verifiable.VCType, limitedAsMap["type"])
+	require.Equal(t, map[string]interface{}{"id": "did:example:a", "given_name": "John"},
+		limitedAsMap["credentialSubject"])
+}
+
+func TestPresentationDefinition_CreateVP_LimitDisclosureArrays(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{
+			ID: "did:example:a",
+			CustomFields: map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"name": "a", "sku": "1"},
+					map[string]interface{}{"name": "b", "sku": "2"},
+					map[string]interface{}{"sku": "3"},
+					map[string]interface{}{
+						"name": "d",
+						"sku":  "4",
+						"tags": []interface{}{
+							[]interface{}{"x", "y"},
+							[]interface{}{"z"},
+						},
+					},
+				},
+			},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "items",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{
+					{Path: []string{"$.credentialSubject.items[*].name"}},
+					{Path: []string{"$.credentialSubject.items[2].sku"}},
+					{Path: []string{"$.credentialSubject.items[*].tags[*][0]"}},
+				},
+			},
+		}},
+	}
+
+	vp, err := pd.CreateVP([]*verifiable.Credential{vc}, lddl,
+		verifiable.WithJSONLDDocumentLoader(lddl))
+	require.NoError(t, err)
+	require.Len(t, vp.Credentials(), 1)
+
+	src, err := json.Marshal(vp.Credentials()[0])
+	require.NoError(t, err)
+
+	var limited map[string]interface{}
+	require.NoError(t, json.Unmarshal(src, &limited))
+
+	require.Equal(t, map[string]interface{}{
+		"id": "did:example:a",
+		"items": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
+			map[string]interface{}{"sku": "3"},
+			map[string]interface{}{
+				"name": "d",
+				"tags": []interface{}{
+					[]interface{}{"x"},
+					[]interface{}{"z"},
+				},
+			},
+		},
+	}, limited["credentialSubject"])
+}
+
+func TestPresentationDefinition_CreateVP_OnSkip(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(fields verifiable.CustomFields) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context:      []string{verifiable.ContextURI},
+			Types:        []string{verifiable.VCType},
+			ID:           uuid.New().String(),
+			Subject:      []verifiable.Subject{{ID: "did:example:holder"}},
+			Issued:       util.NewTime(time.Now()),
+			CustomFields: fields,
+		}
+	}
+
+	valid := newCred(verifiable.CustomFields{"name": "John"})
+	unmatched := newCred(verifiable.CustomFields{"last_name": "Doe"})
+	broken := newCred(verifiable.CustomFields{"name": "John", "callback": func() {}})
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.name"}}},
+			},
+		}},
+	}
+
+	creds := []*verifiable.Credential{valid, unmatched, broken}
+
+	var (
+		skipped []*verifiable.Credential
+		errs    []error
+	)
+
+	vp, err := pd.CreateVPWithOptions(creds, lddl, WithOnSkip(func(credential *verifiable.Credential, err error) {
+		skipped = append(skipped, credential)
+		errs = append(errs, err)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{valid}, vp.Credentials())
+
+	require.Equal(t, []*verifiable.Credential{broken}, skipped)
+	require.ErrorContains(t, errs[0], "marshal credential")
+
+	_, err = pd.CreateVP(creds, lddl)
+	require.NoError(t, err)
+}
+
+func TestField_Filters(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(fields verifiable.CustomFields) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context:      []string{verifiable.ContextURI},
+			Types:        []string{verifiable.VCType},
+			ID:           uuid.New().String(),
+			Subject:      []verifiable.Subject{{ID: "did:example:holder"}},
+			Issued:       util.NewTime(time.Now()),
+			CustomFields: fields,
+		}
+	}
+
+	uriRef := newCred(verifiable.CustomFields{"ref": "did:example:123", "count": "many"})
+	count := newCred(verifiable.CustomFields{"ref": "not a uri", "count": 5})
+	neither := newCred(verifiable.CustomFields{"ref": 5, "count": "5"})
+
+	paths := []string{"$.ref", "$.count"}
+
+	for _, tc := range []struct {
+		name  string
+		field *Field
+	}{
+		{
+			name: "Filter per path",
+			field: &Field{Path: paths, Filters: []*Filter{
+				{Type: &strFilterType, Format: FilterFormatURI},
+				{Type: &intFilterType},
+			}},
+		},
+		{
+			name: "Paths without filters fall back to the filter",
+			field: &Field{
+				Path:    paths,
+				Filter:  &Filter{Type: &intFilterType},
+				Filters: []*Filter{{Type: &strFilterType, Format: FilterFormatURI}},
+			},
+		},
+		{
+			name: "Extra filters are ignored",
+			field: &Field{
+				Path:    paths,
+				Filters: []*Filter{{Type: &strFilterType}, {Type: &intFilterType}, {Type: &arrFilterType}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pd := &PresentationDefinition{
+				ID: uuid.New().String(),
+				InputDescriptors: []*InputDescriptor{{
+					ID:          uuid.New().String(),
+					Schema:      []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+					Constraints: &Constraints{Fields: []*Field{tc.field}},
+				}},
+			}
+
+			result, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{uriRef, count, neither}, lddl)
+			require.NoError(t, err)
+			require.Equal(t, []*verifiable.Credential{uriRef, count}, result[0].Descriptors[0].MatchedVCs)
+		})
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		src, err := json.Marshal(&Field{Path: paths, Filters: []*Filter{
+			{Type: &strFilterType, Format: FilterFormatURI},
+			{Type: &intFilterType},
+		}})
+		require.NoError(t, err)
+		require.Contains(t, string(src), `"filters":[{"format":"uri","type":"string"},{"type":"integer"}]`)
+	})
+
+	t.Run("Strict patterns", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:     uuid.New().String(),
+				Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+				Constraints: &Constraints{Fields: []*Field{{
+					Path:    paths,
+					Filters: []*Filter{{Type: &intFilterType}, {Type: &strFilterType, Pattern: "^(a+)+$"}},
+				}}},
+			}},
+		}
+
+		_, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{uriRef}, lddl, WithStrictPatterns())
+		require.ErrorContains(t, err, "nests unbounded quantifiers")
+	})
+}
+
+func TestPresentationDefinition_Localized(t *testing.T) {
+	src := []byte(`{
+		"id": "32f54163-7166-48f1-93d8-ff217bdb0653",
+		"name": "Age verification",
+		"purpose": "Prove your age",
+		"localized_name": {"fr": "Vérification de l'âge", "fr_CA": "Vérification de l'âge (Canada)"},
+		"localized_purpose": {"DE": "Weisen Sie Ihr Alter nach"},
+		"input_descriptors": [{
+			"id": "age",
+			"name": "Birth date",
+			"localized_name": {"es": "Fecha de nacimiento"},
+			"constraints": {"fields": [{"path": ["$.credentialSubject.birthdate"]}]}
+		}]
+	}`)
+
+	var pd PresentationDefinition
+
+	require.NoError(t, json.Unmarshal(src, &pd))
+	require.NoError(t, pd.ValidateSchema(WithPESpecVersion(V2)))
+
+	v1 := pd
+	v1.InputDescriptors = []*InputDescriptor{{ID: "age", Schema: []*Schema{{URI: verifiable.ContextURI}}}}
+	v1.InputDescriptors[0].LocalizedPurposes = map[string]string{"es": "Demuestre su edad"}
+	require.NoError(t, v1.ValidateSchema(WithPESpecVersion(V1)))
+
+	require.Equal(t, "Vérification de l'âge (Canada)", pd.LocalizedName("fr-CA"))
+	require.Equal(t, "Vérification de l'âge", pd.LocalizedName("fr-BE"))
+	require.Equal(t, "Vérification de l'âge", pd.LocalizedName("FR"))
+	require.Equal(t, "Age verification", pd.LocalizedName("de"))
+	require.Equal(t, "Age verification", pd.LocalizedName(""))
+
+	require.Equal(t, "Weisen Sie Ihr Alter nach", pd.LocalizedPurpose("de-AT"))
+	require.Equal(t, "Prove your age", pd.LocalizedPurpose("fr"))
+
+	descriptor := pd.InputDescriptors[0]
+	require.Equal(t, "Fecha de nacimiento", descriptor.LocalizedName("es-MX"))
+	require.Equal(t, "Birth date", descriptor.LocalizedName("fr"))
+	require.Empty(t, descriptor.LocalizedPurpose("es"))
+}
+
+func TestPresentationDefinition_CreateVP_MultipleProofs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(proofTypes ...string) *verifiable.Credential {
+		vc := &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:holder"}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+
+		for _, proofType := range proofTypes {
+			vc.Proofs = append(vc.Proofs, verifiable.Proof{"type": proofType})
+		}
+
+		return vc
+	}
+
+	dual := newCred("BbsBlsSignature2020", "Ed25519Signature2018")
+	ed25519 := newCred("Ed25519Signature2018")
+	bbs := newCred("BbsBlsSignature2020")
+	unsupported := newCred("JsonWebSignature2020")
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		Format: &Format{
+			Ldp:   &LdpType{ProofType: []string{"BbsBlsSignature2020"}},
+			LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+		},
+		InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+	}
+
+	submission := func(t *testing.T, vp *verifiable.Presentation) *PresentationSubmission {
+		t.Helper()
+
+		ps, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+
+		return ps
+	}
+
+	t.Run("Credentials fitting any format are kept", func(t *testing.T) {
+		vp, err := pd.CreateVP([]*verifiable.Credential{dual, ed25519, bbs, unsupported}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{dual, ed25519, bbs}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Format fitted by most credentials is preferred", func(t *testing.T) {
+		vp, err := pd.CreateVP([]*verifiable.Credential{dual, ed25519}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{dual, ed25519}, vp.Credentials())
+
+		for _, mapping := range submission(t, vp).DescriptorMap {
+			require.Equal(t, FormatLDPVC, mapping.Format)
+		}
+
+		vp, err = pd.CreateVP([]*verifiable.Credential{dual, bbs}, lddl)
+		require.NoError(t, err)
+
+		for _, mapping := range submission(t, vp).DescriptorMap {
+			require.Equal(t, FormatLDP, mapping.Format)
+		}
+	})
+
+	t.Run("Dual proof credential fits either format", func(t *testing.T) {
+		for _, format := range []*Format{
+			{Ldp: &LdpType{ProofType: []string{"BbsBlsSignature2020"}}},
+			{LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}}},
+		} {
+			single := &PresentationDefinition{
+				ID:               uuid.New().String(),
+				Format:           format,
+				InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+			}
+
+			matched, err := single.MatchSubmissionRequirement([]*verifiable.Credential{dual, unsupported}, lddl)
+			require.NoError(t, err)
+			require.Equal(t, []*verifiable.Credential{dual}, matched[0].Descriptors[0].MatchedVCs)
+		}
+	})
+}
+
+func TestPresentationDefinition_CreateVP_Cryptosuite(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(proof verifiable.Proof) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:holder"}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+			Proofs:  []verifiable.Proof{proof},
+		}
+	}
+
+	ed25519 := newCred(verifiable.Proof{"type": "Ed25519Signature2018"})
+	eddsa := newCred(verifiable.Proof{"type": "DataIntegrityProof", "cryptosuite": "eddsa-rdfc-2022"})
+	ecdsa := newCred(verifiable.Proof{"type": "DataIntegrityProof", "cryptosuite": "ecdsa-rdfc-2019"})
+	credentials := []*verifiable.Credential{ed25519, eddsa, ecdsa}
+
+	for _, tc := range []struct {
+		name     string
+		ldp      *LdpType
+		expected []interface{}
+	}{
+		{
+			name:     "Legacy proof type",
+			ldp:      &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+			expected: []interface{}{ed25519},
+		},
+		{
+			name:     "Data Integrity proof type",
+			ldp:      &LdpType{ProofType: []string{"DataIntegrityProof"}},
+			expected: []interface{}{eddsa, ecdsa},
+		},
+		{
+			name:     "Cryptosuite as the proof type",
+			ldp:      &LdpType{ProofType: []string{"Ed25519Signature2018", "eddsa-rdfc-2022"}},
+			expected: []interface{}{ed25519, eddsa},
+		},
+		{
+			name:     "Cryptosuite",
+			ldp:      &LdpType{Cryptosuite: []string{"ecdsa-rdfc-2019"}},
+			expected: []interface{}{ecdsa},
+		},
+		{
+			name: "Data Integrity proof type restricted to a cryptosuite",
+			ldp: &LdpType{
+				ProofType:   []string{"Ed25519Signature2018", "DataIntegrityProof"},
+				Cryptosuite: []string{"eddsa-rdfc-2022"},
+			},
+			expected: []interface{}{ed25519, eddsa},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pd := &PresentationDefinition{
+				ID:               uuid.New().String(),
+				Format:           &Format{LdpVC: tc.ldp},
+				InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+			}
+
+			require.NoError(t, pd.ValidateSchema())
+
+			vp, err := pd.CreateVP(credentials, lddl)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, vp.Credentials())
+
+			// the v1 schema takes the cryptosuite in place of the proof type as well.
+			pd.InputDescriptors[0].Schema = []*Schema{{URI: verifiable.ContextURI}}
+
+			version, err := pd.ValidateSchemaVersion()
+			require.NoError(t, err)
+			require.Equal(t, V1, version)
+		})
+	}
+
+	t.Run("v1 schema requires the proof type or the cryptosuite", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:     uuid.New().String(),
+			Format: &Format{LdpVC: &LdpType{}},
+			InputDescriptors: []*InputDescriptor{{
+				ID:     uuid.New().String(),
+				Schema: []*Schema{{URI: verifiable.ContextURI}},
+			}},
+		}
+
+		err := pd.ValidateSchema()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "v1: presentation_definition.format.ldp_vc: Must validate at least one schema")
+	})
+}
+
+func TestPresentationDefinition_CreateVP_ExternalProofs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	ldpVC := getTestVC()
+	ldpVC.Schemas = nil
+
+	jwtVC := getTestVC()
+	jwtVC.Schemas = nil
+	jwtVC.ID = "http://example.edu/credentials/1873"
+	jwtVC.JWT = createEdDSAJWS(t, jwtVC, ed25519Signer, "76e12ec712ebc6f1c221ebfeb1f", true)
+
+	sdJWTVC := newSdJwtVC(t, getTestVC(), ed25519Signer)
+
+	require.Empty(t, ldpVC.Proofs)
+	require.Empty(t, jwtVC.Proofs)
+	require.Empty(t, sdJWTVC.Proofs)
+
+	ldp := &LdpType{ProofType: []string{"Ed25519Signature2018"}}
+	jwt := &JwtType{Alg: []string{"EdDSA"}}
+
+	for _, tc := range []struct {
+		name     string
+		format   *Format
+		external []*verifiable.Credential
+		inline   []*verifiable.Credential
+	}{
+		{
+			name:     "ldp",
+			format:   &Format{Ldp: ldp},
+			external: []*verifiable.Credential{ldpVC},
+		},
+		{
+			name:     "ldp_vc",
+			format:   &Format{LdpVC: ldp},
+			external: []*verifiable.Credential{ldpVC},
+		},
+		{
+			name:     "ldp_vp",
+			format:   &Format{LdpVP: ldp},
+			external: []*verifiable.Credential{ldpVC},
+		},
+		{
+			name:     "jwt_vc",
+			format:   &Format{JwtVC: jwt},
+			external: []*verifiable.Credential{jwtVC, sdJWTVC},
+			inline:   []*verifiable.Credential{jwtVC, sdJWTVC},
+		},
+		{
+			name:     "vc+sd-jwt",
+			format:   &Format{SdJwt: &SdJwtType{}},
+			external: []*verifiable.Credential{sdJWTVC},
+			inline:   []*verifiable.Credential{sdJWTVC},
+		},
+		{
+			name:     "dc+sd-jwt",
+			format:   &Format{DcSdJwt: &SdJwtType{}},
+			external: []*verifiable.Credential{sdJWTVC},
+			inline:   []*verifiable.Credential{sdJWTVC},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pd := &PresentationDefinition{
+				ID:               uuid.New().String(),
+				Format:           tc.format,
+				InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+			}
+
+			for _, credential := range []*verifiable.Credential{ldpVC, jwtVC, sdJWTVC} {
+				vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{credential}, lddl, WithExternalProofs())
+				if credentialIn(credential, tc.external) {
+					require.NoError(t, err)
+					require.Len(t, vp.Credentials(), 1)
+				} else {
+					require.ErrorIs(t, err, ErrNoCredentials)
+				}
+
+				vp, err = pd.CreateVP([]*verifiable.Credential{credential}, lddl)
+				if credentialIn(credential, tc.inline) {
+					require.NoError(t, err)
+					require.Len(t, vp.Credentials(), 1)
+				} else {
+					require.ErrorIs(t, err, ErrNoCredentials)
+				}
+			}
+		})
+	}
+}
+
+func credentialIn(credential *verifiable.Credential, credentials []*verifiable.Credential) bool {
+	for _, c := range credentials {
+		if c == credential {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestPresentationDefinition_CreateVP_UnparsableJWT(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	vc := getTestVC()
+	vc.Schemas = nil
+	vc.JWT = "not a JWT"
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		Format:           &Format{JwtVC: &JwtType{Alg: []string{"EdDSA"}}},
+		InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+	}
+
+	t.Run("Logged", func(t *testing.T) {
+		logger := &mocklogger.MockLogger{}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithLogger(logger))
+		require.ErrorIs(t, err, ErrNoCredentials)
+		require.Contains(t, logger.WarnLogContents, vc.ID)
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		logger := &mocklogger.MockLogger{}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithLogger(logger), WithStrictJWTParsing())
+		require.ErrorIs(t, err, ErrUnparsableJWT)
+		require.Contains(t, err.Error(), vc.ID)
+		require.Empty(t, logger.WarnLogContents)
+	})
+
+	t.Run("Strict expiration", func(t *testing.T) {
+		_, err := (&PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+		}).CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, WithRejectExpired(), WithStrictJWTParsing())
+		require.ErrorIs(t, err, ErrUnparsableJWT)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_PreferredFormats(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	ldpVC := getTestVC()
+	ldpVC.Proofs = []verifiable.Proof{{"type": "Ed25519Signature2018"}}
+
+	jwtVC := getTestVC()
+	jwtVC.ID = "http://example.edu/credentials/1873"
+	jwtVC.JWT = createEdDSAJWS(t, jwtVC, ed25519Signer, "76e12ec712ebc6f1c221ebfeb1f", true)
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		Format: &Format{
+			LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+			JwtVC: &JwtType{Alg: []string{"EdDSA"}},
+		},
+		InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+	}
+
+	formats := func(t *testing.T, vp *verifiable.Presentation) []string {
+		t.Helper()
+
+		ps, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+
+		var result []string
+		for _, mapping := range ps.DescriptorMap {
+			result = append(result, mapping.Format)
+		}
+
+		return result
+	}
+
+	t.Run("Default order", func(t *testing.T) {
+		vp, err := pd.CreateVP([]*verifiable.Credential{jwtVC, ldpVC}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{ldpVC}, vp.Credentials())
+		require.Equal(t, []string{FormatLDPVC}, formats(t, vp))
+	})
+
+	t.Run("Preferred format", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{jwtVC, ldpVC}, lddl,
+			WithPreferredFormats([]string{FormatJWTVC, FormatLDPVC}))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{jwtVC}, vp.Credentials())
+		require.Equal(t, []string{FormatJWTVC}, formats(t, vp))
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Preferred format without credentials", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{ldpVC}, lddl,
+			WithPreferredFormats([]string{FormatJWTVC, FormatLDPVC}))
+		require.NoError(t, err)
+		require.Equal(t, []string{FormatLDPVC}, formats(t, vp))
+	})
+
+	t.Run("Preferred format of the same family", func(t *testing.T) {
+		family := &PresentationDefinition{
+			ID: uuid.New().String(),
+			Format: &Format{
+				Ldp:   &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+				LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+			},
+			InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+		}
+
+		vp, err := family.CreateVP([]*verifiable.Credential{ldpVC}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []string{FormatLDP}, formats(t, vp))
+
+		vp, err = family.CreateVPWithOptions([]*verifiable.Credential{ldpVC}, lddl,
+			WithPreferredFormats([]string{FormatLDPVC}))
+		require.NoError(t, err)
+		require.Equal(t, []string{FormatLDPVC}, formats(t, vp))
+	})
+}
+
+func TestPresentationDefinition_CreateVP_RejectExpired(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	now := time.Now()
+	past := util.NewTime(now.Add(-time.Hour))
+
+	newVC := func(id string) *verifiable.Credential {
+		vc := getTestVC()
+		vc.ID = id
+
+		return vc
+	}
+
+	valid := newVC("http://example.edu/credentials/valid")
+	valid.Expired = util.NewTime(now.Add(time.Hour))
+
+	noExpiration := newVC("http://example.edu/credentials/no-expiration")
+
+	expired := newVC("http://example.edu/credentials/expired")
+	expired.Expired = past
+
+	validUntil := newVC("http://example.edu/credentials/valid-until")
+	validUntil.CustomFields = verifiable.CustomFields{"validUntil": past.Format(time.RFC3339)}
+
+	expiredJWT := newVC("http://example.edu/credentials/expired-jwt")
+	expiredJWT.Expired = past
+	expiredJWT.JWT = createEdDSAJWS(t, expiredJWT, ed25519Signer, "76e12ec712ebc6f1c221ebfeb1f", true)
+	// the expiration is only in the JWT exp claim.
+	expiredJWT.Expired = nil
+
+	credentials := []*verifiable.Credential{valid, noExpiration, expired, validUntil, expiredJWT}
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{ID: uuid.New().String()}},
+	}
+
+	t.Run("Expired credentials are rejected", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions(credentials, lddl, WithRejectExpired())
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{valid, noExpiration}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Rejections", func(t *testing.T) {
+		_, rejections, err := pd.MatchSubmissionRequirementDetailed(credentials, lddl, WithRejectExpired())
+		require.NoError(t, err)
+		require.Len(t, rejections, 3)
+
+		for i, credential := range []*verifiable.Credential{expired, validUntil, expiredJWT} {
+			require.Equal(t, credential.ID, rejections[i].CredentialID)
+			require.Equal(t, pd.InputDescriptors[0].ID, rejections[i].DescriptorID)
+			require.Equal(t, ExpirationStage, rejections[i].Stage)
+		}
+
+		require.Contains(t, rejections[0].Reason.Error(), "credential expired at")
+		require.Contains(t, rejections[1].Reason.Error(), "credential is valid until")
+		require.Contains(t, rejections[2].Reason.Error(), "credential JWT expired at")
+	})
+
+	t.Run("Invalid validUntil", func(t *testing.T) {
+		invalid := newVC("http://example.edu/credentials/invalid")
+		invalid.CustomFields = verifiable.CustomFields{"validUntil": "tomorrow"}
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{invalid}, lddl, WithRejectExpired())
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Expired credentials are kept by default", func(t *testing.T) {
+		vp, err := pd.CreateVP(credentials, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), len(credentials))
+	})
+
+	t.Run("Clock", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions(credentials, lddl, WithRejectExpired(), WithClock(func() time.Time {
+			return now
+		}))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{valid, noExpiration}, vp.Credentials())
+
+		vp, err = pd.CreateVPWithOptions(credentials, lddl, WithRejectExpired(), WithClock(func() time.Time {
+			return now.Add(-2 * time.Hour)
+		}))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), len(credentials))
+	})
+}
+
+func TestPresentationDefinition_CreateVP_RequireValidProofs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	issuerSigner, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	otherSigner, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	newVC := func(id string) *verifiable.Credential {
+		vc := getTestVC()
+		vc.ID = id
+		vc.Schemas = nil
+
+		return vc
+	}
+
+	valid := newSdJwtVC(t, newVC("http://example.edu/credentials/valid"), issuerSigner)
+	forged := newSdJwtVC(t, newVC("http://example.edu/credentials/forged"), otherSigner)
+	noProof := newVC("http://example.edu/credentials/no-proof")
+
+	credentials := []*verifiable.Credential{valid, forged, noProof}
+
+	var fetched int
+
+	fetcher := func(issuerID, keyID string) (*verifier.PublicKey, error) {
+		fetched++
+
+		return holderPublicKeyFetcher(issuerSigner.PublicKeyBytes())(issuerID, keyID)
+	}
+
+	pd := &PresentationDefinition{
+		ID:               uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{ID: "first"}, {ID: "second"}},
+	}
+
+	t.Run("Credentials with invalid proofs are rejected", func(t *testing.T) {
+		fetched = 0
+
+		_, rejections, err := pd.MatchSubmissionRequirementDetailed(credentials, lddl,
+			WithRequireValidProofs(verifiable.WithPublicKeyFetcher(fetcher)))
+		require.NoError(t, err)
+		require.Len(t, rejections, 4)
+
+		for i, credential := range []*verifiable.Credential{forged, noProof, forged, noProof} {
+			require.Equal(t, credential.ID, rejections[i].CredentialID)
+			require.Equal(t, ProofStage, rejections[i].Stage)
+		}
+
+		require.Contains(t, rejections[0].Reason.Error(), "verify credential proof")
+		require.EqualError(t, rejections[1].Reason, "credential has no proof")
+
+		// the proofs are verified once per match.
+		require.Equal(t, 2, fetched)
+
+		vp, err := pd.CreateVPWithOptions(credentials, lddl, WithRequireValidProofs(verifiable.WithPublicKeyFetcher(fetcher)))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{valid}, vp.Credentials())
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("No public key fetcher", func(t *testing.T) {
+		_, err := pd.CreateVPWithOptions(credentials, lddl, WithRequireValidProofs())
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Proofs are not verified by default", func(t *testing.T) {
+		vp, err := pd.CreateVP(credentials, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), len(credentials))
+	})
+}
+
+func TestPresentationDefinition_MatchSubmissionRequirement_NearMisses(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(name string, fields map[string]interface{}) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context:      []string{verifiable.ContextURI},
+			Types:        []string{verifiable.VCType},
+			ID:           "urn:credential:" + name,
+			CustomFields: fields,
+		}
+	}
+
+	adult := newVC("adult", map[string]interface{}{"age": 21, "country": "US"})
+	minor := newVC("minor", map[string]interface{}{"age": 17, "country": "US"})
+	foreignMinor := newVC("foreign-minor", map[string]interface{}{"age": 16, "country": "FR"})
+	noAge := newVC("no-age", map[string]interface{}{"country": "US"})
+
+	credentials := []*verifiable.Credential{adult, minor, foreignMinor, noAge}
+
+	ageFilter := &Filter{Type: &intFilterType, Minimum: 18}
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "adult",
+			Constraints: &Constraints{
+				Fields: []*Field{
+					{ID: "age", Path: []string{"$.age"}, Filter: ageFilter},
+					{ID: "country", Path: []string{"$.country"}, Filter: &Filter{Const: "US"}},
+				},
+			},
+		}},
+	}
+
+	t.Run("One failed field", func(t *testing.T) {
+		var nearMisses []*NearMiss
+
+		matched, rejections, err := pd.MatchSubmissionRequirementDetailed(credentials, lddl,
+			WithNearMisses(1, &nearMisses))
+		require.NoError(t, err)
+		require.Equal(t, []*verifiable.Credential{adult}, matched[0].Descriptors[0].MatchedVCs)
+
+		// the credentials are still rejected by their first failed field.
+		require.Len(t, rejections, 3)
+		require.Equal(t, foreignMinor.ID, rejections[1].CredentialID)
+		require.Equal(t, 0, rejections[1].FieldIndex)
+
+		require.Len(t, nearMisses, 2)
+
+		require.Equal(t, minor.ID, nearMisses[0].CredentialID)
+		require.Equal(t, "adult", nearMisses[0].DescriptorID)
+		require.Len(t, nearMisses[0].FailedFields, 1)
+
+		failure := nearMisses[0].FailedFields[0]
+		require.Equal(t, 0, failure.FieldIndex)
+		require.Equal(t, "age", failure.FieldID)
+		require.Equal(t, "$.age", failure.Path)
+		require.EqualValues(t, 17, failure.Value)
+		require.Same(t, ageFilter, failure.Filter)
+		require.ErrorContains(t, failure.Reason, "greater than or equal to 18")
+
+		require.Equal(t, noAge.ID, nearMisses[1].CredentialID)
+		require.Len(t, nearMisses[1].FailedFields, 1)
+		require.Empty(t, nearMisses[1].FailedFields[0].Path)
+		require.Nil(t, nearMisses[1].FailedFields[0].Value)
+		require.Nil(t, nearMisses[1].FailedFields[0].Filter)
+		require.Error(t, nearMisses[1].FailedFields[0].Reason)
+	})
+
+	t.Run("Two failed fields", func(t *testing.T) {
+		var nearMisses []*NearMiss
+
+		_, err := pd.MatchSubmissionRequirementWithOptions(credentials, lddl, WithNearMisses(2, &nearMisses))
+		require.NoError(t, err)
+		require.Len(t, nearMisses, 3)
+
+		require.Equal(t, foreignMinor.ID, nearMisses[1].CredentialID)
+		require.Len(t, nearMisses[1].FailedFields, 2)
+		require.Equal(t, "age", nearMisses[1].FailedFields[0].FieldID)
+		require.Equal(t, "country", nearMisses[1].FailedFields[1].FieldID)
+		require.Equal(t, "FR", nearMisses[1].FailedFields[1].Value)
+	})
+
+	t.Run("Error of a remaining field", func(t *testing.T) {
+		invalidDate := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:     "adult",
+				Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+				Constraints: &Constraints{
+					Fields: []*Field{
+						pd.InputDescriptors[0].Constraints.Fields[0],
+						{ID: "since", Path: []string{"$.since"}, Filter: &Filter{
+							Type: &strFilterType, Format: "date", Minimum: "invalid",
+						}},
+					},
+				},
+			}},
+		}
+
+		minorSince := newVC("minor-since", map[string]interface{}{"age": 17, "since": "2020-01-01"})
+
+		// the minor is rejected by its age, whatever the error of the invalid date bound.
+		_, rejections, err := invalidDate.MatchSubmissionRequirementDetailed([]*verifiable.Credential{minorSince}, lddl)
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+
+		var nearMisses []*NearMiss
+
+		_, err = invalidDate.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{minorSince}, lddl,
+			WithNearMisses(2, &nearMisses))
+		require.NoError(t, err)
+		require.Len(t, nearMisses, 1)
+		require.Len(t, nearMisses[0].FailedFields, 2)
+		require.ErrorContains(t, nearMisses[0].FailedFields[1].Reason, "filter minimum")
+	})
+
+	t.Run("Not collected by default", func(t *testing.T) {
+		var nearMisses []*NearMiss
+
+		vp, err := pd.CreateVPWithOptions(credentials, lddl, WithNearMisses(0, &nearMisses))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{adult}, vp.Credentials())
+		require.Empty(t, nearMisses)
+	})
+}
+
+func TestPresentationDefinition_MatchSubmissionRequirementStream(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(givenName string) *verifiable.Credential {
+		vc := getTestVC()
+		vc.ID = "http://example.edu/credentials/" + givenName
+		vc.Subject = []verifiable.Subject{{
+			ID:           "did:example:holder",
+			CustomFields: verifiable.CustomFields{"given_name": givenName},
+		}}
+
+		return vc
+	}
+
+	newDescriptor := func(givenName string) *InputDescriptor {
+		return &InputDescriptor{
+			ID:    givenName,
+			Group: []string{"A"},
+			Constraints: &Constraints{
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject[0].given_name", "$.credentialSubject.given_name"},
+					Filter: &Filter{Type: &strFilterType, Const: givenName},
+				}},
+			},
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID:                     uuid.New().String(),
+		SubmissionRequirements: []*SubmissionRequirement{{Rule: Pick, Count: 1, From: "A"}},
+		InputDescriptors:       []*InputDescriptor{newDescriptor("John"), newDescriptor("Jane")},
+	}
+
+	john, jane, jack := newVC("John"), newVC("Jane"), newVC("Jack")
+
+	stream := func(credentials ...*verifiable.Credential) <-chan *verifiable.Credential {
+		ch := make(chan *verifiable.Credential, len(credentials))
+		for _, credential := range credentials {
+			ch <- credential
+		}
+
+		close(ch)
+
+		return ch
+	}
+
+	t.Run("All credentials", func(t *testing.T) {
+		var updates []*MatchUpdate
+
+		matched, err := pd.MatchSubmissionRequirementStream(stream(jack, john, jane), lddl,
+			func(update *MatchUpdate) bool {
+				updates = append(updates, update)
+
+				return true
+			})
+		require.NoError(t, err)
+
+		require.Len(t, updates, 2)
+		require.Equal(t, "John", updates[0].Descriptor.ID)
+		require.Equal(t, []*verifiable.Credential{john}, updates[0].Descriptor.MatchedVCs)
+		require.True(t, updates[0].Satisfied)
+		require.Equal(t, "Jane", updates[1].Descriptor.ID)
+
+		expected, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{jack, john, jane}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, expected, matched)
+	})
+
+	t.Run("Stop once satisfied", func(t *testing.T) {
+		credentials := make(chan *verifiable.Credential, 3)
+		credentials <- john
+		credentials <- jane
+
+		matched, err := pd.MatchSubmissionRequirementStream(credentials, lddl, func(update *MatchUpdate) bool {
+			return !update.Satisfied
+		})
+		require.NoError(t, err)
+		require.Len(t, credentials, 1)
+
+		require.Len(t, matched, 1)
+		require.Equal(t, 1, matched[0].SelectFrom)
+		require.Equal(t, []*verifiable.Credential{john}, matched[0].Descriptors[0].MatchedVCs)
+		require.Empty(t, matched[0].Descriptors[1].MatchedVCs)
+	})
+
+	t.Run("Not satisfied", func(t *testing.T) {
+		matched, err := pd.MatchSubmissionRequirementStream(stream(jack), lddl, func(*MatchUpdate) bool {
+			require.Fail(t, "unexpected match")
+
+			return true
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0, matched[0].SelectFrom)
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := pd.MatchSubmissionRequirementStream(make(chan *verifiable.Credential), lddl,
+			func(*MatchUpdate) bool { return true }, WithContext(ctx))
+
+		var timeoutErr *MatchTimeoutError
+		require.ErrorAs(t, err, &timeoutErr)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Checks schema", func(t *testing.T) {
+		_, err := (&PresentationDefinition{}).MatchSubmissionRequirementStream(stream(), lddl,
+			func(*MatchUpdate) bool { return true })
+		require.Error(t, err)
+	})
+}
+
+func TestField_Optional(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(subject map[string]interface{}) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:a", CustomFields: subject}},
+			Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	withNickname := newVC(map[string]interface{}{"name": "John", "nickname": "Johnny", "email": "john@example.com"})
+	withoutNickname := newVC(map[string]interface{}{"name": "Jane", "email": "jane@example.com"})
+	invalidNickname := newVC(map[string]interface{}{"name": "Jack", "nickname": 42, "email": "jack@example.com"})
+	withoutName := newVC(map[string]interface{}{"nickname": "Jim"})
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "person",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{
+					{Path: []string{"$.credentialSubject.name"}},
+					{
+						Path:     []string{"$.credentialSubject.nickname"},
+						Filter:   &Filter{Type: &strFilterType},
+						Optional: true,
+					},
+				},
+			},
+		}},
+	}
+
+	subjects := func(t *testing.T, vp *verifiable.Presentation) []interface{} {
+		t.Helper()
+
+		var result []interface{}
+
+		for _, credential := range vp.Credentials() {
+			src, err := json.Marshal(credential)
+			require.NoError(t, err)
+
+			var limited map[string]interface{}
+			require.NoError(t, json.Unmarshal(src, &limited))
+
+			result = append(result, limited["credentialSubject"])
+		}
+
+		return result
+	}
+
+	t.Run("Optional fields are disclosed if satisfied", func(t *testing.T) {
+		vp, err := pd.CreateVP([]*verifiable.Credential{withNickname, withoutNickname, invalidNickname, withoutName},
+			lddl, verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, []interface{}{
+			map[string]interface{}{"id": "did:example:a", "name": "John", "nickname": "Johnny"},
+			map[string]interface{}{"id": "did:example:a", "name": "Jane"},
+			map[string]interface{}{"id": "did:example:a", "name": "Jack"},
+		}, subjects(t, vp))
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Only optional fields", func(t *testing.T) {
+		optional := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "nickname",
+				Constraints: &Constraints{
+					Fields: []*Field{{Path: []string{"$.credentialSubject.nickname"}, Optional: true}},
+				},
+			}},
+		}
+
+		vp, err := optional.CreateVP([]*verifiable.Credential{withoutNickname}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("Required fields still reject", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{withoutName}, lddl)
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		src, err := json.Marshal(pd)
+		require.NoError(t, err)
+		require.Contains(t, string(src), `"optional":true`)
+
+		require.NoError(t, pd.ValidateSchema())
+	})
+}
+
+func TestField_Name(t *testing.T) {
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "age",
+			Schema: []*Schema{{
+				URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+			}},
+			Constraints: &Constraints{
+				Fields: []*Field{{
+					Path: []string{"$.credentialSubject.birthdate"},
+					Name: "Date of birth",
+				}},
+			},
+		}},
+	}
+
+	src, err := json.Marshal(pd)
+	require.NoError(t, err)
+	require.Contains(t, string(src), `"name":"Date of birth"`)
+
+	var parsed PresentationDefinition
+	require.NoError(t, json.Unmarshal(src, &parsed))
+	require.Equal(t, "Date of birth", parsed.InputDescriptors[0].Constraints.Fields[0].Name)
+	require.NoError(t, parsed.ValidateSchema())
+
+	pd.InputDescriptors[0].Constraints.Fields[0].Name = ""
+
+	src, err = json.Marshal(pd)
+	require.NoError(t, err)
+	require.NotContains(t, string(src), `"name"`)
+}
+
+func TestField_JWTClaimPaths(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	expired := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "http://example.edu/credentials/1",
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:holder",
+			CustomFields: verifiable.CustomFields{"given_name": "John", "family_name": "Doe"},
+		}},
+		Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+		Issued:  util.NewTime(time.Now().Add(-time.Hour)),
+		Expired: util.NewTime(expired),
+	}
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	vc.JWT = createEdDSAJWS(t, vc, ed25519Signer, "76e12ec712ebc6f1c221ebfeb1f", true)
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	t.Run("JWT claims", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "name",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:   []string{"$.vc.credentialSubject.given_name"},
+						Filter: &Filter{Type: &strFilterType, Const: "John"},
+					}, {
+						Path:   []string{"$.sub"},
+						Filter: &Filter{Type: &strFilterType, Const: "did:example:holder"},
+					}, {
+						Path:   []string{"$['exp']"},
+						Filter: &Filter{Type: &intFilterType, Minimum: time.Now().Unix()},
+					}, {
+						Path:   []string{"$.jti"},
+						Filter: &Filter{Type: &strFilterType, Const: vc.ID},
+					}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		limited, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+
+		subject := limited.Subject.([]verifiable.Subject)
+		require.Len(t, subject, 1)
+		require.Equal(t, "did:example:holder", subject[0].ID)
+		require.Equal(t, verifiable.CustomFields{"given_name": "John"}, subject[0].CustomFields)
+		require.NotNil(t, limited.Expired)
+		require.True(t, expired.Equal(limited.Expired.Time))
+	})
+
+	ldpVC := *vc
+	ldpVC.JWT = ""
+
+	for _, tc := range []struct {
+		name       string
+		field      *Field
+		credential *verifiable.Credential
+		err        error
+	}{
+		{
+			name: "Credential paths",
+			field: &Field{
+				Path:   []string{"$.credentialSubject.family_name"},
+				Filter: &Filter{Type: &strFilterType, Const: "Doe"},
+			},
+			credential: vc,
+		},
+		{
+			name: "JWT claim not satisfied",
+			field: &Field{
+				Path:   []string{"$.nbf"},
+				Filter: &Filter{Type: &intFilterType, Minimum: time.Now().Unix()},
+			},
+			credential: vc,
+			err:        ErrNoCredentials,
+		},
+		{
+			name:       "Not a JWT credential",
+			field:      &Field{Path: []string{"$.vc.credentialSubject.given_name"}},
+			credential: &ldpVC,
+			err:        ErrNoCredentials,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pd := &PresentationDefinition{
+				ID: uuid.New().String(),
+				InputDescriptors: []*InputDescriptor{{
+					ID:          "name",
+					Constraints: &Constraints{LimitDisclosure: &required, Fields: []*Field{tc.field}},
+				}},
+			}
+
+			vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{tc.credential}, lddl, credOpts)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, vp.Credentials(), 1)
+		})
+	}
+}
+
+func TestField_Derive(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	credOpts := WithSDCredentialOptions(verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(lddl))
+
+	newVC := func(birthdate string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{
+				ID:           "did:example:a",
+				CustomFields: verifiable.CustomFields{"birthdate": birthdate},
+			}},
+			Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+			Issued: util.NewTime(time.Now()),
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "adult",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject.birthdate", "$.credentialSubject.birth_date"},
+					Derive: DeriveAge,
+					Filter: &Filter{Type: &intFilterType, Minimum: 18},
+				}},
+			},
+		}},
+	}
+
+	// unsatisfiablePD requires an age no credential satisfies.
+	unsatisfiablePD := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "adult",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject.birthdate", "$.credentialSubject.birth_date"},
+					Derive: DeriveAge,
+					Filter: &Filter{Type: &intFilterType, Minimum: 200},
+				}},
+			},
+		}},
+	}
+
+	t.Run("Value is replaced with true", func(t *testing.T) {
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{newVC("1940-01-01")}, lddl, credOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		src, err := json.Marshal(vp.Credentials()[0])
+		require.NoError(t, err)
+
+		var limited map[string]interface{}
+		require.NoError(t, json.Unmarshal(src, &limited))
+		require.Equal(t, map[string]interface{}{"id": "did:example:a", "birthdate": true}, limited["credentialSubject"])
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Derived value does not satisfy the filter", func(t *testing.T) {
+		_, err := unsatisfiablePD.CreateVPWithOptions([]*verifiable.Credential{newVC("1940-01-01")}, lddl, credOpts)
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Age as of the clock", func(t *testing.T) {
+		clock := func(date string) MatchRequirementsOpt {
+			return WithClock(func() time.Time {
+				now, err := time.Parse("2006-01-02", date)
+				require.NoError(t, err)
+
+				return now
+			})
+		}
+
+		vc := newVC("2000-06-01")
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, clock("2018-05-31"))
+		require.ErrorIs(t, err, ErrNoCredentials)
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, clock("2018-06-01"))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("Age is in full years", func(t *testing.T) {
+		now := time.Now().UTC()
+		adult := newVC(now.AddDate(-18, 0, 0).Format("2006-01-02"))
+		minor := newVC(now.AddDate(-18, 0, 1).Format("2006-01-02"))
+		invalid := newVC("yesterday")
+
+		matched, err := pd.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{adult, minor, invalid}, lddl,
+			credOpts)
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors[0].MatchedVCs, 1)
+		require.Equal(t, adult.ID, matched[0].Descriptors[0].MatchedVCs[0].ID)
+	})
+
+	t.Run("SD-JWT discloses the issued claim", func(t *testing.T) {
+		ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+		require.NoError(t, err)
+
+		withClaim := getTestVC()
+		withClaim.Subject.(map[string]interface{})["age_over_18"] = true
+
+		sdJwtVC := newSdJwtVC(t, withClaim, ed25519Signer)
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{sdJwtVC}, lddl, credOpts)
+		require.NoError(t, err)
+
+		vc, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Len(t, vc.SDJWTDisclosures, 1)
+		require.Equal(t, "age_over_18", vc.SDJWTDisclosures[0].Name)
+		require.Equal(t, true, vc.SDJWTDisclosures[0].Value)
+
+		// the credential issues no age_over_200 claim.
+		_, err = unsatisfiablePD.CreateVPWithOptions([]*verifiable.Credential{sdJwtVC}, lddl, credOpts)
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Mdoc discloses the issued claim", func(t *testing.T) {
+		const isoNameSpace = "org.iso.18013.5.1"
+
+		mdl := NewMdocCredential(&Mdoc{
+			DocType: "org.iso.18013.5.1.mDL",
+			NameSpaces: map[string]map[string]interface{}{
+				isoNameSpace: {"family_name": "Doe", "birth_date": "1940-01-01", "age_over_18": true},
+			},
+		})
+
+		mdocPD := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "adult",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:   []string{"$['org.iso.18013.5.1']['birth_date']"},
+						Derive: DeriveAge,
+						Filter: &Filter{Type: &intFilterType, Minimum: 18},
+					}},
+				},
+			}},
+		}
+
+		vp, err := mdocPD.CreateVP([]*verifiable.Credential{mdl}, lddl)
+		require.NoError(t, err)
+
+		doc, ok := MdocOf(vp.Credentials()[0].(*verifiable.Credential))
+		require.True(t, ok)
+		require.Equal(t, map[string]map[string]interface{}{isoNameSpace: {"age_over_18": true}}, doc.NameSpaces)
+
+		mdocPD.InputDescriptors[0].Constraints.Fields[0].Filter.Minimum = 65
+
+		_, rejections, err := mdocPD.MatchSubmissionRequirementDetailed([]*verifiable.Credential{mdl}, lddl)
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+		require.EqualError(t, rejections[0].Reason,
+			"derived predicate is not issued by the credential: age_over_65 is not true")
+	})
+
+	t.Run("Custom derivation", func(t *testing.T) {
+		lengthPD := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "name",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:   []string{"$.credentialSubject.given_name"},
+						Derive: "length",
+						Filter: &Filter{Type: &intFilterType, Minimum: 4},
+					}},
+				},
+			}},
+		}
+
+		matched, err := lengthPD.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{getTestVC()}, lddl, credOpts)
+		require.NoError(t, err)
+		require.Empty(t, matched[0].Descriptors[0].MatchedVCs)
+
+		matched, err = lengthPD.MatchSubmissionRequirementWithOptions([]*verifiable.Credential{getTestVC()}, lddl,
+			credOpts, WithDerivation("length", lengthDerivation{}))
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors[0].MatchedVCs, 1)
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		src, err := json.Marshal(pd)
+		require.NoError(t, err)
+		require.Contains(t, string(src), `"derive":"age"`)
+
+		require.NoError(t, pd.ValidateSchema())
+	})
+}
+
+type lengthDerivation struct{}
+
+func (lengthDerivation) Derive(value interface{}, _ time.Time) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+
+	return len(str), nil
+}
+
+func (lengthDerivation) IssuedClaim(*Filter) string {
+	return ""
+}
+
+func TestConstraints_MinimalDisclosurePaths(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{
+			ID: "did:example:a",
+			CustomFields: map[string]interface{}{
+				"email": "john@example.com",
+				"phone": "+1-202-555-0101",
+				"age":   42,
+			},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	required := Required
+
+	constraints := &Constraints{
+		LimitDisclosure: &required,
+		Fields: []*Field{
+			{Path: []string{"$.credentialSubject.email", "$.credentialSubject.phone"}},
+			{
+				Path:    []string{"$.credentialSubject.name", "$.credentialSubject.age"},
+				Filters: []*Filter{{Type: &strFilterType}, {Type: &intFilterType}},
+			},
+			{Path: []string{"$.credentialSubject.nickname"}, Optional: true},
+		},
+	}
+
+	src, err := json.Marshal(vc)
+	require.NoError(t, err)
+
+	var credentialMap map[string]interface{}
+	require.NoError(t, json.Unmarshal(src, &credentialMap))
+
+	t.Run("Paths", func(t *testing.T) {
+		paths, err := constraints.MinimalDisclosurePaths(credentialMap)
+		require.NoError(t, err)
+		require.Equal(t, []string{"$.credentialSubject.email", "$.credentialSubject.age"}, paths)
+
+		_, err = (&Constraints{Fields: []*Field{{Path: []string{"$.credentialSubject.name"}}}}).
+			MinimalDisclosurePaths(credentialMap)
+		require.ErrorContains(t, err, "field 0 is not satisfied")
+	})
+
+	t.Run("Create VP", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{ID: "contact", Constraints: constraints}},
+		}
+
+		disclosed := func(t *testing.T, opts ...MatchRequirementsOpt) interface{} {
+			t.Helper()
+
+			vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl,
+				append(opts, WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)))...)
+			require.NoError(t, err)
+			require.Len(t, vp.Credentials(), 1)
+
+			src, err := json.Marshal(vp.Credentials()[0])
+			require.NoError(t, err)
+
+			var limited map[string]interface{}
+			require.NoError(t, json.Unmarshal(src, &limited))
+
+			return limited["credentialSubject"]
+		}
+
+		require.Equal(t, map[string]interface{}{
+			"id":    "did:example:a",
+			"email": "john@example.com",
+			"phone": "+1-202-555-0101",
+			"age":   42.,
+		}, disclosed(t))
+
+		require.Equal(t, map[string]interface{}{
+			"id":    "did:example:a",
+			"email": "john@example.com",
+			"age":   42.,
+		}, disclosed(t, WithMinimalDisclosure()))
+	})
+}
+
+func TestPresentationDefinition_CreateVPWithAudit(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				Fields: []*Field{
+					{
+						ID:     "given_name",
+						Name:   "Given name",
+						Path:   []string{"$.credentialSubject.first_name", "$.credentialSubject.given_name"},
+						Filter: &Filter{Type: &strFilterType},
+					},
+					{Path: []string{"$.credentialSubject.nickname"}, Optional: true},
+					{
+						Path:      []string{"$.credentialSubject.birthdate"},
+						Filter:    &Filter{Type: &strFilterType},
+						Predicate: &required,
+					},
+				},
+			},
+		}, {
+			ID: "any",
+		}},
+	}
+
+	vc := getTestVC()
+
+	vp, records, err := pd.CreateVPWithAudit([]*verifiable.Credential{vc}, lddl,
+		WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl), verifiable.WithNoCustomSchemaCheck()))
+	require.NoError(t, err)
+	require.Len(t, vp.Credentials(), 2)
+
+	require.Equal(t, []*CredentialAuditRecord{{
+		CredentialID: vc.ID,
+		DescriptorID: "any",
+	}, {
+		CredentialID: vc.ID,
+		DescriptorID: "name",
+		SatisfiedFields: []*FieldAudit{{
+			FieldIndex: 0,
+			FieldID:    "given_name",
+			FieldName:  "Given name",
+			Path:       "$.credentialSubject.given_name",
+			Value:      "John",
+		}, {
+			FieldIndex: 2,
+			Path:       "$.credentialSubject.birthdate",
+			Value:      true,
+		}},
+	}}, records)
+
+	_, _, err = pd.CreateVPWithAudit(nil, lddl)
+	require.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestPresentationDefinition_CreateVP_DebugOriginals(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	vc := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"given_name": "John", "family_name": "Doe"},
+		}},
+		Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+		Issued: util.NewTime(time.Now()),
+	}
+
+	credOpts := WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl))
+
+	t.Run("Limited credentials", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: "name",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields:          []*Field{{Path: []string{"$.credentialSubject.given_name"}}},
+				},
+			}},
+		}
+
+		var originals []*OriginalCredential
+
+		vp, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts,
+			WithDebugOriginals(&originals))
+		require.NoError(t, err)
+		require.Len(t, originals, 1)
+		require.Equal(t, vc.ID, originals[0].CredentialID)
+		require.Equal(t, "name", originals[0].DescriptorID)
+
+		var original map[string]interface{}
+		require.NoError(t, json.Unmarshal(originals[0].Original, &original))
+		require.Equal(t, map[string]interface{}{
+			"id": "did:example:a", "given_name": "John", "family_name": "Doe",
+		}, original["credentialSubject"])
+
+		src, err := json.Marshal(vp)
+		require.NoError(t, err)
+		require.NotContains(t, string(src), "family_name")
+	})
+
+	t.Run("Credentials which are not limited", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          "name",
+				Constraints: &Constraints{Fields: []*Field{{Path: []string{"$.credentialSubject.given_name"}}}},
+			}},
+		}
+
+		var originals []*OriginalCredential
+
+		_, err := pd.CreateVPWithOptions([]*verifiable.Credential{vc}, lddl, credOpts, WithDebugOriginals(&originals))
+		require.NoError(t, err)
+		require.Empty(t, originals)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_MappingDetails(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newVC := func(claim string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{
+				ID:           "did:example:a",
+				CustomFields: verifiable.CustomFields{claim: "value"},
+			}},
+			Issuer: verifiable.Issuer{ID: "did:example:issuer"},
+			Issued: util.NewTime(time.Now()),
+		}
+	}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID:      "name",
+			Name:    "Name",
+			Purpose: "To address the holder",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.given_name"}}},
+			},
+		}, {
+			ID: "address",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.credentialSubject.street"}}},
+			},
+		}},
+	}
+
+	credentials := []*verifiable.Credential{newVC("given_name"), newVC("street")}
+
+	t.Run("Collected", func(t *testing.T) {
+		var details []*MappingDetails
+
+		vp, err := pd.CreateVPWithOptions(credentials, lddl, WithMappingDetails(&details))
+		require.NoError(t, err)
+
+		submission, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Len(t, details, len(submission.DescriptorMap))
+
+		for i, mapping := range submission.DescriptorMap {
+			require.Equal(t, mapping.ID, details[i].DescriptorID)
+		}
+
+		require.Equal(t, []*MappingDetails{
+			{DescriptorID: "address"},
+			{DescriptorID: "name", Name: "Name", Purpose: "To address the holder"},
+		}, details)
+
+		src, err := json.Marshal(submission)
+		require.NoError(t, err)
+		require.NotContains(t, string(src), "To address the holder")
+	})
+
+	t.Run("Not collected by default", func(t *testing.T) {
+		vp, err := pd.CreateVP(credentials, lddl)
+		require.NoError(t, err)
+
+		src, err := json.Marshal(vp.CustomFields["presentation_submission"])
+		require.NoError(t, err)
+		require.NotContains(t, string(src), "purpose")
+	})
+}
+
+func TestField_IssuerAllowList(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	newCred := func(issuer verifiable.Issuer) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: "did:example:holder"}},
+			Issuer:  issuer,
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	stringIssuer := newCred(verifiable.Issuer{ID: "did:example:trusted1"})
+	objectIssuer := newCred(verifiable.Issuer{
+		ID:           "did:example:trusted2",
+		CustomFields: verifiable.CustomFields{"name": "Trusted Issuer"},
+	})
+	untrusted := newCred(verifiable.Issuer{ID: "did:example:untrusted"})
+
+	jwtVC := newCred(verifiable.Issuer{ID: "did:example:trusted1"})
+
+	jwtVC, err = verifiable.ParseCredential([]byte(createEdDSAJWS(t, jwtVC, ed25519Signer, "1", true)),
+		verifiable.WithDisabledProofCheck(), verifiable.WithJSONLDDocumentLoader(lddl))
+	require.NoError(t, err)
+
+	src, err := json.Marshal(objectIssuer)
+	require.NoError(t, err)
+	require.Contains(t, string(src), `"issuer":{"id":"did:example:trusted2","name":"Trusted Issuer"}`)
+
+	creds := []*verifiable.Credential{stringIssuer, objectIssuer, untrusted, jwtVC}
+
+	for _, tc := range []struct {
+		path    string
+		matched []*verifiable.Credential
+	}{
+		{path: "$.issuer", matched: []*verifiable.Credential{stringIssuer, objectIssuer, jwtVC}},
+		{path: "$.issuer.id", matched: []*verifiable.Credential{stringIssuer, objectIssuer, jwtVC}},
+		{path: "$['issuer']['id']", matched: []*verifiable.Credential{stringIssuer, objectIssuer, jwtVC}},
+		// the JWT claims are selected in the JWT credentials only.
+		{path: "$.iss", matched: []*verifiable.Credential{jwtVC}},
+		{path: "$.vc.issuer", matched: []*verifiable.Credential{jwtVC}},
+	} {
+		path, expected := tc.path, tc.matched
+
+		t.Run(path, func(t *testing.T) {
+			pd := &PresentationDefinition{
+				ID: uuid.New().String(),
+				InputDescriptors: []*InputDescriptor{{
+					ID: uuid.New().String(),
+					Constraints: &Constraints{
+						Fields: []*Field{{
+							Path: []string{path},
+							Filter: &Filter{
+								Type: &strFilterType,
+								Enum: []StrOrInt{"did:example:trusted1", "did:example:trusted2"},
+							},
+						}},
+					},
+				}},
+			}
+
+			matched, err := pd.MatchSubmissionRequirement(creds, lddl)
+			require.NoError(t, err)
+			require.Equal(t, expected, matched[0].Descriptors[0].MatchedVCs)
+		})
+	}
+
+	t.Run("Issuer object", func(t *testing.T) {
+		objFilterType := "object"
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:   []string{"$.issuer"},
+						Filter: &Filter{Type: &objFilterType},
+					}},
+				},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement(creds, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []*verifiable.Credential{objectIssuer}, matched[0].Descriptors[0].MatchedVCs)
+	})
+
+	t.Run("Issuer properties", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:   []string{"$.issuer.name"},
+						Filter: &Filter{Type: &strFilterType, Const: "Trusted Issuer"},
+					}},
+				},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement(creds, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []*verifiable.Credential{objectIssuer}, matched[0].Descriptors[0].MatchedVCs)
+	})
+
+	t.Run("Limit disclosure", func(t *testing.T) {
+		required := Required
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:   []string{"$.issuer.id"},
+						Filter: &Filter{Type: &strFilterType, Const: "did:example:trusted1"},
+					}},
+				},
+			}},
+		}
+
+		// the string issuer selected by $.issuer.id is disclosed.
+		vp, err := pd.CreateVP([]*verifiable.Credential{stringIssuer, objectIssuer}, lddl,
+			verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		limited, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Equal(t, "did:example:trusted1", limited.Issuer.ID)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_Context(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Constraints: &Constraints{
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject.given_name", "$.credentialSubject[0].given_name"},
+					Filter: &Filter{Type: &strFilterType, Pattern: "^J"},
+				}},
+			},
+		}},
+	}
+
+	creds := []*verifiable.Credential{getTestVC()}
+
+	t.Run("Within deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		vp, err := pd.CreateVPWithOptions(creds, lddl, WithContext(ctx))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+	})
+
+	t.Run("Deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		_, err := pd.CreateVPWithOptions(creds, lddl, WithContext(ctx))
+
+		var timeoutErr *MatchTimeoutError
+
+		require.ErrorAs(t, err, &timeoutErr)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := pd.MatchSubmissionRequirementWithOptions(creds, lddl, WithContext(ctx), WithConcurrency(2))
+		require.ErrorIs(t, err, context.Canceled)
+		require.EqualError(t, err, "match credentials: context canceled")
+	})
+}
+
+func TestPresentationDefinition_CreateVPFromRaw(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: uuid.New().String(),
+			Constraints: &Constraints{
+				Fields: []*Field{{
+					Path:   []string{"$.credentialSubject.given_name", "$.credentialSubject[0].given_name"},
+					Filter: &Filter{Type: &strFilterType, Const: "John"},
+				}},
+			},
+		}},
+	}
+
+	ldpVC := getTestVC()
+
+	ldpRaw, err := json.Marshal(ldpVC)
+	require.NoError(t, err)
+
+	jwtVC := getTestVC()
+	jwtVC.ID = "http://example.edu/credentials/1873"
+	jwtRaw := []byte(createEdDSAJWS(t, jwtVC, ed25519Signer, "1", true))
+
+	invalid := []byte(`{"invalid": "credential"}`)
+
+	sdOpts := WithSDCredentialOptions(verifiable.WithDisabledProofCheck(), verifiable.WithNoCustomSchemaCheck())
+
+	t.Run("Success", func(t *testing.T) {
+		vp, err := pd.CreateVPFromRaw([][]byte{ldpRaw, jwtRaw}, lddl, sdOpts)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+
+		parsed, ok := vp.Credentials()[1].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Equal(t, string(jwtRaw), parsed.JWT)
+
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Parse options", func(t *testing.T) {
+		_, err := pd.CreateVPFromRaw([][]byte{ldpRaw, jwtRaw}, lddl,
+			WithSDCredentialOptions(verifiable.WithNoCustomSchemaCheck()))
+		require.ErrorContains(t, err, "parse credential 1")
+	})
+
+	t.Run("Fail fast", func(t *testing.T) {
+		_, err := pd.CreateVPFromRaw([][]byte{ldpRaw, invalid, jwtRaw}, lddl, sdOpts)
+		require.ErrorContains(t, err, "parse credential 1")
+	})
+
+	t.Run("Skip unparsable credentials", func(t *testing.T) {
+		var skipped []error
+
+		vp, err := pd.CreateVPFromRaw([][]byte{ldpRaw, invalid, jwtRaw}, lddl, sdOpts,
+			WithSkipUnparsableCredentials(), WithOnSkip(func(credential *verifiable.Credential, err error) {
+				require.Nil(t, credential)
+
+				skipped = append(skipped, err)
+			}))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 2)
+
+		require.Len(t, skipped, 1)
+		require.ErrorContains(t, skipped[0], "parse credential 1")
+	})
+}
+
+func TestFilter_StringFormats(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(subject string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{ID: subject}},
+			Issued:  util.NewTime(time.Now()),
+		}
+	}
+
+	matchedIDs := func(t *testing.T, format string, creds ...*verifiable.Credential) []string {
+		t.Helper()
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:     uuid.New().String(),
+				Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:   []string{"$.credentialSubject[0].id", "$.credentialSubject.id"},
+						Filter: &Filter{Type: &strFilterType, Format: format},
+					}},
+				},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement(creds, lddl)
+		if errors.Is(err, ErrNoCredentials) {
+			return nil
+		}
+
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	email := newCred("john@example.com")
+	namedEmail := newCred("John <john@example.com>")
+	did := newCred("did:example:123")
+	id := uuid.New().String()
+	plainUUID := newCred(id)
+	dateTime := newCred("2022-01-31T10:00:00+02:00")
+	text := newCred("not an identifier")
+
+	tests := []struct {
+		format  string
+		matched []*verifiable.Credential
+	}{
+		{format: FilterFormatEmail, matched: []*verifiable.Credential{email}},
+		{format: FilterFormatURI, matched: []*verifiable.Credential{did}},
+		{format: FilterFormatUUID, matched: []*verifiable.Credential{plainUUID}},
+		{format: FilterFormatDateTime, matched: []*verifiable.Credential{dateTime}},
+		{
+			format:  "unknown-format",
+			matched: []*verifiable.Credential{email, namedEmail, did, plainUUID, dateTime, text},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format, func(t *testing.T) {
+			var expected []string
+			for _, vc := range tc.matched {
+				expected = append(expected, vc.ID)
+			}
+
+			ids := matchedIDs(t, tc.format, email, namedEmail, did, plainUUID, dateTime, text)
+			require.ElementsMatch(t, expected, ids)
+		})
+	}
+}
+
+func TestFilter_TypeCoercion(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(age interface{}) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Subject: []verifiable.Subject{{
+				ID:           uuid.New().String(),
+				CustomFields: verifiable.CustomFields{"age": age},
+			}},
+			Issued: util.NewTime(time.Now()),
+		}
+	}
+
+	matchedIDs := func(t *testing.T, filter *Filter, creds []*verifiable.Credential,
+		opts ...MatchRequirementsOpt) []string {
+		t.Helper()
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:     uuid.New().String(),
+				Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:   []string{"$.credentialSubject.age"},
+						Filter: filter,
+					}},
+				},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirementWithOptions(creds, lddl, opts...)
+		if errors.Is(err, ErrNoCredentials) {
+			return nil
+		}
+
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	number := newCred(21)
+	str := newCred("21")
+	fraction := newCred("21.5")
+	text := newCred("twenty-one")
+	creds := []*verifiable.Credential{number, str, fraction, text}
+
+	numberType := "number"
+
+	tests := []struct {
+		name     string
+		filter   *Filter
+		strict   []*verifiable.Credential
+		coercion []*verifiable.Credential
+	}{
+		{
+			name:     "number const",
+			filter:   &Filter{Type: &numberType, Const: 21},
+			strict:   []*verifiable.Credential{number},
+			coercion: []*verifiable.Credential{number, str},
+		},
+		{
+			name:     "string const of number type",
+			filter:   &Filter{Type: &numberType, Const: "21"},
+			coercion: []*verifiable.Credential{number, str},
+		},
+		{
+			name:     "number enum",
+			filter:   &Filter{Type: &numberType, Enum: []StrOrInt{21, "21.5"}},
+			strict:   []*verifiable.Credential{number},
+			coercion: []*verifiable.Credential{number, str, fraction},
+		},
+		{
+			name:     "integer minimum",
+			filter:   &Filter{Type: &intFilterType, Minimum: "18"},
+			coercion: []*verifiable.Credential{number, str},
+		},
+		{
+			name:     "string const",
+			filter:   &Filter{Type: &strFilterType, Const: "21"},
+			strict:   []*verifiable.Credential{str},
+			coercion: []*verifiable.Credential{number, str},
+		},
+	}
+
+	ids := func(creds []*verifiable.Credential) []string {
+		var result []string
+		for _, vc := range creds {
+			result = append(result, vc.ID)
+		}
+
+		return result
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.ElementsMatch(t, ids(tc.strict), matchedIDs(t, tc.filter, creds))
+			require.ElementsMatch(t, ids(tc.coercion), matchedIDs(t, tc.filter, creds, WithTypeCoercion()))
+		})
+	}
+}
+
+func TestFilter_DateRange(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	newCred := func(issued time.Time, expires string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      uuid.New().String(),
+			Issued:  util.NewTime(issued),
+			CustomFields: map[string]interface{}{
+				"expirationDate": expires,
+			},
+		}
+	}
+
+	matchedIDs := func(t *testing.T, path string, filter *Filter, creds ...*verifiable.Credential) []string {
+		t.Helper()
+
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:     uuid.New().String(),
+				Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+				Constraints: &Constraints{
+					Fields: []*Field{{Path: []string{path}, Filter: filter}},
+				},
+			}},
+		}
+
+		matched, err := pd.MatchSubmissionRequirement(creds, lddl)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, vc := range matched[0].Descriptors[0].MatchedVCs {
+			ids = append(ids, vc.ID)
+		}
+
+		return ids
+	}
+
+	// 2022-01-01T01:00:00+02:00 is 2021-12-31T23:00:00Z, lexically after but temporally before the minimum.
+	east := time.FixedZone("UTC+2", 2*60*60)
+	early := newCred(time.Date(2022, 1, 1, 1, 0, 0, 0, east), "2023-06-30")
+	late := newCred(time.Date(2022, 1, 1, 3, 0, 0, 0, east), "2024-01-01T00:00:00-05:00")
+
+	t.Run("date-time minimum with offsets", func(t *testing.T) {
+		ids := matchedIDs(t, "$.issuanceDate", &Filter{
+			Type:    &strFilterType,
+			Format:  FilterFormatDateTime,
+			Minimum: "2022-01-01T00:00:00Z",
+		}, early, late)
+
+		require.Equal(t, []string{late.ID}, ids)
+	})
+
+	t.Run("date maximum with date-only and RFC3339 values", func(t *testing.T) {
+		ids := matchedIDs(t, "$.expirationDate", &Filter{
+			Type:    &strFilterType,
+			Format:  FilterFormatDate,
+			Maximum: "2024-01-01",
+		}, early, late)
+
+		require.Equal(t, []string{early.ID}, ids)
+	})
+
+	t.Run("exclusive bounds", func(t *testing.T) {
+		ids := matchedIDs(t, "$.expirationDate", &Filter{
+			Type:             &strFilterType,
+			Format:           FilterFormatDateTime,
+			ExclusiveMinimum: "2023-06-30",
+			ExclusiveMaximum: "2025-01-01T00:00:00Z",
+		}, early, late)
+
+		require.Equal(t, []string{late.ID}, ids)
+	})
+
+	t.Run("not a date", func(t *testing.T) {
+		ids := matchedIDs(t, "$.id", &Filter{
+			Type:    &strFilterType,
+			Format:  FilterFormatDate,
+			Minimum: "2022-01-01",
+		}, early)
+
+		require.Empty(t, ids)
+	})
+
+	t.Run("invalid bound", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:     uuid.New().String(),
+				Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+				Constraints: &Constraints{
+					Fields: []*Field{{
+						Path:   []string{"$.issuanceDate"},
+						Filter: &Filter{Type: &strFilterType, Format: FilterFormatDate, Minimum: "yesterday"},
+					}},
+				},
+			}},
+		}
+
+		_, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{early}, lddl)
+		require.EqualError(t, err, "filter field.0: filter minimum: invalid date: yesterday")
+	})
+}
+
+func TestPresentationDefinition_CreateVPs(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	issuerID := "did:example:76e12ec712ebc6f1c221ebfeb1f"
+
+	newVC := func(id string) *verifiable.Credential {
+		return &verifiable.Credential{
+			Context: []string{verifiable.ContextURI},
+			Types:   []string{verifiable.VCType},
+			ID:      id,
+			Subject: []verifiable.Subject{{ID: issuerID}},
+			Issuer:  verifiable.Issuer{ID: issuerID},
+			Issued:  util.NewTime(time.Now()),
+			CustomFields: map[string]interface{}{
+				"first_name": "Jesse",
+				"age":        21,
+			},
+		}
+	}
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	jwtVC := newVC("http://example.edu/credentials/1")
+	jwtVC.JWT = createEdDSAJWS(t, jwtVC, ed25519Signer, "76e12ec712ebc6f1c221ebfeb1f", true)
+
+	ldpVC1 := newVC("http://example.edu/credentials/2")
+	ldpVC1.Proofs = []verifiable.Proof{{"type": "JsonWebSignature2020"}}
+
+	ldpVC2 := newVC("http://example.edu/credentials/3")
+	ldpVC2.Proofs = []verifiable.Proof{{"type": "JsonWebSignature2020"}}
+
+	pd := &PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*InputDescriptor{{
+			ID: "name",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.first_name", "$.vc.first_name"}}},
+			},
+		}, {
+			ID: "age",
+			Constraints: &Constraints{
+				Fields: []*Field{{Path: []string{"$.age", "$.vc.age"}}},
+			},
+		}},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		vps, err := pd.CreateVPs([]*verifiable.Credential{ldpVC1, jwtVC, ldpVC2}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vps, 2)
+
+		jwtVP, ldpVP := vps[0], vps[1]
+
+		require.Len(t, jwtVP.Credentials(), 1)
+		require.Equal(t, jwtVC, jwtVP.Credentials()[0])
+		require.Len(t, ldpVP.Credentials(), 2)
+		require.Equal(t, ldpVC1, ldpVP.Credentials()[0])
+		require.Equal(t, ldpVC2, ldpVP.Credentials()[1])
+
+		for _, vp := range vps {
+			checkSubmission(t, vp, pd)
+		}
+
+		jwtSubmission := jwtVP.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.Len(t, jwtSubmission.DescriptorMap, 2)
+
+		for _, descriptor := range jwtSubmission.DescriptorMap {
+			require.Equal(t, FormatJWTVP, descriptor.Format)
+			require.Equal(t, FormatJWTVC, descriptor.PathNested.Format)
+			require.Equal(t, "$.verifiableCredential[0]", descriptor.PathNested.Path)
+		}
+
+		ldpSubmission := ldpVP.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.Len(t, ldpSubmission.DescriptorMap, 4)
+
+		paths := map[string]int{}
+
+		for _, descriptor := range ldpSubmission.DescriptorMap {
+			require.Equal(t, FormatLDPVP, descriptor.Format)
+			require.Equal(t, FormatLDPVC, descriptor.PathNested.Format)
+
+			paths[descriptor.PathNested.Path]++
+		}
+
+		require.Equal(t, map[string]int{"$.verifiableCredential[0]": 2, "$.verifiableCredential[1]": 2}, paths)
+	})
+
+	t.Run("Single format", func(t *testing.T) {
+		vps, err := pd.CreateVPs([]*verifiable.Credential{ldpVC1}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vps, 1)
+		require.Len(t, vps[0].Credentials(), 1)
+	})
+
+	t.Run("Checks schema", func(t *testing.T) {
+		vps, err := (&PresentationDefinition{ID: uuid.New().String()}).CreateVPs(nil, nil)
+		require.EqualError(t, err, "presentation_definition: input_descriptors is required")
+		require.Nil(t, vps)
+	})
+}
+
+func TestPresentationDefinition_CreateVP_Concurrency(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	required := Required
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	sdJwtVC := newSdJwtVC(t, getTestVC(), ed25519Signer)
+
+	plainVC := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "http://example.edu/credentials/1872",
+		Subject: "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		Issued:  util.NewTime(time.Now()),
+		Issuer:  verifiable.Issuer{ID: "did:example:76e12ec712ebc6f1c221ebfeb1f"},
+		CustomFields: map[string]interface{}{
+			"first_name": "Jesse",
+			"last_name":  "Travis",
+		},
+	}
+
+	pd := &PresentationDefinition{ID: uuid.New().String()}
+
+	for _, path := range []string{
+		"$.credentialSubject.family_name", "$.credentialSubject.given_name", "$.credentialSubject.email",
+		"$.first_name", "$.last_name",
+	} {
+		pd.InputDescriptors = append(pd.InputDescriptors, &InputDescriptor{
+			ID: path,
+			Schema: []*Schema{{
+				URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType),
+			}},
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields:          []*Field{{Path: []string{path}}},
+			},
+		})
+	}
+
+	creds := []*verifiable.Credential{sdJwtVC, plainVC}
+
+	t.Run("Success", func(t *testing.T) {
+		expected, err := pd.MatchSubmissionRequirement(creds, lddl,
+			verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+
+		matched, err := pd.MatchSubmissionRequirementWithOptions(creds, lddl,
+			WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)), WithConcurrency(3))
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors, len(pd.InputDescriptors))
+
+		for i, desc := range matched[0].Descriptors {
+			require.Equal(t, pd.InputDescriptors[i].ID, desc.ID)
+			require.Len(t, desc.MatchedVCs, 1)
+			require.Equal(t, trimTmpIDForTest(expected[0].Descriptors[i].MatchedVCs[0].ID),
+				trimTmpIDForTest(desc.MatchedVCs[0].ID))
+		}
+
+		require.Len(t, matched[0].Descriptors[0].MatchedVCs[0].SDJWTDisclosures, 1)
+		require.Greater(t, len(sdJwtVC.SDJWTDisclosures), 1)
+
+		matched, err = pd.MatchSubmissionRequirementWithOptions(creds, lddl, WithApplicabilityOnly(), WithConcurrency(3))
+		require.NoError(t, err)
+		require.Len(t, matched[0].Descriptors, len(pd.InputDescriptors))
+		require.Same(t, sdJwtVC, matched[0].Descriptors[0].MatchedVCs[0])
+
+		vp, err := pd.CreateVPWithOptions(creds, lddl,
+			WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)), WithConcurrency(3))
+		require.NoError(t, err)
+		checkSubmission(t, vp, pd)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		framed := *pd
+		framed.Frame = map[string]interface{}{"@type": "VerifiableCredential"}
+
+		_, err := framed.MatchSubmissionRequirementWithOptions(creds, lddl,
+			WithSDCredentialOptions(verifiable.WithJSONLDDocumentLoader(lddl)), WithConcurrency(3))
+		require.Error(t, err)
+	})
+}
+
+func trimTmpIDForTest(id string) string {
+	return strings.SplitN(id, "tmp_unique_id_", 2)[0]
+}
+
+func TestPresentationDefinition_CreateVP_SDJWTUnchanged(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	vc := getTestVC()
+	vc.Schemas = nil
+
+	sdJwtVC := newSdJwtVC(t, vc, signer)
+
+	snapshot := func() string {
+		src, err := json.Marshal(map[string]interface{}{
+			"credential":  sdJwtVC,
+			"jwt":         sdJwtVC.JWT,
+			"subject":     sdJwtVC.Subject,
+			"disclosures": sdJwtVC.SDJWTDisclosures,
+		})
+		require.NoError(t, err)
+
+		return string(src)
+	}
+
+	original := snapshot()
+	required := Required
+
+	t.Run("Limited", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields:          []*Field{{Path: []string{"$.credentialSubject.given_name"}}},
+				},
+			}},
+		}
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl,
+			verifiable.WithJSONLDDocumentLoader(lddl))
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		limited, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.NotSame(t, sdJwtVC, limited)
+		require.Less(t, len(limited.SDJWTDisclosures), len(sdJwtVC.SDJWTDisclosures))
+		require.Equal(t, original, snapshot())
+
+		// the limited credential shares nothing with the original one.
+		limited.SDJWTDisclosures[0].Name = "changed"
+		limited.SDJWTDisclosures[0].Value = "changed"
+		require.Equal(t, original, snapshot())
+	})
+
+	t.Run("Not matched", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID: uuid.New().String(),
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields:          []*Field{{Path: []string{"$.credentialSubject.nationality"}}},
+				},
+			}},
+		}
+
+		_, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.ErrorIs(t, err, ErrNoCredentials)
+		require.Equal(t, original, snapshot())
+	})
+
+	t.Run("Not limited", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*InputDescriptor{{
+				ID:          uuid.New().String(),
+				Constraints: &Constraints{Fields: []*Field{{Path: []string{"$.credentialSubject.given_name"}}}},
+			}},
+		}
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{sdJwtVC}, vp.Credentials())
+		require.Equal(t, original, snapshot())
+	})
+}
+
+func TestPresentationDefinition_CreateVP_SDJWTFormat(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	ed25519Signer, err := newCryptoSigner(kms.ED25519Type)
+	require.NoError(t, err)
+
+	sdJwtVC := newSdJwtVC(t, getTestVC(), ed25519Signer)
+
+	descriptors := []*InputDescriptor{{
+		ID:     uuid.New().String(),
+		Schema: []*Schema{{URI: fmt.Sprintf("%s#%s", verifiable.ContextID, verifiable.VCType)}},
+	}}
+
+	t.Run("vc+sd-jwt", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Format:           &Format{SdJwt: &SdJwtType{SdJwtAlgValues: []string{"EdDSA"}}},
+			InputDescriptors: descriptors,
+		}
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		submission, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Len(t, submission.DescriptorMap, 1)
+		require.Equal(t, FormatSDJWT, submission.DescriptorMap[0].Format)
+		require.Equal(t, FormatSDJWT, submission.DescriptorMap[0].PathNested.Format)
+
+		checkVP(t, vp)
+	})
+
+	t.Run("dc+sd-jwt with any algorithm", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Format:           &Format{DcSdJwt: &SdJwtType{}},
+			InputDescriptors: descriptors,
+		}
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.NoError(t, err)
+
+		submission, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Equal(t, FormatDCSDJWT, submission.DescriptorMap[0].Format)
+	})
+
+	t.Run("algorithm does not match", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Format:           &Format{SdJwt: &SdJwtType{SdJwtAlgValues: []string{"ES256"}}},
+			InputDescriptors: descriptors,
+		}
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{sdJwtVC}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+		require.Nil(t, vp)
+	})
+
+	t.Run("not an SD-JWT credential", func(t *testing.T) {
+		jwtVC := getTestVC()
+		jwtVC.JWT = createEdDSAJWS(t, jwtVC, ed25519Signer, "76e12ec712ebc6f1c221ebfeb1f", true)
+
+		pd := &PresentationDefinition{
+			ID:               uuid.New().String(),
+			Format:           &Format{SdJwt: &SdJwtType{}},
+			InputDescriptors: descriptors,
+		}
+
+		_, err := pd.CreateVP([]*verifiable.Credential{jwtVC}, lddl)
+		require.EqualError(t, err, ErrNoCredentials.Error())
+	})
+
+	t.Run("marshal format", func(t *testing.T) {
+		pd := &PresentationDefinition{
+			ID: uuid.New().String(),
+			Format: &Format{
+				SdJwt:   &SdJwtType{SdJwtAlgValues: []string{"EdDSA"}, KbJwtAlgValues: []string{"ES256"}},
+				DcSdJwt: &SdJwtType{SdJwtAlgValues: []string{"EdDSA"}},
+			},
+			InputDescriptors: descriptors,
+		}
+
+		require.NoError(t, pd.ValidateSchema())
+
+		b, err := json.Marshal(pd.Format)
+		require.NoError(t, err)
+		require.JSONEq(t, `{
+			"vc+sd-jwt":{"sd-jwt_alg_values":["EdDSA"],"kb-jwt_alg_values":["ES256"]},
+			"dc+sd-jwt":{"sd-jwt_alg_values":["EdDSA"]}
+		}`, string(b))
+	})
+}
+
 func createEdDSAJWS(t *testing.T, cred *verifiable.Credential, signer verifiable.Signer,
 	keyID string, minimize bool) string {
 	t.Helper()
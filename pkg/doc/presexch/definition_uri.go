@@ -0,0 +1,155 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MaxDefinitionSize is the maximum size (in bytes) of the presentation definition fetched by LoadDefinition,
+// and of the envelopes read by ParseDefinitionFromReader and ParseSubmissionFromReader.
+const MaxDefinitionSize = 1 << 20
+
+const (
+	definitionProperty    = "presentation_definition"
+	definitionURIProperty = "presentation_definition_uri"
+)
+
+// HTTPClient represents an HTTP client.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// LoadDefinition fetches the presentation definition referenced by the HTTPS URI and validates it
+// with ValidateSchema. The fetched JSON is either the presentation definition itself or an object wrapping it
+// into a presentation_definition property, e.g. the JSON of a presentation definition envelope. An object with
+// a presentation_definition_uri property instead (e.g. an OpenID4VP authorization request) is followed once.
+//
+// The response must have a JSON content type (application/json or application/*+json) and must not exceed
+// MaxDefinitionSize. Only the https URIs are fetched, so that the definition can not be served in the clear;
+// the client is responsible for any further policy (e.g. the hosts which may be fetched, redirects and timeouts).
+func LoadDefinition(ctx context.Context, uri string, client HTTPClient) (*PresentationDefinition, error) {
+	raw, err := fetchDefinition(ctx, uri, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapper map[string]json.RawMessage
+
+	if err = json.Unmarshal(raw, &wrapper); err != nil {
+		return nil, fmt.Errorf("unmarshal presentation definition: %w", err)
+	}
+
+	if _, ok := wrapper[definitionProperty]; !ok {
+		if rawURI, ok := wrapper[definitionURIProperty]; ok {
+			var nestedURI string
+
+			if err = json.Unmarshal(rawURI, &nestedURI); err != nil {
+				return nil, fmt.Errorf("unmarshal %s: %w", definitionURIProperty, err)
+			}
+
+			if raw, err = fetchDefinition(ctx, nestedURI, client); err != nil {
+				return nil, err
+			}
+
+			if err = json.Unmarshal(raw, &wrapper); err != nil {
+				return nil, fmt.Errorf("unmarshal presentation definition: %w", err)
+			}
+		}
+	}
+
+	if wrapped, ok := wrapper[definitionProperty]; ok {
+		raw = wrapped
+	}
+
+	var pd PresentationDefinition
+
+	if err = json.Unmarshal(raw, &pd); err != nil {
+		return nil, fmt.Errorf("unmarshal presentation definition: %w", err)
+	}
+
+	if err = pd.ValidateSchema(); err != nil {
+		return nil, fmt.Errorf("validate presentation definition: %w", err)
+	}
+
+	return &pd, nil
+}
+
+func fetchDefinition(ctx context.Context, uri string, client HTTPClient) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse presentation definition uri: %w", err)
+	}
+
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("presentation definition uri must be https: %s", uri)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("httpClient do: %w", err)
+	}
+
+	defer func() {
+		e := resp.Body.Close()
+		if e != nil {
+			logger.Errorf("Failed to close response body: %s", e.Error())
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response status code: %d", resp.StatusCode)
+	}
+
+	if err = checkJSONContentType(resp.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, MaxDefinitionSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if len(raw) > MaxDefinitionSize {
+		return nil, fmt.Errorf("presentation definition exceeds %d bytes", MaxDefinitionSize)
+	}
+
+	return raw, nil
+}
+
+func checkJSONContentType(contentType string) error {
+	if contentType == "" {
+		return errors.New("response content type is missing")
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("parse response content type: %w", err)
+	}
+
+	if mediaType != "application/json" &&
+		!(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")) {
+		return fmt.Errorf("unsupported response content type: %s", mediaType)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+)
+
+func TestLoadDefinition(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample_1.json")
+	require.NoError(t, err)
+
+	responses := map[string]struct {
+		contentType string
+		body        string
+	}{
+		"/pd": {contentType: "application/json", body: string(sample)},
+		"/wrapped": {
+			contentType: "application/json; charset=utf-8",
+			body:        `{"presentation_definition":` + string(sample) + `}`,
+		},
+		"/plain":     {contentType: "text/plain", body: string(sample)},
+		"/invalid":   {contentType: "application/json", body: `{"input_descriptors":[]}`},
+		"/malformed": {contentType: "application/json", body: `{`},
+		"/large":     {contentType: "application/json", body: strings.Repeat(" ", MaxDefinitionSize+1) + "{}"},
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/request" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"client_id":"verifier","presentation_definition_uri":"https://%s/wrapped"}`, r.Host)
+
+			return
+		}
+
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", response.contentType)
+		fmt.Fprint(w, response.body)
+	}))
+	defer server.Close()
+
+	load := func(path string) (*PresentationDefinition, error) {
+		return LoadDefinition(context.Background(), server.URL+path, server.Client())
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		for _, path := range []string{"/pd", "/wrapped", "/request"} {
+			pd, err := load(path)
+			require.NoError(t, err, path)
+			require.Equal(t, "32f54163-7166-48f1-93d8-ff217bdb0653", pd.ID, path)
+		}
+	})
+
+	t.Run("Not https", func(t *testing.T) {
+		_, err := LoadDefinition(context.Background(), "http://example.com/pd", server.Client())
+		require.EqualError(t, err, "presentation definition uri must be https: http://example.com/pd")
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		_, err := load("/unknown")
+		require.EqualError(t, err, "response status code: 404")
+	})
+
+	t.Run("Unsupported content type", func(t *testing.T) {
+		_, err := load("/plain")
+		require.EqualError(t, err, "unsupported response content type: text/plain")
+	})
+
+	t.Run("Too large", func(t *testing.T) {
+		_, err := load("/large")
+		require.EqualError(t, err, fmt.Sprintf("presentation definition exceeds %d bytes", MaxDefinitionSize))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := load("/malformed")
+		require.ErrorContains(t, err, "unmarshal presentation definition")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := load("/invalid")
+		require.ErrorContains(t, err, "validate presentation definition: presentation_definition: id is required")
+	})
+}
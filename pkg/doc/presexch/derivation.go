@@ -0,0 +1,158 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// DeriveAge derives the age in full years from a date (a full-date or an RFC3339 date-time), e.g. to request
+// that the holder is an adult with {"path": ["$.credentialSubject.birthdate"], "derive": "age",
+// "filter": {"type": "integer", "minimum": 18}}.
+const DeriveAge = "age"
+
+// errDerivationNotSupported is the rejection reason of SD-JWT and mdoc credentials matched by a derived field,
+// if they have no issued claim disclosing the derived predicate.
+var errDerivationNotSupported = errors.New("derived predicate is not issued by the credential")
+
+// Derivation derives the value a field filter is validated against from the value selected by the path of
+// the field. Derivations other than DeriveAge are set by WithDerivation.
+type Derivation interface {
+	// Derive returns the derived value, e.g. the age at the given time of the birthdate.
+	Derive(value interface{}, now time.Time) (interface{}, error)
+	// IssuedClaim returns the name of the claim the issuer may include, next to the derived one, with the result
+	// of the filter, e.g. age_over_18 for the filter with minimum 18, or an empty string if there is no such claim.
+	IssuedClaim(filter *Filter) string
+}
+
+type ageDerivation struct{}
+
+func (ageDerivation) Derive(value interface{}, now time.Time) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, errors.New("date value is not a string")
+	}
+
+	birth, err := parseDate(str)
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+	age := now.Year() - birth.Year()
+
+	if now.Month() < birth.Month() || (now.Month() == birth.Month() && now.Day() < birth.Day()) {
+		age--
+	}
+
+	return age, nil
+}
+
+func (ageDerivation) IssuedClaim(filter *Filter) string {
+	if filter == nil {
+		return ""
+	}
+
+	if age, ok := wholeNumber(filter.Minimum); ok {
+		return fmt.Sprintf("age_over_%d", age)
+	}
+
+	if age, ok := wholeNumber(filter.ExclusiveMinimum); ok {
+		return fmt.Sprintf("age_over_%d", age+1)
+	}
+
+	return ""
+}
+
+func wholeNumber(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	}
+
+	return 0, false
+}
+
+// derivedValue derives the value of the field selected by the path with the derivation of the field.
+func derivedValue(f *Field, value interface{}, opts *matchRequirementsOpts) (interface{}, error) {
+	derivation, ok := opts.derivation(f.Derive)
+	if !ok {
+		return nil, fmt.Errorf("unknown derivation %s", f.Derive)
+	}
+
+	derived, err := derivation.Derive(value, opts.currentTime())
+	if err != nil {
+		return nil, fmt.Errorf("derive %s: %w", f.Derive, err)
+	}
+
+	return derived, nil
+}
+
+// derivedDisclosure returns the field disclosing the derived predicate of the field satisfied by its i-th path.
+// The value of a credential which can be limited with any value (i.e. not SD-JWT or mdoc) is replaced with true,
+// as with a required predicate. The issuer-signed SD-JWT and mdoc credentials disclose the claim with the result of
+// the filter issued next to the derived one instead (e.g. age_over_18 next to birthdate), if it is true.
+func derivedDisclosure(f *Field, i int, credential map[string]interface{}, signedValues bool,
+	opts *matchRequirementsOpts) (*Field, error) {
+	if !signedValues {
+		predicate := Required
+
+		disclosed := *f
+		disclosed.Predicate = &predicate
+
+		return &disclosed, nil
+	}
+
+	derivation, _ := opts.derivation(f.Derive)
+
+	claim := derivation.IssuedClaim(f.pathFilter(i))
+	if claim == "" {
+		return nil, errDerivationNotSupported
+	}
+
+	matches, err := jsonPathMatches(f.Path[i:i+1], credential)
+	if err != nil || len(matches) == 0 {
+		return nil, errDerivationNotSupported
+	}
+
+	path := siblingPath(matches[0].keys, claim)
+
+	if value, err := jsonPathValue(path, credential); err != nil || value != true {
+		return nil, fmt.Errorf("%w: %s is not true", errDerivationNotSupported, claim)
+	}
+
+	return &Field{ID: f.ID, Path: []string{path}, Name: f.Name, Purpose: f.Purpose, Optional: f.Optional}, nil
+}
+
+// siblingPath returns the JSONPath (in the bracket notation) of the property named name next to the value
+// at the keys.
+func siblingPath(keys []interface{}, name string) string {
+	var b strings.Builder
+
+	b.WriteString("$")
+
+	for _, key := range keys[:len(keys)-1] {
+		switch k := key.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", k)
+		default:
+			fmt.Fprintf(&b, "['%s']", k)
+		}
+	}
+
+	fmt.Fprintf(&b, "['%s']", name)
+
+	return b.String()
+}
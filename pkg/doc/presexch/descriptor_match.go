@@ -0,0 +1,71 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"github.com/piprate/json-gold/ld"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// MatchesDescriptor checks whether the credential satisfies the input descriptor the same way CreateVP matches
+// the credentials against it (expiration, format, schema and constraints), e.g. for a wallet to tell which input
+// descriptors each credential of its list can fulfill. The input descriptor is matched on its own: neither
+// the submission requirements nor the format and frame of its presentation definition apply.
+//
+// The credential is neither framed nor limited by limit_disclosure or predicates, so it is left intact.
+func MatchesDescriptor(credential *verifiable.Credential, descriptor *InputDescriptor,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) (bool, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.noFrame = true
+	matchOpts.noLimitDisclosure = true
+
+	pd := &PresentationDefinition{InputDescriptors: []*InputDescriptor{descriptor}}
+
+	_, matched, err := pd.filterCredentialsThatMatchDescriptor([]*verifiable.Credential{credential}, descriptor, nil,
+		documentLoader, matchOpts)
+	if err != nil {
+		return false, err
+	}
+
+	return len(matched) != 0, nil
+}
+
+// UnsatisfiedDescriptors returns the input descriptors of the presentation definition which none of the credentials
+// match, in the order of the presentation definition, e.g. for a wallet to prompt the user to obtain the missing
+// credentials. Every input descriptor is matched on its own, the same way CreateVP matches the credentials
+// against it (with the format of the presentation definition), but the submission requirements are not
+// evaluated: see MatchSubmissionRequirement to tell whether the presentation definition can be satisfied.
+//
+// The credentials are neither framed nor limited by limit_disclosure or predicates, as only the matches are returned.
+func (pd *PresentationDefinition) UnsatisfiedDescriptors(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) ([]*InputDescriptor, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.noFrame = true
+	matchOpts.noLimitDisclosure = true
+
+	credentials, err := matchOpts.prepareCredentials(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := pd.filterCredentialsThatMatchDescriptors(credentials, pd.InputDescriptors, nil,
+		documentLoader, matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var unsatisfied []*InputDescriptor
+
+	for i, match := range matches {
+		if len(match.filtered) == 0 {
+			unsatisfied = append(unsatisfied, pd.InputDescriptors[i])
+		}
+	}
+
+	return unsatisfied, nil
+}
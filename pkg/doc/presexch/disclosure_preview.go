@@ -0,0 +1,132 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/piprate/json-gold/ld"
+	"github.com/tidwall/gjson"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// DisclosedField is a field of the credential which is disclosed to the verifier.
+type DisclosedField struct {
+	// Path is the path of the field in the credential (e.g. "credentialSubject.degree.type").
+	Path string
+	// Name is the name of the field of the input descriptor selecting the value, if any.
+	Name  string
+	Value interface{}
+}
+
+// DisclosurePreview describes what is disclosed to the verifier of the credential matched by the input descriptor.
+type DisclosurePreview struct {
+	DescriptorID string
+	CredentialID string
+	// Limited is true if only the Fields (along with the credential metadata such as id, type, issuer
+	// and subject ID) are disclosed. Otherwise, the whole credential is disclosed.
+	Limited bool
+	// Fields are the fields selected by the input descriptor constraints along with the disclosed values.
+	// The value of a field with the required predicate is disclosed as true.
+	Fields []*DisclosedField
+}
+
+// PreviewDisclosure matches the credentials against the presentation definition the same way CreateVP does
+// and returns, per matched credential, the fields which would be disclosed under limit_disclosure.
+// Neither the presentation is created nor the selective disclosure (BBS+ or SD-JWT) is applied,
+// so the preview can be shown to the holder before they consent to present the credentials.
+func (pd *PresentationDefinition) PreviewDisclosure(credentials []*verifiable.Credential,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) ([]*DisclosurePreview, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.noLimitDisclosure = true
+
+	_, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := make(map[string]*InputDescriptor, len(pd.InputDescriptors))
+	for _, descriptor := range pd.InputDescriptors {
+		descriptors[descriptor.ID] = descriptor
+	}
+
+	descriptorIDs := make([]string, 0, len(result))
+	for descriptorID := range result {
+		descriptorIDs = append(descriptorIDs, descriptorID)
+	}
+
+	sort.Strings(descriptorIDs)
+
+	var previews []*DisclosurePreview
+
+	for _, descriptorID := range descriptorIDs {
+		for _, credential := range result[descriptorID] {
+			preview, err := previewDisclosure(descriptors[descriptorID].Constraints, credential)
+			if err != nil {
+				return nil, fmt.Errorf("preview disclosure of credential %s: %w", credential.ID, err)
+			}
+
+			preview.DescriptorID = descriptorID
+
+			previews = append(previews, preview)
+		}
+	}
+
+	return previews, nil
+}
+
+func previewDisclosure(constraints *Constraints, credential *verifiable.Credential) (*DisclosurePreview, error) {
+	preview := &DisclosurePreview{CredentialID: credential.ID}
+
+	if constraints == nil {
+		return preview, nil
+	}
+
+	preview.Limited = shouldLimitDisclosure(constraints.LimitDisclosure, credential)
+
+	credentialWithFieldValues := credential
+
+	if credential.SDJWTHashAlg != "" {
+		var err error
+
+		credentialWithFieldValues, err = credential.CreateDisplayCredential(verifiable.DisplayAllDisclosures())
+		if err != nil {
+			return nil, fmt.Errorf("create display credential: %w", err)
+		}
+	}
+
+	src, err := marshalWithoutJWT(credentialWithFieldValues)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range constraints.Fields {
+		jPaths, err := getJSONPaths(f.Path, src)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range jPaths {
+			if strings.Contains(path[0], credentialSchema) {
+				continue
+			}
+
+			var val interface{} = true
+
+			if !f.Predicate.isRequired() {
+				val = gjson.GetBytes(src, path[1]).Value()
+			}
+
+			preview.Fields = append(preview.Fields, &DisclosedField{Path: path[1], Name: f.Name, Value: val})
+		}
+	}
+
+	return preview, nil
+}
@@ -0,0 +1,239 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	jsonutil "github.com/hyperledger/aries-framework-go/pkg/doc/util/json"
+)
+
+// PresentationExchangeMediaType is the media type of the presentation definition and presentation submission
+// envelopes, e.g. for the Content-Type and Accept headers of the HTTP exchanges.
+const PresentationExchangeMediaType = "application/presentation-exchange+json"
+
+// DefinitionEnvelope is the JSON object wrapping a presentation definition into its presentation_definition
+// property.
+type DefinitionEnvelope struct {
+	PresentationDefinition *PresentationDefinition `json:"presentation_definition"`
+	// CustomFields holds the other properties of the envelope (e.g. of an OpenID4VP authorization request),
+	// which are written back as is.
+	CustomFields map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON marshals DefinitionEnvelope to JSON, including the properties from CustomFields.
+func (e *DefinitionEnvelope) MarshalJSON() ([]byte, error) {
+	type Alias DefinitionEnvelope
+
+	data, err := jsonutil.MarshalWithCustomFields((*Alias)(e), e.CustomFields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal DefinitionEnvelope: %w", err)
+	}
+
+	return data, nil
+}
+
+// UnmarshalJSON unmarshals DefinitionEnvelope from JSON. The properties other than presentation_definition
+// are put to CustomFields.
+func (e *DefinitionEnvelope) UnmarshalJSON(data []byte) error {
+	type Alias DefinitionEnvelope
+
+	e.CustomFields = make(map[string]interface{})
+
+	err := jsonutil.UnmarshalWithCustomFields(data, (*Alias)(e), e.CustomFields)
+	if err != nil {
+		return fmt.Errorf("unmarshal DefinitionEnvelope: %w", err)
+	}
+
+	if len(e.CustomFields) == 0 {
+		e.CustomFields = nil
+	}
+
+	return nil
+}
+
+// ParseDefinitionFromReader reads the presentation definition envelope and validates its presentation definition
+// with ValidateSchema. A bare presentation definition is read as the envelope of no other properties. The unknown
+// properties of the presentation definition itself are not allowed by its schema, and are not kept.
+//
+// The JSON must not exceed MaxDefinitionSize.
+func ParseDefinitionFromReader(r io.Reader, opts ...ValidateSchemaOpt) (*DefinitionEnvelope, error) {
+	raw, err := readEnvelope(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapper map[string]json.RawMessage
+
+	if err = json.Unmarshal(raw, &wrapper); err != nil {
+		return nil, fmt.Errorf("unmarshal presentation definition: %w", err)
+	}
+
+	envelope := &DefinitionEnvelope{}
+
+	if _, ok := wrapper[definitionProperty]; ok {
+		err = json.Unmarshal(raw, envelope)
+	} else {
+		envelope.PresentationDefinition = &PresentationDefinition{}
+		err = json.Unmarshal(raw, envelope.PresentationDefinition)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal presentation definition: %w", err)
+	}
+
+	if envelope.PresentationDefinition == nil {
+		return nil, fmt.Errorf("missing '%s'", definitionProperty)
+	}
+
+	if err = envelope.PresentationDefinition.ValidateSchema(opts...); err != nil {
+		return nil, fmt.Errorf("validate presentation definition: %w", err)
+	}
+
+	return envelope, nil
+}
+
+// WriteDefinition writes the JSON of the envelope, i.e. its presentation definition wrapped into
+// the presentation_definition property along with the other properties of the envelope.
+func (e *DefinitionEnvelope) WriteDefinition(w io.Writer) error {
+	if e.PresentationDefinition == nil {
+		return fmt.Errorf("missing '%s'", definitionProperty)
+	}
+
+	return writeEnvelope(w, e)
+}
+
+// SubmissionEnvelope is the JSON object wrapping a presentation submission into its presentation_submission
+// property.
+type SubmissionEnvelope struct {
+	PresentationSubmission *PresentationSubmission `json:"presentation_submission"`
+	// CustomFields holds the other properties of the envelope (e.g. the vp_token of an OpenID4VP response),
+	// which are written back as is.
+	CustomFields map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON marshals SubmissionEnvelope to JSON, including the properties from CustomFields.
+func (e *SubmissionEnvelope) MarshalJSON() ([]byte, error) {
+	type Alias SubmissionEnvelope
+
+	data, err := jsonutil.MarshalWithCustomFields((*Alias)(e), e.CustomFields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal SubmissionEnvelope: %w", err)
+	}
+
+	return data, nil
+}
+
+// UnmarshalJSON unmarshals SubmissionEnvelope from JSON. The properties other than presentation_submission
+// are put to CustomFields.
+func (e *SubmissionEnvelope) UnmarshalJSON(data []byte) error {
+	type Alias SubmissionEnvelope
+
+	e.CustomFields = make(map[string]interface{})
+
+	err := jsonutil.UnmarshalWithCustomFields(data, (*Alias)(e), e.CustomFields)
+	if err != nil {
+		return fmt.Errorf("unmarshal SubmissionEnvelope: %w", err)
+	}
+
+	if len(e.CustomFields) == 0 {
+		e.CustomFields = nil
+	}
+
+	return nil
+}
+
+// ParseSubmissionFromReader reads the presentation submission envelope and validates its presentation submission:
+// the id, the definition_id and the descriptor_map are required, and so are the id, the format and the path
+// of the input descriptor mappings (and of the nested ones).
+//
+// The JSON must not exceed MaxDefinitionSize.
+func ParseSubmissionFromReader(r io.Reader) (*SubmissionEnvelope, error) {
+	raw, err := readEnvelope(r)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &SubmissionEnvelope{}
+
+	if err = json.Unmarshal(raw, envelope); err != nil {
+		return nil, fmt.Errorf("unmarshal presentation submission: %w", err)
+	}
+
+	if envelope.PresentationSubmission == nil {
+		return nil, fmt.Errorf("missing '%s'", submissionProperty)
+	}
+
+	if err = envelope.PresentationSubmission.validate(); err != nil {
+		return nil, fmt.Errorf("validate presentation submission: %w", err)
+	}
+
+	return envelope, nil
+}
+
+// WriteSubmission writes the JSON of the envelope, i.e. its presentation submission wrapped into
+// the presentation_submission property along with the other properties of the envelope.
+func (e *SubmissionEnvelope) WriteSubmission(w io.Writer) error {
+	if e.PresentationSubmission == nil {
+		return fmt.Errorf("missing '%s'", submissionProperty)
+	}
+
+	return writeEnvelope(w, e)
+}
+
+func (ps *PresentationSubmission) validate() error {
+	if ps.ID == "" {
+		return errors.New("missing 'id'")
+	}
+
+	if ps.DefinitionID == "" {
+		return errors.New("missing 'definition_id'")
+	}
+
+	if ps.DescriptorMap == nil {
+		return fmt.Errorf("missing '%s'", descriptorMapProperty)
+	}
+
+	for i, mapping := range ps.DescriptorMap {
+		for ; mapping != nil; mapping = mapping.PathNested {
+			if mapping.ID == "" || mapping.Format == "" || mapping.Path == "" {
+				return fmt.Errorf("%s[%d]: id, format and path are required", descriptorMapProperty, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+func readEnvelope(r io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, MaxDefinitionSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	if len(raw) > MaxDefinitionSize {
+		return nil, fmt.Errorf("envelope exceeds %d bytes", MaxDefinitionSize)
+	}
+
+	return raw, nil
+}
+
+func writeEnvelope(w io.Writer, envelope json.Marshaler) error {
+	data, err := envelope.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
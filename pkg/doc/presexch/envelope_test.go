@@ -0,0 +1,114 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+)
+
+func TestParseDefinitionFromReader(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample_1.json")
+	require.NoError(t, err)
+
+	t.Run("Round trip", func(t *testing.T) {
+		src := `{"presentation_definition":` + string(sample) + `,"client_id":"verifier","nonce":"n-0S6_WzA2Mj"}`
+
+		envelope, err := ParseDefinitionFromReader(strings.NewReader(src))
+		require.NoError(t, err)
+		require.NotNil(t, envelope.PresentationDefinition)
+		require.Equal(t, map[string]interface{}{"client_id": "verifier", "nonce": "n-0S6_WzA2Mj"},
+			envelope.CustomFields)
+
+		var buf bytes.Buffer
+		require.NoError(t, envelope.WriteDefinition(&buf))
+
+		var expected, actual map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(src), &expected))
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &actual))
+		require.Equal(t, expected, actual)
+	})
+
+	t.Run("Bare definition", func(t *testing.T) {
+		envelope, err := ParseDefinitionFromReader(bytes.NewReader(sample))
+		require.NoError(t, err)
+		require.NotNil(t, envelope.PresentationDefinition)
+		require.Nil(t, envelope.CustomFields)
+
+		var buf bytes.Buffer
+		require.NoError(t, envelope.WriteDefinition(&buf))
+
+		var wrapped map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &wrapped))
+		require.Len(t, wrapped, 1)
+		require.Contains(t, wrapped, "presentation_definition")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := ParseDefinitionFromReader(strings.NewReader(`{"presentation_definition":{"input_descriptors":[]}}`))
+		require.ErrorContains(t, err, "validate presentation definition")
+
+		_, err = ParseDefinitionFromReader(strings.NewReader(`{"presentation_definition":null}`))
+		require.EqualError(t, err, "missing 'presentation_definition'")
+
+		_, err = ParseDefinitionFromReader(strings.NewReader(`{`))
+		require.ErrorContains(t, err, "unmarshal presentation definition")
+
+		_, err = ParseDefinitionFromReader(strings.NewReader(strings.Repeat(" ", MaxDefinitionSize+1) + "{}"))
+		require.ErrorContains(t, err, "exceeds")
+
+		require.EqualError(t, (&DefinitionEnvelope{}).WriteDefinition(&bytes.Buffer{}),
+			"missing 'presentation_definition'")
+	})
+}
+
+func TestParseSubmissionFromReader(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		src := `{"presentation_submission":{"id":"submission","definition_id":"definition","descriptor_map":[` +
+			`{"id":"descriptor","format":"ldp_vp","path":"$",` +
+			`"path_nested":{"id":"descriptor","format":"ldp_vc","path":"$.verifiableCredential[0]"}}]},` +
+			`"vp_token":"token","state":"af0ifjsldkj"}`
+
+		envelope, err := ParseSubmissionFromReader(strings.NewReader(src))
+		require.NoError(t, err)
+		require.Equal(t, "definition", envelope.PresentationSubmission.DefinitionID)
+		require.Equal(t, map[string]interface{}{"vp_token": "token", "state": "af0ifjsldkj"}, envelope.CustomFields)
+
+		var buf bytes.Buffer
+		require.NoError(t, envelope.WriteSubmission(&buf))
+
+		var expected, actual map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(src), &expected))
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &actual))
+		require.Equal(t, expected, actual)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for src, msg := range map[string]string{
+			`{}`: "missing 'presentation_submission'",
+			`{"presentation_submission":{"definition_id":"definition","descriptor_map":[]}}`: "missing 'id'",
+			`{"presentation_submission":{"id":"submission","descriptor_map":[]}}`:            "missing 'definition_id'",
+			`{"presentation_submission":{"id":"submission","definition_id":"definition"}}`:   "missing 'descriptor_map'",
+			`{"presentation_submission":{"id":"submission","definition_id":"definition","descriptor_map":[` +
+				`{"id":"descriptor","format":"ldp_vp","path":"$","path_nested":{"id":"descriptor"}}]}}`: "descriptor_map[0]",
+			`{`: "unmarshal presentation submission",
+		} {
+			_, err := ParseSubmissionFromReader(strings.NewReader(src))
+			require.ErrorContains(t, err, msg)
+		}
+
+		require.EqualError(t, (&SubmissionEnvelope{}).WriteSubmission(&bytes.Buffer{}),
+			"missing 'presentation_submission'")
+	})
+}
@@ -0,0 +1,114 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// filterExpired removes the credentials expired before the time of the clock (see WithClock), recording
+// the rejections of the input descriptor. With WithStrictJWTParsing, a credential JWT which cannot be parsed
+// fails with ErrUnparsableJWT.
+func filterExpired(descriptorID string, credentials []*verifiable.Credential,
+	opts *matchRequirementsOpts) ([]*verifiable.Credential, error) {
+	if !opts.rejectExpired {
+		return credentials, nil
+	}
+
+	var result []*verifiable.Credential
+
+	for _, credential := range credentials {
+		if err := checkExpiration(credential, opts); err != nil {
+			if errors.Is(err, ErrUnparsableJWT) {
+				return nil, err
+			}
+
+			opts.rejections.add(&CredentialRejection{
+				CredentialID: credential.ID,
+				DescriptorID: descriptorID,
+				Stage:        ExpirationStage,
+				FieldIndex:   -1,
+				Reason:       err,
+			})
+
+			continue
+		}
+
+		result = append(result, credential)
+	}
+
+	return result, nil
+}
+
+// checkExpiration returns an error if the credential is expired at the time of the clock: if the expirationDate
+// of VC Data Model 1.1, the validUntil of VC Data Model 2.0 or the exp claim of the credential JWT is before it.
+// A credential with a validUntil which is not a date is considered expired.
+func checkExpiration(credential *verifiable.Credential, opts *matchRequirementsOpts) error {
+	now := opts.currentTime()
+
+	if credential.Expired != nil && credential.Expired.Time.Before(now) {
+		return fmt.Errorf("credential expired at %s", credential.Expired.Time.Format(time.RFC3339))
+	}
+
+	if validUntil, ok := credential.CustomFields["validUntil"]; ok {
+		s, _ := validUntil.(string)
+
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return fmt.Errorf("invalid validUntil %v: %w", validUntil, err)
+		}
+
+		if t.Before(now) {
+			return fmt.Errorf("credential is valid until %s", s)
+		}
+	}
+
+	exp, ok, err := jwtExpiration(credential, opts)
+	if err != nil {
+		return err
+	}
+
+	if ok && exp.Before(now) {
+		return fmt.Errorf("credential JWT expired at %s", exp.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// jwtExpiration returns the exp claim of the credential JWT (the issuer-signed JWT of an SD-JWT), if any.
+func jwtExpiration(credential *verifiable.Credential, opts *matchRequirementsOpts) (time.Time, bool, error) {
+	if credential.JWT == "" {
+		return time.Time{}, false, nil
+	}
+
+	token, err := jwt.Parse(strings.Split(credential.JWT, "~")[0], jwt.WithSignatureVerifier(&noVerifier{}))
+	if err != nil {
+		if opts.strictJWT {
+			return time.Time{}, false, fmt.Errorf("%w: credential %s: %s", ErrUnparsableJWT, credential.ID, err)
+		}
+
+		opts.log().Warnf("parse credential %s JWT: %s", credential.ID, err)
+
+		return time.Time{}, false, nil
+	}
+
+	var claims struct {
+		Expiry *float64 `json:"exp"`
+	}
+
+	if err = token.DecodeClaims(&claims); err != nil || claims.Expiry == nil {
+		return time.Time{}, false, nil
+	}
+
+	return time.Unix(int64(*claims.Expiry), 0), true, nil
+}
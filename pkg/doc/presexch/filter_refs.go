@@ -0,0 +1,183 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// definitionKeywords are the keywords holding the subschemas the filters reference locally, e.g. "#/$defs/adult".
+var definitionKeywords = []string{"$defs", "definitions"} // nolint: gochecknoglobals
+
+// FilterRefLoader loads the JSON schema document referenced by an external $ref of a field filter, i.e. a $ref
+// which is not a JSON pointer into the filter itself (e.g. "https://example.com/schemas/age.json"), e.g. with
+// an HTTP client applying its own policy (allowed hosts, timeouts, caching).
+type FilterRefLoader func(ref string) ([]byte, error)
+
+// WithFilterRefLoader resolves the external $refs of the field filters with the given loader, once per document
+// and filter. By default, the filters may only reference their own subschemas, e.g. {"$ref": "#/$defs/adult"}
+// with the adult subschema defined in the $defs of the filter, and a field with a filter referencing an external
+// document is not satisfied, so that matching never fetches the documents a presentation definition refers to.
+func WithFilterRefLoader(loader FilterRefLoader) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.loadFilterRef = loader
+	}
+}
+
+// newFilterSchema compiles the JSON schema of a filter (or of its subschema), loading the documents of its
+// external $refs with the loader.
+func newFilterSchema(schema interface{}, loader FilterRefLoader) (*gojsonschema.Schema, error) {
+	document, err := toGenericJSON(schema)
+	if err != nil {
+		return nil, fmt.Errorf("filter schema: %w", err)
+	}
+
+	return compileSchema(document, nil, loader)
+}
+
+// compileSchema compiles the JSON schema document, loading the documents of its external $refs with the loader,
+// so that gojsonschema never fetches them itself. The $refs of the document loaded from the URL id (nil for
+// the filters) are resolved against it.
+func compileSchema(document interface{}, id *url.URL, loader FilterRefLoader) (*gojsonschema.Schema, error) {
+	schemaLoader := gojsonschema.NewSchemaLoader()
+	root := gojsonschema.NewGoLoader(document)
+	loaded := map[string]struct{}{}
+
+	if id != nil {
+		if err := schemaLoader.AddSchema(id.String(), root); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", id, err)
+		}
+
+		loaded[id.String()] = struct{}{}
+		root = gojsonschema.NewReferenceLoader(id.String())
+	}
+
+	refs := externalRefs(document, id, nil)
+
+	for len(refs) > 0 {
+		ref := refs[0]
+		refs = refs[1:]
+
+		ref.Fragment = ""
+		ref.RawFragment = ""
+
+		documentURL := ref.String()
+		if _, ok := loaded[documentURL]; ok {
+			continue
+		}
+
+		if loader == nil {
+			return nil, fmt.Errorf("filter schema: external $ref %s is not allowed", documentURL)
+		}
+
+		referenced, err := loadFilterRef(documentURL, loader)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = schemaLoader.AddSchema(documentURL, gojsonschema.NewGoLoader(referenced)); err != nil {
+			return nil, fmt.Errorf("schema $ref %s: %w", documentURL, err)
+		}
+
+		loaded[documentURL] = struct{}{}
+		refs = append(refs, externalRefs(referenced, ref, nil)...)
+	}
+
+	return schemaLoader.Compile(root)
+}
+
+func loadFilterRef(documentURL string, loader FilterRefLoader) (interface{}, error) {
+	raw, err := loader(documentURL)
+	if err != nil {
+		return nil, fmt.Errorf("load schema $ref %s: %w", documentURL, err)
+	}
+
+	var referenced interface{}
+
+	if err = json.Unmarshal(raw, &referenced); err != nil {
+		return nil, fmt.Errorf("unmarshal schema $ref %s: %w", documentURL, err)
+	}
+
+	return referenced, nil
+}
+
+// externalRefs appends the $refs of the document which are not JSON pointers into the document itself,
+// resolved against the URL of the document (nil for the filter).
+func externalRefs(document interface{}, base *url.URL, refs []*url.URL) []*url.URL {
+	switch v := document.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			ref, ok := value.(string)
+			if key != "$ref" || !ok {
+				refs = externalRefs(value, base, refs)
+
+				continue
+			}
+
+			if strings.HasPrefix(ref, "#") && base == nil {
+				continue
+			}
+
+			u, err := url.Parse(ref)
+			if err != nil {
+				// gojsonschema reports the invalid $ref on compiling.
+				continue
+			}
+
+			if base != nil {
+				u = base.ResolveReference(u)
+			}
+
+			refs = append(refs, u)
+		}
+	case []interface{}:
+		for _, value := range v {
+			refs = externalRefs(value, base, refs)
+		}
+	}
+
+	return refs
+}
+
+// toGenericJSON converts the schema to the generic JSON representation (maps, slices and scalars).
+func toGenericJSON(schema interface{}) (interface{}, error) {
+	src, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+
+	if err = json.Unmarshal(src, &document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// containsSchema returns the contains subschema of the filter along with the subschemas the filter defines,
+// for the local $refs of the contains subschema to resolve when it is compiled on its own.
+func (f *Filter) containsSchema() map[string]interface{} {
+	schema := make(map[string]interface{}, len(f.Contains)+len(definitionKeywords))
+
+	for _, keyword := range definitionKeywords {
+		if definitions, ok := f.CustomFields[keyword]; ok {
+			schema[keyword] = definitions
+		}
+	}
+
+	for k, v := range f.Contains {
+		schema[k] = v
+	}
+
+	return schema
+}
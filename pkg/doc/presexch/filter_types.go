@@ -0,0 +1,38 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// unmarshalTypes puts the array form of the type keyword to Types and returns the filter JSON without it,
+// for the alias to unmarshal the single type only.
+func (f *Filter) unmarshalTypes(data []byte) ([]byte, error) {
+	f.Types = nil
+
+	types := gjson.GetBytes(data, "type")
+	if !types.IsArray() {
+		return data, nil
+	}
+
+	if err := json.Unmarshal([]byte(types.Raw), &f.Types); err != nil {
+		return nil, fmt.Errorf("unmarshal Filter type: %w", err)
+	}
+
+	f.Type = nil
+
+	if f.Types == nil {
+		f.Types = []string{}
+	}
+
+	return sjson.DeleteBytes(data, "type")
+}
@@ -0,0 +1,43 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+)
+
+// validateScalars checks that the bounds of the filter (including formatMinimum and formatMaximum) are scalars,
+// for a hostile definition not to turn them into JSON schemas of nested objects or arrays. The const and enum
+// values may be any JSON values, as JSON Schema allows.
+func (f *Filter) validateScalars() error {
+	for _, value := range []struct {
+		keyword string
+		value   StrOrInt
+	}{
+		{"minimum", f.Minimum},
+		{"maximum", f.Maximum},
+		{"exclusiveMinimum", f.ExclusiveMinimum},
+		{"exclusiveMaximum", f.ExclusiveMaximum},
+		{"formatMinimum", f.CustomFields["formatMinimum"]},
+		{"formatMaximum", f.CustomFields["formatMaximum"]},
+	} {
+		if !isScalar(value.value) {
+			return &FilterValueError{Keyword: value.keyword, Value: value.value}
+		}
+	}
+
+	return nil
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case nil, string, float64, json.Number, bool:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	// FilterFormatEmail is the JSON Schema format of e-mail addresses (e.g. "john@example.com").
+	FilterFormatEmail = "email"
+	// FilterFormatURI is the JSON Schema format of absolute URIs (e.g. "did:example:123").
+	FilterFormatURI = "uri"
+	// FilterFormatUUID is the JSON Schema format of UUIDs (e.g. "f81d4fae-7dec-11d0-a765-00a0c91e6bf6").
+	FilterFormatUUID = "uuid"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// stringFormats are the string formats the filters assert. The other formats are annotations only
+// (or are up to gojsonschema), so that the definitions with unknown formats keep matching.
+var stringFormats = map[string]func(string) bool{
+	FilterFormatEmail: func(value string) bool {
+		// mail.ParseAddress accepts the name-addr form as well, e.g. "John <john@example.com>".
+		addr, err := mail.ParseAddress(value)
+
+		return err == nil && addr.Name == "" && addr.Address == value
+	},
+	FilterFormatURI: func(value string) bool {
+		u, err := url.Parse(value)
+
+		return err == nil && u.Scheme != ""
+	},
+	FilterFormatDateTime: func(value string) bool {
+		_, err := time.Parse(time.RFC3339Nano, value)
+
+		return err == nil
+	},
+	FilterFormatUUID: uuidRegexp.MatchString,
+}
+
+// hasStringFormat checks whether the filter has one of the string formats asserted by validateStringFormat.
+func (f *Filter) hasStringFormat() bool {
+	if f == nil {
+		return false
+	}
+
+	_, ok := stringFormats[f.Format]
+
+	return ok
+}
+
+// withoutFormat returns a copy of the filter without the format, which is checked by validateStringFormat instead
+// of the process-wide gojsonschema format checkers.
+func (f *Filter) withoutFormat() *Filter {
+	filter := *f
+	filter.Format = ""
+
+	return &filter
+}
+
+// validateStringFormat checks that the string value conforms to the format of the filter.
+// As in JSON Schema, the format does not apply to the values of other types.
+func validateStringFormat(f *Filter, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return nil
+	}
+
+	if !stringFormats[f.Format](str) {
+		return fmt.Errorf("%w: %s does not match format %s", errPathNotApplicable, str, f.Format)
+	}
+
+	return nil
+}
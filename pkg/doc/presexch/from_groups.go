@@ -0,0 +1,135 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// WithFromGroupLists lets the from of a submission requirement list several groups separated by commas, e.g.
+// {"rule": "all", "from": "A,B"} requires all the input descriptors of the group A or B, which otherwise takes
+// a from_nested requirement per group. This is an extension of Presentation Exchange, whose from names a single
+// group; the spaces around the group names are trimmed. By default, the from is a single group name, which may
+// contain commas. The array form of from (e.g. {"from": ["A", "B"]}, see SubmissionRequirement.FromGroups) lists
+// the groups whether this option is set or not, as it can not be mistaken for a group name.
+//
+// Pass the option to ValidateSemantics and ResolveRequirements as well, for them to check and resolve
+// the requirements the way they are matched. DefinitionBuilder checks the from as a single group name.
+func WithFromGroupLists() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.fromGroupLists = true
+	}
+}
+
+// hasFrom tells whether the submission requirement selects the input descriptors of groups, rather than
+// the nested requirements.
+func (sr *SubmissionRequirement) hasFrom() bool {
+	return sr.From != "" || len(sr.FromGroups) != 0
+}
+
+// fromName returns the from of the submission requirement as it is named in the errors.
+func (sr *SubmissionRequirement) fromName() string {
+	if len(sr.FromGroups) != 0 {
+		return strings.Join(sr.FromGroups, ",")
+	}
+
+	return sr.From
+}
+
+// fromGroups returns the groups the from of a submission requirement selects the input descriptors of.
+func (opts *matchRequirementsOpts) fromGroups(sr *SubmissionRequirement) []string {
+	if len(sr.FromGroups) != 0 {
+		return sr.FromGroups
+	}
+
+	if !opts.fromGroupLists {
+		return []string{sr.From}
+	}
+
+	var groups []string
+
+	for _, group := range strings.Split(sr.From, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// fromDescriptors returns the input descriptors of the groups the from of a submission requirement selects.
+func (opts *matchRequirementsOpts) fromDescriptors(sr *SubmissionRequirement,
+	descriptors []*InputDescriptor) []*InputDescriptor {
+	groups := opts.fromGroups(sr)
+
+	var result []*InputDescriptor
+
+	for _, descriptor := range descriptors {
+		if descriptor != nil && containsAny(descriptor.Group, groups) {
+			result = append(result, descriptor)
+		}
+	}
+
+	return result
+}
+
+func containsAny(data, elements []string) bool {
+	for _, e := range elements {
+		if contains(data, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarshalJSON marshals SubmissionRequirement to JSON, with the from in the array form if FromGroups is set.
+func (sr *SubmissionRequirement) MarshalJSON() ([]byte, error) {
+	type Alias SubmissionRequirement
+
+	data, err := json.Marshal((*Alias)(sr))
+	if err != nil {
+		return nil, fmt.Errorf("marshal SubmissionRequirement: %w", err)
+	}
+
+	if len(sr.FromGroups) == 0 {
+		return data, nil
+	}
+
+	return sjson.SetBytes(data, "from", sr.FromGroups)
+}
+
+// UnmarshalJSON unmarshals SubmissionRequirement from JSON, putting the array form of the from to FromGroups.
+func (sr *SubmissionRequirement) UnmarshalJSON(data []byte) error {
+	type Alias SubmissionRequirement
+
+	sr.FromGroups = nil
+
+	if from := gjson.GetBytes(data, "from"); from.IsArray() {
+		if err := json.Unmarshal([]byte(from.Raw), &sr.FromGroups); err != nil {
+			return fmt.Errorf("unmarshal SubmissionRequirement from: %w", err)
+		}
+
+		var err error
+
+		data, err = sjson.DeleteBytes(data, "from")
+		if err != nil {
+			return fmt.Errorf("unmarshal SubmissionRequirement from: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, (*Alias)(sr)); err != nil {
+		return fmt.Errorf("unmarshal SubmissionRequirement: %w", err)
+	}
+
+	return nil
+}
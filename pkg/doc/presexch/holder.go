@@ -0,0 +1,150 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+const credentialSubjectKey = "credentialSubject"
+
+// holderBinding is the is_holder directive with its field_id resolved to the indexes of the constraints fields.
+type holderBinding struct {
+	required     bool
+	fieldIndexes []int
+}
+
+// resolveHolderBindings resolves the field_id of the is_holder directives to the fields of the same constraints.
+// The directives which are neither required nor preferred are skipped.
+func resolveHolderBindings(constraints *Constraints) ([]*holderBinding, error) {
+	var bindings []*holderBinding
+
+	for _, holder := range constraints.IsHolder {
+		if holder == nil || (!holder.Directive.isRequired() && !holder.Directive.isPreferred()) {
+			continue
+		}
+
+		binding := &holderBinding{required: holder.Directive.isRequired()}
+
+		for _, id := range holder.FieldID {
+			idx := fieldIndexByID(constraints.Fields, id)
+			if idx < 0 {
+				return nil, fmt.Errorf("is_holder: field_id %s is not defined in constraints fields", id)
+			}
+
+			binding.fieldIndexes = append(binding.fieldIndexes, idx)
+		}
+
+		bindings = append(bindings, binding)
+	}
+
+	return bindings, nil
+}
+
+func fieldIndexByID(fields []*Field, id string) int {
+	for i, field := range fields {
+		if field.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// checkIsHolder checks that the holder is the subject of the fields referenced by the is_holder directives,
+// i.e. that the ID of the credential subject the field belongs to equals the holder DID. Both are compared
+// as normalized DIDs (see normalizeDID), e.g. the holder DID may be the DID URL of the holder key.
+// A failed preferred directive is ignored. If a required directive fails, the index of the field which
+// is not bound to the holder is returned along with the error.
+func checkIsHolder(bindings []*holderBinding, fields []*Field, credentialSrc []byte,
+	opts *matchRequirementsOpts) (int, error) {
+	for _, binding := range bindings {
+		for _, idx := range binding.fieldIndexes {
+			err := checkFieldSubject(fields[idx], credentialSrc, opts.holderDID)
+			if err == nil {
+				continue
+			}
+
+			if binding.required {
+				return idx, err
+			}
+
+			opts.log().Debugf("is_holder preferred directive is not satisfied: %s", err)
+		}
+	}
+
+	return -1, nil
+}
+
+func checkFieldSubject(field *Field, credentialSrc []byte, holderDID string) error {
+	if holderDID == "" {
+		return fmt.Errorf("field %s: holder DID is not set", field.ID)
+	}
+
+	for _, path := range field.Path {
+		ids, err := fieldSubjectIDs(path, credentialSrc)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.ID, err)
+		}
+
+		for _, id := range ids {
+			if normalizeDID(id) == normalizeDID(holderDID) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("field %s: holder is not the subject", field.ID)
+}
+
+// fieldSubjectIDs returns the IDs of the credential subjects the values selected by the path belong to.
+// Values outside of credentialSubject have no subject, so an empty ID is returned for them.
+func fieldSubjectIDs(path string, credentialSrc []byte) ([]string, error) {
+	var credential interface{}
+	if err := json.Unmarshal(credentialSrc, &credential); err != nil {
+		return nil, err
+	}
+
+	matches, err := jsonPathMatches([]string{path}, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = subjectID(m.keys, credentialSrc)
+	}
+
+	return ids, nil
+}
+
+// subjectID returns the ID of the credential subject the value with the given keys belongs to.
+// Both a single subject and an array of subjects (e.g. credentialSubject[1].name) are supported.
+func subjectID(keys []interface{}, credentialSrc []byte) string {
+	if len(keys) == 0 || keys[0] != credentialSubjectKey {
+		return ""
+	}
+
+	subjectPath := credentialSubjectKey
+
+	if len(keys) > 1 {
+		if idx, ok := keys[1].(int); ok {
+			subjectPath += "." + strconv.Itoa(idx)
+		}
+	}
+
+	subject := gjson.GetBytes(credentialSrc, subjectPath)
+	if subject.Type == gjson.String {
+		return subject.String()
+	}
+
+	return subject.Get("id").String()
+}
@@ -0,0 +1,149 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"errors"
+	"strings"
+)
+
+// issuerIDPaths map the paths selecting the issuer ID (joined by ".") to the path of the issuer: the issuer
+// as a string or its id property as an object, of the credential or of the vc claim of the JWT credentials.
+var issuerIDPaths = map[string]string{ // nolint: gochecknoglobals
+	"issuer":       "$.issuer",
+	"issuer.id":    "$.issuer",
+	"vc.issuer":    "$.vc.issuer",
+	"vc.issuer.id": "$.vc.issuer",
+}
+
+// fieldValue returns the value selected by the path of a field, see fieldValues.
+func fieldValue(path string, credential map[string]interface{}) (interface{}, error) {
+	values, err := fieldValues(path, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	return values[0], nil
+}
+
+// fieldValues returns the values the filter of a field is validated against for the path, in order: the value
+// the path selects and, for the paths selecting the issuer ID (see issuerPath) which do not select a string,
+// the issuer ID however the issuer is serialized, so that a single filter (e.g. {"enum": ["did:example:issuer1",
+// "did:example:issuer2"]}) allow-lists the issuers of all the credentials. A single JSON-LD context serialized
+// as a string is matched as an array as well, see contextValues.
+func fieldValues(path string, credential map[string]interface{}) ([]interface{}, error) {
+	value, err := jsonPathValue(path, credential)
+
+	if s, ok := value.(string); ok && err == nil {
+		if isContextPath(path) {
+			return contextValues(s), nil
+		}
+
+		return []interface{}{value}, nil
+	}
+
+	issuer, ok := issuerPath(path)
+	if !ok {
+		return []interface{}{value}, err
+	}
+
+	id, idErr := issuerID(issuer, credential)
+
+	switch {
+	case err != nil && idErr != nil:
+		return nil, err
+	case err != nil:
+		return []interface{}{id}, nil
+	case idErr != nil:
+		return []interface{}{value}, nil
+	default:
+		return []interface{}{value, id}, nil
+	}
+}
+
+// issuerPath returns the path of the issuer if the path is one of $.issuer, $.issuer.id, $.vc.issuer or
+// $.vc.issuer.id (in the dot or the bracket notation).
+func issuerPath(path string) (string, bool) {
+	names, ok := simplePath(path)
+	if !ok {
+		return "", false
+	}
+
+	issuer, ok := issuerIDPaths[names]
+
+	return issuer, ok
+}
+
+// simplePath returns the names of the path joined by "." if the path selects a single property of nested
+// objects, e.g. "vc.issuer" for $.vc.issuer or $['vc']['issuer'].
+func simplePath(path string) (string, bool) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return "", false
+	}
+
+	names := make([]string, len(p.segments))
+
+	for i, s := range p.segments {
+		simple := !s.descendant && !s.wildcard && len(s.indexes) == 0 && s.slice == nil && s.filter == nil
+		if !simple || len(s.names) != 1 {
+			return "", false
+		}
+
+		names[i] = s.names[0]
+	}
+
+	return strings.Join(names, "."), true
+}
+
+// issuerID returns the ID of the issuer selected by the path, serialized either as a string or as an object.
+func issuerID(path string, credential map[string]interface{}) (string, error) {
+	issuer, err := jsonPathValue(path, credential)
+	if err != nil {
+		return "", err
+	}
+
+	switch issuer := issuer.(type) {
+	case string:
+		return issuer, nil
+	case map[string]interface{}:
+		if id, ok := issuer["id"].(string); ok {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("no issuer ID in the credential")
+}
+
+// issuerDisclosurePaths returns the paths disclosing the values the paths of a field are validated against:
+// a path selecting the issuer ID which selects no value discloses the issuer, see fieldValues.
+func issuerDisclosurePaths(paths []string, doc interface{}) []string {
+	var result []string
+
+	for i, path := range paths {
+		issuer, ok := issuerPath(path)
+		if !ok {
+			continue
+		}
+
+		if _, err := jsonPathValue(path, doc); err == nil {
+			continue
+		}
+
+		if result == nil {
+			result = append([]string{}, paths...)
+		}
+
+		result[i] = issuer
+	}
+
+	if result == nil {
+		return paths
+	}
+
+	return result
+}
@@ -0,0 +1,561 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+)
+
+// jsonPath is a parsed JSONPath. It is the only JSONPath evaluator used for the constraints fields, so that
+// a path matched while filtering the credentials resolves to the same values while limiting disclosure.
+//
+// Supported are the root ($), child (.name, ['name'], ["name"]), wildcard (.*, [*]), recursive descent (..),
+// index and union ([0], [0,1], ['a','b']), slice ([start:end:step]) and filter ([?(expression)]) selectors.
+// The filter expressions are evaluated by PaesslerAG/jsonpath (with the gval text and arithmetic operators,
+// e.g. =~ for the regular expressions) with @ referring to the current element. Unlike in PaesslerAG/jsonpath,
+// the single-quoted strings are allowed both in the names and in the filter expressions.
+type jsonPath struct {
+	segments []*pathSegment
+}
+
+type pathSegment struct {
+	descendant bool
+	wildcard   bool
+	names      []string
+	indexes    []int
+	slice      *pathSlice
+	filter     gval.Evaluable
+}
+
+type pathSlice struct {
+	start, end *int
+	step       int
+}
+
+// pathMatch is the value matched by JSONPath along with the keys (object keys and array indexes) leading to it.
+type pathMatch struct {
+	keys  []interface{}
+	value interface{}
+}
+
+// jsonPathValue returns the value selected by the path the same way PaesslerAG/jsonpath does: the value itself
+// for a definite path (an error if there is no such value), or the list of matched values otherwise.
+func jsonPathValue(path string, doc interface{}) (interface{}, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := p.eval(doc)
+
+	if !p.definite() {
+		values := make([]interface{}, len(matches))
+		for i, m := range matches {
+			values[i] = m.value
+		}
+
+		return values, nil
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no value at path %s", path)
+	}
+
+	return matches[0].value, nil
+}
+
+// jsonPathMatches returns the values matched by the paths ordered by their location in the document.
+func jsonPathMatches(paths []string, doc interface{}) ([]*pathMatch, error) {
+	var matches []*pathMatch
+
+	for _, path := range paths {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, p.eval(doc)...)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareKeys(matches[i].keys, matches[j].keys) < 0
+	})
+
+	return matches, nil
+}
+
+func compareKeys(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ai, aIsIndex := a[i].(int)
+		bi, bIsIndex := b[i].(int)
+
+		switch {
+		case aIsIndex && bIsIndex && ai != bi:
+			if ai < bi {
+				return -1
+			}
+
+			return 1
+		case !aIsIndex && !bIsIndex && a[i] != b[i]:
+			return strings.Compare(fmt.Sprint(a[i]), fmt.Sprint(b[i]))
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// definite checks whether the path selects at most one value.
+func (p *jsonPath) definite() bool {
+	for _, s := range p.segments {
+		if s.descendant || s.wildcard || s.filter != nil || s.slice != nil || len(s.names)+len(s.indexes) != 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *jsonPath) eval(doc interface{}) []*pathMatch {
+	matches := []*pathMatch{{value: doc}}
+
+	for _, segment := range p.segments {
+		var next []*pathMatch
+
+		for _, m := range matches {
+			nodes := []*pathMatch{m}
+			if segment.descendant {
+				nodes = descendants(m)
+			}
+
+			for _, node := range nodes {
+				next = append(next, segment.selectFrom(node)...)
+			}
+		}
+
+		matches = next
+	}
+
+	return matches
+}
+
+func (s *pathSegment) selectFrom(node *pathMatch) []*pathMatch {
+	switch {
+	case s.wildcard:
+		return children(node)
+	case s.filter != nil:
+		var matches []*pathMatch
+
+		for _, child := range children(node) {
+			if filterMatches(s.filter, child.value) {
+				matches = append(matches, child)
+			}
+		}
+
+		return matches
+	case s.slice != nil:
+		return s.slice.selectFrom(node)
+	}
+
+	var matches []*pathMatch
+
+	if obj, ok := node.value.(map[string]interface{}); ok {
+		for _, name := range s.names {
+			if v, exists := obj[name]; exists {
+				matches = append(matches, node.child(name, v))
+			}
+		}
+	}
+
+	if arr, ok := node.value.([]interface{}); ok {
+		for _, idx := range s.indexes {
+			if idx < 0 {
+				idx += len(arr)
+			}
+
+			if idx >= 0 && idx < len(arr) {
+				matches = append(matches, node.child(idx, arr[idx]))
+			}
+		}
+	}
+
+	return matches
+}
+
+// selectFrom selects the elements of the array as RFC 9535 (section 2.3.4.2) does: the negative bounds count
+// from the end of the array, a negative step selects the elements in the reverse order and a zero step selects
+// nothing.
+func (s *pathSlice) selectFrom(node *pathMatch) []*pathMatch {
+	arr, ok := node.value.([]interface{})
+	if !ok || s.step == 0 {
+		return nil
+	}
+
+	lower, upper := s.bounds(len(arr))
+
+	var matches []*pathMatch
+
+	if s.step > 0 {
+		for i := lower; i < upper; i += s.step {
+			matches = append(matches, node.child(i, arr[i]))
+		}
+	} else {
+		for i := upper; lower < i; i += s.step {
+			matches = append(matches, node.child(i, arr[i]))
+		}
+	}
+
+	return matches
+}
+
+// bounds returns the lower and the upper bounds of the slice of an array of the given length. The lower bound is
+// inclusive and the upper one exclusive for a positive step, the other way round for a negative one.
+func (s *pathSlice) bounds(length int) (int, int) {
+	if s.step > 0 {
+		start, end := 0, length
+
+		if s.start != nil {
+			start = clamp(normalizeIndex(*s.start, length), 0, length)
+		}
+
+		if s.end != nil {
+			end = clamp(normalizeIndex(*s.end, length), 0, length)
+		}
+
+		return start, end
+	}
+
+	start, end := length-1, -1
+
+	if s.start != nil {
+		start = clamp(normalizeIndex(*s.start, length), -1, length-1)
+	}
+
+	if s.end != nil {
+		end = clamp(normalizeIndex(*s.end, length), -1, length-1)
+	}
+
+	return end, start
+}
+
+func normalizeIndex(i, length int) int {
+	if i < 0 {
+		return i + length
+	}
+
+	return i
+}
+
+func clamp(n, lower, upper int) int {
+	if n < lower {
+		return lower
+	}
+
+	if n > upper {
+		return upper
+	}
+
+	return n
+}
+
+func filterMatches(filter gval.Evaluable, value interface{}) bool {
+	result, err := filter(context.Background(), []interface{}{value})
+	if err != nil {
+		return false
+	}
+
+	matched, ok := result.([]interface{})
+
+	return ok && len(matched) != 0
+}
+
+func (m *pathMatch) child(key, value interface{}) *pathMatch {
+	keys := make([]interface{}, len(m.keys), len(m.keys)+1)
+	copy(keys, m.keys)
+
+	return &pathMatch{keys: append(keys, key), value: value}
+}
+
+// children returns the elements of the array in the document order or the members of the object ordered by
+// the key, as the order of the members is not kept once the object is decoded.
+func children(node *pathMatch) []*pathMatch {
+	var result []*pathMatch
+
+	switch v := node.value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			result = append(result, node.child(k, v[k]))
+		}
+	case []interface{}:
+		for i, e := range v {
+			result = append(result, node.child(i, e))
+		}
+	}
+
+	return result
+}
+
+// descendants returns the node along with all its descendants in the document order.
+func descendants(node *pathMatch) []*pathMatch {
+	result := []*pathMatch{node}
+
+	for _, child := range children(node) {
+		result = append(result, descendants(child)...)
+	}
+
+	return result
+}
+
+func parseJSONPath(path string) (*jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid path %s: must start with $", path)
+	}
+
+	p := &jsonPath{}
+
+	for i := 1; i < len(path); {
+		segment := &pathSegment{}
+
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			segment.descendant = true
+			i += 2
+		case path[i] == '.':
+			i++
+		case path[i] != '[':
+			return nil, fmt.Errorf("invalid path %s: unexpected %q at %d", path, path[i], i)
+		}
+
+		var err error
+
+		if i < len(path) && path[i] == '[' {
+			i, err = parseBracket(path, i, segment)
+		} else {
+			i, err = parseName(path, i, segment)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %s: %w", path, err)
+		}
+
+		p.segments = append(p.segments, segment)
+	}
+
+	return p, nil
+}
+
+func parseName(path string, i int, segment *pathSegment) (int, error) {
+	end := i
+	for end < len(path) && path[end] != '.' && path[end] != '[' {
+		end++
+	}
+
+	name := path[i:end]
+
+	switch name {
+	case "":
+		return 0, fmt.Errorf("empty name at %d", i)
+	case "*":
+		segment.wildcard = true
+	default:
+		segment.names = []string{name}
+	}
+
+	return end, nil
+}
+
+func parseBracket(path string, i int, segment *pathSegment) (int, error) {
+	end, err := closingBracket(path, i)
+	if err != nil {
+		return 0, err
+	}
+
+	content := strings.TrimSpace(path[i+1 : end])
+
+	switch {
+	case content == "*":
+		segment.wildcard = true
+	case strings.HasPrefix(content, "?"):
+		segment.filter, err = gval.Full(jsonpath.Language()).NewEvaluable("$[" + doubleQuoted(content) + "]")
+		if err != nil {
+			return 0, fmt.Errorf("filter %s: %w", content, err)
+		}
+	case !isQuoted(content) && strings.Contains(content, ":"):
+		segment.slice, err = parseSlice(content)
+	default:
+		err = parseUnion(content, segment)
+	}
+
+	return end + 1, err
+}
+
+// closingBracket returns the index of the bracket closing the one at i, skipping quoted strings and parentheses.
+func closingBracket(path string, i int) (int, error) {
+	var (
+		quote byte
+		depth int
+	)
+
+	for j := i + 1; j < len(path); j++ {
+		c := path[j]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ']' && depth == 0:
+			return j, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unclosed bracket at %d", i)
+}
+
+func parseSlice(content string) (*pathSlice, error) {
+	parts := strings.Split(content, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid slice %s", content)
+	}
+
+	s := &pathSlice{step: 1}
+
+	bounds := []**int{&s.start, &s.end}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slice %s", content)
+		}
+
+		if i == len(bounds) {
+			s.step = n
+
+			continue
+		}
+
+		*bounds[i] = &n
+	}
+
+	return s, nil
+}
+
+func parseUnion(content string, segment *pathSegment) error {
+	for _, item := range splitUnion(content) {
+		item = strings.TrimSpace(item)
+
+		if isQuoted(item) {
+			segment.names = append(segment.names, item[1:len(item)-1])
+
+			continue
+		}
+
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return fmt.Errorf("invalid selector [%s]", content)
+		}
+
+		segment.indexes = append(segment.indexes, n)
+	}
+
+	return nil
+}
+
+// splitUnion splits the bracket content by the commas which are not quoted.
+func splitUnion(content string) []string {
+	var (
+		items []string
+		quote byte
+		start int
+	)
+
+	for j := 0; j < len(content); j++ {
+		c := content[j]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			items = append(items, content[start:j])
+			start = j + 1
+		}
+	}
+
+	return append(items, content[start:])
+}
+
+// doubleQuoted replaces the single-quoted strings of the expression with the double-quoted ones, as gval parses
+// the single-quoted strings as characters.
+func doubleQuoted(expr string) string {
+	var (
+		b     strings.Builder
+		quote byte
+		start int
+	)
+
+	for j := 0; j < len(expr); j++ {
+		c := expr[j]
+
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				b.WriteString(strconv.Quote(expr[start:j]))
+
+				quote = 0
+			}
+		case quote != 0:
+			b.WriteByte(c)
+
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'':
+			quote = c
+			start = j + 1
+		default:
+			if c == '"' {
+				quote = c
+			}
+
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]
+}
@@ -0,0 +1,109 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// jwtClaimPaths map the registered JWT claims of the JWT credentials to the paths of the credential properties
+// they are set from.
+var jwtClaimPaths = map[string]string{ // nolint: gochecknoglobals
+	"iss": "$.issuer",
+	"sub": "$.credentialSubject.id",
+	"exp": "$.expirationDate",
+	"nbf": "$.issuanceDate",
+	"jti": "$.id",
+}
+
+// vcClaimPrefixes are the prefixes of the paths selecting the vc claim of the JWT credentials.
+var vcClaimPrefixes = []string{"$.vc", "$['vc']", `$["vc"]`} // nolint: gochecknoglobals
+
+// withJWTClaims returns the JSON of a JWT credential along with its JWT claims, so that the fields select
+// the values whether the paths are the ones of the credential or of the JWT claims: the vc claim is the credential
+// itself, and iss, sub, exp, nbf and jti are set from the credential whatever the options it was parsed with
+// (exp and nbf are NumericDate, i.e. the seconds since the epoch). The properties of the credential are kept
+// when they have the names of the claims.
+func withJWTClaims(credential *verifiable.Credential, credentialMap map[string]interface{}) map[string]interface{} {
+	claims := make(map[string]interface{}, len(credentialMap)+len(jwtClaimPaths)+1)
+	for k, v := range credentialMap {
+		claims[k] = v
+	}
+
+	setClaim := func(name string, value interface{}) {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+
+	setClaim("vc", credentialMap)
+
+	if credential.Issuer.ID != "" {
+		setClaim("iss", credential.Issuer.ID)
+	}
+
+	if subjectID, err := verifiable.SubjectID(credential.Subject); err == nil && subjectID != "" {
+		setClaim("sub", subjectID)
+	}
+
+	if credential.Expired != nil {
+		setClaim("exp", float64(credential.Expired.Unix()))
+	}
+
+	if credential.Issued != nil {
+		setClaim("nbf", float64(credential.Issued.Unix()))
+	}
+
+	if credential.ID != "" {
+		setClaim("jti", credential.ID)
+	}
+
+	return claims
+}
+
+// credentialPath returns the path of the credential JSON selecting the value the path selects in the JWT claims
+// of the credential (see withJWTClaims), for the disclosure of the field to be limited to the credential
+// properties. The paths not selecting a JWT claim are returned as is.
+func credentialPath(path string) string {
+	for _, prefix := range vcClaimPrefixes {
+		rest := strings.TrimPrefix(path, prefix)
+		if rest != path && (rest == "" || rest[0] == '.' || rest[0] == '[') {
+			return "$" + rest
+		}
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil || len(p.segments) != 1 {
+		return path
+	}
+
+	s := p.segments[0]
+	if s.descendant || s.wildcard || len(s.names) != 1 || len(s.indexes) != 0 || s.slice != nil || s.filter != nil {
+		return path
+	}
+
+	if credentialPath, ok := jwtClaimPaths[s.names[0]]; ok {
+		return credentialPath
+	}
+
+	return path
+}
+
+// inCredential returns the field with the paths selecting the JWT claims replaced with the paths of the credential
+// properties (see credentialPath).
+func (f *Field) inCredential() *Field {
+	field := *f
+	field.Path = make([]string, len(f.Path))
+
+	for i, path := range f.Path {
+		field.Path[i] = credentialPath(path)
+	}
+
+	return &field
+}
@@ -0,0 +1,65 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import "strings"
+
+// LocalizedName returns the name of the presentation definition in the given locale (e.g. "fr-CA"), so that
+// the consent can be rendered in the language of the holder. If there is no translation for the locale,
+// the translation for its language (e.g. "fr") is returned, and otherwise the Name.
+func (pd *PresentationDefinition) LocalizedName(locale string) string {
+	return localize(pd.LocalizedNames, locale, pd.Name)
+}
+
+// LocalizedPurpose returns the purpose of the presentation definition in the given locale, falling back
+// the same way as LocalizedName.
+func (pd *PresentationDefinition) LocalizedPurpose(locale string) string {
+	return localize(pd.LocalizedPurposes, locale, pd.Purpose)
+}
+
+// LocalizedName returns the name of the input descriptor in the given locale, falling back the same way as
+// PresentationDefinition.LocalizedName.
+func (d *InputDescriptor) LocalizedName(locale string) string {
+	return localize(d.LocalizedNames, locale, d.Name)
+}
+
+// LocalizedPurpose returns the purpose of the input descriptor in the given locale, falling back the same way as
+// PresentationDefinition.LocalizedName.
+func (d *InputDescriptor) LocalizedPurpose(locale string) string {
+	return localize(d.LocalizedPurposes, locale, d.Purpose)
+}
+
+// localize looks the locale up in the translations. The locales are compared case-insensitively,
+// and "_" is accepted as the subtag separator.
+func localize(translations map[string]string, locale, fallback string) string {
+	if len(translations) == 0 || locale == "" {
+		return fallback
+	}
+
+	byLocale := make(map[string]string, len(translations))
+	for l, text := range translations {
+		byLocale[normalizeLocale(l)] = text
+	}
+
+	locale = normalizeLocale(locale)
+
+	if text, ok := byLocale[locale]; ok {
+		return text
+	}
+
+	if i := strings.Index(locale, "-"); i > 0 {
+		if text, ok := byLocale[locale[:i]]; ok {
+			return text
+		}
+	}
+
+	return fallback
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
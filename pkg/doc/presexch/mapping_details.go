@@ -0,0 +1,51 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+// MappingDetails describes the input descriptor which drove an entry of the descriptor_map of the presentation
+// submission, collected with WithMappingDetails.
+type MappingDetails struct {
+	DescriptorID string
+	Name         string
+	Purpose      string
+}
+
+// WithMappingDetails collects the name and the purpose of the input descriptors of the presentation submission
+// created by CreateVP (CreateVPs and the like), e.g. for the holder to log a self-describing submission.
+// The details are appended to the given slice in the order of the descriptor_map, one per entry, so that
+// (*details)[i] describes DescriptorMap[i]; CreateVPs appends the details of its presentations one after another.
+//
+// The presentation submission itself is left as the specification defines it.
+func WithMappingDetails(details *[]*MappingDetails) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.mappingDetails = details
+	}
+}
+
+// collectMappingDetails appends the details of the input descriptors of the descriptor map, if requested.
+func (pd *PresentationDefinition) collectMappingDetails(descriptorMap []*InputDescriptorMapping,
+	opts *matchRequirementsOpts) {
+	if opts.mappingDetails == nil {
+		return
+	}
+
+	descriptors := make(map[string]*InputDescriptor, len(pd.InputDescriptors))
+	for _, descriptor := range pd.InputDescriptors {
+		descriptors[descriptor.ID] = descriptor
+	}
+
+	for _, mapping := range descriptorMap {
+		details := &MappingDetails{DescriptorID: mapping.ID}
+
+		if descriptor, ok := descriptors[mapping.ID]; ok {
+			details.Name = descriptor.Name
+			details.Purpose = descriptor.Purpose
+		}
+
+		*opts.mappingDetails = append(*opts.mappingDetails, details)
+	}
+}
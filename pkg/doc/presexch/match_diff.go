@@ -0,0 +1,129 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// MatchDiff describes how the matches of a presentation definition changed, e.g. once the holder added credentials.
+type MatchDiff struct {
+	// Descriptors are the input descriptors whose matched VCs changed, ordered by ID.
+	Descriptors []*DescriptorMatchDiff
+	// SatisfiedBefore and SatisfiedAfter tell whether all the submission requirements could be satisfied.
+	SatisfiedBefore bool
+	SatisfiedAfter  bool
+}
+
+// BecameSatisfiable tells whether the submission requirements can be satisfied now, but could not before.
+func (d *MatchDiff) BecameSatisfiable() bool {
+	return !d.SatisfiedBefore && d.SatisfiedAfter
+}
+
+// BecameUnsatisfiable tells whether the submission requirements could be satisfied before, but cannot now.
+func (d *MatchDiff) BecameUnsatisfiable() bool {
+	return d.SatisfiedBefore && !d.SatisfiedAfter
+}
+
+// DescriptorMatchDiff describes how the VCs matched by an input descriptor changed.
+type DescriptorMatchDiff struct {
+	ID string
+	// Gained are the VCs matched after but not before, and Lost the VCs matched before but not after.
+	Gained []*verifiable.Credential
+	Lost   []*verifiable.Credential
+	// MatchedBefore and MatchedAfter tell whether the input descriptor matched any VC.
+	MatchedBefore bool
+	MatchedAfter  bool
+}
+
+// DiffMatched compares two results of MatchSubmissionRequirement for the same presentation definition. The VCs
+// are compared by ID, and the VCs an input descriptor matches in several requirements are merged. An empty result
+// (e.g. before any credential is matched) cannot be satisfied.
+func DiffMatched(before, after []*MatchedSubmissionRequirement) *MatchDiff {
+	matchedBefore := map[string][]*verifiable.Credential{}
+	collectMatchedVCs(before, matchedBefore)
+
+	matchedAfter := map[string][]*verifiable.Credential{}
+	collectMatchedVCs(after, matchedAfter)
+
+	diff := &MatchDiff{
+		SatisfiedBefore: matchedSatisfied(before),
+		SatisfiedAfter:  matchedSatisfied(after),
+	}
+
+	ids := make([]string, 0, len(matchedBefore)+len(matchedAfter))
+
+	for id := range matchedBefore {
+		ids = append(ids, id)
+	}
+
+	for id := range matchedAfter {
+		if _, ok := matchedBefore[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		descriptorDiff := &DescriptorMatchDiff{
+			ID:            id,
+			Gained:        subtractVCs(matchedAfter[id], matchedBefore[id]),
+			Lost:          subtractVCs(matchedBefore[id], matchedAfter[id]),
+			MatchedBefore: len(matchedBefore[id]) != 0,
+			MatchedAfter:  len(matchedAfter[id]) != 0,
+		}
+
+		if len(descriptorDiff.Gained) != 0 || len(descriptorDiff.Lost) != 0 {
+			diff.Descriptors = append(diff.Descriptors, descriptorDiff)
+		}
+	}
+
+	return diff
+}
+
+// collectMatchedVCs collects the VCs matched by the input descriptors of the requirements and the nested ones.
+func collectMatchedVCs(requirements []*MatchedSubmissionRequirement, matched map[string][]*verifiable.Credential) {
+	for _, req := range requirements {
+		for _, descriptor := range req.Descriptors {
+			matched[descriptor.ID] = append(matched[descriptor.ID],
+				subtractVCs(descriptor.MatchedVCs, matched[descriptor.ID])...)
+		}
+
+		collectMatchedVCs(req.Nested, matched)
+	}
+}
+
+// subtractVCs returns the VCs of a whose IDs are not the IDs of the VCs of b.
+func subtractVCs(a, b []*verifiable.Credential) []*verifiable.Credential {
+	ids := make(map[string]struct{}, len(b))
+	for _, vc := range b {
+		ids[trimTmpID(vc.ID)] = struct{}{}
+	}
+
+	var result []*verifiable.Credential
+
+	for _, vc := range a {
+		if _, ok := ids[trimTmpID(vc.ID)]; !ok {
+			result = append(result, vc)
+		}
+	}
+
+	return result
+}
+
+func matchedSatisfied(requirements []*MatchedSubmissionRequirement) bool {
+	for _, req := range requirements {
+		if !req.satisfied() {
+			return false
+		}
+	}
+
+	return len(requirements) != 0
+}
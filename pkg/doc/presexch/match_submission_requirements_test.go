@@ -85,6 +85,8 @@ func TestInstance_GetSubmissionRequirements(t *testing.T) {
 		require.Len(t, requirements, 1)
 
 		require.Len(t, requirements[0].Descriptors, 3)
+		require.Equal(t, 3, requirements[0].SelectCount)
+		require.Equal(t, 3, requirements[0].SelectFrom)
 
 		for _, desc := range requirements[0].Descriptors {
 			if desc.ID == driversLicenseVCType {
@@ -109,6 +111,8 @@ func TestInstance_GetSubmissionRequirements(t *testing.T) {
 		require.Len(t, requirements, 1)
 
 		require.Len(t, requirements[0].Descriptors, 3)
+		require.Equal(t, 1, requirements[0].SelectCount)
+		require.Equal(t, 3, requirements[0].SelectFrom)
 
 		for _, desc := range requirements[0].Descriptors {
 			if desc.ID == driversLicenseVCType {
@@ -134,19 +138,61 @@ func TestInstance_GetSubmissionRequirements(t *testing.T) {
 
 		require.Len(t, requirements[0].Descriptors, 0)
 		require.Len(t, requirements[0].Nested, 2)
+		require.Equal(t, 2, requirements[0].SelectCount)
+		require.Equal(t, 2, requirements[0].SelectFrom)
 
 		for _, req := range requirements[0].Nested {
 			if req.Name == "VerifiedEmployee or Degree" {
 				require.Len(t, req.Descriptors, 2)
+				require.Equal(t, 1, req.SelectCount)
+				require.Equal(t, 2, req.SelectFrom)
 			}
 
 			if req.Name == driversLicenseVCType {
 				require.Len(t, req.Descriptors, 1)
 				require.Len(t, req.Descriptors[0].MatchedVCs, 2)
+				require.Equal(t, 1, req.SelectCount)
+				require.Equal(t, 1, req.SelectFrom)
 			}
 		}
 	})
 
+	t.Run("Selection with min and max", func(t *testing.T) {
+		descriptor := func(vcType string) string {
+			return `{
+				"id": "` + vcType + `",
+				"group": ["A"],
+				"constraints": {"fields": [{
+					"path": ["$.type", "$.vc.type"],
+					"filter": {"type": "array", "contains": {"type": "string", "const": "` + vcType + `"}}
+				}]}
+			}`
+		}
+
+		pdQuery := &presexch.PresentationDefinition{}
+		err := json.Unmarshal([]byte(`{
+			"id": "pd",
+			"submission_requirements": [{"rule": "pick", "min": 2, "max": 3, "from": "A"}],
+			"input_descriptors": [`+descriptor("VerifiedEmployee")+`, `+descriptor(driversLicenseVCType)+`,
+				`+descriptor("Passport")+`]
+		}`), pdQuery)
+		require.NoError(t, err)
+
+		requirements, err := pdQuery.MatchSubmissionRequirement(
+			credentials,
+			docLoader,
+			verifiable.WithDisabledProofCheck(),
+			verifiable.WithJSONLDDocumentLoader(docLoader),
+		)
+
+		require.NoError(t, err)
+		require.Len(t, requirements, 1)
+		require.Len(t, requirements[0].Descriptors, 3)
+		require.Equal(t, 2, requirements[0].SelectCount)
+		require.Equal(t, 2, requirements[0].SelectFrom)
+		require.Equal(t, 3, requirements[0].Max)
+	})
+
 	t.Run("Checks schema", func(t *testing.T) {
 		pd := &presexch.PresentationDefinition{ID: uuid.New().String()}
 
@@ -216,3 +262,438 @@ func TestInstance_GetSubmissionRequirements(t *testing.T) {
 		require.Nil(t, result)
 	})
 }
+
+func TestInstance_MatchSubmissionRequirementDetailed(t *testing.T) {
+	docLoader := createTestJSONLDDocumentLoader(t)
+
+	issuerID := uuid.New().String()
+
+	pd := &presexch.PresentationDefinition{
+		ID: uuid.New().String(),
+		InputDescriptors: []*presexch.InputDescriptor{{
+			ID: "adult",
+			Constraints: &presexch.Constraints{
+				Fields: []*presexch.Field{{
+					Path: []string{"$.first_name"},
+				}, {
+					Path: []string{"$.age"},
+					Filter: &presexch.Filter{
+						Type:    &intFilterType,
+						Minimum: 18,
+					},
+				}},
+			},
+		}, {
+			ID: "self-issued",
+			Constraints: &presexch.Constraints{
+				SubjectIsIssuer: &subIsIssuerRequired,
+				Fields: []*presexch.Field{{
+					Path: []string{"$.first_name"},
+				}},
+			},
+		}, {
+			ID: "ldp",
+			Format: &presexch.Format{
+				LdpVC: &presexch.LdpType{ProofType: []string{"Ed25519Signature2018"}},
+			},
+		}},
+	}
+
+	teenager := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Subject: []verifiable.Subject{{ID: issuerID}},
+		Issuer:  verifiable.Issuer{ID: issuerID},
+		CustomFields: map[string]interface{}{
+			"first_name": "Jesse",
+			"age":        17,
+		},
+	}
+
+	anonymous := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Issuer:  verifiable.Issuer{ID: issuerID},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		requirements, rejections, err := pd.MatchSubmissionRequirementDetailed(
+			[]*verifiable.Credential{teenager, anonymous}, docLoader)
+		require.NoError(t, err)
+		require.Len(t, requirements, 1)
+
+		for _, desc := range requirements[0].Descriptors {
+			if desc.ID == "self-issued" {
+				require.Len(t, desc.MatchedVCs, 1)
+			} else {
+				require.Empty(t, desc.MatchedVCs)
+			}
+		}
+
+		byKey := map[string]*presexch.CredentialRejection{}
+		for _, rejection := range rejections {
+			byKey[rejection.DescriptorID+rejection.CredentialID] = rejection
+		}
+
+		require.Len(t, byKey, 5)
+
+		rejection := byKey["adult"+teenager.ID]
+		require.Equal(t, presexch.ConstraintsStage, rejection.Stage)
+		require.Equal(t, 1, rejection.FieldIndex)
+		require.Equal(t, []string{"$.age"}, rejection.FieldPath)
+		require.Contains(t, rejection.Reason.Error(), "Must be greater than or equal to 18")
+
+		rejection = byKey["adult"+anonymous.ID]
+		require.Equal(t, presexch.ConstraintsStage, rejection.Stage)
+		require.Equal(t, 0, rejection.FieldIndex)
+		require.Equal(t, []string{"$.first_name"}, rejection.FieldPath)
+
+		rejection = byKey["self-issued"+anonymous.ID]
+		require.Equal(t, presexch.ConstraintsStage, rejection.Stage)
+		require.Equal(t, -1, rejection.FieldIndex)
+		require.EqualError(t, rejection.Reason, "credential subject is not the issuer")
+
+		for _, cred := range []*verifiable.Credential{teenager, anonymous} {
+			rejection = byKey["ldp"+cred.ID]
+			require.Equal(t, presexch.FormatStage, rejection.Stage)
+			require.Equal(t, -1, rejection.FieldIndex)
+		}
+	})
+
+	t.Run("Schema rejection", func(t *testing.T) {
+		schemaPD := &presexch.PresentationDefinition{
+			ID: uuid.New().String(),
+			InputDescriptors: []*presexch.InputDescriptor{{
+				ID: "degree",
+				Schema: []*presexch.Schema{{
+					URI: "https://example.org/examples#UniversityDegreeCredential",
+				}},
+			}},
+		}
+
+		_, rejections, err := schemaPD.MatchSubmissionRequirementDetailed(
+			[]*verifiable.Credential{anonymous}, docLoader)
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+		require.Equal(t, presexch.SchemaStage, rejections[0].Stage)
+		require.Equal(t, anonymous.ID, rejections[0].CredentialID)
+		require.Contains(t, rejections[0].Reason.Error(), "UniversityDegreeCredential")
+	})
+
+	t.Run("Checks schema", func(t *testing.T) {
+		invalidPD := &presexch.PresentationDefinition{ID: uuid.New().String()}
+
+		requirements, rejections, err := invalidPD.MatchSubmissionRequirementDetailed(nil, nil)
+		require.EqualError(t, err, "presentation_definition: input_descriptors is required")
+		require.Nil(t, requirements)
+		require.Nil(t, rejections)
+	})
+}
+
+func TestPresentationDefinition_ResolveRequirements(t *testing.T) {
+	t.Run("Nested submission requirements", func(t *testing.T) {
+		pd := &presexch.PresentationDefinition{}
+		require.NoError(t, json.Unmarshal(nestedSubmissionRequirementsPD, pd))
+
+		requirements, err := pd.ResolveRequirements()
+		require.NoError(t, err)
+		require.Len(t, requirements, 1)
+
+		require.Equal(t, "Nested requirements", requirements[0].Name)
+		require.Equal(t, presexch.All, requirements[0].Rule)
+		require.Equal(t, 2, requirements[0].Count)
+		require.Empty(t, requirements[0].InputDescriptorIDs)
+		require.Len(t, requirements[0].Nested, 2)
+
+		require.Equal(t, presexch.Pick, requirements[0].Nested[0].Rule)
+		require.Equal(t, 1, requirements[0].Nested[0].Count)
+		require.Equal(t, []string{"VerifiedEmployee", "degree"}, requirements[0].Nested[0].InputDescriptorIDs)
+		require.Equal(t, []string{"DriversLicense"}, requirements[0].Nested[1].InputDescriptorIDs)
+	})
+
+	t.Run("No submission requirements", func(t *testing.T) {
+		pd := &presexch.PresentationDefinition{}
+		require.NoError(t, json.Unmarshal(presentationDefinition, pd))
+
+		requirements, err := pd.ResolveRequirements()
+		require.NoError(t, err)
+		require.Len(t, requirements, 1)
+		require.Equal(t, presexch.All, requirements[0].Rule)
+		require.Equal(t, len(pd.InputDescriptors), requirements[0].Count)
+		require.Len(t, requirements[0].InputDescriptorIDs, len(pd.InputDescriptors))
+	})
+
+	t.Run("No descriptors for from", func(t *testing.T) {
+		pd := &presexch.PresentationDefinition{
+			ID: uuid.New().String(),
+			SubmissionRequirements: []*presexch.SubmissionRequirement{{
+				Rule: presexch.All,
+				From: "teenager",
+			}},
+			InputDescriptors: []*presexch.InputDescriptor{{
+				ID:    uuid.New().String(),
+				Group: []string{"A"},
+			}},
+		}
+
+		requirements, err := pd.ResolveRequirements()
+		require.EqualError(t, err, "no descriptors for from: teenager")
+		require.Nil(t, requirements)
+	})
+
+	t.Run("Checks schema", func(t *testing.T) {
+		pd := &presexch.PresentationDefinition{ID: uuid.New().String()}
+
+		requirements, err := pd.ResolveRequirements()
+		require.EqualError(t, err, "presentation_definition: input_descriptors is required")
+		require.Nil(t, requirements)
+	})
+}
+
+func TestPresentationDefinition_MaxNestingDepth(t *testing.T) {
+	descriptors := []*presexch.InputDescriptor{{
+		ID:     uuid.New().String(),
+		Group:  []string{"A"},
+		Schema: []*presexch.Schema{{URI: verifiable.ContextURI}},
+	}}
+
+	for _, tc := range []struct {
+		name  string
+		depth int
+		opts  []presexch.MatchRequirementsOpt
+		err   string
+	}{
+		{name: "Default depth", depth: presexch.DefaultMaxNestingDepth},
+		{
+			name:  "Deeper than the default depth",
+			depth: presexch.DefaultMaxNestingDepth + 1,
+			err:   "submission requirements are nested deeper than 10 levels",
+		},
+		{
+			name:  "Custom depth",
+			depth: presexch.DefaultMaxNestingDepth + 5,
+			opts:  []presexch.MatchRequirementsOpt{presexch.WithMaxNestingDepth(presexch.DefaultMaxNestingDepth + 5)},
+		},
+		{
+			name:  "Deeper than the custom depth",
+			depth: 3,
+			opts:  []presexch.MatchRequirementsOpt{presexch.WithMaxNestingDepth(2)},
+			err:   "submission requirements are nested deeper than 2 levels",
+		},
+		{
+			name:  "Zero depth is ignored",
+			depth: 3,
+			opts:  []presexch.MatchRequirementsOpt{presexch.WithMaxNestingDepth(0)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pd := &presexch.PresentationDefinition{
+				ID:                     uuid.New().String(),
+				SubmissionRequirements: []*presexch.SubmissionRequirement{nestedRequirement(tc.depth, "A")},
+				InputDescriptors:       descriptors,
+			}
+
+			requirements, err := pd.ResolveRequirements(tc.opts...)
+			if tc.err == "" {
+				require.NoError(t, err)
+				require.Len(t, requirements, 1)
+
+				_, err = pd.MatchSubmissionRequirementWithOptions(nil, nil, tc.opts...)
+				require.NoError(t, err)
+
+				return
+			}
+
+			var depthErr *presexch.NestingDepthError
+			require.ErrorAs(t, err, &depthErr)
+			require.EqualError(t, err, tc.err)
+
+			_, err = pd.MatchSubmissionRequirementWithOptions(nil, nil, tc.opts...)
+			require.ErrorAs(t, err, &depthErr)
+
+			_, err = pd.CreateVPWithOptions(nil, nil, tc.opts...)
+			require.ErrorAs(t, err, &depthErr)
+		})
+	}
+
+	t.Run("No descriptors for nested from", func(t *testing.T) {
+		pd := &presexch.PresentationDefinition{
+			ID:                     uuid.New().String(),
+			SubmissionRequirements: []*presexch.SubmissionRequirement{nestedRequirement(3, "B")},
+			InputDescriptors:       descriptors,
+		}
+
+		_, err := pd.MatchSubmissionRequirement(nil, nil)
+		require.EqualError(t, err, "no descriptors for from: B")
+	})
+}
+
+// nestedRequirement returns the submission requirement of all the input descriptors of the group nested
+// with from_nested to the depth.
+func nestedRequirement(depth int, from string) *presexch.SubmissionRequirement {
+	requirement := &presexch.SubmissionRequirement{Rule: presexch.All, From: from}
+
+	for i := 1; i < depth; i++ {
+		requirement = &presexch.SubmissionRequirement{
+			Rule:       presexch.All,
+			FromNested: []*presexch.SubmissionRequirement{requirement},
+		}
+	}
+
+	return requirement
+}
+
+func TestInstance_MatchSubmissionRequirementFormat(t *testing.T) {
+	docLoader := createTestJSONLDDocumentLoader(t)
+
+	jwtVC, err := verifiable.ParseCredential(universityDegreeVC, verifiable.WithDisabledProofCheck(),
+		verifiable.WithJSONLDDocumentLoader(docLoader))
+	require.NoError(t, err)
+
+	ldpVC := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      uuid.New().String(),
+		Proofs:  []verifiable.Proof{{"type": "Ed25519Signature2018"}},
+	}
+
+	ldpFormat := &presexch.Format{LdpVC: &presexch.LdpType{ProofType: []string{"Ed25519Signature2018"}}}
+
+	pd := &presexch.PresentationDefinition{
+		ID:     uuid.New().String(),
+		Format: ldpFormat,
+		SubmissionRequirements: []*presexch.SubmissionRequirement{{
+			Name:   "jwt",
+			Rule:   presexch.All,
+			Format: &presexch.Format{JwtVC: &presexch.JwtType{Alg: []string{"ES256"}}},
+			FromNested: []*presexch.SubmissionRequirement{{
+				Rule: presexch.All,
+				From: "A",
+			}},
+		}, {
+			Name: "definition format",
+			Rule: presexch.All,
+			From: "B",
+		}},
+		InputDescriptors: []*presexch.InputDescriptor{{
+			ID:    "inherited",
+			Group: []string{"A"},
+		}, {
+			ID:     "own",
+			Group:  []string{"A"},
+			Format: ldpFormat,
+		}, {
+			ID:    "definition",
+			Group: []string{"B"},
+		}},
+	}
+
+	requirements, err := pd.MatchSubmissionRequirement([]*verifiable.Credential{jwtVC, ldpVC}, docLoader)
+	require.NoError(t, err)
+	require.Len(t, requirements, 2)
+
+	matched := map[string][]*verifiable.Credential{}
+
+	for _, desc := range requirements[0].Nested[0].Descriptors {
+		matched[desc.ID] = desc.MatchedVCs
+	}
+
+	for _, desc := range requirements[1].Descriptors {
+		matched[desc.ID] = desc.MatchedVCs
+	}
+
+	require.Equal(t, map[string][]*verifiable.Credential{
+		"inherited":  {jwtVC},
+		"own":        {ldpVC},
+		"definition": {ldpVC},
+	}, matched)
+}
+
+func TestDiffMatched(t *testing.T) {
+	license := &verifiable.Credential{ID: "urn:credential:license"}
+	degree := &verifiable.Credential{ID: "urn:credential:degree"}
+	limitedDegree := &verifiable.Credential{ID: "urn:credential:degreetmp_unique_id_1"}
+
+	newMatched := func(licenses, degrees []*verifiable.Credential) []*presexch.MatchedSubmissionRequirement {
+		nested := &presexch.MatchedSubmissionRequirement{
+			Rule:        presexch.Pick,
+			Count:       1,
+			SelectCount: 1,
+			Descriptors: []*presexch.MatchedInputDescriptor{{ID: "degree", MatchedVCs: degrees}},
+		}
+
+		if len(degrees) != 0 {
+			nested.SelectFrom = 1
+		}
+
+		req := &presexch.MatchedSubmissionRequirement{
+			Rule:        presexch.All,
+			SelectCount: 2,
+			Descriptors: []*presexch.MatchedInputDescriptor{{ID: "license", MatchedVCs: licenses}},
+			Nested:      []*presexch.MatchedSubmissionRequirement{nested},
+		}
+
+		if len(licenses) != 0 {
+			req.SelectFrom++
+		}
+
+		if nested.SelectFrom != 0 {
+			req.SelectFrom++
+		}
+
+		return []*presexch.MatchedSubmissionRequirement{req}
+	}
+
+	t.Run("Became satisfiable", func(t *testing.T) {
+		diff := presexch.DiffMatched(
+			newMatched([]*verifiable.Credential{license}, nil),
+			newMatched([]*verifiable.Credential{license}, []*verifiable.Credential{degree}),
+		)
+
+		require.True(t, diff.BecameSatisfiable())
+		require.False(t, diff.BecameUnsatisfiable())
+		require.Equal(t, []*presexch.DescriptorMatchDiff{{
+			ID:           "degree",
+			Gained:       []*verifiable.Credential{degree},
+			MatchedAfter: true,
+		}}, diff.Descriptors)
+	})
+
+	t.Run("Became unsatisfiable", func(t *testing.T) {
+		diff := presexch.DiffMatched(
+			newMatched([]*verifiable.Credential{license}, []*verifiable.Credential{degree}),
+			newMatched(nil, []*verifiable.Credential{degree}),
+		)
+
+		require.False(t, diff.BecameSatisfiable())
+		require.True(t, diff.BecameUnsatisfiable())
+		require.Equal(t, []*presexch.DescriptorMatchDiff{{
+			ID:            "license",
+			Lost:          []*verifiable.Credential{license},
+			MatchedBefore: true,
+		}}, diff.Descriptors)
+	})
+
+	t.Run("Limited VCs are compared by ID", func(t *testing.T) {
+		diff := presexch.DiffMatched(
+			newMatched([]*verifiable.Credential{license}, []*verifiable.Credential{degree}),
+			newMatched([]*verifiable.Credential{license}, []*verifiable.Credential{limitedDegree}),
+		)
+
+		require.True(t, diff.SatisfiedBefore)
+		require.True(t, diff.SatisfiedAfter)
+		require.Empty(t, diff.Descriptors)
+	})
+
+	t.Run("Nothing matched before", func(t *testing.T) {
+		diff := presexch.DiffMatched(nil,
+			newMatched([]*verifiable.Credential{license}, []*verifiable.Credential{degree}))
+
+		require.True(t, diff.BecameSatisfiable())
+		require.Len(t, diff.Descriptors, 2)
+		require.Equal(t, "degree", diff.Descriptors[0].ID)
+		require.Equal(t, "license", diff.Descriptors[1].ID)
+	})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// FormatMsoMdoc is the claim format of ISO/IEC 18013-5 mobile documents (mdoc), e.g. mobile driving licences.
+const FormatMsoMdoc = "mso_mdoc"
+
+// mdocProperty is the custom field of the credential wrapping an mdoc.
+const mdocProperty = "mso_mdoc"
+
+// errMdocPredicate is the rejection reason of mdoc credentials matched by an input descriptor with a required
+// predicate: the data elements of an mdoc are signed by the issuer, so their values can not be replaced with true.
+var errMdocPredicate = errors.New("predicate is not supported by mdoc credentials")
+
+// Mdoc is an ISO/IEC 18013-5 mobile document, e.g. a mobile driving licence. The CBOR of the document is decoded
+// by the caller, for the package not to depend on a CBOR library: the data elements are the decoded values of
+// the issuer-signed items of the document.
+//
+// An mdoc is matched like the other credentials once wrapped into one by NewMdocCredential. The paths of
+// the fields select the data elements by their name space and identifier, e.g. $['org.iso.18013.5.1']['family_name'],
+// and limit_disclosure limits the data elements of the mdoc to the ones selected by the fields.
+type Mdoc struct {
+	// DocType is the type of the document, e.g. org.iso.18013.5.1.mDL.
+	DocType string `json:"docType"`
+	// NameSpaces are the data elements of the document by their identifier, by name space.
+	NameSpaces map[string]map[string]interface{} `json:"nameSpaces"`
+	// Alg is the algorithm of the issuer signature of the mobile security object, e.g. ES256.
+	Alg string `json:"alg,omitempty"`
+	// Raw is the CBOR of the document, which the caller presents (e.g. in a device response) with the data
+	// elements of the matched mdoc. It is cleared when limit_disclosure limits the data elements, as it holds
+	// all of them: the caller encodes the disclosed data elements instead.
+	Raw []byte `json:"-"`
+}
+
+// NewMdocCredential wraps the mdoc into a credential, to be matched along with the other credentials. The type
+// of the credential is the type of the document, and its ID is random. The credentials matched by an input
+// descriptor with the mso_mdoc format are mapped with this format in the presentation submission, and MdocOf
+// returns their mdoc, with the disclosed data elements only if the input descriptor limits disclosure.
+func NewMdocCredential(doc *Mdoc) *verifiable.Credential {
+	return &verifiable.Credential{
+		ID:           "urn:uuid:" + uuid.New().String(),
+		Types:        []string{doc.DocType},
+		CustomFields: verifiable.CustomFields{mdocProperty: doc},
+	}
+}
+
+// MdocOf returns the mdoc wrapped into the credential by NewMdocCredential.
+func MdocOf(credential *verifiable.Credential) (*Mdoc, bool) {
+	doc, ok := credential.CustomFields[mdocProperty].(*Mdoc)
+
+	return doc, ok
+}
+
+func isMdoc(credential *verifiable.Credential) bool {
+	_, ok := MdocOf(credential)
+
+	return ok
+}
+
+// limitMdoc returns a copy of the mdoc credential with the data elements selected by the fields only.
+func limitMdoc(credential *verifiable.Credential, doc *Mdoc, fields []*Field) (*verifiable.Credential, error) {
+	limited := *doc
+	limited.NameSpaces = map[string]map[string]interface{}{}
+	limited.Raw = nil
+
+	nameSpaces := make(map[string]interface{}, len(doc.NameSpaces))
+
+	for nameSpace, elements := range doc.NameSpaces {
+		values := make(map[string]interface{}, len(elements))
+		for id, value := range elements {
+			values[id] = value
+		}
+
+		nameSpaces[nameSpace] = values
+	}
+
+	for _, field := range fields {
+		matches, err := jsonPathMatches(field.Path, nameSpaces)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matches {
+			if len(m.keys) < 2 {
+				continue
+			}
+
+			// the data elements are disclosed as a whole.
+			nameSpace, isNameSpace := m.keys[0].(string)
+			id, isID := m.keys[1].(string)
+
+			if !isNameSpace || !isID {
+				continue
+			}
+
+			if limited.NameSpaces[nameSpace] == nil {
+				limited.NameSpaces[nameSpace] = map[string]interface{}{}
+			}
+
+			limited.NameSpaces[nameSpace][id] = doc.NameSpaces[nameSpace][id]
+		}
+	}
+
+	result := *credential
+	result.CustomFields = verifiable.CustomFields{}
+
+	for k, v := range credential.CustomFields {
+		result.CustomFields[k] = v
+	}
+
+	result.CustomFields[mdocProperty] = &limited
+
+	return &result, nil
+}
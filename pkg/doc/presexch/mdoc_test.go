@@ -0,0 +1,151 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+func TestMdocCredential(t *testing.T) {
+	lddl := createTestJSONLDDocumentLoader(t)
+
+	const isoNameSpace = "org.iso.18013.5.1"
+
+	newMDL := func(alg string) *Mdoc {
+		return &Mdoc{
+			DocType: "org.iso.18013.5.1.mDL",
+			NameSpaces: map[string]map[string]interface{}{
+				isoNameSpace: {
+					"family_name":     "Doe",
+					"given_name":      "John",
+					"age_over_18":     true,
+					"document_number": "123456789",
+				},
+			},
+			Alg: alg,
+			Raw: []byte{0xa1},
+		}
+	}
+
+	required := Required
+
+	pd := &PresentationDefinition{
+		ID:     uuid.New().String(),
+		Format: &Format{MsoMdoc: &JwtType{Alg: []string{"ES256"}}},
+		InputDescriptors: []*InputDescriptor{{
+			ID: "org.iso.18013.5.1.mDL",
+			Constraints: &Constraints{
+				LimitDisclosure: &required,
+				Fields: []*Field{
+					{Path: []string{"$['org.iso.18013.5.1']['family_name']"}},
+					{
+						Path:   []string{"$['org.iso.18013.5.1']['age_over_18']"},
+						Filter: &Filter{Const: true},
+					},
+				},
+			},
+		}},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		mdl := NewMdocCredential(newMDL("ES256"))
+
+		vp, err := pd.CreateVP([]*verifiable.Credential{mdl, getTestVC()}, lddl)
+		require.NoError(t, err)
+		require.Len(t, vp.Credentials(), 1)
+
+		credential, ok := vp.Credentials()[0].(*verifiable.Credential)
+		require.True(t, ok)
+		require.Equal(t, mdl.ID, credential.ID)
+
+		doc, ok := MdocOf(credential)
+		require.True(t, ok)
+		require.Equal(t, "org.iso.18013.5.1.mDL", doc.DocType)
+		require.Nil(t, doc.Raw)
+		require.Equal(t, map[string]map[string]interface{}{
+			isoNameSpace: {"family_name": "Doe", "age_over_18": true},
+		}, doc.NameSpaces)
+
+		original, ok := MdocOf(mdl)
+		require.True(t, ok)
+		require.Len(t, original.NameSpaces[isoNameSpace], 4)
+		require.Equal(t, []byte{0xa1}, original.Raw)
+
+		ps, ok := vp.CustomFields["presentation_submission"].(*PresentationSubmission)
+		require.True(t, ok)
+		require.Len(t, ps.DescriptorMap, 1)
+		require.Equal(t, FormatMsoMdoc, ps.DescriptorMap[0].Format)
+		require.Equal(t, FormatMsoMdoc, ps.DescriptorMap[0].PathNested.Format)
+	})
+
+	t.Run("Without limit disclosure", func(t *testing.T) {
+		unlimited := &PresentationDefinition{
+			ID:     uuid.New().String(),
+			Format: &Format{MsoMdoc: &JwtType{Alg: []string{"ES256"}}},
+			InputDescriptors: []*InputDescriptor{{
+				ID: "org.iso.18013.5.1.mDL",
+				Constraints: &Constraints{
+					Fields: []*Field{{Path: []string{"$['org.iso.18013.5.1']['family_name']"}}},
+				},
+			}},
+		}
+
+		vp, err := unlimited.CreateVP([]*verifiable.Credential{NewMdocCredential(newMDL("ES256"))}, lddl)
+		require.NoError(t, err)
+
+		doc, ok := MdocOf(vp.Credentials()[0].(*verifiable.Credential))
+		require.True(t, ok)
+		require.Len(t, doc.NameSpaces[isoNameSpace], 4)
+	})
+
+	t.Run("Algorithm not accepted", func(t *testing.T) {
+		_, err := pd.CreateVP([]*verifiable.Credential{NewMdocCredential(newMDL("ES384"))}, lddl)
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Constraints not satisfied", func(t *testing.T) {
+		minor := newMDL("ES256")
+		minor.NameSpaces[isoNameSpace]["age_over_18"] = false
+
+		_, err := pd.CreateVP([]*verifiable.Credential{NewMdocCredential(minor)}, lddl)
+		require.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Predicate", func(t *testing.T) {
+		predicatePD := &PresentationDefinition{
+			ID:     uuid.New().String(),
+			Format: &Format{MsoMdoc: &JwtType{Alg: []string{"ES256"}}},
+			InputDescriptors: []*InputDescriptor{{
+				ID: "org.iso.18013.5.1.mDL",
+				Constraints: &Constraints{
+					LimitDisclosure: &required,
+					Fields: []*Field{{
+						Path:      []string{"$['org.iso.18013.5.1']['age_over_18']"},
+						Filter:    &Filter{Const: true},
+						Predicate: &required,
+					}},
+				},
+			}},
+		}
+
+		_, rejections, err := predicatePD.MatchSubmissionRequirementDetailed(
+			[]*verifiable.Credential{NewMdocCredential(newMDL("ES256"))}, lddl)
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+		require.EqualError(t, rejections[0].Reason, "predicate is not supported by mdoc credentials")
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		require.NoError(t, pd.ValidateSchema())
+	})
+}
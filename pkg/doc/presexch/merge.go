@@ -0,0 +1,412 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// MergedDescriptor maps an input descriptor of a merged presentation definition to the input descriptor
+// of the definition returned by MergeDefinitionsDetailed.
+type MergedDescriptor struct {
+	// Definition is the index of the merged presentation definition.
+	Definition   int
+	DefinitionID string
+	// OriginalID is the ID of the input descriptor in the merged presentation definition, and ID is its ID
+	// in the result. They differ if the ID collides with an input descriptor of a preceding definition.
+	OriginalID string
+	ID         string
+}
+
+// MergeDefinitions merges the presentation definitions into a single one, e.g. to combine the requirements
+// of multiple relying parties into one request, see MergeDefinitionsDetailed.
+func MergeDefinitions(defs ...*PresentationDefinition) (*PresentationDefinition, error) {
+	merged, _, err := MergeDefinitionsDetailed(defs...)
+
+	return merged, err
+}
+
+// MergeDefinitionsDetailed works like MergeDefinitions, but additionally returns the mapping of every input
+// descriptor of the merged presentation definitions to the input descriptor of the result.
+//
+// The input descriptors are concatenated in the order of the definitions. The input descriptor IDs, the group
+// names and the field IDs colliding with the ones of a preceding definition are suffixed with the index of
+// the definition (e.g. the input descriptor "passport" of the second definition becomes "passport_1"), along
+// with the references to them (from, is_holder and same_subject). The frame and the format of a definition are
+// moved to its input descriptors which have no frame (or format) of their own, so that an input descriptor accepts
+// the same formats as before the merge.
+//
+// The formats are united: the claim format designations of all the definitions are kept, and the algorithms
+// (or the proof types) of a designation specified by several definitions are intersected. An error is returned
+// if the intersection is empty. If any definition has submission requirements, the requirements of every
+// definition are wrapped into a requirement of the rule "all" (the one of a definition without submission
+// requirements is of all its input descriptors), so that all the definitions must be satisfied.
+//
+// The merged definitions are not modified. The result has a new ID and is validated with ValidateSchema.
+func MergeDefinitionsDetailed(defs ...*PresentationDefinition) (*PresentationDefinition, []*MergedDescriptor, error) {
+	if len(defs) == 0 {
+		return nil, nil, errors.New("no presentation definitions to merge")
+	}
+
+	merged := &PresentationDefinition{ID: uuid.New().String()}
+
+	var (
+		mapping []*MergedDescriptor
+		copies  []*PresentationDefinition
+		hasReqs bool
+	)
+
+	descriptorIDs, groups, fieldIDs := map[string]struct{}{}, map[string]struct{}{}, map[string]struct{}{}
+
+	for i, def := range defs {
+		if def == nil {
+			return nil, nil, fmt.Errorf("presentation definition %d is nil", i)
+		}
+
+		pd, err := copyDefinition(def)
+		if err != nil {
+			return nil, nil, fmt.Errorf("copy presentation definition %d: %w", i, err)
+		}
+
+		pd.renamespace(i, groups, fieldIDs)
+
+		merged.Format, err = mergeFormats(merged.Format, pd.Format)
+		if err != nil {
+			return nil, nil, fmt.Errorf("merge format of presentation definition %d: %w", i, err)
+		}
+
+		for _, descriptor := range pd.InputDescriptors {
+			id := uniqueName(descriptor.ID, i, descriptorIDs)
+
+			mapping = append(mapping, &MergedDescriptor{
+				Definition:   i,
+				DefinitionID: def.ID,
+				OriginalID:   descriptor.ID,
+				ID:           id,
+			})
+
+			descriptor.ID = id
+
+			if descriptor.Frame == nil {
+				descriptor.Frame = pd.Frame
+			}
+
+			if descriptor.Format == nil {
+				descriptor.Format = pd.Format
+			}
+		}
+
+		merged.InputDescriptors = append(merged.InputDescriptors, pd.InputDescriptors...)
+		merged.SameSubject = append(merged.SameSubject, pd.SameSubject...)
+
+		copies = append(copies, pd)
+		hasReqs = hasReqs || len(pd.SubmissionRequirements) != 0
+	}
+
+	if hasReqs {
+		for i, pd := range copies {
+			merged.SubmissionRequirements = append(merged.SubmissionRequirements, pd.allRequirement(i, groups))
+		}
+	}
+
+	if err := merged.ValidateSchema(); err != nil {
+		return nil, nil, fmt.Errorf("merged presentation definition: %w", err)
+	}
+
+	return merged, mapping, nil
+}
+
+func copyDefinition(pd *PresentationDefinition) (*PresentationDefinition, error) {
+	src, err := json.Marshal(pd)
+	if err != nil {
+		return nil, err
+	}
+
+	var result PresentationDefinition
+
+	if err = json.Unmarshal(src, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// renamespace renames the groups and the field IDs of the i-th merged definition which collide with the ones
+// of the preceding definitions, and marks its names as used.
+func (pd *PresentationDefinition) renamespace(i int, groups, fieldIDs map[string]struct{}) {
+	groupNames, fieldNames := map[string]string{}, map[string]string{}
+
+	for _, descriptor := range pd.InputDescriptors {
+		for _, group := range descriptor.Group {
+			groupNames[group] = group
+		}
+
+		if descriptor.Constraints == nil {
+			continue
+		}
+
+		for _, field := range descriptor.Constraints.Fields {
+			if field.ID != "" {
+				fieldNames[field.ID] = field.ID
+			}
+		}
+	}
+
+	renameAll(groupNames, i, groups)
+	renameAll(fieldNames, i, fieldIDs)
+
+	for _, descriptor := range pd.InputDescriptors {
+		for j, group := range descriptor.Group {
+			descriptor.Group[j] = groupNames[group]
+		}
+
+		if descriptor.Constraints == nil {
+			continue
+		}
+
+		for _, field := range descriptor.Constraints.Fields {
+			if field.ID != "" {
+				field.ID = fieldNames[field.ID]
+			}
+		}
+
+		for _, holder := range descriptor.Constraints.IsHolder {
+			renameReferences(holder.FieldID, fieldNames)
+		}
+	}
+
+	for _, group := range pd.SameSubject {
+		renameReferences(group.FieldID, fieldNames)
+	}
+
+	renameRequirementGroups(pd.SubmissionRequirements, groupNames)
+}
+
+// renameAll gives the names unique values, in the order of the names for the renaming to be deterministic.
+func renameAll(names map[string]string, i int, used map[string]struct{}) {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		names[name] = uniqueName(name, i, used)
+	}
+}
+
+// uniqueName returns the name suffixed with the index of the definition (and with a counter if it is used
+// as well) if the name is used, and marks the returned name as used.
+func uniqueName(name string, i int, used map[string]struct{}) string {
+	unique := name
+
+	if _, ok := used[unique]; ok {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+
+	for n := 2; ; n++ {
+		if _, ok := used[unique]; !ok {
+			break
+		}
+
+		unique = fmt.Sprintf("%s_%d_%d", name, i, n)
+	}
+
+	used[unique] = struct{}{}
+
+	return unique
+}
+
+func renameReferences(references []string, names map[string]string) {
+	for i, reference := range references {
+		if name, ok := names[reference]; ok {
+			references[i] = name
+		}
+	}
+}
+
+func renameRequirementGroups(requirements []*SubmissionRequirement, groups map[string]string) {
+	for _, requirement := range requirements {
+		if name, ok := groups[requirement.From]; ok {
+			requirement.From = name
+		}
+
+		renameReferences(requirement.FromGroups, groups)
+
+		renameRequirementGroups(requirement.FromNested, groups)
+	}
+}
+
+// allRequirement returns the requirement of the rule "all" of the submission requirements of the i-th merged
+// definition, or of all its input descriptors (which are given a new group) if it has no submission requirements.
+func (pd *PresentationDefinition) allRequirement(i int, groups map[string]struct{}) *SubmissionRequirement {
+	requirement := &SubmissionRequirement{
+		Name:       pd.Name,
+		Purpose:    pd.Purpose,
+		Rule:       All,
+		FromNested: pd.SubmissionRequirements,
+	}
+
+	if len(pd.SubmissionRequirements) != 0 {
+		return requirement
+	}
+
+	requirement.FromNested = nil
+	requirement.From = uniqueName(fmt.Sprintf("definition_%d", i), i, groups)
+
+	for _, descriptor := range pd.InputDescriptors {
+		descriptor.Group = append(descriptor.Group, requirement.From)
+	}
+
+	return requirement
+}
+
+func mergeFormats(a, b *Format) (*Format, error) {
+	if a == nil {
+		return b, nil
+	}
+
+	if b == nil {
+		return a, nil
+	}
+
+	var (
+		result Format
+		err    error
+	)
+
+	for _, designation := range []struct {
+		name         string
+		result, a, b **JwtType
+	}{
+		{FormatJWT, &result.Jwt, &a.Jwt, &b.Jwt},
+		{FormatJWTVC, &result.JwtVC, &a.JwtVC, &b.JwtVC},
+		{FormatJWTVP, &result.JwtVP, &a.JwtVP, &b.JwtVP},
+		{FormatMsoMdoc, &result.MsoMdoc, &a.MsoMdoc, &b.MsoMdoc},
+	} {
+		*designation.result, err = mergeJwtTypes(*designation.a, *designation.b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", designation.name, err)
+		}
+	}
+
+	for _, designation := range []struct {
+		name         string
+		result, a, b **LdpType
+	}{
+		{FormatLDP, &result.Ldp, &a.Ldp, &b.Ldp},
+		{FormatLDPVC, &result.LdpVC, &a.LdpVC, &b.LdpVC},
+		{FormatLDPVP, &result.LdpVP, &a.LdpVP, &b.LdpVP},
+	} {
+		*designation.result, err = mergeLdpTypes(*designation.a, *designation.b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", designation.name, err)
+		}
+	}
+
+	if result.SdJwt, err = mergeSdJwtTypes(a.SdJwt, b.SdJwt); err != nil {
+		return nil, fmt.Errorf("%s: %w", FormatSDJWT, err)
+	}
+
+	if result.DcSdJwt, err = mergeSdJwtTypes(a.DcSdJwt, b.DcSdJwt); err != nil {
+		return nil, fmt.Errorf("%s: %w", FormatDCSDJWT, err)
+	}
+
+	return &result, nil
+}
+
+func mergeJwtTypes(a, b *JwtType) (*JwtType, error) {
+	if a == nil || b == nil {
+		if a == nil {
+			return b, nil
+		}
+
+		return a, nil
+	}
+
+	alg, err := intersectValues("alg", a.Alg, b.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JwtType{Alg: alg}, nil
+}
+
+func mergeLdpTypes(a, b *LdpType) (*LdpType, error) {
+	if a == nil || b == nil {
+		if a == nil {
+			return b, nil
+		}
+
+		return a, nil
+	}
+
+	proofType, err := intersectValues("proof_type", a.ProofType, b.ProofType)
+	if err != nil {
+		return nil, err
+	}
+
+	cryptosuite, err := intersectValues("cryptosuite", a.Cryptosuite, b.Cryptosuite)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LdpType{ProofType: proofType, Cryptosuite: cryptosuite}, nil
+}
+
+func mergeSdJwtTypes(a, b *SdJwtType) (*SdJwtType, error) {
+	if a == nil || b == nil {
+		if a == nil {
+			return b, nil
+		}
+
+		return a, nil
+	}
+
+	sdJwtAlgValues, err := intersectValues("sd-jwt_alg_values", a.SdJwtAlgValues, b.SdJwtAlgValues)
+	if err != nil {
+		return nil, err
+	}
+
+	kbJwtAlgValues, err := intersectValues("kb-jwt_alg_values", a.KbJwtAlgValues, b.KbJwtAlgValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SdJwtType{SdJwtAlgValues: sdJwtAlgValues, KbJwtAlgValues: kbJwtAlgValues}, nil
+}
+
+// intersectValues returns the values of a which are in b as well. An empty list does not restrict the values.
+func intersectValues(name string, a, b []string) ([]string, error) {
+	if len(a) == 0 {
+		return b, nil
+	}
+
+	if len(b) == 0 {
+		return a, nil
+	}
+
+	var result []string
+
+	for _, value := range a {
+		if stringsContain(b, value) {
+			result = append(result, value)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no common %s: %v and %v", name, a, b)
+	}
+
+	return result, nil
+}
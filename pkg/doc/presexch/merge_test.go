@@ -0,0 +1,206 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+)
+
+func TestMergeDefinitions(t *testing.T) {
+	required := Required
+
+	newDescriptor := func(id string, fieldID string, groups ...string) *InputDescriptor {
+		return &InputDescriptor{
+			ID:    id,
+			Group: groups,
+			Constraints: &Constraints{
+				Fields: []*Field{{ID: fieldID, Path: []string{"$.credentialSubject." + id}}},
+			},
+		}
+	}
+
+	bank := &PresentationDefinition{
+		ID:      "bank",
+		Name:    "Bank",
+		Purpose: "Open an account",
+		Format: &Format{
+			JwtVC: &JwtType{Alg: []string{"ES256", "EdDSA"}},
+			LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+		},
+		SubmissionRequirements: []*SubmissionRequirement{{Rule: Pick, Count: 1, From: "A"}},
+		InputDescriptors: []*InputDescriptor{
+			newDescriptor("passport", "subject", "A"),
+			newDescriptor("license", "license_subject", "A"),
+		},
+		SameSubject: []*SameSubjectGroup{{FieldID: []string{"subject", "license_subject"}, Directive: &required}},
+	}
+
+	telco := &PresentationDefinition{
+		ID:    "telco",
+		Frame: map[string]interface{}{"@type": "VerifiableCredential"},
+		Format: &Format{
+			JwtVC: &JwtType{Alg: []string{"EdDSA", "ES384"}},
+			SdJwt: &SdJwtType{},
+		},
+		InputDescriptors: []*InputDescriptor{
+			newDescriptor("passport", "subject", "A"),
+			newDescriptor("address", "address_subject"),
+		},
+		SameSubject: []*SameSubjectGroup{{FieldID: []string{"subject", "address_subject"}, Directive: &required}},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		bankSrc, err := json.Marshal(bank)
+		require.NoError(t, err)
+
+		merged, mapping, err := MergeDefinitionsDetailed(bank, telco)
+		require.NoError(t, err)
+		require.NotEmpty(t, merged.ID)
+		require.NoError(t, merged.ValidateSchema())
+
+		require.Equal(t, []*MergedDescriptor{
+			{Definition: 0, DefinitionID: "bank", OriginalID: "passport", ID: "passport"},
+			{Definition: 0, DefinitionID: "bank", OriginalID: "license", ID: "license"},
+			{Definition: 1, DefinitionID: "telco", OriginalID: "passport", ID: "passport_1"},
+			{Definition: 1, DefinitionID: "telco", OriginalID: "address", ID: "address"},
+		}, mapping)
+
+		require.Len(t, merged.InputDescriptors, 4)
+
+		telcoPassport := merged.InputDescriptors[2]
+		require.Equal(t, "passport_1", telcoPassport.ID)
+		require.Equal(t, []string{"A_1", "definition_1"}, telcoPassport.Group)
+		require.Equal(t, "subject_1", telcoPassport.Constraints.Fields[0].ID)
+		require.Equal(t, telco.Frame, telcoPassport.Frame)
+		require.Nil(t, merged.InputDescriptors[0].Frame)
+
+		require.Equal(t, []*SameSubjectGroup{
+			{FieldID: []string{"subject", "license_subject"}, Directive: &required},
+			{FieldID: []string{"subject_1", "address_subject"}, Directive: &required},
+		}, merged.SameSubject)
+
+		require.Equal(t, []*SubmissionRequirement{{
+			Name:       "Bank",
+			Purpose:    "Open an account",
+			Rule:       All,
+			FromNested: []*SubmissionRequirement{{Rule: Pick, Count: 1, From: "A"}},
+		}, {
+			Rule: All,
+			From: "definition_1",
+		}}, merged.SubmissionRequirements)
+
+		require.Equal(t, &Format{
+			JwtVC: &JwtType{Alg: []string{"EdDSA"}},
+			LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+			SdJwt: &SdJwtType{},
+		}, merged.Format)
+
+		src, err := json.Marshal(bank)
+		require.NoError(t, err)
+		require.Equal(t, bankSrc, src)
+		require.Equal(t, "passport", telco.InputDescriptors[0].ID)
+	})
+
+	t.Run("Formats of the definitions", func(t *testing.T) {
+		ldp := &PresentationDefinition{
+			ID:     "ldp",
+			Format: &Format{LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}}},
+			InputDescriptors: []*InputDescriptor{
+				newDescriptor("passport", "subject"),
+				{
+					ID:     "license",
+					Format: &Format{LdpVC: &LdpType{ProofType: []string{"BbsBlsSignature2020"}}},
+				},
+			},
+		}
+
+		jwt := &PresentationDefinition{
+			ID:               "jwt",
+			Format:           &Format{JwtVC: &JwtType{Alg: []string{"ES256"}}},
+			InputDescriptors: []*InputDescriptor{newDescriptor("address", "address_subject")},
+		}
+
+		merged, err := MergeDefinitions(ldp, jwt)
+		require.NoError(t, err)
+		require.NoError(t, merged.ValidateSchema())
+
+		require.Equal(t, &Format{
+			JwtVC: &JwtType{Alg: []string{"ES256"}},
+			LdpVC: &LdpType{ProofType: []string{"Ed25519Signature2018"}},
+		}, merged.Format)
+
+		require.Len(t, merged.InputDescriptors, 3)
+		require.Equal(t, ldp.Format, merged.InputDescriptors[0].Format)
+		require.Equal(t, ldp.InputDescriptors[1].Format, merged.InputDescriptors[1].Format)
+		require.Equal(t, jwt.Format, merged.InputDescriptors[2].Format)
+	})
+
+	t.Run("Without submission requirements", func(t *testing.T) {
+		merged, err := MergeDefinitions(telco, telco)
+		require.NoError(t, err)
+		require.Empty(t, merged.SubmissionRequirements)
+
+		var ids []string
+		for _, descriptor := range merged.InputDescriptors {
+			ids = append(ids, descriptor.ID)
+			require.NotContains(t, descriptor.Group, "definition_0")
+		}
+
+		require.Equal(t, []string{"passport", "address", "passport_1", "address_1"}, ids)
+	})
+
+	t.Run("Deterministic renaming", func(t *testing.T) {
+		collision := &PresentationDefinition{
+			ID:               "collision",
+			InputDescriptors: []*InputDescriptor{newDescriptor("passport_1", "")},
+		}
+
+		merged, err := MergeDefinitions(bank, collision, telco)
+		require.NoError(t, err)
+		require.Equal(t, "passport_1", merged.InputDescriptors[2].ID)
+		require.Equal(t, "passport_2", merged.InputDescriptors[3].ID)
+
+		again, err := MergeDefinitions(bank, collision, telco)
+		require.NoError(t, err)
+
+		again.ID = merged.ID
+		require.Equal(t, merged, again)
+	})
+
+	t.Run("No common algorithms", func(t *testing.T) {
+		_, err := MergeDefinitions(bank, &PresentationDefinition{
+			ID:               "es384",
+			Format:           &Format{JwtVC: &JwtType{Alg: []string{"ES384"}}},
+			InputDescriptors: []*InputDescriptor{newDescriptor("email", "")},
+		})
+		require.EqualError(t, err,
+			"merge format of presentation definition 1: jwt_vc: no common alg: [ES256 EdDSA] and [ES384]")
+	})
+
+	t.Run("Invalid merged definition", func(t *testing.T) {
+		v1 := &PresentationDefinition{
+			ID:               "v1",
+			InputDescriptors: []*InputDescriptor{{ID: "schema", Schema: []*Schema{{URI: "https://example.com"}}}},
+		}
+
+		_, err := MergeDefinitions(v1, telco)
+		require.ErrorContains(t, err, "merged presentation definition")
+	})
+
+	t.Run("No definitions", func(t *testing.T) {
+		_, err := MergeDefinitions()
+		require.EqualError(t, err, "no presentation definitions to merge")
+
+		_, err = MergeDefinitions(bank, nil)
+		require.EqualError(t, err, "presentation definition 1 is nil")
+	})
+}
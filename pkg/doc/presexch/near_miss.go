@@ -0,0 +1,117 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// NearMiss describes a credential which satisfies all the constraints fields of an input descriptor but a few,
+// collected with WithNearMisses, e.g. to tell the holder the credential almost qualifies.
+type NearMiss struct {
+	CredentialID string
+	DescriptorID string
+	// FailedFields are the fields the credential does not satisfy, in the order of Constraints.Fields.
+	FailedFields []*FieldFailure
+}
+
+// FieldFailure describes a field a credential does not satisfy.
+type FieldFailure struct {
+	// FieldIndex is the index of the field in Constraints.Fields.
+	FieldIndex int
+	FieldID    string
+	FieldName  string
+	// Path is the first path of the field which selects a value, Value is the value it selects and Filter is
+	// the filter of the path the value fails (e.g. 17 against {"type": "number", "minimum": 18}). Path, Value and
+	// Filter are empty if none of the paths selects a value.
+	Path   string
+	Value  interface{}
+	Filter *Filter
+	// Reason is the error of the last path of the field.
+	Reason error
+}
+
+// WithNearMisses collects the credentials which fail at most maxFailedFields of the required constraints fields of
+// an input descriptor, along with the fields they fail, e.g. "age 17, required >= 18". The credentials must pass
+// the checks preceding the fields (e.g. the format, the schemas and the statuses); the checks following them
+// (e.g. limiting the disclosure) are not run for the near misses, which are rejected anyway.
+// The near misses are appended to the given slice in the order they are found. Collecting them costs
+// the evaluation of the remaining fields once a field is not satisfied, which otherwise rejects the credential
+// right away, so it is opt-in. The errors of the remaining fields are recorded as failures rather than failing
+// the match. A maxFailedFields lower than 1 is ignored.
+func WithNearMisses(maxFailedFields int, nearMisses *[]*NearMiss) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		if maxFailedFields > 0 && nearMisses != nil {
+			opts.nearMisses = &nearMissCollector{maxFailed: maxFailedFields, nearMisses: nearMisses}
+		}
+	}
+}
+
+// nearMissCollector gathers the near misses of the credentials. A nil collector discards them.
+type nearMissCollector struct {
+	mu         sync.Mutex
+	maxFailed  int
+	nearMisses *[]*NearMiss
+}
+
+// track returns the tracker of the fields the credential fails, or nil if the near misses are not collected.
+func (c *nearMissCollector) track(descriptorID string, credential *verifiable.Credential) *nearMissTracker {
+	if c == nil {
+		return nil
+	}
+
+	return &nearMissTracker{
+		collector: c,
+		miss:      &NearMiss{CredentialID: credential.ID, DescriptorID: descriptorID},
+	}
+}
+
+// nearMissTracker tracks the fields a credential fails for an input descriptor. A nil tracker tracks nothing.
+type nearMissTracker struct {
+	collector *nearMissCollector
+	miss      *NearMiss
+	exceeded  bool
+}
+
+// fail records the field the credential does not satisfy. It returns whether the remaining fields are to be
+// evaluated, i.e. false once the credential fails more fields than a near miss may.
+func (t *nearMissTracker) fail(i int, field *Field, credential map[string]interface{}, reason error) bool {
+	if t == nil || t.exceeded {
+		return false
+	}
+
+	failure := &FieldFailure{FieldIndex: i, FieldID: field.ID, FieldName: field.Name, Reason: reason}
+
+	for j, path := range field.Path {
+		if value, err := fieldValue(path, credential); err == nil {
+			failure.Path = path
+			failure.Value = value
+			failure.Filter = field.pathFilter(j)
+
+			break
+		}
+	}
+
+	t.miss.FailedFields = append(t.miss.FailedFields, failure)
+	t.exceeded = len(t.miss.FailedFields) > t.collector.maxFailed
+
+	return !t.exceeded
+}
+
+// done collects the near miss, if the credential fails some fields but not too many.
+func (t *nearMissTracker) done() {
+	if t == nil || t.exceeded || len(t.miss.FailedFields) == 0 {
+		return
+	}
+
+	t.collector.mu.Lock()
+	defer t.collector.mu.Unlock()
+
+	*t.collector.nearMisses = append(*t.collector.nearMisses, t.miss)
+}
@@ -0,0 +1,742 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/piprate/json-gold/ld"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	spi "github.com/hyperledger/aries-framework-go/spi/log"
+)
+
+// matchRequirementsOpts holds the options used while matching credentials against requirements.
+type matchRequirementsOpts struct {
+	credOpts       []verifiable.CredentialOpt
+	statusResolver StatusResolver
+	holderDID      string
+	binding        *PresentationBinding
+	rejections     *rejectionCollector
+	audit          *auditCollector
+	originals      *originalsCollector
+	submissionID   string
+	idGenerator    func() string
+	idMu           sync.Mutex
+	contextCache   *ContextCache
+	resolveSchema  SchemaResolver
+	typeMatchOnly  bool
+	lenientSchema  bool
+	concurrency    int
+
+	credentialSchemaMatch bool
+	loadCredentialSchema  CredentialSchemaLoader
+	loadFilterRef         FilterRefLoader
+
+	preferredFormats []string
+	rejectExpired    bool
+	clock            func() time.Time
+
+	// proofErrs are the errors verifying the proofs of the credentials with WithRequireValidProofs, so that
+	// a credential is verified once per match.
+	requireValidProofs bool
+	proofOpts          []verifiable.CredentialOpt
+	proofsMu           sync.Mutex
+	proofErrs          map[*verifiable.Credential]error
+
+	minimalDisclosure          bool
+	verifiableDisclosure       bool
+	postDisclosureVerification bool
+	typeCoercion               bool
+	externalProofs             bool
+	derivations                map[string]Derivation
+	disclosureProvider         SelectiveDisclosureProvider
+
+	presentationClaims map[string]interface{}
+	presentationPaths  []string
+
+	// noLimitDisclosure is set by PreviewDisclosure, AddSubmissionToPresentation and MatchSubmissionRequirementWithOptions
+	// with WithApplicabilityOnly to match the credentials without limiting disclosure.
+	noLimitDisclosure bool
+	// noFrame is set by MatchesDescriptor, UnsatisfiedDescriptors and MatchSubmissionRequirementWithOptions
+	// with WithApplicabilityOnly to match the credentials without framing them.
+	noFrame           bool
+	applicabilityOnly bool
+	// bindingReturned is set by CreateBoundVP, the only one returning the binding set by WithPresentationBinding.
+	bindingReturned bool
+
+	copyCredentials    bool
+	partialMatches     bool
+	unmetDescriptorIDs *[]string
+	mappingDetails     *[]*MappingDetails
+	credentialRanker   func(a, b *verifiable.Credential) int
+
+	strictPatterns  bool
+	maxNestingDepth int
+	fromGroupLists  bool
+	nearMisses      *nearMissCollector
+	onSkip          func(credential *verifiable.Credential, err error)
+	skipUnparsable  bool
+	strictJWT       bool
+	logger          spi.Logger
+	presentations   []*verifiable.Presentation
+	enclosing       map[*verifiable.Credential]*enclosingPresentation
+
+	stableCredentialIDs bool
+	limitedMu           sync.Mutex
+	limitedCreds        map[*verifiable.Credential]string
+
+	// limitedFrom maps the limited credentials to the credentials they were created from, for WithUnionDisclosure.
+	unionDisclosure bool
+	limitedFrom     map[*verifiable.Credential]*verifiable.Credential
+
+	ctx context.Context
+	// schemas are the compiled JSON schemas of the filters, so that a filter is compiled once per match.
+	schemasMu sync.Mutex
+	schemas   map[*Filter]*compiledFilter
+	// credentialSchemas are the compiled JSON schemas of the credentialSchema ids loaded with
+	// WithCredentialSchemaMatch.
+	credentialSchemas map[string]*compiledFilter
+	// containsSchemas are the compiled contains subschemas of the filters bounding them, see validateContains.
+	containsSchemas map[*Filter]*compiledFilter
+}
+
+// compiledFilter is a JSON schema compiled once, outside schemasMu, so that the documents it references are loaded
+// concurrently with the other schemas.
+type compiledFilter struct {
+	once   sync.Once
+	schema *gojsonschema.Schema
+	err    error
+}
+
+// MatchRequirementsOpt is an option of CreateVPWithOptions and MatchSubmissionRequirementWithOptions.
+type MatchRequirementsOpt func(opts *matchRequirementsOpts)
+
+// WithSDCredentialOptions used when applying selective disclosure (e.g. parsing the limited credential).
+func WithSDCredentialOptions(options ...verifiable.CredentialOpt) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.credOpts = append(opts.credOpts, options...)
+	}
+}
+
+// WithStatusResolver sets the resolver used to check credentials against the constraints statuses.
+// If not set, the statuses are not checked.
+func WithStatusResolver(resolver StatusResolver) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.statusResolver = resolver
+	}
+}
+
+// WithHolderDID sets the DID of the holder used to check the is_holder constraints: the holder must be
+// the subject of the fields referenced by a required is_holder directive, otherwise the credential is filtered out.
+func WithHolderDID(did string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.holderDID = did
+	}
+}
+
+// WithPresentationClaims evaluates the constraints fields whose paths select no value in a credential against
+// the claims of the presentation the credentials are presented in, e.g. {"holder": "did:example:holder"} for
+// the field {"path": ["$.holder"], "filter": {"type": "string", "const": "did:example:holder"}} to assert
+// the holder of the presentation. The fields satisfied by the presentation are not disclosed by the credentials,
+// nor audited as satisfied by them. By default, the fields are evaluated against the credentials only.
+//
+// Only the paths of the presentation-level claims are evaluated against the presentation: the given paths,
+// or $.holder if none are given, so that the presentation cannot satisfy the claims of the credentials.
+func WithPresentationClaims(claims map[string]interface{}, paths ...string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.presentationClaims = claims
+		opts.presentationPaths = paths
+	}
+}
+
+// defaultPresentationPaths are the paths evaluated against the presentation claims by default.
+var defaultPresentationPaths = []string{"$.holder"} // nolint: gochecknoglobals
+
+// presentationField returns the field with the paths evaluated against the presentation claims only,
+// see WithPresentationClaims, or nil if it has none.
+func (opts *matchRequirementsOpts) presentationField(field *Field) *Field {
+	allowed := opts.presentationPaths
+	if len(allowed) == 0 {
+		allowed = defaultPresentationPaths
+	}
+
+	var paths []string
+
+	for _, path := range field.Path {
+		for _, p := range allowed {
+			if path == p {
+				paths = append(paths, path)
+
+				break
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	f := *field
+	f.Path = paths
+
+	return &f
+}
+
+// WithPresentationBinding sets the verifier's nonce (e.g. OpenID4VP nonce) and audience (e.g. OpenID4VP client_id)
+// the proof of the presentation must be bound to. They are returned by CreateBoundVP along with the presentation
+// so that the signing step can apply them; no proof is created. The other functions creating a presentation
+// (e.g. CreateVPWithOptions) fail with ErrBindingNotSupported.
+func WithPresentationBinding(nonce, audience string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.binding = &PresentationBinding{Challenge: nonce, Domain: audience}
+	}
+}
+
+// WithSubmissionID sets the ID of the presentation submission created by CreateVPWithOptions
+// (or of every presentation submission created by CreateVPs).
+func WithSubmissionID(id string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.submissionID = id
+	}
+}
+
+// WithIDGenerator sets the generator of the unique values used by CreateVPWithOptions and CreateVPs: the presentation
+// submission IDs (unless WithSubmissionID is given) and the values used while limiting disclosure.
+// The generator must not return the same value twice during a single call. Defaults to random UUIDs.
+func WithIDGenerator(generator func() string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.idGenerator = generator
+	}
+}
+
+// WithContextCache used to reuse the parsed JSON-LD contexts across calls when checking input descriptor schemas.
+func WithContextCache(cache *ContextCache) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.contextCache = cache
+	}
+}
+
+// SchemaResolver resolves the JSON-LD context of a credential (by its URI, as listed in the credential) which
+// defines the types checked against the input descriptor schemas.
+type SchemaResolver func(contextURI string) (*ld.Context, error)
+
+// WithSchemaResolver resolves the credential contexts with the given resolver instead of the document loader when
+// checking input descriptor schemas, e.g. to apply a fetching policy of its own (timeouts, caching headers) or to
+// reject the contexts of hosts which are not allowed when matching untrusted definitions. A context which is
+// not resolved fails the match with a ContextLoadError (see WithLenientSchema), unless the resolver refuses it
+// with an error wrapping ErrContextRefused: the credential is rejected then. The resolved contexts are cached
+// by WithContextCache as well.
+func WithSchemaResolver(resolver SchemaResolver) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.resolveSchema = resolver
+	}
+}
+
+// WithTypeMatchOnly matches the input descriptor schemas against the declared credential types by their
+// short names or IRIs, without loading the JSON-LD contexts of the credentials, so that the credentials can be
+// matched offline or with custom contexts which cannot be resolved. E.g. the schema URI
+// https://www.w3.org/2018/credentials/examples/v1#UniversityDegreeCredential is satisfied by the credential of
+// the UniversityDegreeCredential type. By default, the types are resolved with the credential contexts.
+func WithTypeMatchOnly() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.typeMatchOnly = true
+	}
+}
+
+// WithLenientSchema matches the credentials whose JSON-LD contexts cannot be loaded against the input descriptor
+// schemas by the names of their types, as WithTypeMatchOnly does, and logs a warning. By default, the match fails
+// with a ContextLoadError, so that a missing or misconfigured document loader is not mistaken for credentials
+// which do not match. The contexts refused by the SchemaResolver (see ErrContextRefused) are not loading failures:
+// their credentials are rejected either way.
+func WithLenientSchema() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.lenientSchema = true
+	}
+}
+
+// WithConcurrency sets the maximum number of input descriptors the credentials are filtered for concurrently.
+// Defaults to 1, i.e. the input descriptors are processed sequentially.
+//
+// With concurrency, the StatusResolver must be safe for concurrent use, and the values of the ID generator
+// set by WithIDGenerator are not assigned in a deterministic order.
+func WithConcurrency(n int) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.concurrency = n
+	}
+}
+
+// WithStableCredentialIDs keeps the IDs of the credentials limited by limit_disclosure the same as the IDs of
+// the original credentials. By default, a unique value is appended to the IDs of the limited credentials
+// (e.g. the ones returned by MatchSubmissionRequirement) to tell them apart, since a credential may be limited
+// differently for different input descriptors.
+func WithStableCredentialIDs() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.stableCredentialIDs = true
+	}
+}
+
+// WithCopyCredentials makes CreateVPWithOptions and MatchSubmissionRequirementWithOptions match deep copies of the
+// given credentials, so that neither the given credentials are modified nor the returned ones share any data
+// (e.g. proofs, custom fields or SD-JWT disclosures) with them. Without the option, the returned credentials may be
+// the given ones.
+func WithCopyCredentials() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.copyCredentials = true
+	}
+}
+
+// WithExternalProofs matches the format of the JSON-LD credentials presented with external proofs only, e.g.
+// a detached JWS or the proof of an enveloping presentation (the enveloped credentials of VC Data Model 2.0):
+// a credential with neither inline proofs nor a JWT fits all the LDP formats (ldp, ldp_vc and ldp_vp) of
+// the presentation definition or input descriptor then, whatever their proof types, which cannot be checked.
+// By default such a credential fits no format, and is not matched by the definitions which set a format.
+//
+// The JWT credentials are classified by their JWT, with or without this option.
+func WithExternalProofs() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.externalProofs = true
+	}
+}
+
+// WithPartialMatches relaxes the all-or-nothing matching of the presentation definition without submission
+// requirements: CreateVPWithOptions creates the presentation of the input descriptors which are matched, instead of
+// failing with ErrNoCredentials when some input descriptors are not. ErrNoCredentials is still returned if no input
+// descriptor is matched. Unless unmetDescriptorIDs is nil, the IDs of the input descriptors which are not matched are
+// stored to it in the order of the presentation definition, so that the holder can negotiate the rest.
+//
+// The option has no effect on the presentation definition with submission requirements, since the requirements
+// state which input descriptors may be left unmet.
+func WithPartialMatches(unmetDescriptorIDs *[]string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.partialMatches = true
+		opts.unmetDescriptorIDs = unmetDescriptorIDs
+	}
+}
+
+// WithCredentialRanker makes CreateVPWithOptions trim the matched credentials to the least set satisfying the
+// presentation definition, preferring the credentials ranked first: every input descriptor is given a single
+// credential, and the pick requirements are given only as many input descriptors (or nested requirements) as they
+// require (count, or min), so that a pick requirement with count is satisfied even if more input descriptors are
+// matched. The ranker returns a negative number if credential a is preferred to credential b, a positive number if b is
+// preferred to a, and zero otherwise, e.g. to prefer the freshest issuance date or a preferred issuer. The input
+// descriptors and the requirements ranked equally keep the order of the presentation definition.
+//
+// The credentials are trimmed before same_subject is checked, so the credentials ranked first must satisfy it.
+// Without the ranker, all the matched credentials are returned.
+func WithCredentialRanker(ranker func(a, b *verifiable.Credential) int) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.credentialRanker = ranker
+	}
+}
+
+// WithStrictPatterns makes CreateVPWithOptions and MatchSubmissionRequirementWithOptions fail if a pattern of the
+// constraints fields is malformed or nests unbounded quantifiers (see Filter.Validate), e.g. to reject the patterns
+// supplied by a verifier which may cause catastrophic backtracking. By default, a malformed pattern does not match
+// any credential.
+func WithStrictPatterns() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.strictPatterns = true
+	}
+}
+
+// WithPresentations matches the credentials of the given presentations (e.g. the chained or delegated ones)
+// along with the given credentials. The credentials of the presentations must be parsed.
+//
+// CreateVPWithOptions presents a credential of the presentations as is within its enclosing presentation, i.e. the
+// presentation submission maps the input descriptor to the enclosing presentation and then to the credential
+// with path_nested (e.g. $.verifiableCredential[1] and then $.verifiableCredential[0]). The credentials derived
+// from the credentials of the presentations (e.g. limited by limit_disclosure or framed) are not a part of
+// the enclosing presentations, so they are presented directly.
+func WithPresentations(presentations []*verifiable.Presentation) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.presentations = presentations
+	}
+}
+
+// WithApplicabilityOnly makes MatchSubmissionRequirementWithOptions and MatchSubmissionRequirementDetailed only check
+// which credentials satisfy the presentation definition, e.g. for the holder to tell whether the definition
+// can be satisfied before the presentation is created: the credentials are neither framed (with the frame of
+// the presentation definition or of the input descriptors) nor limited by limit_disclosure or predicates,
+// so no BBS+ selective disclosure is generated and no SD-JWT disclosure is dropped. The matched credentials
+// are returned untouched, and the fields are filtered as given, i.e. as before framing.
+//
+// The option has no effect on CreateVPWithOptions.
+func WithApplicabilityOnly() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.applicabilityOnly = true
+	}
+}
+
+// WithOnSkip sets the callback notified of the credentials which are skipped by an input descriptor because they
+// cannot be processed at all (e.g. the credential cannot be marshaled, or the SD-JWT disclosures cannot be
+// applied), as opposed to the credentials which do not match the constraints, so that integrators can log or
+// surface them. The callback is called once per input descriptor skipping the credential; with WithConcurrency,
+// it must be safe for concurrent use. The raw credentials skipped by CreateVPFromRaw (see
+// WithSkipUnparsableCredentials) are reported with a nil credential. By default, the skipped credentials are not
+// reported.
+func WithOnSkip(onSkip func(credential *verifiable.Credential, err error)) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.onSkip = onSkip
+	}
+}
+
+// WithSkipUnparsableCredentials makes CreateVPFromRaw skip the raw credentials which cannot be parsed instead
+// of failing. The skipped credentials are reported to the callback set by WithOnSkip with a nil credential.
+func WithSkipUnparsableCredentials() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.skipUnparsable = true
+	}
+}
+
+// WithStrictJWTParsing fails the match with an error when the JWT of a credential cannot be parsed to check its
+// algorithm against the requested formats (or its exp claim with WithRejectExpired). By default, the failure
+// is logged as a warning and the credential is matched as if it had no JWT: it fits none of the JWT formats
+// and is not rejected as expired, which can hide malformed credentials.
+func WithStrictJWTParsing() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.strictJWT = true
+	}
+}
+
+// WithLogger sets the logger of the match (e.g. of the warnings about the credentials which cannot be parsed,
+// and of the preferred directives which are not satisfied) instead of the "doc/presexch" module logger.
+// With WithConcurrency, the logger must be safe for concurrent use.
+func WithLogger(logger spi.Logger) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.logger = logger
+	}
+}
+
+// WithContext bounds the matching of the credentials by the context, e.g. with a deadline to limit the time spent
+// filtering the credentials by a presentation definition supplied by a third party. Once the context is done,
+// MatchTimeoutError is returned. The context is checked before every field of every credential is filtered,
+// so the validation of a single field is not interrupted.
+func WithContext(ctx context.Context) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.ctx = ctx
+	}
+}
+
+// WithMaxNestingDepth sets the maximum depth of the submission requirements nested with from_nested, the top-level
+// requirements being of depth 1. NestingDepthError is returned for the presentation definitions with deeper
+// requirements, which protects the verifiers and holders processing untrusted definitions. By default,
+// the maximum depth is DefaultMaxNestingDepth. A depth lower than 1 is ignored.
+func WithMaxNestingDepth(depth int) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		if depth > 0 {
+			opts.maxNestingDepth = depth
+		}
+	}
+}
+
+// WithPreferredFormats sets the claim formats the holder prefers, in the order of preference (e.g. jwt_vc before
+// ldp_vc to present smaller credentials). When the credentials fit several of the formats accepted by
+// the presentation definition, the most preferred format is selected and set to the input descriptor mappings
+// of the presentation submission. By default, the LDP formats are preferred to the SD-JWT ones, which are
+// preferred to the JWT ones.
+func WithPreferredFormats(formats []string) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.preferredFormats = formats
+	}
+}
+
+// WithRejectExpired rejects the credentials expired at the time of the clock (see WithClock), whatever the fields
+// of the presentation definition: the credentials with an expirationDate (VC Data Model 1.1), a validUntil
+// (VC Data Model 2.0) or a JWT exp claim before it. The expired credentials are rejected by every input descriptor
+// at ExpirationStage, before they are framed and their constraints are evaluated. The credentials without
+// an expiration are kept.
+func WithRejectExpired() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.rejectExpired = true
+	}
+}
+
+// WithClock sets the clock the time-based checks consult instead of time.Now, e.g. to evaluate the derived
+// predicates (like the age derived from a birthdate) and the expiration of the credentials (with WithRejectExpired)
+// as of a past moment, or to make the match reproducible.
+func WithClock(clock func() time.Time) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.clock = clock
+	}
+}
+
+// WithMinimalDisclosure limits the credentials (with limit_disclosure) to the first path satisfying each field,
+// rather than to all the paths of the field, see Constraints.MinimalDisclosurePaths. E.g. a field with the paths
+// $.credentialSubject.email and $.credentialSubject.phone discloses the email only, if the credential has both.
+// The fields with a required predicate keep all their paths.
+func WithMinimalDisclosure() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.minimalDisclosure = true
+	}
+}
+
+// WithVerifiableDisclosure rejects the signed credentials which can not be limited (by limit_disclosure or
+// a predicate) verifiably with ErrSelectiveDisclosureNotSupported, e.g. the JSON-LD credentials with an Ed25519
+// proof or the JWT credentials, rather than presenting them limited without a valid proof. The credentials are
+// limited verifiably with a derived BBS+ proof (without predicates) or as SD-JWT and mdoc credentials.
+func WithVerifiableDisclosure() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.verifiableDisclosure = true
+	}
+}
+
+// WithPostDisclosureVerification checks that every credential limited by limit_disclosure (or a predicate) still
+// satisfies the fields it discloses once limited, and fails the match with ErrDisclosureNotVerified otherwise.
+// It is a self-consistency check of the limiting, which is off by default because of the extra cost of
+// evaluating the fields twice.
+func WithPostDisclosureVerification() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.postDisclosureVerification = true
+	}
+}
+
+// WithTypeCoercion compares the values of the fields with the type of their filter (string, number or integer)
+// rather than their JSON type: a numeric string is compared as a number and a number as a string, e.g. the filter
+// {"type": "number", "const": 21} is satisfied by the "21" value. The const, enum and bounds of the filter are
+// coerced the same way. The date filters are not coerced. By default, the JSON types must match the filter.
+func WithTypeCoercion() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.typeCoercion = true
+	}
+}
+
+// WithDerivation sets the derivation of the fields with the given derive name, in addition to DeriveAge (which
+// it may replace). The filter of such a field validates the value derived from the one selected by its path.
+func WithDerivation(name string, derivation Derivation) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		if opts.derivations == nil {
+			opts.derivations = map[string]Derivation{}
+		}
+
+		opts.derivations[name] = derivation
+	}
+}
+
+func newMatchRequirementsOpts(options []MatchRequirementsOpt) *matchRequirementsOpts {
+	opts := &matchRequirementsOpts{
+		idGenerator: func() string {
+			return uuid.New().String()
+		},
+		maxNestingDepth: DefaultMaxNestingDepth,
+	}
+
+	for _, option := range options {
+		option(opts)
+	}
+
+	return opts
+}
+
+// skip notifies the callback set by WithOnSkip of the credential which cannot be processed.
+func (opts *matchRequirementsOpts) skip(credential *verifiable.Credential, err error) {
+	if opts.onSkip != nil {
+		opts.onSkip(credential, err)
+	}
+}
+
+// currentTime returns the time of the clock set by WithClock, or the current time.
+func (opts *matchRequirementsOpts) currentTime() time.Time {
+	if opts.clock != nil {
+		return opts.clock()
+	}
+
+	return time.Now()
+}
+
+// log returns the logger set by WithLogger or the module logger.
+func (opts *matchRequirementsOpts) log() spi.Logger {
+	if opts == nil || opts.logger == nil {
+		return logger
+	}
+
+	return opts.logger
+}
+
+func (opts *matchRequirementsOpts) newID() string {
+	opts.idMu.Lock()
+	defer opts.idMu.Unlock()
+
+	return opts.idGenerator()
+}
+
+// derivation returns the derivation set by WithDerivation, or the built-in one.
+func (opts *matchRequirementsOpts) derivation(name string) (Derivation, bool) {
+	if derivation, ok := opts.derivations[name]; ok {
+		return derivation, true
+	}
+
+	if name == DeriveAge {
+		return ageDerivation{}, true
+	}
+
+	return nil, false
+}
+
+// checkDeadline returns MatchTimeoutError if the context set by WithContext is done.
+func (opts *matchRequirementsOpts) checkDeadline() error {
+	if opts.ctx == nil {
+		return nil
+	}
+
+	if err := opts.ctx.Err(); err != nil {
+		return &MatchTimeoutError{Err: err}
+	}
+
+	return nil
+}
+
+// schemaResolver returns the resolver set by WithSchemaResolver, or the one loading the contexts with
+// the document loader.
+func (opts *matchRequirementsOpts) schemaResolver(documentLoader ld.DocumentLoader) SchemaResolver {
+	if opts.resolveSchema != nil {
+		return opts.resolveSchema
+	}
+
+	return loaderSchemaResolver(documentLoader)
+}
+
+// filterSchema returns the compiled JSON schema of the filter (or the error compiling it), compiling it
+// on the first use.
+func (opts *matchRequirementsOpts) filterSchema(f *Filter) (*gojsonschema.Schema, error) {
+	opts.schemasMu.Lock()
+
+	compiled, ok := opts.schemas[f]
+	if !ok {
+		compiled = &compiledFilter{}
+
+		if opts.schemas == nil {
+			opts.schemas = make(map[*Filter]*compiledFilter)
+		}
+
+		opts.schemas[f] = compiled
+	}
+
+	opts.schemasMu.Unlock()
+
+	compiled.once.Do(func() {
+		if opts.typeCoercion {
+			compiled.schema, compiled.err = compileFilter(f.coerced(), opts.loadFilterRef)
+		} else {
+			compiled.schema, compiled.err = compileFilter(f, opts.loadFilterRef)
+		}
+	})
+
+	return compiled.schema, compiled.err
+}
+
+// credentialSchema returns the compiled JSON schema of the credentialSchema id (or the error loading or compiling
+// it), loading it on the first use. The external $refs of the schema are loaded with the CredentialSchemaLoader
+// as well, resolved against the id.
+func (opts *matchRequirementsOpts) credentialSchema(id string) (*gojsonschema.Schema, error) {
+	opts.schemasMu.Lock()
+
+	compiled, ok := opts.credentialSchemas[id]
+	if !ok {
+		compiled = &compiledFilter{}
+
+		if opts.credentialSchemas == nil {
+			opts.credentialSchemas = make(map[string]*compiledFilter)
+		}
+
+		opts.credentialSchemas[id] = compiled
+	}
+
+	opts.schemasMu.Unlock()
+
+	compiled.once.Do(func() {
+		compiled.schema, compiled.err = compileCredentialSchema(id, opts.loadCredentialSchema)
+	})
+
+	return compiled.schema, compiled.err
+}
+
+// markLimited gives the credential limited for an input descriptor a unique identity. Unless stable credential IDs
+// are used, the unique value is appended to the credential ID, otherwise it is kept aside.
+func (opts *matchRequirementsOpts) markLimited(credential *verifiable.Credential) {
+	unique := opts.newID()
+
+	if !opts.stableCredentialIDs {
+		credential.ID = tmpID(credential.ID, unique)
+
+		return
+	}
+
+	opts.limitedMu.Lock()
+	defer opts.limitedMu.Unlock()
+
+	if opts.limitedCreds == nil {
+		opts.limitedCreds = make(map[*verifiable.Credential]string)
+	}
+
+	opts.limitedCreds[credential] = unique
+}
+
+// credentialKey returns the key identifying the credential instance, i.e. the limited credentials have
+// different keys than the original ones.
+func (opts *matchRequirementsOpts) credentialKey(credential *verifiable.Credential) string {
+	if !opts.stableCredentialIDs {
+		return credential.ID
+	}
+
+	opts.limitedMu.Lock()
+	defer opts.limitedMu.Unlock()
+
+	if unique, ok := opts.limitedCreds[credential]; ok {
+		return tmpID(credential.ID, unique)
+	}
+
+	return credential.ID
+}
+
+// originalID returns the ID of the original credential.
+func (opts *matchRequirementsOpts) originalID(credential *verifiable.Credential) string {
+	if opts.stableCredentialIDs {
+		return credential.ID
+	}
+
+	return trimTmpID(credential.ID)
+}
+
+func (opts *matchRequirementsOpts) presentationSubmissionID() string {
+	if opts.submissionID != "" {
+		return opts.submissionID
+	}
+
+	return opts.newID()
+}
+
+// SpecVersion is the version of the Presentation Exchange specification the presentation definition
+// is validated against.
+type SpecVersion string
+
+const (
+	// V1 is Presentation Exchange v1, see DefinitionJSONSchemaV1.
+	V1 SpecVersion = "v1"
+	// V2 is Presentation Exchange v2, see DefinitionJSONSchemaV2.
+	V2 SpecVersion = "v2"
+)
+
+type validateSchemaOpts struct {
+	version SpecVersion
+}
+
+// ValidateSchemaOpt is an option of ValidateSchema.
+type ValidateSchemaOpt func(opts *validateSchemaOpts)
+
+// WithPESpecVersion pins the Presentation Exchange version the presentation definition is validated against,
+// so that the errors of that version's schema are returned. By default, the definition is validated against
+// V1 and, if it is not valid, against V2.
+func WithPESpecVersion(version SpecVersion) ValidateSchemaOpt {
+	return func(opts *validateSchemaOpts) {
+		opts.version = version
+	}
+}
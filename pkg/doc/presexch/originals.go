@@ -0,0 +1,108 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// OriginalCredential is the original of a credential of the presentation limited for an input descriptor
+// (by limit_disclosure or a predicate), collected with WithDebugOriginals.
+type OriginalCredential struct {
+	// CredentialID is the ID of the limited credential in the presentation.
+	CredentialID string
+	DescriptorID string
+	// Original is the JSON of the credential before it was limited, as the fields were evaluated against:
+	// the JSON-LD of the credential, with all the disclosures of an SD-JWT credential.
+	Original json.RawMessage
+}
+
+// WithDebugOriginals collects the originals of the limited credentials of the presentation created by
+// CreateVPWithOptions (CreateVPs, CreateVPWithAudit and the like), e.g. to compare them with the limited ones when the
+// presentation fails verification. The originals are appended to the given slice, ordered by descriptor ID, and are
+// never added to the presentation. As they disclose the credentials in full, they are meant for debugging only.
+func WithDebugOriginals(originals *[]*OriginalCredential) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.originals = &originalsCollector{out: originals}
+	}
+}
+
+// originalsCollector gathers the originals of the credentials limited by the input descriptors. A nil collector
+// discards them.
+type originalsCollector struct {
+	mu        sync.Mutex
+	originals map[auditKey]json.RawMessage
+	out       *[]*OriginalCredential
+}
+
+func (c *originalsCollector) add(descriptorID string, limited *verifiable.Credential, original []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.originals == nil {
+		c.originals = map[auditKey]json.RawMessage{}
+	}
+
+	c.originals[auditKey{descriptorID: descriptorID, credential: limited}] = original
+}
+
+// replace moves the original of the credential limited by the input descriptor to the credential replacing it.
+// The original is dropped if the credential is nil.
+func (c *originalsCollector) replace(descriptorID string, old, credential *verifiable.Credential) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if original, ok := c.originals[auditKey{descriptorID: descriptorID, credential: old}]; ok {
+		delete(c.originals, auditKey{descriptorID: descriptorID, credential: old})
+
+		if credential != nil {
+			c.originals[auditKey{descriptorID: descriptorID, credential: credential}] = original
+		}
+	}
+}
+
+// collect appends the originals of the limited credentials of the match result. The originals are collected once
+// the presentation is created, for the IDs of the credentials to be the ones of the presentation.
+func (c *originalsCollector) collect(result map[string][]*verifiable.Credential) {
+	if c == nil || c.out == nil {
+		return
+	}
+
+	descriptorIDs := make([]string, 0, len(result))
+	for descriptorID := range result {
+		descriptorIDs = append(descriptorIDs, descriptorID)
+	}
+
+	sort.Strings(descriptorIDs)
+
+	for _, descriptorID := range descriptorIDs {
+		for _, credential := range result[descriptorID] {
+			original, ok := c.originals[auditKey{descriptorID: descriptorID, credential: credential}]
+			if !ok {
+				continue
+			}
+
+			*c.out = append(*c.out, &OriginalCredential{
+				CredentialID: credential.ID,
+				DescriptorID: descriptorID,
+				Original:     original,
+			})
+		}
+	}
+}
@@ -7,15 +7,22 @@ SPDX-License-Identifier: Apache-2.0
 package presexch
 
 import (
+	"crypto"
 	_ "embed"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/piprate/json-gold/ld"
 	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
 
 	jld "github.com/hyperledger/aries-framework-go/pkg/doc/ld"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/ldcontext"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/sdjwt/common"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
 	mockldstore "github.com/hyperledger/aries-framework-go/pkg/mock/ld"
 	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
@@ -92,7 +99,8 @@ func Test_mDLNestedCtx(t *testing.T) {
 
 		require.NoError(t, err)
 
-		matched := filterSchema(schemas, creds, docLoader)
+		matched, err := filterSchema(schemas, creds, loaderSchemaResolver(docLoader), nil, false, logger, nil)
+		require.NoError(t, err)
 		require.Len(t, matched, 1)
 	})
 
@@ -103,11 +111,222 @@ func Test_mDLNestedCtx(t *testing.T) {
 		}))
 		require.NoError(t, err)
 
-		matched := filterSchema(schemas, creds, docLoader)
+		matched, err := filterSchema(schemas, creds, loaderSchemaResolver(docLoader), nil, false, logger, nil)
+		require.NoError(t, err)
 		require.Len(t, matched, 0)
 	})
 }
 
+func TestContextCache(t *testing.T) {
+	schemas := []*Schema{{
+		URI: "https://example.org/examples#mDL",
+	}}
+
+	creds := []*verifiable.Credential{{
+		Context: []string{
+			verifiable.ContextURI,
+			"https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld",
+		},
+		Types: []string{verifiable.VCType, "mDL"},
+	}}
+
+	docLoader, err := jld.NewDocumentLoader(createMockCtxProvider(), jld.WithExtraContexts(ldcontext.Document{
+		URL:     "https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld",
+		Content: mDLv1JSONLD,
+	}))
+	require.NoError(t, err)
+
+	loader := &countingLoader{DocumentLoader: docLoader}
+	cache := NewContextCache()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			matched, err := filterSchema(schemas, creds, loaderSchemaResolver(loader), cache, false, logger, nil)
+			require.NoError(t, err)
+			require.Len(t, matched, 1)
+		}()
+	}
+
+	wg.Wait()
+
+	loads := loader.loads()
+
+	matched, err := filterSchema(schemas, creds, loaderSchemaResolver(loader), cache, false, logger, nil)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+	require.Equal(t, loads, loader.loads())
+	require.Len(t, cache.contexts, 2)
+
+	matched, err = filterSchema(schemas, creds, loaderSchemaResolver(loader), nil, false, logger, nil)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+	require.Equal(t, loads+2, loader.loads())
+
+	// the zero value is ready to use.
+	zero := &ContextCache{}
+
+	matched, err = filterSchema(schemas, creds, loaderSchemaResolver(loader), zero, false, logger, nil)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+	require.Len(t, zero.contexts, 2)
+	require.NotEmpty(t, zero.types)
+}
+
+func TestWithSchemaResolver(t *testing.T) {
+	schemas := []*Schema{{
+		URI: "https://example.org/examples#mDL",
+	}}
+
+	creds := []*verifiable.Credential{{
+		Context: []string{
+			verifiable.ContextURI,
+			"https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld",
+		},
+		Types: []string{verifiable.VCType, "mDL"},
+	}}
+
+	docLoader, err := jld.NewDocumentLoader(createMockCtxProvider(), jld.WithExtraContexts(ldcontext.Document{
+		URL:     "https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld",
+		Content: mDLv1JSONLD,
+	}))
+	require.NoError(t, err)
+
+	loader := &countingLoader{DocumentLoader: docLoader}
+
+	t.Run("document loader by default", func(t *testing.T) {
+		opts := newMatchRequirementsOpts(nil)
+
+		matched, err := filterSchema(schemas, creds, opts.schemaResolver(loader), nil, false, logger, nil)
+		require.NoError(t, err)
+		require.Len(t, matched, 1)
+		require.Equal(t, 2, loader.loads())
+	})
+
+	t.Run("allow-listed hosts", func(t *testing.T) {
+		var resolved []string
+
+		opts := newMatchRequirementsOpts([]MatchRequirementsOpt{
+			WithSchemaResolver(func(contextURI string) (*ld.Context, error) {
+				resolved = append(resolved, contextURI)
+
+				if !strings.HasPrefix(contextURI, "https://www.w3.org/") {
+					return nil, fmt.Errorf("host is not allowed: %s", contextURI)
+				}
+
+				return getContext(contextURI, docLoader)
+			}),
+		})
+
+		_, err := filterSchema(schemas, creds, opts.schemaResolver(loader), nil, false, logger, nil)
+
+		var loadErr *ContextLoadError
+		require.ErrorAs(t, err, &loadErr)
+		require.Equal(t, "https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld", loadErr.ContextURI)
+		require.Equal(t, creds[0].Context, resolved)
+		require.Equal(t, 2, loader.loads())
+	})
+
+	t.Run("refused contexts", func(t *testing.T) {
+		opts := newMatchRequirementsOpts([]MatchRequirementsOpt{
+			WithLenientSchema(),
+			WithSchemaResolver(func(contextURI string) (*ld.Context, error) {
+				if !strings.HasPrefix(contextURI, "https://www.w3.org/") {
+					return nil, fmt.Errorf("%w: host is not allowed: %s", ErrContextRefused, contextURI)
+				}
+
+				return getContext(contextURI, docLoader)
+			}),
+		})
+
+		var refused []error
+
+		// the credential is not matched by type names, although the schema is lenient.
+		matched, err := filterSchema(schemas, creds, opts.schemaResolver(loader), nil, opts.lenientSchema, logger,
+			func(credential *verifiable.Credential, err error) {
+				require.Same(t, creds[0], credential)
+
+				refused = append(refused, err)
+			})
+		require.NoError(t, err)
+		require.Empty(t, matched)
+		require.Len(t, refused, 1)
+		require.ErrorIs(t, refused[0], ErrContextRefused)
+
+		var loadErr *ContextLoadError
+		require.ErrorAs(t, refused[0], &loadErr)
+		require.Equal(t, "https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld", loadErr.ContextURI)
+
+		pd := &PresentationDefinition{
+			ID:               "pd",
+			InputDescriptors: []*InputDescriptor{{ID: "mdl", Schema: schemas}},
+		}
+
+		_, rejections, err := pd.MatchSubmissionRequirementDetailed(creds, loader,
+			WithLenientSchema(), WithSchemaResolver(opts.resolveSchema))
+		require.NoError(t, err)
+		require.Len(t, rejections, 1)
+		require.Equal(t, SchemaStage, rejections[0].Stage)
+		require.ErrorIs(t, rejections[0].Reason, ErrContextRefused)
+	})
+}
+
+func TestWithLenientSchema(t *testing.T) {
+	schemas := []*Schema{{
+		URI: "https://example.org/examples#mDL",
+	}}
+
+	creds := []*verifiable.Credential{{
+		Context: []string{
+			verifiable.ContextURI,
+			"https://trustbloc.github.io/context/vc/examples/mdl-v1.jsonld",
+		},
+		Types: []string{verifiable.VCType, "mDL"},
+	}, {
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+	}}
+
+	t.Run("no document loader", func(t *testing.T) {
+		_, err := filterSchema(schemas, creds, loaderSchemaResolver(nil), nil, false, logger, nil)
+		require.EqualError(t, err, "load context https://www.w3.org/2018/credentials/v1: no document loader")
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		opts := newMatchRequirementsOpts([]MatchRequirementsOpt{WithLenientSchema()})
+
+		matched, err := filterSchema(schemas, creds, opts.schemaResolver(nil), nil, opts.lenientSchema, logger, nil)
+		require.NoError(t, err)
+		require.Equal(t, creds[:1], matched)
+	})
+}
+
+type countingLoader struct {
+	ld.DocumentLoader
+	mu    sync.Mutex
+	count int
+}
+
+func (l *countingLoader) LoadDocument(url string) (*ld.RemoteDocument, error) {
+	l.mu.Lock()
+	l.count++
+	l.mu.Unlock()
+
+	return l.DocumentLoader.LoadDocument(url)
+}
+
+func (l *countingLoader) loads() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.count
+}
+
 func createMockCtxProvider() *mockprovider.Provider {
 	p := &mockprovider.Provider{
 		ContextStoreValue:        mockldstore.NewMockContextStore(),
@@ -116,3 +335,485 @@ func createMockCtxProvider() *mockprovider.Provider {
 
 	return p
 }
+
+func TestJSONPath(t *testing.T) {
+	src := []byte(`{
+		"id": "http://example.edu/credentials/1872",
+		"credentialSubject": {
+			"id": "did:example:ebfeb1f712ebc6f1c276e12ec21",
+			"name": "Jayden Doe",
+			"degrees": [
+				{"type": "BachelorDegree", "name": "Bachelor of Science and Arts"},
+				{"type": "MasterDegree", "name": "Master of Science"},
+				{"type": "BachelorDegree", "name": "Bachelor of Music"}
+			]
+		}
+	}`)
+
+	var credential map[string]interface{}
+	require.NoError(t, json.Unmarshal(src, &credential))
+
+	tests := []struct {
+		path  string
+		value interface{}
+	}{
+		{path: "$.credentialSubject.name", value: "Jayden Doe"},
+		{path: "$['credentialSubject']['name']", value: "Jayden Doe"},
+		{path: `$["credentialSubject"].degrees[1].type`, value: "MasterDegree"},
+		{path: "$.credentialSubject.degrees[-1].name", value: "Bachelor of Music"},
+		{path: "$.credentialSubject.degrees[*].type", value: []interface{}{
+			"BachelorDegree", "MasterDegree", "BachelorDegree",
+		}},
+		{path: "$.credentialSubject.degrees.*.type", value: []interface{}{
+			"BachelorDegree", "MasterDegree", "BachelorDegree",
+		}},
+		{path: "$.credentialSubject.degrees[0,2].name", value: []interface{}{
+			"Bachelor of Science and Arts", "Bachelor of Music",
+		}},
+		{path: "$.credentialSubject.degrees[1:].type", value: []interface{}{"MasterDegree", "BachelorDegree"}},
+		{path: "$.credentialSubject.degrees[?(@.type == 'BachelorDegree')].name", value: []interface{}{
+			"Bachelor of Science and Arts", "Bachelor of Music",
+		}},
+		{path: "$.credentialSubject.degrees[?(@.name =~ '^Master')].type", value: []interface{}{"MasterDegree"}},
+		{path: "$..type", value: []interface{}{"BachelorDegree", "MasterDegree", "BachelorDegree"}},
+		{path: "$.credentialSubject['id','name']", value: []interface{}{
+			"did:example:ebfeb1f712ebc6f1c276e12ec21", "Jayden Doe",
+		}},
+		{path: "$.credentialSubject.degrees[?(@.type == 'PhD')]", value: []interface{}{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			value, err := jsonPathValue(tc.path, credential)
+			require.NoError(t, err)
+			require.Equal(t, tc.value, value)
+
+			paths, err := getJSONPaths([]string{tc.path}, src)
+			require.NoError(t, err)
+
+			values, ok := value.([]interface{})
+			if !ok {
+				values = []interface{}{value}
+			}
+
+			require.Len(t, paths, len(values))
+
+			for i, path := range paths {
+				require.Equal(t, values[i], gjson.GetBytes(src, path[1]).Value())
+			}
+		})
+	}
+
+	t.Run("no value at definite path", func(t *testing.T) {
+		_, err := jsonPathValue("$.credentialSubject.degrees[3].type", credential)
+		require.EqualError(t, err, "no value at path $.credentialSubject.degrees[3].type")
+
+		paths, err := getJSONPaths([]string{"$.credentialSubject.degrees[3].type"}, src)
+		require.NoError(t, err)
+		require.Empty(t, paths)
+	})
+
+	t.Run("paths are ordered by the location in the document", func(t *testing.T) {
+		paths, err := getJSONPaths([]string{
+			"$.credentialSubject.degrees[2].name",
+			"$.credentialSubject.degrees[0].name",
+			"$.credentialSubject.degrees[0].type",
+		}, src)
+		require.NoError(t, err)
+		require.Equal(t, [][2]string{
+			{"credentialSubject.degrees.0.name", "credentialSubject.degrees.0.name"},
+			{"credentialSubject.degrees.0.type", "credentialSubject.degrees.0.type"},
+			{"credentialSubject.degrees.1.name", "credentialSubject.degrees.2.name"},
+		}, paths)
+	})
+
+	t.Run("slices", func(t *testing.T) {
+		array := []interface{}{"a", "b", "c", "d", "e"}
+
+		slices := []struct {
+			path  string
+			value []interface{}
+		}{
+			{path: "$[1:3]", value: []interface{}{"b", "c"}},
+			{path: "$[:2]", value: []interface{}{"a", "b"}},
+			{path: "$[-2:]", value: []interface{}{"d", "e"}},
+			{path: "$[::2]", value: []interface{}{"a", "c", "e"}},
+			{path: "$[1:10]", value: []interface{}{"b", "c", "d", "e"}},
+			{path: "$[3:1]", value: []interface{}{}},
+			{path: "$[3:0:-1]", value: []interface{}{"d", "c", "b"}},
+			{path: "$[::-1]", value: []interface{}{"e", "d", "c", "b", "a"}},
+			{path: "$[-1:-3:-1]", value: []interface{}{"e", "d"}},
+			{path: "$[10:0:-2]", value: []interface{}{"e", "c"}},
+			{path: "$[1:3:-1]", value: []interface{}{}},
+			{path: "$[0:5:0]", value: []interface{}{}},
+		}
+
+		for _, tc := range slices {
+			value, err := jsonPathValue(tc.path, array)
+			require.NoError(t, err, tc.path)
+			require.Equal(t, tc.value, value, tc.path)
+		}
+
+		// the paths to disclose are in the document order whatever the order of the selection.
+		paths, err := getJSONPaths([]string{"$.credentialSubject.degrees[2:0:-1].type"}, src)
+		require.NoError(t, err)
+		require.Equal(t, [][2]string{
+			{"credentialSubject.degrees.0.type", "credentialSubject.degrees.1.type"},
+			{"credentialSubject.degrees.1.type", "credentialSubject.degrees.2.type"},
+		}, paths)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		for _, path := range []string{"123", "$.", "$[0", "$[a]", "$[1:2:3:4]", "$[?(@.type ==)]"} {
+			_, err := jsonPathValue(path, credential)
+			require.Error(t, err, path)
+			require.Contains(t, err.Error(), "invalid path "+path)
+
+			_, err = getJSONPaths([]string{path}, src)
+			require.Error(t, err, path)
+		}
+	})
+}
+
+func TestFilterSchema(t *testing.T) {
+	strType := "string"
+
+	opts := newMatchRequirementsOpts(nil)
+
+	filter := &Filter{Type: &strType, Pattern: "^did:"}
+
+	schema, err := opts.filterSchema(filter)
+	require.NoError(t, err)
+
+	cached, err := opts.filterSchema(filter)
+	require.NoError(t, err)
+	require.Same(t, schema, cached)
+
+	require.NoError(t, validateFilter(filter, "did:example:123", opts))
+	require.ErrorIs(t, validateFilter(filter, "example", opts), errPathNotApplicable)
+	require.Len(t, opts.schemas, 1)
+
+	malformed := &Filter{Type: &strType, Pattern: "(1872"}
+
+	_, err = opts.filterSchema(malformed)
+	require.Error(t, err)
+	require.ErrorIs(t, validateFilter(malformed, "1872", opts), errPathNotApplicable)
+	require.Len(t, opts.schemas, 2)
+}
+
+func TestContainsSchema(t *testing.T) {
+	arrayType := "array"
+
+	opts := newMatchRequirementsOpts(nil)
+
+	filter := &Filter{Type: &arrayType, Contains: map[string]interface{}{"const": "b"}}
+
+	schema, err := opts.containsSchema(filter)
+	require.NoError(t, err)
+
+	cached, err := opts.containsSchema(filter)
+	require.NoError(t, err)
+	require.Same(t, schema, cached)
+
+	require.NoError(t, validateContains(filter, []interface{}{"a", "b"}, opts))
+	require.Error(t, validateContains(filter, []interface{}{"a", "c"}, opts))
+	require.Len(t, opts.containsSchemas, 1)
+}
+
+func TestFilterField_Context(t *testing.T) {
+	const vaccinationContext = "https://w3id.org/vaccination/v1"
+
+	arrayType := "array"
+	field := &Field{
+		Path:   []string{"$['@context']", "$.vc['@context']"},
+		Filter: &Filter{Type: &arrayType, Contains: map[string]interface{}{"const": vaccinationContext}},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		credential map[string]interface{}
+		index      int
+	}{
+		{
+			name:       "Single context",
+			credential: map[string]interface{}{"@context": vaccinationContext},
+		},
+		{
+			name: "Array of contexts",
+			credential: map[string]interface{}{
+				"@context": []interface{}{"https://www.w3.org/2018/credentials/v1", vaccinationContext},
+			},
+		},
+		{
+			name: "Array of contexts with embedded ones",
+			credential: map[string]interface{}{
+				"@context": []interface{}{
+					"https://www.w3.org/2018/credentials/v1",
+					map[string]interface{}{"name": "https://schema.org/name"},
+					vaccinationContext,
+				},
+			},
+		},
+		{
+			name: "Context of the vc claim",
+			credential: map[string]interface{}{
+				"vc": map[string]interface{}{"@context": vaccinationContext},
+			},
+			index: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			i, err := filterField(field, tc.credential, newMatchRequirementsOpts(nil))
+			require.NoError(t, err)
+			require.Equal(t, tc.index, i)
+		})
+	}
+
+	for _, tc := range []struct {
+		name       string
+		credential map[string]interface{}
+	}{
+		{
+			name:       "Other single context",
+			credential: map[string]interface{}{"@context": "https://www.w3.org/2018/credentials/v1"},
+		},
+		{
+			name: "Array of other contexts",
+			credential: map[string]interface{}{
+				"@context": []interface{}{
+					"https://www.w3.org/2018/credentials/v1",
+					map[string]interface{}{"vaccination": vaccinationContext},
+				},
+			},
+		},
+		{
+			name:       "No context",
+			credential: map[string]interface{}{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := filterField(field, tc.credential, newMatchRequirementsOpts(nil))
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("Filter on the string", func(t *testing.T) {
+		strType := "string"
+		str := &Field{
+			Path:   []string{"$['@context']"},
+			Filter: &Filter{Type: &strType, Const: vaccinationContext},
+		}
+
+		_, err := filterField(str, map[string]interface{}{"@context": vaccinationContext}, newMatchRequirementsOpts(nil))
+		require.NoError(t, err)
+
+		value, err := fieldValue("$['@context']", map[string]interface{}{"@context": vaccinationContext})
+		require.NoError(t, err)
+		require.Equal(t, vaccinationContext, value)
+	})
+
+	t.Run("Dot notation", func(t *testing.T) {
+		require.True(t, isContextPath("$.@context"))
+		require.True(t, isContextPath(`$["vc"]["@context"]`))
+		require.False(t, isContextPath("$['@context'][0]"))
+	})
+}
+
+func TestMakeRequirement_FromAndFromNested(t *testing.T) {
+	descriptors := []*InputDescriptor{{ID: "A", Group: []string{"adult"}}}
+	requirements := []*SubmissionRequirement{
+		{Rule: All, From: "adult"},
+		{
+			Rule: Pick,
+			From: "adult",
+			FromNested: []*SubmissionRequirement{
+				{Rule: All, From: "adult"},
+			},
+		},
+	}
+
+	_, err := makeRequirement(requirements, descriptors, newMatchRequirementsOpts(nil))
+	require.ErrorIs(t, err, ErrFromAndFromNested)
+
+	var defErr *DefinitionError
+	require.ErrorAs(t, err, &defErr)
+	require.Equal(t, "submission_requirements[1]", defErr.Element)
+
+	requirements[1].From = ""
+	requirements[1].FromNested[0].FromNested = []*SubmissionRequirement{{Rule: All, From: "adult"}}
+
+	_, err = makeRequirementsForMatch(requirements, descriptors, newMatchRequirementsOpts(nil))
+	require.ErrorAs(t, err, &defErr)
+	require.Equal(t, "submission_requirements[1].from_nested[0]", defErr.Element)
+
+	requirements[1].FromNested[0].FromNested = nil
+
+	req, err := makeRequirement(requirements, descriptors, newMatchRequirementsOpts(nil))
+	require.NoError(t, err)
+	require.Len(t, req.Nested, 2)
+}
+
+func TestSubjectIsIssuer(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		issuer  string
+		result  bool
+	}{
+		{"Same DID", "did:example:123", "did:example:123", true},
+		{"Fragment", "did:example:123#key-1", "did:example:123", true},
+		{"Issuer fragment", "did:example:123", "did:example:123#key-1", true},
+		{"Different fragments", "did:example:123#key-1", "did:example:123#key-2", true},
+		{"Path and query", "did:example:123/path?service=agent", "did:example:123", true},
+		{"Scheme and method case", "DID:Example:123", "did:example:123", true},
+		{"Different DIDs", "did:example:123#key-1", "did:example:456", false},
+		{"Prefix", "did:example:1234", "did:example:123", false},
+		{"did:key is case-sensitive", "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK",
+			"did:key:z6mkhaxgbzdvotdkl5257faiztigic2qtklgpbnnegta2dok", false},
+		{"did:key fragment", "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK#z6MkhaXgBZDvotDkL5257faiztiG",
+			"did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK", true},
+		{"did:web domain case", "did:web:Example.com", "did:web:example.com#key-1", true},
+		{"did:web path is case-sensitive", "did:web:example.com:User", "did:web:example.com:user", false},
+		{"did:web path", "did:web:example.com:user", "did:web:example.com", false},
+		{"did:ethr case", "did:ethr:0xB9C5714089478a327F09197987f16f9E5d936E8a",
+			"did:ethr:0xb9c5714089478a327f09197987f16f9e5d936e8a", true},
+		{"Not DIDs", "https://example.com/issuer#key-1", "https://example.com/issuer", false},
+		{"Same URL", "https://example.com/issuer", "https://example.com/issuer", true},
+		{"No subject ID", "", "", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			credential := &verifiable.Credential{
+				Subject: []verifiable.Subject{{ID: tc.subject}},
+				Issuer:  verifiable.Issuer{ID: tc.issuer},
+			}
+
+			require.Equal(t, tc.result, subjectIsIssuer(credential))
+		})
+	}
+}
+
+func TestGetLimitedDisclosures(t *testing.T) {
+	disclose := func(name string, value interface{}) *common.DisclosureClaim {
+		raw, err := json.Marshal([]interface{}{"salt-" + name, name, value})
+		require.NoError(t, err)
+
+		var disclosed []interface{}
+		require.NoError(t, json.Unmarshal(raw, &disclosed))
+
+		return &common.DisclosureClaim{
+			Disclosure: base64.RawURLEncoding.EncodeToString(raw),
+			Salt:       "salt-" + name,
+			Name:       name,
+			Value:      disclosed[2],
+		}
+	}
+
+	digest := func(dc *common.DisclosureClaim) string {
+		d, err := common.GetHash(crypto.SHA256, dc.Disclosure)
+		require.NoError(t, err)
+
+		return d
+	}
+
+	// address and geo are disclosed as objects having the digests of their own claims.
+	lat := disclose("lat", 48.85)
+	geo := disclose("geo", map[string]interface{}{"_sd": []string{digest(lat)}})
+	street := disclose("street", "Rue de Rivoli")
+	city := disclose("city", "Paris")
+	address := disclose("address", map[string]interface{}{
+		"_sd":     []string{digest(street), digest(city), digest(geo)},
+		"country": "FR",
+	})
+	name := disclose("name", "John")
+
+	credential := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      "http://example.edu/credentials/1872",
+		Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"_sd": []string{digest(address), digest(name)}},
+		}},
+		SDJWTHashAlg:     "sha-256",
+		SDJWTDisclosures: []*common.DisclosureClaim{name, address, city, street, geo, lat},
+	}
+
+	displaySrc := []byte(`{
+		"@context": ["https://www.w3.org/2018/credentials/v1"],
+		"type": ["VerifiableCredential"],
+		"id": "http://example.edu/credentials/1872",
+		"issuer": "did:example:issuer",
+		"credentialSubject": {
+			"id": "did:example:a",
+			"name": "John",
+			"address": {"street": "Rue de Rivoli", "city": "Paris", "country": "FR", "geo": {"lat": 48.85}}
+		}
+	}`)
+
+	for _, tc := range []struct {
+		name     string
+		paths    []string
+		expected []*common.DisclosureClaim
+	}{
+		{name: "One level", paths: []string{"$.credentialSubject.name"}, expected: []*common.DisclosureClaim{name}},
+		{
+			name:     "Two levels",
+			paths:    []string{"$.credentialSubject.address.street"},
+			expected: []*common.DisclosureClaim{address, street},
+		},
+		{
+			name:     "Three levels",
+			paths:    []string{"$.credentialSubject.address.geo.lat"},
+			expected: []*common.DisclosureClaim{address, geo, lat},
+		},
+		{
+			name:     "Claim of a disclosed object",
+			paths:    []string{"$.credentialSubject.address.country"},
+			expected: []*common.DisclosureClaim{address},
+		},
+		{
+			name:     "Shared parent",
+			paths:    []string{"$.credentialSubject.address.city", "$.credentialSubject.address.street"},
+			expected: []*common.DisclosureClaim{address, city, street},
+		},
+		{name: "Not selectively disclosable", paths: []string{"$.issuer"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			constraints := &Constraints{}
+			for _, path := range tc.paths {
+				constraints.Fields = append(constraints.Fields, &Field{Path: []string{path}})
+			}
+
+			disclosures, err := getLimitedDisclosures(constraints, displaySrc, credential)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, disclosures)
+		})
+	}
+}
+
+func TestVerifyDisclosure(t *testing.T) {
+	credential := &verifiable.Credential{
+		Context: []string{verifiable.ContextURI},
+		Types:   []string{verifiable.VCType},
+		ID:      tmpID("http://example.edu/credentials/1872", "1"),
+		Issuer:  verifiable.Issuer{ID: "did:example:issuer"},
+		Subject: []verifiable.Subject{{
+			ID:           "did:example:a",
+			CustomFields: verifiable.CustomFields{"given_name": "John"},
+		}},
+	}
+
+	opts := newMatchRequirementsOpts(nil)
+
+	require.NoError(t, verifyDisclosure(credential, []*Field{
+		{Path: []string{"$.id"}, Filter: &Filter{Const: "http://example.edu/credentials/1872"}},
+		{Path: []string{"$.credentialSubject.given_name"}},
+	}, opts))
+
+	err := verifyDisclosure(credential, []*Field{{Path: []string{"$.credentialSubject.family_name"}}}, opts)
+	require.ErrorIs(t, err, ErrDisclosureNotVerified)
+	require.Contains(t, err.Error(), "$.credentialSubject.family_name")
+}
@@ -0,0 +1,178 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+const patternKeyword = "pattern"
+
+// DiagnosticKind tells what is likely wrong with a valid filter.
+type DiagnosticKind string
+
+const (
+	// UnanchoredPatternDiagnostic means the pattern is not anchored with ^ and $, so it matches any value
+	// containing it.
+	UnanchoredPatternDiagnostic DiagnosticKind = "unanchored_pattern"
+	// NestedQuantifiersDiagnostic means the pattern nests unbounded quantifiers, which may cause catastrophic
+	// backtracking.
+	NestedQuantifiersDiagnostic DiagnosticKind = "nested_quantifiers"
+)
+
+// FilterDiagnostic describes a filter keyword which is valid but is likely not to work as intended.
+type FilterDiagnostic struct {
+	Kind DiagnosticKind
+	// Keyword is the JSON Schema keyword of the filter, e.g. pattern.
+	Keyword string
+	// Value is the value of the keyword.
+	Value   string
+	Message string
+}
+
+// Validate checks the regular expressions of the filter patterns, including the ones of the nested schemas
+// (e.g. of not or contains). An error is returned if a pattern is malformed. The diagnostics are returned
+// for the patterns which are valid but are likely not to work as intended; they are not fatal:
+//   - the pattern is not anchored with ^ and $, so it matches any value containing it, e.g. the pattern
+//     "1234" matches "01234567";
+//   - the pattern nests the unbounded quantifiers, e.g. "(a+)+", which takes exponential time to fail
+//     in the backtracking regular expression engines (the one of Go is not backtracking).
+func (f *Filter) Validate() ([]FilterDiagnostic, error) {
+	var diagnostics []FilterDiagnostic
+
+	for _, pattern := range f.patterns() {
+		re, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+
+		if !isAnchored(pattern) {
+			diagnostics = append(diagnostics, FilterDiagnostic{
+				Kind:    UnanchoredPatternDiagnostic,
+				Keyword: patternKeyword,
+				Value:   pattern,
+				Message: "pattern is not anchored with ^ and $, so it matches any value containing it",
+			})
+		}
+
+		if hasNestedQuantifiers(re, false) {
+			diagnostics = append(diagnostics, FilterDiagnostic{
+				Kind:    NestedQuantifiersDiagnostic,
+				Keyword: patternKeyword,
+				Value:   pattern,
+				Message: "pattern nests unbounded quantifiers, which may cause catastrophic backtracking",
+			})
+		}
+	}
+
+	return diagnostics, nil
+}
+
+// patterns returns the patterns of the filter and of its nested schemas.
+func (f *Filter) patterns() []string {
+	var patterns []string
+
+	if f.Pattern != "" {
+		patterns = append(patterns, f.Pattern)
+	}
+
+	for _, schema := range []map[string]interface{}{f.Not, f.Contains, f.CustomFields} {
+		patterns = append(patterns, schemaPatterns(schema)...)
+	}
+
+	return patterns
+}
+
+func schemaPatterns(v interface{}) []string {
+	var patterns []string
+
+	switch schema := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(schema))
+		for k := range schema {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if pattern, ok := schema[k].(string); ok && k == patternKeyword {
+				patterns = append(patterns, pattern)
+
+				continue
+			}
+
+			patterns = append(patterns, schemaPatterns(schema[k])...)
+		}
+	case []interface{}:
+		for _, item := range schema {
+			patterns = append(patterns, schemaPatterns(item)...)
+		}
+	}
+
+	return patterns
+}
+
+func isAnchored(pattern string) bool {
+	return strings.HasPrefix(pattern, "^") && strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`)
+}
+
+// hasNestedQuantifiers checks whether an unbounded quantifier of the regular expression repeats an expression
+// with another unbounded quantifier.
+func hasNestedQuantifiers(re *syntax.Regexp, repeated bool) bool {
+	unbounded := re.Op == syntax.OpStar || re.Op == syntax.OpPlus || (re.Op == syntax.OpRepeat && re.Max == -1)
+	if unbounded {
+		if repeated {
+			return true
+		}
+
+		repeated = true
+	}
+
+	for _, sub := range re.Sub {
+		if hasNestedQuantifiers(sub, repeated) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPatterns checks the patterns of the constraints fields (of both Filter and Filters) of all input descriptors
+// for WithStrictPatterns: the malformed patterns and the ones nesting unbounded quantifiers are rejected.
+func (pd *PresentationDefinition) checkPatterns() error {
+	for _, descriptor := range pd.InputDescriptors {
+		if descriptor.Constraints == nil {
+			continue
+		}
+
+		for i, field := range descriptor.Constraints.Fields {
+			for _, filter := range append([]*Filter{field.Filter}, field.Filters...) {
+				if filter == nil {
+					continue
+				}
+
+				diagnostics, err := filter.Validate()
+				if err != nil {
+					return fmt.Errorf("input descriptor %s field %d: %w", descriptor.ID, i, err)
+				}
+
+				for _, diagnostic := range diagnostics {
+					if diagnostic.Kind == NestedQuantifiersDiagnostic {
+						return fmt.Errorf("input descriptor %s field %d: %s %q: %s",
+							descriptor.ID, i, diagnostic.Keyword, diagnostic.Value, diagnostic.Message)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// enclosingPresentation is the presentation (given by WithPresentations) a credential is unwrapped from.
+type enclosingPresentation struct {
+	presentation *verifiable.Presentation
+	// index is the index of the credential in the presentation.
+	index int
+}
+
+// prepareCredentials returns the credentials to match: the given ones followed by the ones unwrapped from
+// the presentations given by WithPresentations (copied if WithCopyCredentials is used). The presentations
+// enclosing the credentials are remembered to map the input descriptors through them.
+func (opts *matchRequirementsOpts) prepareCredentials(
+	credentials []*verifiable.Credential) ([]*verifiable.Credential, error) {
+	if len(opts.presentations) == 0 {
+		if opts.copyCredentials {
+			return copyCredentials(credentials), nil
+		}
+
+		return credentials, nil
+	}
+
+	prepared := append([]*verifiable.Credential{}, credentials...)
+
+	var enclosing []*enclosingPresentation
+
+	for i, vp := range opts.presentations {
+		for j, c := range vp.Credentials() {
+			credential, ok := c.(*verifiable.Credential)
+			if !ok {
+				return nil, fmt.Errorf("credential %d of presentation %d is not parsed", j, i)
+			}
+
+			prepared = append(prepared, credential)
+			enclosing = append(enclosing, &enclosingPresentation{presentation: vp, index: j})
+		}
+	}
+
+	if opts.copyCredentials {
+		prepared = copyCredentials(prepared)
+	}
+
+	opts.enclosing = make(map[*verifiable.Credential]*enclosingPresentation, len(enclosing))
+
+	for i, e := range enclosing {
+		opts.enclosing[prepared[len(credentials)+i]] = e
+	}
+
+	return prepared, nil
+}
+
+// nestedDescriptorMapping maps the input descriptor to the credential through the presentation enclosing it,
+// which is at the given index of the presentation.
+func nestedDescriptorMapping(descriptorID, presentationFormat string, credential *verifiable.Credential,
+	enclosing *enclosingPresentation, idx int) *InputDescriptorMapping {
+	mapping := descriptorMapping(descriptorID, presentationFormat, credential, enclosing.index)
+
+	vpFormat := FormatLDPVP
+	if enclosing.presentation.JWT != "" {
+		vpFormat = FormatJWTVP
+	}
+
+	mapping.PathNested = &InputDescriptorMapping{
+		ID:         descriptorID,
+		Format:     vpFormat,
+		Path:       fmt.Sprintf("$.verifiableCredential[%d]", idx),
+		PathNested: mapping.PathNested,
+	}
+
+	return mapping
+}
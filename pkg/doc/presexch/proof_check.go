@@ -0,0 +1,105 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// WithRequireValidProofs verifies the proofs of the credentials with the given options (e.g. the public key fetcher
+// and the JSON-LD document loader) before matching them, and rejects the credentials whose proofs do not verify
+// by every input descriptor at ProofStage. A JWT (or SD-JWT) credential is verified by its JWT signature,
+// any other by its embedded proofs; the credentials without a proof and the mdocs (whose issuer signature is not
+// verified by this package) are rejected as well. Each credential is verified once per match.
+// By default, the proofs are not verified.
+func WithRequireValidProofs(options ...verifiable.CredentialOpt) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.requireValidProofs = true
+		opts.proofOpts = options
+	}
+}
+
+// filterInvalidProofs removes the credentials whose proofs do not verify with WithRequireValidProofs, recording
+// the rejections of the input descriptor.
+func filterInvalidProofs(descriptorID string, credentials []*verifiable.Credential,
+	opts *matchRequirementsOpts) []*verifiable.Credential {
+	if !opts.requireValidProofs {
+		return credentials
+	}
+
+	var result []*verifiable.Credential
+
+	for _, credential := range credentials {
+		if err := opts.proofError(credential); err != nil {
+			opts.rejections.add(&CredentialRejection{
+				CredentialID: credential.ID,
+				DescriptorID: descriptorID,
+				Stage:        ProofStage,
+				FieldIndex:   -1,
+				Reason:       err,
+			})
+
+			continue
+		}
+
+		result = append(result, credential)
+	}
+
+	return result
+}
+
+// proofError returns the error verifying the proof of the credential, verifying it on the first use.
+func (opts *matchRequirementsOpts) proofError(credential *verifiable.Credential) error {
+	opts.proofsMu.Lock()
+	defer opts.proofsMu.Unlock()
+
+	err, ok := opts.proofErrs[credential]
+	if !ok {
+		err = verifyProof(credential, opts.proofOpts)
+
+		if opts.proofErrs == nil {
+			opts.proofErrs = make(map[*verifiable.Credential]error)
+		}
+
+		opts.proofErrs[credential] = err
+	}
+
+	return err
+}
+
+// verifyProof verifies the proof of the credential by parsing it anew with the options.
+func verifyProof(credential *verifiable.Credential, options []verifiable.CredentialOpt) error {
+	if isMdoc(credential) {
+		return errors.New("mdoc issuer signature is not verified")
+	}
+
+	var src []byte
+
+	switch {
+	case credential.JWT != "":
+		src = []byte(credential.JWT)
+	case len(credential.Proofs) == 0:
+		return errors.New("credential has no proof")
+	default:
+		var err error
+
+		src, err = json.Marshal(credential)
+		if err != nil {
+			return fmt.Errorf("marshal credential: %w", err)
+		}
+	}
+
+	if _, err := verifiable.ParseCredential(src, options...); err != nil {
+		return fmt.Errorf("verify credential proof: %w", err)
+	}
+
+	return nil
+}
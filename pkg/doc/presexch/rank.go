@@ -0,0 +1,95 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// rankDescriptors keeps the best ranked credential of every input descriptor of the requirement and, for the pick
+// rule, only as many input descriptors as the requirement selects, preferring the ones with the best credentials.
+func (opts *matchRequirementsOpts) rankDescriptors(req *requirement,
+	result map[string][]*verifiable.Credential) map[string][]*verifiable.Credential {
+	var ranked []map[string][]*verifiable.Credential
+
+	for _, descriptor := range req.InputDescriptors {
+		if credentials, ok := result[descriptor.ID]; ok {
+			ranked = append(ranked, map[string][]*verifiable.Credential{
+				descriptor.ID: {opts.bestCredential(credentials)},
+			})
+		}
+	}
+
+	trimmed := make(map[string][]*verifiable.Credential)
+
+	for _, res := range opts.pickResults(req, ranked) {
+		for descriptorID, credentials := range res {
+			trimmed[descriptorID] = credentials
+		}
+	}
+
+	return trimmed
+}
+
+// pickResults keeps, for the pick rule, only as many results (of the input descriptors or the nested requirements)
+// as the requirement selects, preferring the ones with the best credentials. The results are returned as is
+// for the other rules or if the requirement selects no less than all of them.
+func (opts *matchRequirementsOpts) pickResults(req *requirement,
+	results []map[string][]*verifiable.Credential) []map[string][]*verifiable.Credential {
+	n := req.selectCount()
+	if req.Rule != Pick || n == 0 || n >= len(results) {
+		return results
+	}
+
+	best := make([]*verifiable.Credential, len(results))
+	for i, res := range results {
+		ids := make([]string, 0, len(res))
+		for id := range res {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+
+		var credentials []*verifiable.Credential
+		for _, id := range ids {
+			credentials = append(credentials, res[id]...)
+		}
+
+		best[i] = opts.bestCredential(credentials)
+	}
+
+	idx := make([]int, len(results))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	sort.SliceStable(idx, func(i, j int) bool {
+		return opts.credentialRanker(best[idx[i]], best[idx[j]]) < 0
+	})
+
+	picked := make([]map[string][]*verifiable.Credential, n)
+	for i := range picked {
+		picked[i] = results[idx[i]]
+	}
+
+	return picked
+}
+
+// bestCredential returns the first of the credentials ranked by the credential ranker.
+func (opts *matchRequirementsOpts) bestCredential(credentials []*verifiable.Credential) *verifiable.Credential {
+	best := credentials[0]
+
+	for _, credential := range credentials[1:] {
+		if opts.credentialRanker(credential, best) < 0 {
+			best = credential
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,68 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+
+	"github.com/piprate/json-gold/ld"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// CreateVPFromRaw works like CreateVP, but parses the raw credentials (JSON-LD, JWT or SD-JWT) first.
+// The credentials are parsed with the document loader and the options set by WithSDCredentialOptions
+// (e.g. the public key fetcher, or verifiable.WithDisabledProofCheck), i.e. the same options the matched
+// credentials are processed with.
+//
+// By default, the first credential which cannot be parsed fails CreateVPFromRaw. With
+// WithSkipUnparsableCredentials, such credentials are skipped instead and reported to the callback set by
+// WithOnSkip (with a nil credential and the error telling the index of the raw credential).
+func (pd *PresentationDefinition) CreateVPFromRaw(rawCredentials [][]byte,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) (*verifiable.Presentation, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+
+	credentials, err := parseCredentials(rawCredentials, documentLoader, matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	format, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pd.presentation(format, result, matchOpts)
+}
+
+func parseCredentials(rawCredentials [][]byte, documentLoader ld.DocumentLoader,
+	opts *matchRequirementsOpts) ([]*verifiable.Credential, error) {
+	// the options set by WithSDCredentialOptions take precedence over the document loader.
+	credOpts := append([]verifiable.CredentialOpt{verifiable.WithJSONLDDocumentLoader(documentLoader)},
+		opts.credOpts...)
+
+	credentials := make([]*verifiable.Credential, 0, len(rawCredentials))
+
+	for i, raw := range rawCredentials {
+		credential, err := verifiable.ParseCredential(raw, credOpts...)
+		if err != nil {
+			err = fmt.Errorf("parse credential %d: %w", i, err)
+
+			if !opts.skipUnparsable {
+				return nil, err
+			}
+
+			opts.skip(nil, err)
+
+			continue
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// SameSubjectGroup describes PresentationDefinition`s same_subject object: the credentials submitted for
+// the input descriptors the fields belong to must be about the same subject.
+type SameSubjectGroup struct {
+	FieldID   []string    `json:"field_id,omitempty"`
+	Directive *Preference `json:"directive,omitempty"`
+}
+
+// applySameSubject filters the result so that the credentials of the input descriptors grouped by
+// the same_subject directives share a subject ID.
+//
+// The subject is chosen so that every grouped descriptor of the result keeps at least one credential;
+// the descriptors which have no credentials in the result (e.g. were not picked) are not taken into account.
+// If there is no such subject, ErrNoCredentials is returned for a required directive,
+// while the result is left as is for a preferred one.
+func (pd *PresentationDefinition) applySameSubject(result map[string][]*verifiable.Credential,
+	opts *matchRequirementsOpts) error {
+	for i, group := range pd.SameSubject {
+		if group == nil || (!group.Directive.isRequired() && !group.Directive.isPreferred()) {
+			continue
+		}
+
+		fields, err := pd.sameSubjectFields(group)
+		if err != nil {
+			return fmt.Errorf("same_subject[%d]: %w", i, err)
+		}
+
+		subjects, err := credentialSubjects(fields, result)
+		if err != nil {
+			return fmt.Errorf("same_subject[%d]: %w", i, err)
+		}
+
+		if len(subjects) == 0 {
+			continue
+		}
+
+		subject, ok := commonSubject(result, subjects)
+
+		switch {
+		case ok:
+			for descriptorID := range subjects {
+				result[descriptorID] = credentialsOfSubject(result[descriptorID], subjects[descriptorID], subject)
+			}
+		case group.Directive.isRequired():
+			return ErrNoCredentials
+		default:
+			opts.log().Debugf("same_subject[%d] preferred directive is not satisfied", i)
+		}
+	}
+
+	return nil
+}
+
+// sameSubjectFields resolves the field_id of the same_subject group to the fields of the input descriptors.
+// The fields are grouped by the input descriptor ID.
+func (pd *PresentationDefinition) sameSubjectFields(group *SameSubjectGroup) (map[string][]*Field, error) {
+	fields := map[string][]*Field{}
+
+	for _, id := range group.FieldID {
+		var found bool
+
+		for _, descriptor := range pd.InputDescriptors {
+			if descriptor.Constraints == nil {
+				continue
+			}
+
+			if idx := fieldIndexByID(descriptor.Constraints.Fields, id); idx >= 0 {
+				fields[descriptor.ID] = append(fields[descriptor.ID], descriptor.Constraints.Fields[idx])
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("field_id %s is not defined in input descriptors", id)
+		}
+	}
+
+	return fields, nil
+}
+
+// credentialSubjects returns the IDs of the subjects of the grouped fields for every credential of the result
+// matched by the grouped input descriptors. The map is keyed by the descriptor ID and the credential, rather than
+// its ID, which may be missing or shared by several credentials.
+func credentialSubjects(fields map[string][]*Field,
+	result map[string][]*verifiable.Credential) (map[string]map[*verifiable.Credential][]string, error) {
+	subjects := map[string]map[*verifiable.Credential][]string{}
+
+	for descriptorID, descriptorFields := range fields {
+		for _, credential := range result[descriptorID] {
+			credentialSrc, err := marshalWithoutJWT(credential)
+			if err != nil {
+				return nil, fmt.Errorf("marshal credential: %w", err)
+			}
+
+			var ids []string
+
+			for _, field := range descriptorFields {
+				for _, path := range field.Path {
+					pathIDs, err := fieldSubjectIDs(path, credentialSrc)
+					if err != nil {
+						return nil, fmt.Errorf("field %s: %w", field.ID, err)
+					}
+
+					ids = append(ids, pathIDs...)
+				}
+			}
+
+			if _, ok := subjects[descriptorID]; !ok {
+				subjects[descriptorID] = map[*verifiable.Credential][]string{}
+			}
+
+			subjects[descriptorID][credential] = ids
+		}
+	}
+
+	return subjects, nil
+}
+
+// commonSubject returns the first subject ID (in the order of the sorted descriptor IDs and their credentials)
+// which is shared by a credential of every grouped descriptor of the result.
+func commonSubject(result map[string][]*verifiable.Credential,
+	subjects map[string]map[*verifiable.Credential][]string) (string, bool) {
+	descriptorIDs := make([]string, 0, len(subjects))
+	for descriptorID := range subjects {
+		descriptorIDs = append(descriptorIDs, descriptorID)
+	}
+
+	sort.Strings(descriptorIDs)
+
+	for _, credential := range result[descriptorIDs[0]] {
+		for _, subject := range subjects[descriptorIDs[0]][credential] {
+			if subject == "" {
+				continue
+			}
+
+			shared := true
+
+			for descriptorID := range subjects {
+				if len(credentialsOfSubject(result[descriptorID], subjects[descriptorID], subject)) == 0 {
+					shared = false
+
+					break
+				}
+			}
+
+			if shared {
+				return subject, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func credentialsOfSubject(credentials []*verifiable.Credential, subjects map[*verifiable.Credential][]string,
+	subject string) []*verifiable.Credential {
+	var result []*verifiable.Credential
+
+	for _, credential := range credentials {
+		if contains(subjects[credential], subject) {
+			result = append(result, credential)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,55 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"regexp"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// tmpIDSuffix matches the unique suffix given to the IDs of the limited credentials while matching (see tmpID),
+// up to the end of the ID or of the quoted ID within a path.
+var tmpIDSuffix = regexp.MustCompile(tmpEnding + `[^'"\s\])]*`) // nolint: gochecknoglobals
+
+// SanitizePresentation strips the unique suffixes the IDs of the limited credentials are given while matching
+// from the IDs of the credentials of the presentation (and of the enclosed presentations) and from the paths of
+// its presentation submission. The presentations created by CreateVP (and the like) have none, so it is a safety
+// net for the presentations made of the credentials matched in a custom flow, e.g. before they are serialized.
+func SanitizePresentation(vp *verifiable.Presentation) {
+	if vp == nil {
+		return
+	}
+
+	for _, credential := range vp.Credentials() {
+		switch vc := credential.(type) {
+		case *verifiable.Credential:
+			vc.ID = stripTmpIDs(vc.ID)
+		case *verifiable.Presentation:
+			SanitizePresentation(vc)
+		case map[string]interface{}:
+			if id, ok := vc["id"].(string); ok {
+				vc["id"] = stripTmpIDs(id)
+			}
+		}
+	}
+
+	submission, ok := vp.CustomFields[submissionProperty].(*PresentationSubmission)
+	if !ok {
+		return
+	}
+
+	for _, mapping := range submission.DescriptorMap {
+		for ; mapping != nil; mapping = mapping.PathNested {
+			mapping.Path = stripTmpIDs(mapping.Path)
+		}
+	}
+}
+
+func stripTmpIDs(s string) string {
+	return tmpIDSuffix.ReplaceAllString(s, "")
+}
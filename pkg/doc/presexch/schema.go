@@ -33,7 +33,13 @@ const DefinitionJSONSchemaV1 = `
          "type":"object",
          "properties":{
             "type":{
-               "type":"string"
+               "type":[
+                  "string",
+                  "array"
+               ],
+               "items":{
+                  "type":"string"
+               }
             },
             "format":{
                "type":"string"
@@ -115,6 +121,22 @@ const DefinitionJSONSchemaV1 = `
                ],
                "additionalProperties":false
             },
+            "^mso_mdoc$":{
+               "type":"object",
+               "properties":{
+                  "alg":{
+                     "type":"array",
+                     "minItems":1,
+                     "items":{
+                        "type":"string"
+                     }
+                  }
+               },
+               "required":[
+                  "alg"
+               ],
+               "additionalProperties":false
+            },
             "^ldp_vc$|^ldp_vp$|^ldp$":{
                "type":"object",
                "properties":{
@@ -124,13 +146,49 @@ const DefinitionJSONSchemaV1 = `
                      "items":{
                         "type":"string"
                      }
+                  },
+                  "cryptosuite":{
+                     "type":"array",
+                     "minItems":1,
+                     "items":{
+                        "type":"string"
+                     }
                   }
                },
-               "required":[
-                  "proof_type"
+               "anyOf":[
+                  {
+                     "required":[
+                        "proof_type"
+                     ]
+                  },
+                  {
+                     "required":[
+                        "cryptosuite"
+                     ]
+                  }
                ],
                "additionalProperties":false
             },
+            "^vc\\+sd-jwt$|^dc\\+sd-jwt$":{
+               "type":"object",
+               "properties":{
+                  "sd-jwt_alg_values":{
+                     "type":"array",
+                     "minItems":1,
+                     "items":{
+                        "type":"string"
+                     }
+                  },
+                  "kb-jwt_alg_values":{
+                     "type":"array",
+                     "minItems":1,
+                     "items":{
+                        "type":"string"
+                     }
+                  }
+               },
+               "additionalProperties":false
+            },
             "additionalProperties":false
          },
          "additionalProperties":false
@@ -165,8 +223,18 @@ const DefinitionJSONSchemaV1 = `
                      "type":"integer",
                      "minimum":0
                   },
+                  "format":{
+                     "$ref":"#/definitions/format"
+                  },
                   "from":{
-                     "type":"string"
+                     "type":[
+                        "string",
+                        "array"
+                     ],
+                     "items":{
+                        "type":"string"
+                     },
+                     "minItems":1
                   }
                },
                "required":[
@@ -202,6 +270,9 @@ const DefinitionJSONSchemaV1 = `
                      "type":"integer",
                      "minimum":0
                   },
+                  "format":{
+                     "$ref":"#/definitions/format"
+                  },
                   "from_nested":{
                      "type":"array",
                      "minItems":1,
@@ -230,6 +301,18 @@ const DefinitionJSONSchemaV1 = `
             "purpose":{
                "type":"string"
             },
+            "localized_name":{
+               "type":"object",
+               "additionalProperties":{
+                  "type":"string"
+               }
+            },
+            "localized_purpose":{
+               "type":"object",
+               "additionalProperties":{
+                  "type":"string"
+               }
+            },
             "group":{
                "type":"array",
                "items":{
@@ -363,6 +446,9 @@ const DefinitionJSONSchemaV1 = `
                   }
                },
                "additionalProperties":false
+            },
+            "frame":{
+               "type":"object"
             }
          },
          "required":[
@@ -385,11 +471,23 @@ const DefinitionJSONSchemaV1 = `
                         "type":"string"
                      }
                   },
+                  "name":{
+                     "type":"string"
+                  },
                   "purpose":{
                      "type":"string"
                   },
+                  "derive":{
+                     "type":"string"
+                  },
                   "filter":{
                      "$ref":"#/definitions/filter"
+                  },
+                  "filters":{
+                     "type":"array",
+                     "items":{
+                        "$ref":"#/definitions/filter"
+                     }
                   }
                },
                "required":[
@@ -408,12 +506,24 @@ const DefinitionJSONSchemaV1 = `
                         "type":"string"
                      }
                   },
+                  "name":{
+                     "type":"string"
+                  },
                   "purpose":{
                      "type":"string"
                   },
+                  "derive":{
+                     "type":"string"
+                  },
                   "filter":{
                      "$ref":"#/definitions/filter"
                   },
+                  "filters":{
+                     "type":"array",
+                     "items":{
+                        "$ref":"#/definitions/filter"
+                     }
+                  },
                   "predicate":{
                      "type":"string",
                      "enum":[
@@ -446,6 +556,18 @@ const DefinitionJSONSchemaV1 = `
             "purpose":{
                "type":"string"
             },
+            "localized_name":{
+               "type":"object",
+               "additionalProperties":{
+                  "type":"string"
+               }
+            },
+            "localized_purpose":{
+               "type":"object",
+               "additionalProperties":{
+                  "type":"string"
+               }
+            },
             "format":{
                "$ref":"#/definitions/format"
             },
@@ -460,6 +582,32 @@ const DefinitionJSONSchemaV1 = `
                "items":{
                   "$ref":"#/definitions/input_descriptors"
                }
+            },
+            "same_subject":{
+               "type":"array",
+               "items":{
+                  "type":"object",
+                  "properties":{
+                     "field_id":{
+                        "type":"array",
+                        "items":{
+                           "type":"string"
+                        }
+                     },
+                     "directive":{
+                        "type":"string",
+                        "enum":[
+                           "required",
+                           "preferred"
+                        ]
+                     }
+                  },
+                  "required":[
+                     "field_id",
+                     "directive"
+                  ],
+                  "additionalProperties":false
+               }
             }
          },
          "required":[
@@ -503,9 +651,16 @@ const DefinitionJSONSchemaV2 = `
               "type": "array",
               "items": { "type": "string" }
             },
+            "name": { "type": "string" },
             "purpose": { "type": "string" },
             "intent_to_retain": { "type": "boolean" },
-            "filter": { "$ref": "http://json-schema.org/draft-07/schema#" }
+            "optional": { "type": "boolean" },
+            "derive": { "type": "string" },
+            "filter": { "$ref": "http://json-schema.org/draft-07/schema#" },
+            "filters": {
+              "type": "array",
+              "items": { "$ref": "http://json-schema.org/draft-07/schema#" }
+            }
           },
           "required": ["path"],
           "additionalProperties": false
@@ -517,9 +672,16 @@ const DefinitionJSONSchemaV2 = `
               "type": "array",
               "items": { "type": "string" }
             },
+            "name": { "type": "string" },
             "purpose": { "type": "string" },
             "intent_to_retain": { "type": "boolean" },
+            "optional": { "type": "boolean" },
+            "derive": { "type": "string" },
             "filter": { "$ref": "http://json-schema.org/draft-07/schema#" },
+            "filters": {
+              "type": "array",
+              "items": { "$ref": "http://json-schema.org/draft-07/schema#" }
+            },
             "predicate": {
               "type": "string",
               "enum": ["required", "preferred"]
@@ -537,6 +699,14 @@ const DefinitionJSONSchemaV2 = `
         "id": { "type": "string" },
         "name": { "type": "string" },
         "purpose": { "type": "string" },
+        "localized_name": {
+          "type": "object",
+          "additionalProperties": { "type": "string" }
+        },
+        "localized_purpose": {
+          "type": "object",
+          "additionalProperties": { "type": "string" }
+        },
         "format": {
 		  "$schema": "http://json-schema.org/draft-07/schema#",
 		  "title": "Presentation Definition Claim Format Designations",
@@ -554,6 +724,17 @@ const DefinitionJSONSchemaV2 = `
 				}
 			  }
 			},
+			"^mso_mdoc$": {
+			  "type": "object",
+			  "additionalProperties": false,
+			  "properties": {
+				"alg": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
+				}
+			  }
+			},
 			"^ldp_vc$|^ldp_vp$|^ldp$": {
 			  "type": "object",
 			  "additionalProperties": false,
@@ -562,6 +743,27 @@ const DefinitionJSONSchemaV2 = `
 				  "type": "array",
 				  "minItems": 1,
 				  "items": { "type": "string" }
+				},
+				"cryptosuite": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
+				}
+			  }
+			},
+			"^vc\\+sd-jwt$|^dc\\+sd-jwt$": {
+			  "type": "object",
+			  "additionalProperties": false,
+			  "properties": {
+				"sd-jwt_alg_values": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
+				},
+				"kb-jwt_alg_values": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
 				}
 			  }
 			}
@@ -624,10 +826,74 @@ const DefinitionJSONSchemaV2 = `
               }
             }
           }
+        },
+        "frame": {
+          "type": "object",
+          "additionalProperties": true
         }
       },
       "required": ["id"]
     },
+    "format": {
+      "type": "object",
+      "additionalProperties": false,
+      "patternProperties": {
+        "^jwt$|^jwt_vc$|^jwt_vp$": {
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "alg": {
+              "type": "array",
+              "minItems": 1,
+              "items": { "type": "string" }
+            }
+          }
+        },
+        "^mso_mdoc$": {
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "alg": {
+              "type": "array",
+              "minItems": 1,
+              "items": { "type": "string" }
+            }
+          }
+        },
+        "^ldp_vc$|^ldp_vp$|^ldp$": {
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "proof_type": {
+              "type": "array",
+              "minItems": 1,
+              "items": { "type": "string" }
+            },
+            "cryptosuite": {
+              "type": "array",
+              "minItems": 1,
+              "items": { "type": "string" }
+            }
+          }
+        },
+        "^vc\\+sd-jwt$|^dc\\+sd-jwt$": {
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "sd-jwt_alg_values": {
+              "type": "array",
+              "minItems": 1,
+              "items": { "type": "string" }
+            },
+            "kb-jwt_alg_values": {
+              "type": "array",
+              "minItems": 1,
+              "items": { "type": "string" }
+            }
+          }
+        }
+      }
+    },
     "submission_requirement": {
       "type": "object",
       "oneOf": [
@@ -642,7 +908,8 @@ const DefinitionJSONSchemaV2 = `
             "count": { "type": "integer", "minimum": 1 },
             "min": { "type": "integer", "minimum": 0 },
             "max": { "type": "integer", "minimum": 0 },
-            "from": { "type": "string" }
+            "format": { "$ref": "#/definitions/format" },
+            "from": { "type": ["string", "array"], "items": { "type": "string" }, "minItems": 1 }
           },
           "required": ["rule", "from"],
           "additionalProperties": false
@@ -658,6 +925,7 @@ const DefinitionJSONSchemaV2 = `
             "count": { "type": "integer", "minimum": 1 },
             "min": { "type": "integer", "minimum": 0 },
             "max": { "type": "integer", "minimum": 0 },
+            "format": { "$ref": "#/definitions/format" },
             "from_nested": {
               "type": "array",
               "minItems": 1,
@@ -677,6 +945,14 @@ const DefinitionJSONSchemaV2 = `
         "id": { "type": "string" },
         "name": { "type": "string" },
         "purpose": { "type": "string" },
+        "localized_name": {
+          "type": "object",
+          "additionalProperties": { "type": "string" }
+        },
+        "localized_purpose": {
+          "type": "object",
+          "additionalProperties": { "type": "string" }
+        },
         "format": {
 		  "$schema": "http://json-schema.org/draft-07/schema#",
 		  "title": "Presentation Definition Claim Format Designations",
@@ -694,6 +970,17 @@ const DefinitionJSONSchemaV2 = `
 				}
 			  }
 			},
+			"^mso_mdoc$": {
+			  "type": "object",
+			  "additionalProperties": false,
+			  "properties": {
+				"alg": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
+				}
+			  }
+			},
 			"^ldp_vc$|^ldp_vp$|^ldp$": {
 			  "type": "object",
 			  "additionalProperties": false,
@@ -702,6 +989,27 @@ const DefinitionJSONSchemaV2 = `
 				  "type": "array",
 				  "minItems": 1,
 				  "items": { "type": "string" }
+				},
+				"cryptosuite": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
+				}
+			  }
+			},
+			"^vc\\+sd-jwt$|^dc\\+sd-jwt$": {
+			  "type": "object",
+			  "additionalProperties": false,
+			  "properties": {
+				"sd-jwt_alg_values": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
+				},
+				"kb-jwt_alg_values": {
+				  "type": "array",
+				  "minItems": 1,
+				  "items": { "type": "string" }
 				}
 			  }
 			}
@@ -720,6 +1028,24 @@ const DefinitionJSONSchemaV2 = `
         "input_descriptors": {
           "type": "array",
           "items": { "$ref": "#/definitions/input_descriptor" }
+        },
+        "same_subject": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "additionalProperties": false,
+            "properties": {
+              "field_id": {
+                "type": "array",
+                "items": { "type": "string" }
+              },
+              "directive": {
+                "type": "string",
+                "enum": ["required", "preferred"]
+              }
+            },
+            "required": ["field_id", "directive"]
+          }
         }
       },
       "required": ["id", "input_descriptors"],
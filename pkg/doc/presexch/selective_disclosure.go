@@ -0,0 +1,48 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// SelectiveDisclosureProvider derives the BBS+ selective disclosure of a credential: the credential revealing
+// the properties of the reveal document (a JSON-LD frame), with the proof derived using the nonce. It is used
+// to frame the credentials (with the frame of the presentation definition or input descriptor) and to limit
+// the disclosure of the BBS+ credentials.
+type SelectiveDisclosureProvider interface {
+	GenerateSelectiveDisclosure(credential *verifiable.Credential, revealDoc map[string]interface{}, nonce []byte,
+		opts ...verifiable.CredentialOpt) (*verifiable.Credential, error)
+}
+
+// WithSelectiveDisclosureProvider sets the provider deriving the BBS+ selective disclosures, e.g. an alternative
+// BBS+ implementation or a mock for tests. By default, the disclosures are derived with
+// verifiable.Credential.GenerateBBSSelectiveDisclosure.
+func WithSelectiveDisclosureProvider(provider SelectiveDisclosureProvider) MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.disclosureProvider = provider
+	}
+}
+
+// bbsDisclosureProvider is the default SelectiveDisclosureProvider of the verifiable package.
+type bbsDisclosureProvider struct{}
+
+func (bbsDisclosureProvider) GenerateSelectiveDisclosure(credential *verifiable.Credential,
+	revealDoc map[string]interface{}, nonce []byte, opts ...verifiable.CredentialOpt) (*verifiable.Credential, error) {
+	return credential.GenerateBBSSelectiveDisclosure(revealDoc, nonce, opts...)
+}
+
+// selectiveDisclosure derives the selective disclosure of the credential with the provider of the options.
+func (opts *matchRequirementsOpts) selectiveDisclosure(credential *verifiable.Credential,
+	revealDoc map[string]interface{}, nonce []byte, credOpts ...verifiable.CredentialOpt) (*verifiable.Credential, error) {
+	var provider SelectiveDisclosureProvider = bbsDisclosureProvider{}
+	if opts.disclosureProvider != nil {
+		provider = opts.disclosureProvider
+	}
+
+	return provider.GenerateSelectiveDisclosure(credential, revealDoc, nonce, credOpts...)
+}
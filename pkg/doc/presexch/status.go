@@ -0,0 +1,84 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+const (
+	// StatusActive is the status of a credential which is neither suspended nor revoked.
+	StatusActive CredentialStatus = "active"
+	// StatusSuspended is the status of a suspended credential.
+	StatusSuspended CredentialStatus = "suspended"
+	// StatusRevoked is the status of a revoked credential.
+	StatusRevoked CredentialStatus = "revoked"
+)
+
+// CredentialStatus is the status of a credential: "active", "suspended" or "revoked".
+type CredentialStatus string
+
+// StatusResolver resolves the status of a credential (e.g. by fetching the StatusList2021 credential
+// referenced by credentialStatus).
+type StatusResolver interface {
+	Resolve(vc *verifiable.Credential) (CredentialStatus, error)
+}
+
+// checkStatuses checks the credential status against the statuses directives.
+// The check is skipped if either statuses or resolver is not set.
+func checkStatuses(statuses *Statuses, vc *verifiable.Credential, resolver StatusResolver) error {
+	if statuses == nil || resolver == nil {
+		return nil
+	}
+
+	status, err := resolver.Resolve(vc)
+	if err != nil {
+		return fmt.Errorf("resolve credential status: %w", err)
+	}
+
+	directives := []struct {
+		status    CredentialStatus
+		directive *StatusDirective
+	}{
+		{status: StatusActive, directive: statuses.Active},
+		{status: StatusSuspended, directive: statuses.Suspended},
+		{status: StatusRevoked, directive: statuses.Revoked},
+	}
+
+	for _, d := range directives {
+		directiveStatus, directive := d.status, d.directive
+		if directive == nil || !statusTypeMatches(directive.Type, vc) {
+			continue
+		}
+
+		switch directive.Directive {
+		case DirectiveRequired:
+			if status != directiveStatus {
+				return fmt.Errorf("credential status is %s, but %s is required", status, directiveStatus)
+			}
+		case DirectiveDisallowed:
+			if status == directiveStatus {
+				return fmt.Errorf("credential status %s is disallowed", status)
+			}
+		case DirectiveAllowed:
+		}
+	}
+
+	return nil
+}
+
+// statusTypeMatches checks whether the directive applies to the credential status type.
+// Directive without types applies to any credential.
+func statusTypeMatches(types []string, vc *verifiable.Credential) bool {
+	if len(types) == 0 {
+		return true
+	}
+
+	return vc.Status != nil && contains(types, vc.Status.Type)
+}
@@ -0,0 +1,212 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"github.com/piprate/json-gold/ld"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// MatchUpdate is sent by MatchSubmissionRequirementStream when an input descriptor matches a credential.
+type MatchUpdate struct {
+	// Descriptor is the matching input descriptor, with the matched credential in MatchedVCs.
+	Descriptor *MatchedInputDescriptor
+	// Satisfied tells whether all the submission requirements are satisfied by the credentials matched so far.
+	Satisfied bool
+}
+
+// MatchSubmissionRequirementStream works like MatchSubmissionRequirement, but consumes the credentials from
+// the channel one by one, until it is closed, so that a large wallet does not have to be loaded at once:
+// only the matched credentials are kept. Every match is sent to onMatch as soon as it is found, e.g. for
+// a UI to show the results progressively. If onMatch returns false (e.g. once MatchUpdate.Satisfied is true),
+// no more credentials are received from the channel and the matches found so far are returned.
+//
+// The context set by WithContext interrupts the wait for the credentials as well. The credentials of
+// the presentations set by WithPresentations are matched before the ones received from the channel.
+func (pd *PresentationDefinition) MatchSubmissionRequirementStream(credentials <-chan *verifiable.Credential,
+	documentLoader ld.DocumentLoader, onMatch func(update *MatchUpdate) bool,
+	opts ...MatchRequirementsOpt) ([]*MatchedSubmissionRequirement, error) {
+	matchOpts := newMatchRequirementsOpts(opts)
+
+	requirements, err := pd.requirementsForMatch(matchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &matchStream{
+		pd:             pd,
+		requirements:   requirements,
+		documentLoader: documentLoader,
+		opts:           matchOpts,
+		matches:        map[*requirement][][]*verifiable.Credential{},
+	}
+
+	enclosed, err := matchOpts.prepareCredentials(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, credential := range enclosed {
+		more, err := stream.match(credential, onMatch)
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			return stream.result(), nil
+		}
+	}
+
+	for {
+		credential, ok, err := stream.next(credentials)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return stream.result(), nil
+		}
+
+		if matchOpts.copyCredentials {
+			credential = copyCredential(credential)
+		}
+
+		more, err := stream.match(credential, onMatch)
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			return stream.result(), nil
+		}
+	}
+}
+
+// matchStream holds the credentials matched by the input descriptors of every requirement so far.
+type matchStream struct {
+	pd             *PresentationDefinition
+	requirements   []*requirement
+	documentLoader ld.DocumentLoader
+	opts           *matchRequirementsOpts
+	// matches are the credentials matched by each input descriptor of the requirements, which may
+	// match differently in different requirements (e.g. of different formats).
+	matches map[*requirement][][]*verifiable.Credential
+}
+
+// next receives the next credential, unless the channel is closed or the context set by WithContext is done.
+func (s *matchStream) next(credentials <-chan *verifiable.Credential) (*verifiable.Credential, bool, error) {
+	if s.opts.ctx == nil {
+		credential, ok := <-credentials
+
+		return credential, ok, nil
+	}
+
+	select {
+	case credential, ok := <-credentials:
+		return credential, ok, nil
+	case <-s.opts.ctx.Done():
+		return nil, false, s.opts.checkDeadline()
+	}
+}
+
+// match matches the credential against the input descriptors of all the requirements, and tells whether
+// the matching goes on.
+func (s *matchStream) match(credential *verifiable.Credential, onMatch func(update *MatchUpdate) bool) (bool, error) {
+	var updates []*MatchedInputDescriptor
+
+	err := s.forEachRequirement(s.requirements, func(req *requirement) error {
+		if _, ok := s.matches[req]; !ok {
+			s.matches[req] = make([][]*verifiable.Credential, len(req.InputDescriptors))
+		}
+
+		for i, descriptor := range req.InputDescriptors {
+			_, filtered, err := s.pd.filterCredentialsThatMatchDescriptor([]*verifiable.Credential{credential},
+				descriptor, req.Format, s.documentLoader, s.opts)
+			if err != nil {
+				return err
+			}
+
+			if len(filtered) == 0 {
+				continue
+			}
+
+			s.matches[req][i] = append(s.matches[req][i], filtered...)
+			updates = append(updates, matchedDescriptor(descriptor, filtered))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, update := range updates {
+		if !onMatch(&MatchUpdate{Descriptor: update, Satisfied: s.satisfied()}) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *matchStream) forEachRequirement(requirements []*requirement, f func(req *requirement) error) error {
+	for _, req := range requirements {
+		if err := f(req); err != nil {
+			return err
+		}
+
+		if err := s.forEachRequirement(req.Nested, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *matchStream) satisfied() bool {
+	for _, matched := range s.result() {
+		if !matched.satisfied() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// result returns the requirements matched so far.
+func (s *matchStream) result() []*MatchedSubmissionRequirement {
+	result := make([]*MatchedSubmissionRequirement, len(s.requirements))
+
+	for i, req := range s.requirements {
+		result[i] = s.matchedRequirement(req)
+	}
+
+	return result
+}
+
+func (s *matchStream) matchedRequirement(req *requirement) *MatchedSubmissionRequirement {
+	var (
+		descriptors []*MatchedInputDescriptor
+		nested      []*MatchedSubmissionRequirement
+	)
+
+	for i, descriptor := range req.InputDescriptors {
+		var matched []*verifiable.Credential
+		if s.matches[req] != nil {
+			matched = s.matches[req][i]
+		}
+
+		descriptors = append(descriptors, matchedDescriptor(descriptor, matched))
+	}
+
+	for _, nestedReq := range req.Nested {
+		nested = append(nested, s.matchedRequirement(nestedReq))
+	}
+
+	return newMatchedRequirement(req, descriptors, nested)
+}
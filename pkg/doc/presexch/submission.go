@@ -0,0 +1,95 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/piprate/json-gold/ld"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// AddSubmissionToPresentation describes the already built presentation with the presentation submission:
+// the credentials of the presentation are matched against the presentation definition the same way CreateVP
+// matches them, and the presentation submission mapping the input descriptors to the matched credentials
+// is set to the presentation along with its JSON-LD context and type.
+//
+// The credentials of the presentation must be parsed (i.e. *verifiable.Credential) and are neither reordered
+// nor limited: limit_disclosure is up to the builder of the presentation. ErrNoCredentials is returned
+// if the credentials do not satisfy the presentation definition; the presentation is left intact in that case.
+func AddSubmissionToPresentation(vp *verifiable.Presentation, pd *PresentationDefinition,
+	documentLoader ld.DocumentLoader, opts ...MatchRequirementsOpt) error {
+	credentials := make([]*verifiable.Credential, len(vp.Credentials()))
+
+	for i, c := range vp.Credentials() {
+		credential, ok := c.(*verifiable.Credential)
+		if !ok {
+			return fmt.Errorf("credential %d of the presentation is not parsed", i)
+		}
+
+		credentials[i] = credential
+	}
+
+	matchOpts := newMatchRequirementsOpts(opts)
+	matchOpts.noLimitDisclosure = true
+	// the credentials of the presentation are looked up by identity, and are the only ones described.
+	matchOpts.copyCredentials = false
+	matchOpts.presentations = nil
+
+	format, result, err := pd.matchCredentials(credentials, documentLoader, matchOpts)
+	if err != nil {
+		return err
+	}
+
+	descriptorIDs := make([]string, 0, len(result))
+	for descriptorID := range result {
+		descriptorIDs = append(descriptorIDs, descriptorID)
+	}
+
+	sort.Strings(descriptorIDs)
+
+	var descriptors []*InputDescriptorMapping
+
+	for _, descriptorID := range descriptorIDs {
+		for _, credential := range result[descriptorID] {
+			descriptors = append(descriptors,
+				descriptorMapping(descriptorID, format, credential, credentialIndex(credentials, credential)))
+		}
+	}
+
+	if !stringsContain(vp.Context, PresentationSubmissionJSONLDContextIRI) {
+		vp.Context = append(vp.Context, PresentationSubmissionJSONLDContextIRI)
+	}
+
+	if !stringsContain(vp.Type, PresentationSubmissionJSONLDType) {
+		vp.Type = append(vp.Type, PresentationSubmissionJSONLDType)
+	}
+
+	if vp.CustomFields == nil {
+		vp.CustomFields = verifiable.CustomFields{}
+	}
+
+	vp.CustomFields[submissionProperty] = &PresentationSubmission{
+		ID:            matchOpts.presentationSubmissionID(),
+		DefinitionID:  pd.ID,
+		DescriptorMap: descriptors,
+	}
+
+	return nil
+}
+
+func credentialIndex(credentials []*verifiable.Credential, credential *verifiable.Credential) int {
+	for i, c := range credentials {
+		if c == credential {
+			return i
+		}
+	}
+
+	return -1
+}
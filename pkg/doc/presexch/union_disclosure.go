@@ -0,0 +1,230 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package presexch
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/sdjwt/common"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// WithUnionDisclosure presents a credential matched by several input descriptors, which limit its disclosure
+// differently, once: with the union of the claims the input descriptors disclose, which satisfies all of them.
+// By default, the credential is presented once per input descriptor, each copy disclosing the claims of its
+// input descriptor only.
+//
+// The union applies to the credentials limited without a proof (the JSON-LD credentials without BBS+ proofs and
+// the JWT credentials) and to the disclosures of the SD-JWT credentials. If an input descriptor discloses
+// the credential in full, the full credential is presented for all of them. The BBS+ derived credentials and
+// the mdocs are presented per input descriptor, and so are the limited credentials whose claims conflict
+// (e.g. a claim replaced by a predicate for one input descriptor only, or arrays compacted differently).
+func WithUnionDisclosure() MatchRequirementsOpt {
+	return func(opts *matchRequirementsOpts) {
+		opts.unionDisclosure = true
+	}
+}
+
+// recordLimited records the credential the limited credential was created from, for WithUnionDisclosure.
+func (opts *matchRequirementsOpts) recordLimited(limited, original *verifiable.Credential) {
+	if !opts.unionDisclosure {
+		return
+	}
+
+	opts.limitedMu.Lock()
+	defer opts.limitedMu.Unlock()
+
+	if opts.limitedFrom == nil {
+		opts.limitedFrom = make(map[*verifiable.Credential]*verifiable.Credential)
+	}
+
+	opts.limitedFrom[limited] = original
+}
+
+// unionOccurrence is a credential of the match result: result[descriptorID][index].
+type unionOccurrence struct {
+	descriptorID string
+	index        int
+	credential   *verifiable.Credential
+}
+
+// unionDisclosures replaces the credentials limited differently by several input descriptors with the union
+// of their disclosures, see WithUnionDisclosure.
+func (pd *PresentationDefinition) unionDisclosures(result map[string][]*verifiable.Credential,
+	opts *matchRequirementsOpts) error {
+	var (
+		originals   []*verifiable.Credential
+		occurrences = map[*verifiable.Credential][]*unionOccurrence{}
+	)
+
+	for _, descriptor := range pd.InputDescriptors {
+		for i, credential := range result[descriptor.ID] {
+			original, ok := opts.limitedFrom[credential]
+			if !ok {
+				original = credential
+			}
+
+			if _, ok = occurrences[original]; !ok {
+				originals = append(originals, original)
+			}
+
+			occurrences[original] = append(occurrences[original], &unionOccurrence{
+				descriptorID: descriptor.ID,
+				index:        i,
+				credential:   credential,
+			})
+		}
+	}
+
+	for _, original := range originals {
+		group := occurrences[original]
+		if len(group) < 2 {
+			continue
+		}
+
+		union, err := disclosureUnion(original, group, opts)
+		if err != nil {
+			return err
+		}
+
+		if union == nil {
+			continue
+		}
+
+		for _, occurrence := range group {
+			result[occurrence.descriptorID][occurrence.index] = union
+
+			opts.audit.replace(occurrence.descriptorID, occurrence.credential, union)
+
+			if union == original {
+				// the original is presented, so it is not collected by WithDebugOriginals.
+				opts.originals.replace(occurrence.descriptorID, occurrence.credential, nil)
+			} else {
+				opts.originals.replace(occurrence.descriptorID, occurrence.credential, union)
+			}
+		}
+	}
+
+	return nil
+}
+
+// disclosureUnion returns the credential disclosing the claims of all the occurrences of the original credential,
+// or nil if their disclosures cannot be united.
+func disclosureUnion(original *verifiable.Credential, group []*unionOccurrence,
+	opts *matchRequirementsOpts) (*verifiable.Credential, error) {
+	for _, occurrence := range group {
+		if occurrence.credential == original {
+			// the credential is disclosed in full for one of the input descriptors.
+			return original, nil
+		}
+	}
+
+	if original.SDJWTHashAlg != "" {
+		return sdJWTDisclosureUnion(original, group), nil
+	}
+
+	if isMdoc(original) {
+		return nil, nil
+	}
+
+	var union map[string]interface{}
+
+	for _, occurrence := range group {
+		if len(occurrence.credential.Proofs) != 0 {
+			return nil, nil
+		}
+
+		limited := *occurrence.credential
+		limited.ID = trimTmpID(limited.ID)
+
+		src, err := json.Marshal(&limited)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc map[string]interface{}
+
+		if err = json.Unmarshal(src, &doc); err != nil {
+			return nil, err
+		}
+
+		if union == nil {
+			union = doc
+		} else if !mergeDisclosed(union, doc) {
+			return nil, nil
+		}
+	}
+
+	src, err := json.Marshal(union)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := verifiable.ParseCredential(src,
+		append(append([]verifiable.CredentialOpt{}, opts.credOpts...), verifiable.WithDisabledProofCheck())...)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.markLimited(credential)
+
+	return credential, nil
+}
+
+// sdJWTDisclosureUnion returns the SD-JWT credential with the disclosures of all the occurrences, in the order
+// of the original credential.
+func sdJWTDisclosureUnion(original *verifiable.Credential, group []*unionOccurrence) *verifiable.Credential {
+	disclosed := map[string]struct{}{}
+
+	for _, occurrence := range group {
+		for _, disclosure := range occurrence.credential.SDJWTDisclosures {
+			disclosed[disclosure.Disclosure] = struct{}{}
+		}
+	}
+
+	var disclosures []*common.DisclosureClaim
+
+	for _, disclosure := range original.SDJWTDisclosures {
+		if _, ok := disclosed[disclosure.Disclosure]; ok {
+			disclosures = append(disclosures, disclosure)
+		}
+	}
+
+	union := *group[0].credential
+	union.SDJWTDisclosures = disclosures
+
+	return &union
+}
+
+// mergeDisclosed adds the properties of src to dst. The properties of both must be either objects,
+// which are merged in turn, or equal values; the arrays are not merged, since the limited credentials compact
+// the array indices. It returns false if the properties conflict.
+func mergeDisclosed(dst, src map[string]interface{}) bool {
+	for k, v := range src {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+
+			continue
+		}
+
+		existingMap, existingIsMap := existing.(map[string]interface{})
+		vMap, vIsMap := v.(map[string]interface{})
+
+		switch {
+		case existingIsMap && vIsMap:
+			if !mergeDisclosed(existingMap, vMap) {
+				return false
+			}
+		case !reflect.DeepEqual(existing, v):
+			return false
+		}
+	}
+
+	return true
+}
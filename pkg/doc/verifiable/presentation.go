@@ -207,6 +207,18 @@ func WithCredentials(cs ...*Credential) CreatePresentationOpt {
 	}
 }
 
+// WithPresentations sets the provided presentations into the presentation as its verifiable credentials,
+// e.g. to present the chained or delegated presentations as they are.
+func WithPresentations(ps ...*Presentation) CreatePresentationOpt {
+	return func(p *Presentation) error {
+		for _, vp := range ps {
+			p.credentials = append(p.credentials, vp)
+		}
+
+		return nil
+	}
+}
+
 // WithJWTCredentials sets the provided base64url encoded JWT credentials into the presentation.
 func WithJWTCredentials(cs ...string) CreatePresentationOpt {
 	return func(p *Presentation) error {
@@ -489,6 +489,16 @@ func TestNewPresentation(t *testing.T) {
 	r.Equal(jwt, vp.credentials[2])
 	r.Equal(vc, vp.credentials[3])
 
+	// set nested presentation
+	nestedVP, err := NewPresentation(WithCredentials(vc))
+	r.NoError(err)
+
+	vp, err = NewPresentation(WithCredentials(vc), WithPresentations(nestedVP))
+	r.NoError(err)
+	r.Len(vp.credentials, 2)
+	r.Equal(vc, vp.credentials[0])
+	r.Equal(nestedVP, vp.credentials[1])
+
 	// Error - pass unsupported type
 	_, err = NewPresentation(WithJWTCredentials("notajwt"))
 	r.Error(err)